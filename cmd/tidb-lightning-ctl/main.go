@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -27,6 +28,7 @@ import (
 	uuid "github.com/satori/go.uuid"
 
 	kv "github.com/pingcap/tidb-lightning/lightning/backend"
+	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
 	"github.com/pingcap/tidb-lightning/lightning/common"
 	"github.com/pingcap/tidb-lightning/lightning/config"
 	"github.com/pingcap/tidb-lightning/lightning/restore"
@@ -44,6 +46,11 @@ func run() error {
 		compact                                     *bool
 		mode, flagImportEngine, flagCleanupEngine   *string
 		cpRemove, cpErrIgnore, cpErrDestroy, cpDump *string
+		cpList                                      *bool
+		cpMigrate                                   *string
+		cpReimport                                  *string
+		historyList                                 *bool
+		historyShow                                 *int64
 
 		fsUsage func()
 	)
@@ -58,7 +65,13 @@ func run() error {
 		cpRemove = fs.String("checkpoint-remove", "", "remove the checkpoint associated with the given table (value can be 'all' or '`db`.`table`')")
 		cpErrIgnore = fs.String("checkpoint-error-ignore", "", "ignore errors encoutered previously on the given table (value can be 'all' or '`db`.`table`'); may corrupt this table if used incorrectly")
 		cpErrDestroy = fs.String("checkpoint-error-destroy", "", "deletes imported data with table which has an error before (value can be 'all' or '`db`.`table`')")
-		cpDump = fs.String("checkpoint-dump", "", "dump the checkpoint information as two CSV files in the given folder")
+		cpDump = fs.String("checkpoint-dump", "", "dump the checkpoint information as three CSV files in the given folder")
+		cpList = fs.Bool("checkpoint-list", false, "list the status and progress of the checkpoints of every table")
+		cpReimport = fs.String("checkpoint-reimport", "", "import the given data engines or source files of a fully imported table again, e.g. after replacing bad files (value should be '`db`.`table`:ID,...' where each ID is an engine ID or a source file path); the rows previously imported from them are deleted first, which requires the importer backend and a table without an integer primary key")
+		cpMigrate = fs.String("checkpoint-migrate", "", "copy all checkpoints into another empty checkpoint storage (value should be 'file:/path/to/cp.pb' or 'mysql:user:pass@tcp(host:port)/')")
+
+		historyList = fs.Bool("task-history-list", false, "list the past tasks recorded in the \"mysql\" checkpoint storage")
+		historyShow = fs.Int64("task-history-show", 0, "show the result of every table of the past task with the given task ID")
 
 		fsUsage = fs.Usage
 	}))
@@ -106,6 +119,21 @@ func run() error {
 	if len(*cpDump) != 0 {
 		return errors.Trace(checkpointDump(ctx, cfg, *cpDump))
 	}
+	if *cpList {
+		return errors.Trace(checkpointList(ctx, cfg))
+	}
+	if len(*cpMigrate) != 0 {
+		return errors.Trace(checkpointMigrate(ctx, cfg, *cpMigrate))
+	}
+	if len(*cpReimport) != 0 {
+		return errors.Trace(checkpointReimport(ctx, cfg, tls, *cpReimport))
+	}
+	if *historyList {
+		return errors.Trace(taskHistoryList(ctx, cfg))
+	}
+	if *historyShow != 0 {
+		return errors.Trace(taskHistoryShow(ctx, cfg, *historyShow))
+	}
 
 	fsUsage()
 	return nil
@@ -214,6 +242,95 @@ func checkpointErrorDestroy(ctx context.Context, cfg *config.Config, tls *common
 	return errors.Trace(lastErr)
 }
 
+func checkpointReimport(ctx context.Context, cfg *config.Config, tls *common.TLS, target string) error {
+	// the table name ends with a backquote, while the paths may contain colons.
+	index := strings.Index(target, "`:")
+	if index < 0 {
+		return errors.Errorf("invalid re-import target %s, should be of the form '`db`.`table`:ID,...'", target)
+	}
+	tableName := target[:index+1]
+	var engineIDs []int32
+	var paths []string
+	for _, item := range strings.Split(target[index+2:], ",") {
+		if engineID, err := strconv.ParseInt(item, 10, 32); err == nil {
+			engineIDs = append(engineIDs, int32(engineID))
+		} else if len(item) > 0 {
+			paths = append(paths, item)
+		}
+	}
+
+	cpdb, err := restore.OpenCheckpointsDB(ctx, cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer cpdb.Close()
+
+	cp, err := cpdb.Get(ctx, tableName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	engines, err := checkpoints.PrepareReimport(tableName, cp, engineIDs, paths)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// the rows written by the TiDB backend are assigned handles by TiDB, so
+	// there is no way to tell which rows came from the re-imported chunks.
+	if cfg.TikvImporter.Backend != config.BackendImporter {
+		return errors.Errorf("the rows of table %s imported by the %q backend cannot be deleted, so it cannot be re-imported; please use `--checkpoint-error-destroy` instead", tableName, cfg.TikvImporter.Backend)
+	}
+
+	// the stale rows and index entries must be deleted before re-importing,
+	// otherwise the checksum can never match.
+	tidbMgr, err := restore.NewTiDBManager(cfg.TiDB, tls)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer tidbMgr.Close()
+	fmt.Fprintln(os.Stderr, "Deleting the rows to be re-imported:", tableName)
+	deleted, err := tidbMgr.DeleteReimportedRows(ctx, tableName, engines)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Fprintln(os.Stderr, "Deleted rows:", deleted)
+
+	engineIDs = engineIDs[:0]
+	for engineID := range engines {
+		engineIDs = append(engineIDs, engineID)
+	}
+	sort.Slice(engineIDs, func(i, j int) bool { return engineIDs[i] < engineIDs[j] })
+
+	// the engines are going to be opened again, so any leftover with the same
+	// UUID must be removed from the importer first. The engines have normally
+	// been cleaned up after being imported, so the errors are only reported.
+	importer, err := kv.NewImporter(ctx, tls, cfg.TikvImporter.Addr, cfg.TiDB.PdAddr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer importer.Close()
+
+	for _, engineID := range engineIDs {
+		fmt.Fprintln(os.Stderr, "Closing and cleaning up engine:", tableName, engineID)
+		closedEngine, err := importer.UnsafeCloseEngine(ctx, tableName, engineID)
+		if err == nil {
+			err = closedEngine.Cleanup(ctx)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "* Encountered error while cleaning up engine:", err)
+		}
+	}
+
+	if err := cpdb.ResetEngineCheckpoints(ctx, tableName, engines); err != nil {
+		return errors.Trace(err)
+	}
+	for _, engineID := range engineIDs {
+		for _, chunk := range engines[engineID].Chunks {
+			fmt.Fprintln(os.Stderr, "Going to re-import:", tableName, engineID, chunk.Key.String())
+		}
+	}
+	return nil
+}
+
 func checkpointDump(ctx context.Context, cfg *config.Config, dumpFolder string) error {
 	cpdb, err := restore.OpenCheckpointsDB(ctx, cfg)
 	if err != nil {
@@ -233,7 +350,7 @@ func checkpointDump(ctx context.Context, cfg *config.Config, dumpFolder string)
 	defer tablesFile.Close()
 
 	enginesFileName := filepath.Join(dumpFolder, "engines.csv")
-	enginesFile, err := os.Create(tablesFileName)
+	enginesFile, err := os.Create(enginesFileName)
 	if err != nil {
 		return errors.Annotatef(err, "failed to create %s", enginesFileName)
 	}
@@ -258,6 +375,74 @@ func checkpointDump(ctx context.Context, cfg *config.Config, dumpFolder string)
 	return nil
 }
 
+func checkpointList(ctx context.Context, cfg *config.Config) error {
+	cpdb, err := restore.OpenCheckpointsDB(ctx, cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer cpdb.Close()
+
+	return errors.Trace(checkpoints.ListCheckpoints(ctx, cpdb, os.Stdout))
+}
+
+func taskHistoryList(ctx context.Context, cfg *config.Config) error {
+	historyDB, err := restore.OpenTaskHistoryDB(cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer historyDB.Close()
+
+	histories, err := historyDB.ListTaskHistory(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(checkpoints.PrintTaskHistoryList(os.Stdout, histories))
+}
+
+func taskHistoryShow(ctx context.Context, cfg *config.Config, taskID int64) error {
+	historyDB, err := restore.OpenTaskHistoryDB(cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer historyDB.Close()
+
+	history, err := historyDB.GetTaskHistory(ctx, taskID)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if history == nil {
+		return errors.Errorf("task %d not found in the task history", taskID)
+	}
+	return errors.Trace(checkpoints.PrintTaskHistory(os.Stdout, history))
+}
+
+func checkpointMigrate(ctx context.Context, cfg *config.Config, target string) error {
+	index := strings.IndexByte(target, ':')
+	if index < 0 {
+		return errors.Errorf("invalid checkpoint migration target %s, should be of the form 'driver:dsn'", target)
+	}
+	targetCfg := *cfg
+	targetCfg.Checkpoint.Enable = true
+	targetCfg.Checkpoint.Driver = target[:index]
+	targetCfg.Checkpoint.DSN = target[index+1:]
+
+	from, err := restore.OpenCheckpointsDB(ctx, cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer from.Close()
+
+	to, err := restore.OpenCheckpointsDB(ctx, &targetCfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = checkpoints.MigrateCheckpoints(ctx, from, to)
+	if closeErr := to.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Trace(err)
+}
+
 func unsafeCloseEngine(ctx context.Context, importer kv.Backend, engine string) (*kv.ClosedEngine, error) {
 	if index := strings.LastIndexByte(engine, ':'); index >= 0 {
 		tableName := engine[:index]
@@ -49,7 +49,8 @@ Usual workflow:
 
 		a. Create an `OpenedEngine` via `backend.OpenEngine()`
 
-		b. For each chunk, deliver data into the engine via `engine.WriteRows()`
+		b. For each chunk, deliver data into the engine via `engine.WriteRows()`,
+		   or via a long-lived writer from `engine.OpenWriter()`
 
 		c. When all chunks are written, obtain a `ClosedEngine` via `engine.Close()`
 
@@ -97,7 +98,7 @@ type AbstractBackend interface {
 	ShouldPostProcess() bool
 
 	// NewEncoder creates an encoder of a TiDB table.
-	NewEncoder(tbl table.Table, options *SessionOptions) Encoder
+	NewEncoder(tbl table.Table, options *SessionOptions) (Encoder, error)
 
 	OpenEngine(ctx context.Context, engineUUID uuid.UUID) error
 
@@ -117,6 +118,29 @@ type AbstractBackend interface {
 	CleanupEngine(ctx context.Context, engineUUID uuid.UUID) error
 }
 
+// streamingBackend is implemented by backends which can keep a connection to
+// an engine open across multiple writes.
+type streamingBackend interface {
+	// OpenWriter opens a long-lived writer of the engine.
+	OpenWriter(ctx context.Context, engineUUID uuid.UUID, commitTS uint64) (EngineWriter, error)
+}
+
+// EngineWriter writes encoded rows into an opened engine. Unlike
+// OpenedEngine, this type is not goroutine safe, every goroutine should open
+// its own writer.
+type EngineWriter interface {
+	// AppendRows sends a collection of encoded rows to the engine. The rows
+	// are only guaranteed to be persisted after Flush returns.
+	AppendRows(ctx context.Context, columnNames []string, rows Rows) error
+
+	// Flush persists all rows appended so far.
+	Flush(ctx context.Context) error
+
+	// Close releases the resources of the writer. Rows appended after the
+	// last Flush may be discarded.
+	Close()
+}
+
 // Backend is the delivery target for Lightning
 type Backend struct {
 	abstract AbstractBackend
@@ -162,7 +186,7 @@ func (be Backend) MakeEmptyRows() Rows {
 	return be.abstract.MakeEmptyRows()
 }
 
-func (be Backend) NewEncoder(tbl table.Table, options *SessionOptions) Encoder {
+func (be Backend) NewEncoder(tbl table.Table, options *SessionOptions) (Encoder, error) {
 	return be.abstract.NewEncoder(tbl, options)
 }
 
@@ -237,6 +261,32 @@ outside:
 	return nil
 }
 
+// OpenWriter opens a writer of the engine. If the backend supports it, the
+// connection to the engine is kept open across multiple AppendRows calls.
+// Otherwise, every AppendRows call is the same as WriteRows.
+func (engine *OpenedEngine) OpenWriter(ctx context.Context) (EngineWriter, error) {
+	if sb, ok := engine.backend.(streamingBackend); ok {
+		return sb.OpenWriter(ctx, engine.uuid, engine.ts)
+	}
+	return directWriter{engine: engine}, nil
+}
+
+// directWriter is an EngineWriter which writes every collection of rows
+// immediately via OpenedEngine.WriteRows.
+type directWriter struct {
+	engine *OpenedEngine
+}
+
+func (w directWriter) AppendRows(ctx context.Context, columnNames []string, rows Rows) error {
+	return w.engine.WriteRows(ctx, columnNames, rows)
+}
+
+func (directWriter) Flush(context.Context) error {
+	return nil
+}
+
+func (directWriter) Close() {}
+
 // UnsafeCloseEngine closes the engine without first opening it.
 // This method is "unsafe" as it does not follow the normal operation sequence
 // (Open -> Write -> Close -> Import). This method should only be used when one
@@ -327,6 +377,11 @@ type Row interface {
 		indices *Rows,
 		indexChecksum *verification.KVChecksum,
 	)
+
+	// Size returns the total byte size of the encoded row. The meaning of
+	// "byte size" should be consistent with the value used in
+	// `Row.ClassifyAndAppend`.
+	Size() uint64
 }
 
 // Rows represents a collection of encoded rows.
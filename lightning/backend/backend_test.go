@@ -322,7 +322,9 @@ func (s *backendSuite) TestNewEncoder(c *C) {
 
 	encoder := mock.NewMockEncoder(s.controller)
 	options := &kv.SessionOptions{SQLMode: mysql.ModeANSIQuotes, Timestamp: 1234567890, RowFormatVersion: "1"}
-	s.mockBackend.EXPECT().NewEncoder(nil, options).Return(encoder)
+	s.mockBackend.EXPECT().NewEncoder(nil, options).Return(encoder, nil)
 
-	c.Assert(s.mockBackend.NewEncoder(nil, options), Equals, encoder)
+	realEncoder, err := s.mockBackend.NewEncoder(nil, options)
+	c.Assert(realEncoder, Equals, encoder)
+	c.Assert(err, IsNil)
 }
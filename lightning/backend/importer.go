@@ -15,6 +15,7 @@ package backend
 
 import (
 	"context"
+	"io"
 	"strings"
 	"time"
 
@@ -176,18 +177,29 @@ func (importer *importer) WriteRows(
 	}()
 
 	// Bind uuid for this write request
-	req := &kv.WriteEngineRequest{
+	if err := wstream.Send(makeWriteHead(engineUUID)); err != nil {
+		return errors.Trace(err)
+	}
+
+	// Send kv paris as write request content
+	if err := wstream.Send(makeWriteBatch(kvs, ts)); err != nil {
+		return errors.Trace(err)
+	}
+
+	return nil
+}
+
+func makeWriteHead(engineUUID uuid.UUID) *kv.WriteEngineRequest {
+	return &kv.WriteEngineRequest{
 		Chunk: &kv.WriteEngineRequest_Head{
 			Head: &kv.WriteHead{
 				Uuid: engineUUID.Bytes(),
 			},
 		},
 	}
-	if err := wstream.Send(req); err != nil {
-		return errors.Trace(err)
-	}
+}
 
-	// Send kv paris as write request content
+func makeWriteBatch(kvs kvPairs, ts uint64) *kv.WriteEngineRequest {
 	mutations := make([]*kv.Mutation, len(kvs))
 	for i, pair := range kvs {
 		mutations[i] = &kv.Mutation{
@@ -197,25 +209,164 @@ func (importer *importer) WriteRows(
 		}
 	}
 
-	req.Reset()
-	req.Chunk = &kv.WriteEngineRequest_Batch{
-		Batch: &kv.WriteBatch{
-			CommitTs:  ts,
-			Mutations: mutations,
+	return &kv.WriteEngineRequest{
+		Chunk: &kv.WriteEngineRequest_Batch{
+			Batch: &kv.WriteBatch{
+				CommitTs:  ts,
+				Mutations: mutations,
+			},
 		},
 	}
+}
+
+// importerWriter keeps a WriteEngine stream to tikv-importer open across
+// multiple AppendRows calls. The importer only persists the received KV pairs
+// when the stream is closed, which is done in Flush. The batches sent since
+// the last flush are kept, so they can be resent through a new stream if the
+// current one is broken.
+type importerWriter struct {
+	importer   *importer
+	ctx        context.Context
+	cancel     context.CancelFunc
+	engineUUID uuid.UUID
+	commitTS   uint64
+	logger     log.Logger
+
+	wstream kv.ImportKV_WriteEngineClient
+	pending []*kv.WriteEngineRequest
+}
 
-	if err := wstream.Send(req); err != nil {
+func (importer *importer) OpenWriter(ctx context.Context, engineUUID uuid.UUID, commitTS uint64) (EngineWriter, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &importerWriter{
+		importer:   importer,
+		ctx:        ctx,
+		cancel:     cancel,
+		engineUUID: engineUUID,
+		commitTS:   commitTS,
+		logger:     log.With(zap.Stringer("engineUUID", engineUUID)),
+	}, nil
+}
+
+func (w *importerWriter) AppendRows(ctx context.Context, _ []string, rows Rows) error {
+	for _, r := range rows.SplitIntoChunks(w.importer.MaxChunkSize()) {
+		kvs := r.(kvPairs)
+		if len(kvs) == 0 {
+			continue
+		}
+		req := makeWriteBatch(kvs, w.commitTS)
+		w.pending = append(w.pending, req)
+		if err := w.send(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send sends a batch through the stream, reconnecting if the stream is
+// broken by a retryable error.
+func (w *importerWriter) send(ctx context.Context, req *kv.WriteEngineRequest) error {
+	var err error
+	for i := 0; i < maxRetryTimes; i++ {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if w.wstream == nil {
+			// the new stream already contains req, since it is pending.
+			err = w.reconnect()
+		} else {
+			err = w.wstream.Send(req)
+		}
+		if err == nil {
+			return nil
+		}
+		err = w.abort(err)
+		if !common.IsRetryableError(err) {
+			return errors.Trace(err)
+		}
+		w.logger.Warn("write stream broken, going to reconnect", log.ShortError(err))
+	}
+	return errors.Annotatef(err, "write rows reach max retry %d and still failed", maxRetryTimes)
+}
+
+// reconnect opens a new stream and resends all pending batches.
+func (w *importerWriter) reconnect() error {
+	wstream, err := w.importer.cli.WriteEngine(w.ctx)
+	if err != nil {
 		return errors.Trace(err)
 	}
+	w.wstream = wstream
 
+	if err := wstream.Send(makeWriteHead(w.engineUUID)); err != nil {
+		return errors.Trace(err)
+	}
+	for _, req := range w.pending {
+		if err := wstream.Send(req); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	return nil
 }
 
+// abort closes the broken stream. Since Send returns io.EOF when the stream is
+// broken by the server, the actual error is obtained from CloseAndRecv.
+func (w *importerWriter) abort(err error) error {
+	if w.wstream == nil {
+		return err
+	}
+	_, closeErr := w.wstream.CloseAndRecv()
+	w.wstream = nil
+	if errors.Cause(err) == io.EOF && closeErr != nil {
+		return closeErr
+	}
+	return err
+}
+
+func (w *importerWriter) Flush(ctx context.Context) error {
+	var err error
+	for i := 0; i < maxRetryTimes; i++ {
+		if len(w.pending) == 0 {
+			return nil
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if w.wstream == nil {
+			if err = w.reconnect(); err != nil {
+				err = w.abort(err)
+				if !common.IsRetryableError(err) {
+					return errors.Trace(err)
+				}
+				w.logger.Warn("write stream broken, going to reconnect", log.ShortError(err))
+				continue
+			}
+		}
+		_, err = w.wstream.CloseAndRecv()
+		w.wstream = nil
+		if err == nil {
+			w.pending = nil
+			return nil
+		}
+		if !common.IsRetryableError(err) {
+			return errors.Trace(err)
+		}
+		w.logger.Warn("flush write stream failed, going to reconnect", log.ShortError(err))
+	}
+	return errors.Annotatef(err, "flush write stream reach max retry %d and still failed", maxRetryTimes)
+}
+
+func (w *importerWriter) Close() {
+	// abandon the stream. The batches not yet flushed may or may not be
+	// persisted by the importer.
+	w.cancel()
+	w.wstream = nil
+	w.pending = nil
+}
+
 func (*importer) MakeEmptyRows() Rows {
 	return kvPairs(nil)
 }
 
-func (*importer) NewEncoder(tbl table.Table, options *SessionOptions) Encoder {
+func (*importer) NewEncoder(tbl table.Table, options *SessionOptions) (Encoder, error) {
 	return NewTableKVEncoder(tbl, options)
 }
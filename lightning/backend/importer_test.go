@@ -2,12 +2,15 @@ package backend_test
 
 import (
 	"context"
+	"io"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/pingcap/check"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/import_kvpb"
 	uuid "github.com/satori/go.uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	kv "github.com/pingcap/tidb-lightning/lightning/backend"
 	"github.com/pingcap/tidb-lightning/lightning/common"
@@ -172,6 +175,107 @@ func (s *importerSuite) TestWriteCloseFailed(c *C) {
 	c.Assert(err, ErrorMatches, "fake unrecoverable close stream error.*")
 }
 
+func (s *importerSuite) TestWriterReuseStream(c *C) {
+	s.setUpTest(c)
+	defer s.tearDownTest()
+
+	s.mockClient.EXPECT().WriteEngine(gomock.Any()).Return(s.mockWriter, nil)
+
+	// both batches are sent through the same stream.
+	headSendCall := s.mockWriter.EXPECT().
+		Send(gomock.Any()).
+		DoAndReturn(func(x *import_kvpb.WriteEngineRequest) error {
+			c.Assert(x.GetHead().GetUuid(), DeepEquals, s.engineUUID)
+			return nil
+		})
+	batchSendCall := s.mockWriter.EXPECT().
+		Send(gomock.Any()).
+		DoAndReturn(func(x *import_kvpb.WriteEngineRequest) error {
+			c.Assert(x.GetBatch().GetMutations(), HasLen, 2)
+			return nil
+		}).
+		Times(2).
+		After(headSendCall)
+	s.mockWriter.EXPECT().
+		CloseAndRecv().
+		Return(nil, nil).
+		After(batchSendCall)
+
+	writer, err := s.engine.OpenWriter(s.ctx)
+	c.Assert(err, IsNil)
+	defer writer.Close()
+
+	c.Assert(writer.AppendRows(s.ctx, nil, s.kvPairs), IsNil)
+	c.Assert(writer.AppendRows(s.ctx, nil, s.kvPairs), IsNil)
+	c.Assert(writer.Flush(s.ctx), IsNil)
+
+	// nothing to flush.
+	c.Assert(writer.Flush(s.ctx), IsNil)
+}
+
+func (s *importerSuite) TestWriterReconnect(c *C) {
+	s.setUpTest(c)
+	defer s.tearDownTest()
+
+	mockWriter2 := mock.NewMockImportKV_WriteEngineClient(s.controller)
+
+	// the first stream is broken when sending the second batch...
+	firstStreamCall := s.mockClient.EXPECT().WriteEngine(gomock.Any()).Return(s.mockWriter, nil)
+	s.mockWriter.EXPECT().Send(gomock.Any()).Return(nil).Times(2)
+	s.mockWriter.EXPECT().Send(gomock.Any()).Return(io.EOF)
+	s.mockWriter.EXPECT().
+		CloseAndRecv().
+		Return(nil, status.Error(codes.Unavailable, "fake retryable stream error"))
+
+	// ... so both batches are resent through a new stream.
+	s.mockClient.EXPECT().WriteEngine(gomock.Any()).Return(mockWriter2, nil).After(firstStreamCall)
+	headSendCall := mockWriter2.EXPECT().
+		Send(gomock.Any()).
+		DoAndReturn(func(x *import_kvpb.WriteEngineRequest) error {
+			c.Assert(x.GetHead(), NotNil)
+			return nil
+		})
+	batchSendCall := mockWriter2.EXPECT().
+		Send(gomock.Any()).
+		DoAndReturn(func(x *import_kvpb.WriteEngineRequest) error {
+			c.Assert(x.GetBatch(), NotNil)
+			return nil
+		}).
+		Times(2).
+		After(headSendCall)
+	mockWriter2.EXPECT().
+		CloseAndRecv().
+		Return(nil, nil).
+		After(batchSendCall)
+
+	writer, err := s.engine.OpenWriter(s.ctx)
+	c.Assert(err, IsNil)
+	defer writer.Close()
+
+	c.Assert(writer.AppendRows(s.ctx, nil, s.kvPairs), IsNil)
+	c.Assert(writer.AppendRows(s.ctx, nil, s.kvPairs), IsNil)
+	c.Assert(writer.Flush(s.ctx), IsNil)
+}
+
+func (s *importerSuite) TestWriterUnrecoverableError(c *C) {
+	s.setUpTest(c)
+	defer s.tearDownTest()
+
+	s.mockClient.EXPECT().WriteEngine(gomock.Any()).Return(s.mockWriter, nil)
+	s.mockWriter.EXPECT().Send(gomock.Any()).Return(nil).Times(2)
+	s.mockWriter.EXPECT().
+		CloseAndRecv().
+		Return(nil, errors.Annotate(context.Canceled, "fake unrecoverable close stream error"))
+
+	writer, err := s.engine.OpenWriter(s.ctx)
+	c.Assert(err, IsNil)
+	defer writer.Close()
+
+	c.Assert(writer.AppendRows(s.ctx, nil, s.kvPairs), IsNil)
+	err = writer.Flush(s.ctx)
+	c.Assert(err, ErrorMatches, "fake unrecoverable close stream error.*")
+}
+
 func (s *importerSuite) TestCloseImportCleanupEngine(c *C) {
 	s.setUpTest(c)
 	defer s.tearDownTest()
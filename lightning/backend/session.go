@@ -19,9 +19,11 @@ import (
 
 	"github.com/pingcap/parser/mysql"
 	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/log"
 	"github.com/pingcap/tidb/kv"
 	"github.com/pingcap/tidb/sessionctx"
 	"github.com/pingcap/tidb/sessionctx/variable"
+	"go.uber.org/zap"
 )
 
 // invalidIterator is a trimmed down Iterator type which is invalid.
@@ -90,6 +92,12 @@ type SessionOptions struct {
 	SQLMode          mysql.SQLMode
 	Timestamp        int64
 	RowFormatVersion string
+	// TimeZone is the value of the `time_zone` variable. The process local
+	// time zone is used if empty.
+	TimeZone string
+	// OnDuplicate overrides the action on duplicated rows of the TiDB backend
+	// for the encoded table. The setting of the backend is used if empty.
+	OnDuplicate string
 }
 
 func newSession(options *SessionOptions) *session {
@@ -103,6 +111,12 @@ func newSession(options *SessionOptions) *session {
 	vars.StmtCtx.OverflowAsWarning = !sqlMode.HasStrictMode()
 	vars.StmtCtx.AllowInvalidDate = sqlMode.HasAllowInvalidDatesMode()
 	vars.StmtCtx.IgnoreZeroInDate = !sqlMode.HasStrictMode() || sqlMode.HasAllowInvalidDatesMode()
+	if len(options.TimeZone) > 0 {
+		if err := vars.SetSystemVar(variable.TimeZone, options.TimeZone); err != nil {
+			log.L().Warn("invalid time zone, fallback to the local time zone",
+				zap.String("timeZone", options.TimeZone), log.ShortError(err))
+		}
+	}
 	vars.StmtCtx.TimeZone = vars.Location()
 	vars.SetSystemVar("timestamp", strconv.FormatInt(options.Timestamp, 10))
 	vars.SetSystemVar(variable.TiDBRowFormatVersion, options.RowFormatVersion)
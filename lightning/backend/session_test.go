@@ -15,6 +15,7 @@ package backend
 
 import (
 	"testing"
+	"time"
 
 	. "github.com/pingcap/check"
 	"github.com/pingcap/parser/mysql"
@@ -35,3 +36,12 @@ func (s *kvSuite) TestSetOption(c *C) {
 	c.Assert(err, IsNil)
 	txn.SetOption(tidbkv.Priority, tidbkv.PriorityHigh)
 }
+
+func (s *kvSuite) TestSessionTimeZone(c *C) {
+	session := newSession(&SessionOptions{SQLMode: mysql.ModeNone, TimeZone: "+08:00"})
+	_, offset := time.Now().In(session.vars.StmtCtx.TimeZone).Zone()
+	c.Assert(offset, Equals, 8*60*60)
+
+	session = newSession(&SessionOptions{SQLMode: mysql.ModeNone, TimeZone: "Asia/Tokyo"})
+	c.Assert(session.vars.StmtCtx.TimeZone.String(), Equals, "Asia/Tokyo")
+}
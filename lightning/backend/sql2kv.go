@@ -17,9 +17,12 @@ import (
 	"github.com/pingcap/errors"
 	"github.com/pingcap/parser/model"
 	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/expression"
 	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/table/tables"
 	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/chunk"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
@@ -35,15 +38,96 @@ type tableKVEncoder struct {
 	tbl         table.Table
 	se          *session
 	recordCache []types.Datum
+	genCols     []genCol
+	autoRandom  *autoRandomCol
 }
 
-func NewTableKVEncoder(tbl table.Table, options *SessionOptions) Encoder {
+// autoRandomCol describes the AUTO_RANDOM primary key column of the table.
+type autoRandomCol struct {
+	index int
+	// shardBits is the number of AUTO_RANDOM shard bits.
+	shardBits uint64
+	// typeBits is the number of bits of the column type.
+	typeBits uint64
+}
+
+// incrementalMask returns the mask extracting the auto-increasing part of an
+// AUTO_RANDOM value, i.e. the bits excluding the sign and shard bits.
+func (c *autoRandomCol) incrementalMask() int64 {
+	return 1<<(c.typeBits-c.shardBits-1) - 1
+}
+
+// genCol is a generated column to be evaluated by the encoder.
+type genCol struct {
+	index int
+	expr  expression.Expression
+}
+
+func NewTableKVEncoder(tbl table.Table, options *SessionOptions) (Encoder, error) {
+	se := newSession(options)
+	genCols, err := collectGeneratedColumns(se, tbl)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to parse generated columns of table %s", tbl.Meta().Name)
+	}
+
 	metric.KvEncoderCounter.WithLabelValues("open").Inc()
 
 	return &tableKVEncoder{
-		tbl: tbl,
-		se:  newSession(options),
+		tbl:        tbl,
+		se:         se,
+		genCols:    genCols,
+		autoRandom: findAutoRandomColumn(tbl),
+	}, nil
+}
+
+// findAutoRandomColumn returns the AUTO_RANDOM column of the table, or nil if
+// the table has none.
+func findAutoRandomColumn(tbl table.Table) *autoRandomCol {
+	meta := tbl.Meta()
+	if !meta.PKIsHandle || !meta.ContainsAutoRandomBits() {
+		return nil
+	}
+	for i, col := range tbl.Cols() {
+		if mysql.HasPriKeyFlag(col.Flag) {
+			return &autoRandomCol{
+				index:     i,
+				shardBits: meta.AutoRandomBits,
+				typeBits:  uint64(mysql.DefaultLengthOfMysqlTypes[col.Tp] * 8),
+			}
+		}
+	}
+	return nil
+}
+
+// value fills in a missing AUTO_RANDOM value. The row ID becomes the
+// auto-increasing part, and the shard bits are derived from the row ID (rather
+// than the transaction start TS like TiDB does), so that the rows are
+// scattered while the result stays the same when the chunk is re-imported.
+func (c *autoRandomCol) value(rowID int64) (int64, error) {
+	if tables.OverflowShardBits(rowID, c.shardBits, c.typeBits) {
+		return 0, errors.Errorf("row ID %d overflows the AUTO_RANDOM column with %d shard bits", rowID, c.shardBits)
+	}
+	return rowID | tables.CalcShard(c.shardBits, uint64(rowID), c.typeBits), nil
+}
+
+// collectGeneratedColumns rewrites the expressions of all generated columns
+// (stored or virtual) of the table. Virtual columns are needed as well since
+// they can be indexed or referred to by stored columns. The result is ordered
+// by the column offset, which is also the dependency order as a generated
+// column can only refer to generated columns defined before it.
+func collectGeneratedColumns(se *session, tbl table.Table) ([]genCol, error) {
+	var genCols []genCol
+	for i, col := range tbl.Cols() {
+		if col.GeneratedExpr == nil {
+			continue
+		}
+		expr, err := expression.RewriteSimpleExprWithTableInfo(se, tbl.Meta(), col.GeneratedExpr)
+		if err != nil {
+			return nil, errors.Annotatef(err, "column `%s`", col.Name.O)
+		}
+		genCols = append(genCols, genCol{index: i, expr: expr})
 	}
+	return genCols, nil
 }
 
 func (kvcodec *tableKVEncoder) Close() {
@@ -140,6 +224,37 @@ func MakeRowFromKvPairs(pairs []common.KvPair) Row {
 	return kvPairs(pairs)
 }
 
+// KvPairsFromRow extracts the KV pairs from a Row instance produced by the
+// importer backend. Returns nil if the row is not made of KV pairs (e.g. it is
+// produced by the TiDB backend).
+func KvPairsFromRow(row Row) []common.KvPair {
+	if pairs, ok := row.(kvPairs); ok {
+		return pairs
+	}
+	return nil
+}
+
+// TargetColumnNames returns the names of the target columns which receive
+// values from the source row according to the column permutation, in the
+// canonical order of the table. The TiDB backend encodes the values in the
+// same order.
+//
+// See comments in `(*TableRestore).initializeColumns` for the meaning of the
+// `columnPermutation` parameter.
+func TargetColumnNames(tbl table.Table, columnPermutation []int) []string {
+	cols := tbl.Cols()
+	names := make([]string, 0, len(columnPermutation))
+	for i, col := range cols {
+		if i < len(columnPermutation) && columnPermutation[i] >= 0 {
+			names = append(names, col.Name.O)
+		}
+	}
+	if len(columnPermutation) > len(cols) && columnPermutation[len(cols)] >= 0 {
+		names = append(names, model.ExtraHandleName.O)
+	}
+	return names
+}
+
 // Encode a row of data into KV pairs.
 //
 // See comments in `(*TableRestore).initializeColumns` for the meaning of the
@@ -165,7 +280,12 @@ func (kvcodec *tableKVEncoder) Encode(
 	for i, col := range cols {
 		j := columnPermutation[i]
 		isAutoIncCol := mysql.HasAutoIncrementFlag(col.Flag)
-		if j >= 0 && j < len(row) {
+		isAutoRandomCol := kvcodec.autoRandom != nil && kvcodec.autoRandom.index == i
+		if col.IsGenerated() {
+			// generated columns are evaluated after all base columns are
+			// filled in, ignoring the source content.
+			value, err = types.Datum{}, nil
+		} else if j >= 0 && j < len(row) {
 			value, err = table.CastValue(kvcodec.se, row[j], col.ToInfo())
 			if err == nil {
 				value, err = col.HandleBadNull(value, kvcodec.se.vars.StmtCtx)
@@ -173,6 +293,11 @@ func (kvcodec *tableKVEncoder) Encode(
 		} else if isAutoIncCol {
 			// we still need a conversion, e.g. to catch overflow with a TINYINT column.
 			value, err = table.CastValue(kvcodec.se, types.NewIntDatum(rowID), col.ToInfo())
+		} else if isAutoRandomCol {
+			var id int64
+			if id, err = kvcodec.autoRandom.value(rowID); err == nil {
+				value, err = table.CastValue(kvcodec.se, types.NewIntDatum(id), col.ToInfo())
+			}
 		} else {
 			value, err = table.GetColDefaultValue(kvcodec.se, col.ToInfo())
 		}
@@ -182,6 +307,9 @@ func (kvcodec *tableKVEncoder) Encode(
 		record = append(record, value)
 		if isAutoIncCol {
 			kvcodec.tbl.RebaseAutoID(kvcodec.se, value.GetInt64(), false)
+		} else if isAutoRandomCol {
+			// only the auto-increasing part is tracked, like TiDB.
+			kvcodec.tbl.RebaseAutoID(kvcodec.se, value.GetInt64()&kvcodec.autoRandom.incrementalMask(), false)
 		}
 	}
 
@@ -199,6 +327,25 @@ func (kvcodec *tableKVEncoder) Encode(
 		kvcodec.tbl.RebaseAutoID(kvcodec.se, value.GetInt64(), false)
 	}
 
+	for _, gc := range kvcodec.genCols {
+		colInfo := cols[gc.index].ToInfo()
+		// the row is rebuilt every time since it must contain the values of
+		// the generated columns evaluated previously.
+		value, err = gc.expr.Eval(chunk.MutRowFromDatums(record).ToRow())
+		if err == nil {
+			value, err = table.CastValue(kvcodec.se, value, colInfo)
+		}
+		if err != nil {
+			logger.Error("kv evaluate generated column failed",
+				zap.Array("originalRow", rowArrayMarshaler(row)),
+				zap.String("colName", colInfo.Name.O),
+				log.ShortError(err),
+			)
+			return nil, errors.Annotatef(err, "failed to evaluate generated column `%s`", colInfo.Name.O)
+		}
+		record[gc.index] = value
+	}
+
 	_, err = kvcodec.tbl.AddRecord(kvcodec.se, record)
 	if err != nil {
 		logger.Error("kv encode failed",
@@ -238,6 +385,14 @@ func (kvs kvPairs) ClassifyAndAppend(
 	*indices = indexKVs
 }
 
+func (kvs kvPairs) Size() uint64 {
+	size := uint64(0)
+	for _, kv := range kvs {
+		size += uint64(len(kv.Key) + len(kv.Val))
+	}
+	return size
+}
+
 func (totalKVs kvPairs) SplitIntoChunks(splitSize int) []Rows {
 	if len(totalKVs) == 0 {
 		return nil
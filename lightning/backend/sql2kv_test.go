@@ -15,6 +15,7 @@ package backend
 
 import (
 	"errors"
+	"time"
 
 	. "github.com/pingcap/check"
 	"github.com/pingcap/parser"
@@ -25,6 +26,7 @@ import (
 	"github.com/pingcap/tidb/sessionctx"
 	"github.com/pingcap/tidb/table"
 	"github.com/pingcap/tidb/table/tables"
+	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/types"
 	"github.com/pingcap/tidb/util/mock"
 	"go.uber.org/zap"
@@ -80,11 +82,12 @@ func (s *kvSuite) TestEncode(c *C) {
 	}
 
 	// Strict mode
-	strictMode := NewTableKVEncoder(tbl, &SessionOptions{
+	strictMode, err := NewTableKVEncoder(tbl, &SessionOptions{
 		SQLMode:          mysql.ModeStrictAllTables,
 		Timestamp:        1234567890,
 		RowFormatVersion: "1",
 	})
+	c.Assert(err, IsNil)
 	pairs, err := strictMode.Encode(logger, rows, 1, []int{0, 1})
 	c.Assert(err, ErrorMatches, "failed to cast `10000000` as tinyint\\(4\\) for column `c1` \\(#1\\):.*overflows tinyint")
 	c.Assert(pairs, IsNil)
@@ -111,20 +114,22 @@ func (s *kvSuite) TestEncode(c *C) {
 
 	// Mock add record error
 	mockTbl := &mockTable{Table: tbl}
-	mockMode := NewTableKVEncoder(mockTbl, &SessionOptions{
+	mockMode, err := NewTableKVEncoder(mockTbl, &SessionOptions{
 		SQLMode:          mysql.ModeStrictAllTables,
 		Timestamp:        1234567891,
 		RowFormatVersion: "1",
 	})
+	c.Assert(err, IsNil)
 	pairs, err = mockMode.Encode(logger, rowsWithPk2, 2, []int{0, 1})
 	c.Assert(err, ErrorMatches, "mock error")
 
 	// Non-strict mode
-	noneMode := NewTableKVEncoder(tbl, &SessionOptions{
+	noneMode, err := NewTableKVEncoder(tbl, &SessionOptions{
 		SQLMode:          mysql.ModeNone,
 		Timestamp:        1234567892,
 		RowFormatVersion: "1",
 	})
+	c.Assert(err, IsNil)
 	pairs, err = noneMode.Encode(logger, rows, 1, []int{0, 1})
 	c.Assert(err, IsNil)
 	c.Assert(pairs, DeepEquals, kvPairs([]common.KvPair{
@@ -135,6 +140,108 @@ func (s *kvSuite) TestEncode(c *C) {
 	}))
 }
 
+func (s *kvSuite) TestTargetColumnNames(c *C) {
+	a := &model.ColumnInfo{ID: 1, Name: model.NewCIStr("A"), State: model.StatePublic, Offset: 0, FieldType: *types.NewFieldType(mysql.TypeLong)}
+	b := &model.ColumnInfo{ID: 2, Name: model.NewCIStr("b"), State: model.StatePublic, Offset: 1, FieldType: *types.NewFieldType(mysql.TypeLong)}
+	tblInfo := &model.TableInfo{ID: 1, Columns: []*model.ColumnInfo{a, b}, PKIsHandle: false, State: model.StatePublic}
+	tbl, err := tables.TableFromMeta(NewPanickingAllocators(0), tblInfo)
+	c.Assert(err, IsNil)
+
+	c.Assert(TargetColumnNames(tbl, []int{0, 1, -1}), DeepEquals, []string{"A", "b"})
+	c.Assert(TargetColumnNames(tbl, []int{-1, 0, -1}), DeepEquals, []string{"b"})
+	c.Assert(TargetColumnNames(tbl, []int{1, 2, 0}), DeepEquals, []string{"A", "b", "_tidb_rowid"})
+}
+
+func (s *kvSuite) TestEncodeGeneratedColumns(c *C) {
+	mockTable := func(createSQL string) table.Table {
+		node, err := parser.New().ParseOneStmt(createSQL, "", "")
+		c.Assert(err, IsNil)
+		tblInfo, err := ddl.MockTableInfo(mock.NewContext(), node.(*ast.CreateTableStmt), 1)
+		c.Assert(err, IsNil)
+		tblInfo.State = model.StatePublic
+		tbl, err := tables.TableFromMeta(NewPanickingAllocators(0), tblInfo)
+		c.Assert(err, IsNil)
+		return tbl
+	}
+	logger := log.Logger{Logger: zap.NewNop()}
+
+	// `b` is stored and `c` is virtual but indexed. The source values of
+	// generated columns are ignored.
+	genTbl := mockTable("create table t (a int, b int as (a * 2) stored, c int as (b + 1), key idx_c (c))")
+	genEncoder, err := NewTableKVEncoder(genTbl, &SessionOptions{})
+	c.Assert(err, IsNil)
+	genPairs, err := genEncoder.Encode(logger, types.MakeDatums(5, 999, 999), 1, []int{0, 1, 2, -1})
+	c.Assert(err, IsNil)
+
+	// the equivalent table without generated columns.
+	refTbl := mockTable("create table t (a int, b int, c int, key idx_c (c))")
+	refEncoder, err := NewTableKVEncoder(refTbl, &SessionOptions{})
+	c.Assert(err, IsNil)
+	refPairs, err := refEncoder.Encode(logger, types.MakeDatums(5, 10, 11), 1, []int{0, 1, 2, -1})
+	c.Assert(err, IsNil)
+
+	genKVs := genPairs.(kvPairs)
+	refKVs := refPairs.(kvPairs)
+	c.Assert(genKVs, HasLen, 2)
+	c.Assert(refKVs, HasLen, 2)
+	// index KVs must be identical.
+	c.Assert(genKVs[0], DeepEquals, refKVs[0])
+
+	// the record of the generated table does not contain the virtual column.
+	colTypes := map[int64]*types.FieldType{
+		1: types.NewFieldType(mysql.TypeLong),
+		2: types.NewFieldType(mysql.TypeLong),
+		3: types.NewFieldType(mysql.TypeLong),
+	}
+	record, err := tablecodec.DecodeRow(genKVs[1].Val, colTypes, time.UTC)
+	c.Assert(err, IsNil)
+	c.Assert(record, HasLen, 2)
+	c.Assert(record[1], DeepEquals, types.NewIntDatum(5))
+	c.Assert(record[2], DeepEquals, types.NewIntDatum(10))
+}
+
+func (s *kvSuite) TestEncodeAutoRandom(c *C) {
+	pkCol := &model.ColumnInfo{ID: 1, Name: model.NewCIStr("id"), State: model.StatePublic, Offset: 0, FieldType: *types.NewFieldType(mysql.TypeLonglong)}
+	pkCol.Flag = mysql.PriKeyFlag | mysql.NotNullFlag
+	valCol := &model.ColumnInfo{ID: 2, Name: model.NewCIStr("v"), State: model.StatePublic, Offset: 1, FieldType: *types.NewFieldType(mysql.TypeLong)}
+	tblInfo := &model.TableInfo{ID: 1, Columns: []*model.ColumnInfo{pkCol, valCol}, PKIsHandle: true, AutoRandomBits: 5, State: model.StatePublic}
+	alloc := NewPanickingAllocators(0)
+	tbl, err := tables.TableFromMeta(alloc, tblInfo)
+	c.Assert(err, IsNil)
+
+	logger := log.Logger{Logger: zap.NewNop()}
+	encoder, err := NewTableKVEncoder(tbl, &SessionOptions{})
+	c.Assert(err, IsNil)
+
+	// a missing AUTO_RANDOM value is filled with the row ID plus the shard bits.
+	pairs, err := encoder.Encode(logger, types.MakeDatums(1), 7, []int{-1, 0})
+	c.Assert(err, IsNil)
+	kvs := pairs.(kvPairs)
+	c.Assert(kvs, HasLen, 1)
+	handle, err := tablecodec.DecodeRowKey(kvs[0].Key)
+	c.Assert(err, IsNil)
+	c.Assert(handle, Equals, 7|tables.CalcShard(5, 7, 64))
+	c.Assert(handle&^(1<<58-1), Not(Equals), int64(0))
+	c.Assert(alloc[0].Base(), Equals, int64(7))
+
+	// re-encoding the same row gives the same result.
+	pairs2, err := encoder.Encode(logger, types.MakeDatums(1), 7, []int{-1, 0})
+	c.Assert(err, IsNil)
+	c.Assert(pairs2, DeepEquals, pairs)
+
+	// explicit values are kept, and only the auto-increasing part is rebased.
+	pairs, err = encoder.Encode(logger, types.MakeDatums(31<<58|100, 1), 8, []int{0, 1})
+	c.Assert(err, IsNil)
+	handle, err = tablecodec.DecodeRowKey(pairs.(kvPairs)[0].Key)
+	c.Assert(err, IsNil)
+	c.Assert(handle, Equals, int64(31<<58|100))
+	c.Assert(alloc[0].Base(), Equals, int64(100))
+
+	// the row ID must not overflow into the shard bits.
+	_, err = encoder.Encode(logger, types.MakeDatums(1), 1<<58, []int{-1, 0})
+	c.Assert(err, ErrorMatches, ".*overflows the AUTO_RANDOM column.*")
+}
+
 func (s *kvSuite) TestEncodeRowFormatV2(c *C) {
 	// Test encoding in row format v2, as described in <https://github.com/pingcap/tidb/blob/master/docs/design/2018-07-19-row-format.md>.
 
@@ -149,11 +256,12 @@ func (s *kvSuite) TestEncodeRowFormatV2(c *C) {
 		types.NewIntDatum(10000000),
 	}
 
-	noneMode := NewTableKVEncoder(tbl, &SessionOptions{
+	noneMode, err := NewTableKVEncoder(tbl, &SessionOptions{
 		SQLMode:          mysql.ModeNone,
 		Timestamp:        1234567892,
 		RowFormatVersion: "2",
 	})
+	c.Assert(err, IsNil)
 	pairs, err := noneMode.Encode(logger, rows, 1, []int{0, 1})
 	c.Assert(err, IsNil)
 	c.Assert(pairs, DeepEquals, kvPairs([]common.KvPair{
@@ -192,11 +300,13 @@ func (s *kvSuite) TestEncodeTimestamp(c *C) {
 
 	logger := log.Logger{Logger: zap.NewNop()}
 
-	encoder := NewTableKVEncoder(tbl, &SessionOptions{
+	encoder, err := NewTableKVEncoder(tbl, &SessionOptions{
 		SQLMode:          mysql.ModeStrictAllTables,
 		Timestamp:        1234567893,
 		RowFormatVersion: "1",
+		TimeZone:         "Asia/Shanghai",
 	})
+	c.Assert(err, IsNil)
 	pairs, err := encoder.Encode(logger, nil, 70, []int{-1, 1})
 	c.Assert(err, IsNil)
 	c.Assert(pairs, DeepEquals, kvPairs([]common.KvPair{
@@ -344,7 +454,8 @@ func (s *benchSQL2KVSuite) SetUpTest(c *C) {
 	// Construct the corresponding KV encoder.
 	tbl, err := tables.TableFromMeta(NewPanickingAllocators(0), tableInfo)
 	c.Assert(err, IsNil)
-	s.encoder = NewTableKVEncoder(tbl, &SessionOptions{RowFormatVersion: "2"})
+	s.encoder, err = NewTableKVEncoder(tbl, &SessionOptions{RowFormatVersion: "2"})
+	c.Assert(err, IsNil)
 	s.logger = log.Logger{Logger: zap.NewNop()}
 
 	// Prepare the row to insert.
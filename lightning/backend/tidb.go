@@ -14,14 +14,21 @@
 package backend
 
 import (
+	"container/list"
 	"context"
 	"database/sql"
 	"encoding/hex"
+	"io"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/pingcap/errors"
 	"github.com/pingcap/failpoint"
+	"github.com/pingcap/parser/model"
 	"github.com/pingcap/parser/mysql"
 	"github.com/pingcap/tidb/table"
 	"github.com/pingcap/tidb/types"
@@ -34,17 +41,130 @@ import (
 	"github.com/pingcap/tidb-lightning/lightning/verification"
 )
 
-type tidbRow string
+// tidbRow is a row encoded for the TiDB backend. Depending on the write mode,
+// the row is an SQL tuple, the values bound to a prepared statement, or a line
+// of LOAD DATA input. Rows which cannot be represented in the write mode (e.g.
+// containing DEFAULT) are always encoded as SQL tuples.
+type tidbRow struct {
+	// kind is the write mode this row is encoded for.
+	kind string
+	// sql is the SQL tuple (e.g. `(1,'x',DEFAULT)`) of the `insert` kind,
+	// or the line (e.g. "1\tx\n") of the `load-data` kind.
+	sql string
+	// args are the values of the `prepared` kind.
+	args []interface{}
+	size int
+	// policy is shared by all rows of the same table. nil means using the
+	// policy of the backend.
+	policy *tidbDupPolicy
+}
 
 type tidbRows []tidbRow
 
+// tidbDupPolicy describes how the rows of a table resolve conflicts with the
+// existing rows.
+type tidbDupPolicy struct {
+	onDuplicate string
+	// keyColumns are the lower-cased names of the columns in the primary key
+	// or any unique index, which are not updated by the `upsert` policy.
+	keyColumns map[string]struct{}
+}
+
+func newTiDBDupPolicy(tbl table.Table, onDuplicate string) *tidbDupPolicy {
+	policy := &tidbDupPolicy{onDuplicate: onDuplicate}
+	if onDuplicate != config.UpsertOnDup {
+		return policy
+	}
+
+	policy.keyColumns = map[string]struct{}{model.ExtraHandleName.L: {}}
+	if tbl == nil {
+		return policy
+	}
+	tblInfo := tbl.Meta()
+	for _, col := range tblInfo.Columns {
+		if tblInfo.PKIsHandle && mysql.HasPriKeyFlag(col.Flag) {
+			policy.keyColumns[col.Name.L] = struct{}{}
+		}
+	}
+	for _, index := range tblInfo.Indices {
+		if !index.Primary && !index.Unique {
+			continue
+		}
+		for _, col := range index.Columns {
+			policy.keyColumns[col.Name.L] = struct{}{}
+		}
+	}
+	return policy
+}
+
+// updateColumns returns the columns to be overwritten by the `upsert` policy,
+// i.e. the inserted columns which are not part of any unique key.
+func (policy *tidbDupPolicy) updateColumns(columnNames []string) []string {
+	res := make([]string, 0, len(columnNames))
+	for _, colName := range columnNames {
+		if _, ok := policy.keyColumns[strings.ToLower(colName)]; !ok {
+			res = append(res, colName)
+		}
+	}
+	return res
+}
+
 type tidbEncoder struct {
-	mode mysql.SQLMode
+	mode      mysql.SQLMode
+	writeMode string
+	policy    *tidbDupPolicy
 }
 
+// endpointCooldown is the duration a TiDB server is skipped after it failed.
+const endpointCooldown = 10 * time.Second
+
+const (
+	// maxPreparedRows is the maximum number of rows inserted by one prepared
+	// statement. Smaller batches are split into statements of power-of-two
+	// number of rows, so the number of distinct statements stays small.
+	maxPreparedRows = 256
+	// maxPreparedStmts is the maximum number of prepared statements cached
+	// for each TiDB server. The least recently used ones are evicted.
+	maxPreparedStmts = 256
+	// maxPlaceholders is the maximum number of placeholders in a statement
+	// accepted by the MySQL protocol.
+	maxPlaceholders = 65535
+)
+
 type tidbBackend struct {
-	db          *sql.DB
+	endpoints   []*tidbEndpoint
 	onDuplicate string
+	writeMode   string
+	// loadDataSeq is used to generate unique names of the LOAD DATA readers.
+	loadDataSeq uint64
+	// next is the endpoint to start looking for the least loaded one, so
+	// that the writes are distributed round-robin when all are equally busy.
+	next uint32
+}
+
+// tidbEndpoint is the connection to one of the TiDB servers receiving rows.
+type tidbEndpoint struct {
+	db *sql.DB
+	// inflight is the number of statements being executed.
+	inflight int64
+	// unhealthyUntil is the Unix time in nanoseconds until which the server
+	// should not be used because it had failed.
+	unhealthyUntil int64
+
+	stmtsMu sync.Mutex
+	stmts   map[string]*list.Element
+	// stmtsLRU orders the cached *preparedStmt from the most recently used.
+	stmtsLRU *list.List
+}
+
+// preparedStmt is a prepared statement cached by an endpoint. A statement
+// evicted from the cache is closed only after all its users have released it.
+type preparedStmt struct {
+	*sql.Stmt
+	query string
+	// refs is the number of users of the statement. Guarded by stmtsMu.
+	refs    int
+	evicted bool
 }
 
 // NewTiDBBackend creates a new TiDB backend using the given database.
@@ -52,22 +172,51 @@ type tidbBackend struct {
 // The backend does not take ownership of `db`. Caller should close `db`
 // manually after the backend expired.
 func NewTiDBBackend(db *sql.DB, onDuplicate string) Backend {
+	return NewMultiTiDBBackend([]*sql.DB{db}, onDuplicate, config.WriteModeInsert)
+}
+
+// NewMultiTiDBBackend creates a new TiDB backend which distributes the writes
+// across multiple databases, each connected to a different TiDB server. The
+// rows are written with the statements of the given write mode.
+//
+// The backend does not take ownership of `dbs`. Caller should close them
+// manually after the backend expired.
+func NewMultiTiDBBackend(dbs []*sql.DB, onDuplicate string, writeMode string) Backend {
 	switch onDuplicate {
-	case config.ReplaceOnDup, config.IgnoreOnDup, config.ErrorOnDup:
+	case config.ReplaceOnDup, config.IgnoreOnDup, config.ErrorOnDup, config.UpsertOnDup:
 	default:
 		log.L().Warn("unsupported action on duplicate, overwrite with `replace`")
 		onDuplicate = config.ReplaceOnDup
 	}
-	return MakeBackend(&tidbBackend{db: db, onDuplicate: onDuplicate})
+	switch writeMode {
+	case config.WriteModeInsert, config.WriteModePrepared:
+	case config.WriteModeLoadData:
+		if !loadDataSupports(onDuplicate) {
+			log.L().Warn("LOAD DATA cannot handle duplicated rows with this action, overwrite write mode with `insert`", zap.String("onDuplicate", onDuplicate))
+			writeMode = config.WriteModeInsert
+		}
+	default:
+		log.L().Warn("unsupported write mode, overwrite with `insert`")
+		writeMode = config.WriteModeInsert
+	}
+	endpoints := make([]*tidbEndpoint, 0, len(dbs))
+	for _, db := range dbs {
+		endpoints = append(endpoints, &tidbEndpoint{db: db})
+	}
+	return MakeBackend(&tidbBackend{endpoints: endpoints, onDuplicate: onDuplicate, writeMode: writeMode})
 }
 
 func (row tidbRow) ClassifyAndAppend(data *Rows, checksum *verification.KVChecksum, _ *Rows, _ *verification.KVChecksum) {
 	rows := (*data).(tidbRows)
 	*data = tidbRows(append(rows, row))
-	cs := verification.MakeKVChecksum(uint64(len(row)), 1, 0)
+	cs := verification.MakeKVChecksum(uint64(row.size), 1, 0)
 	checksum.Add(&cs)
 }
 
+func (row tidbRow) Size() uint64 {
+	return uint64(row.size)
+}
+
 func (rows tidbRows) SplitIntoChunks(splitSize int) []Rows {
 	if len(rows) == 0 {
 		return nil
@@ -78,12 +227,12 @@ func (rows tidbRows) SplitIntoChunks(splitSize int) []Rows {
 	cumSize := 0
 
 	for j, row := range rows {
-		if i < j && cumSize+len(row) > splitSize {
+		if i < j && cumSize+row.size > splitSize {
 			res = append(res, rows[i:j])
 			i = j
 			cumSize = 0
 		}
-		cumSize += len(row)
+		cumSize += row.size
 	}
 
 	return append(res, rows[i:])
@@ -203,30 +352,228 @@ func (enc tidbEncoder) appendSQL(sb *strings.Builder, datum *types.Datum) error
 
 func (tidbEncoder) Close() {}
 
-func (enc tidbEncoder) Encode(logger log.Logger, row []types.Datum, _ int64, _ []int) (Row, error) {
+// appendArg converts the Datum into a value bound to a prepared statement.
+// Returns false if the value cannot be bound as-is, e.g. a hexadecimal literal
+// is a number or a string depending on the column type.
+func (enc tidbEncoder) appendArg(args []interface{}, datum *types.Datum) ([]interface{}, int, bool, error) {
+	switch datum.Kind() {
+	case types.KindNull:
+		return append(args, nil), 4, true, nil
+
+	case types.KindInt64:
+		return append(args, datum.GetInt64()), 8, true, nil
+
+	case types.KindUint64, types.KindMysqlEnum, types.KindMysqlSet:
+		return append(args, datum.GetUint64()), 8, true, nil
+
+	case types.KindFloat32, types.KindFloat64:
+		return append(args, datum.GetFloat64()), 8, true, nil
+
+	case types.KindString, types.KindBytes:
+		value := datum.GetBytes()
+		return append(args, value), len(value), true, nil
+
+	case types.KindMysqlJSON:
+		value, err := datum.GetMysqlJSON().MarshalJSON()
+		if err != nil {
+			return args, 0, false, err
+		}
+		return append(args, value), len(value), true, nil
+
+	case types.KindMinNotNull, types.KindMaxValue, types.KindBinaryLiteral, types.KindMysqlBit:
+		return args, 0, false, nil
+
+		// time, duration, decimal
+	default:
+		value, err := datum.ToString()
+		if err != nil {
+			return args, 0, false, err
+		}
+		return append(args, value), len(value), true, nil
+	}
+}
+
+func (enc tidbEncoder) appendLoadDataBytes(sb *strings.Builder, value []byte) {
+	sb.Grow(len(value))
+	for _, b := range value {
+		switch b {
+		case 0:
+			sb.WriteString(`\0`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\\':
+			sb.WriteString(`\\`)
+		default:
+			sb.WriteByte(b)
+		}
+	}
+}
+
+// appendLoadData appends the Datum as a field of LOAD DATA input into the
+// string builder. Returns false if the value cannot be represented.
+func (enc tidbEncoder) appendLoadData(sb *strings.Builder, datum *types.Datum) (bool, error) {
+	switch datum.Kind() {
+	case types.KindNull:
+		sb.WriteString(`\N`)
+
+	case types.KindInt64:
+		var buffer [20]byte
+		sb.Write(strconv.AppendInt(buffer[:0], datum.GetInt64(), 10))
+
+	case types.KindUint64:
+		var buffer [20]byte
+		sb.Write(strconv.AppendUint(buffer[:0], datum.GetUint64(), 10))
+
+	case types.KindFloat32, types.KindFloat64:
+		var buffer [32]byte
+		sb.Write(strconv.AppendFloat(buffer[:0], datum.GetFloat64(), 'g', -1, 64))
+
+	case types.KindString, types.KindBytes:
+		enc.appendLoadDataBytes(sb, datum.GetBytes())
+
+	case types.KindMysqlJSON:
+		value, err := datum.GetMysqlJSON().MarshalJSON()
+		if err != nil {
+			return false, err
+		}
+		enc.appendLoadDataBytes(sb, value)
+
+	case types.KindMinNotNull, types.KindMaxValue, types.KindBinaryLiteral, types.KindMysqlBit:
+		return false, nil
+
+		// enum and set (by name), time, duration, decimal
+	default:
+		value, err := datum.ToString()
+		if err != nil {
+			return false, err
+		}
+		enc.appendLoadDataBytes(sb, []byte(value))
+	}
+
+	return true, nil
+}
+
+// encodePrepared encodes the row into the values bound to a prepared
+// statement. Returns false if the row cannot be bound.
+func (enc tidbEncoder) encodePrepared(row []types.Datum, indices []int) (tidbRow, int, bool, error) {
+	args := make([]interface{}, 0, len(indices))
+	size := 0
+	for _, j := range indices {
+		if j >= len(row) {
+			return tidbRow{}, j, false, nil
+		}
+		var argSize int
+		var ok bool
+		var err error
+		if args, argSize, ok, err = enc.appendArg(args, &row[j]); !ok || err != nil {
+			return tidbRow{}, j, false, err
+		}
+		size += argSize
+	}
+	return tidbRow{kind: config.WriteModePrepared, args: args, size: size, policy: enc.policy}, 0, true, nil
+}
+
+// encodeLoadData encodes the row into a line of LOAD DATA input. Returns false
+// if the row cannot be represented.
+func (enc tidbEncoder) encodeLoadData(row []types.Datum, indices []int) (tidbRow, int, bool, error) {
+	var encoded strings.Builder
+	encoded.Grow(8 * len(indices))
+	for i, j := range indices {
+		if j >= len(row) {
+			return tidbRow{}, j, false, nil
+		}
+		if i != 0 {
+			encoded.WriteByte('\t')
+		}
+		if ok, err := enc.appendLoadData(&encoded, &row[j]); !ok || err != nil {
+			return tidbRow{}, j, false, err
+		}
+	}
+	encoded.WriteByte('\n')
+	line := encoded.String()
+	return tidbRow{kind: config.WriteModeLoadData, sql: line, size: len(line), policy: enc.policy}, 0, true, nil
+}
+
+// encodeInsert encodes the row into an SQL tuple.
+func (enc tidbEncoder) encodeInsert(row []types.Datum, indices []int) (tidbRow, int, error) {
 	var encoded strings.Builder
-	encoded.Grow(8 * len(row))
+	encoded.Grow(8 * len(indices))
 	encoded.WriteByte('(')
-	for i, field := range row {
+	for i, j := range indices {
 		if i != 0 {
 			encoded.WriteByte(',')
 		}
-		if err := enc.appendSQL(&encoded, &field); err != nil {
-			logger.Error("tidb encode failed",
-				zap.Array("original", rowArrayMarshaler(row)),
-				zap.Int("originalCol", i),
-				log.ShortError(err),
-			)
-			return nil, err
+		if j >= len(row) {
+			encoded.WriteString("DEFAULT")
+			continue
+		}
+		if err := enc.appendSQL(&encoded, &row[j]); err != nil {
+			return tidbRow{}, j, err
 		}
 	}
 	encoded.WriteByte(')')
-	return tidbRow(encoded.String()), nil
+	tuple := encoded.String()
+	return tidbRow{kind: config.WriteModeInsert, sql: tuple, size: len(tuple), policy: enc.policy}, 0, nil
+}
+
+// Encode a row of data for the write mode of the encoder. If the column
+// permutation is given, only the values of the columns listed in
+// `TargetColumnNames` are encoded, in that order. Columns beyond the end of
+// the row are filled with DEFAULT.
+func (enc tidbEncoder) Encode(logger log.Logger, row []types.Datum, _ int64, columnPermutation []int) (Row, error) {
+	var indices []int
+	if len(columnPermutation) > 0 {
+		indices = make([]int, 0, len(columnPermutation))
+		for _, j := range columnPermutation {
+			if j >= 0 {
+				indices = append(indices, j)
+			}
+		}
+	} else {
+		indices = make([]int, 0, len(row))
+		for j := range row {
+			indices = append(indices, j)
+		}
+	}
+
+	var (
+		encoded tidbRow
+		errCol  int
+		ok      bool
+		err     error
+	)
+	switch enc.writeMode {
+	case config.WriteModePrepared:
+		encoded, errCol, ok, err = enc.encodePrepared(row, indices)
+	case config.WriteModeLoadData:
+		encoded, errCol, ok, err = enc.encodeLoadData(row, indices)
+	}
+	if !ok && err == nil {
+		encoded, errCol, err = enc.encodeInsert(row, indices)
+	}
+	if err != nil {
+		logger.Error("tidb encode failed",
+			zap.Array("original", rowArrayMarshaler(row)),
+			zap.Int("originalCol", errCol),
+			log.ShortError(err),
+		)
+		return nil, err
+	}
+	return encoded, nil
 }
 
 func (be *tidbBackend) Close() {
-	// *Not* going to close `be.db`. The db object is normally borrowed from a
-	// TidbManager, so we let the manager to close it.
+	// *Not* going to close the databases. The db objects are normally borrowed
+	// from a TidbManager, so we let the manager to close them.
+	for _, endpoint := range be.endpoints {
+		endpoint.stmtsMu.Lock()
+		endpoint.closeStmts()
+		endpoint.stmtsMu.Unlock()
+	}
 }
 
 func (be *tidbBackend) MakeEmptyRows() Rows {
@@ -248,8 +595,22 @@ func (be *tidbBackend) ShouldPostProcess() bool {
 	return false
 }
 
-func (be *tidbBackend) NewEncoder(_ table.Table, options *SessionOptions) Encoder {
-	return tidbEncoder{mode: options.SQLMode}
+func (be *tidbBackend) NewEncoder(tbl table.Table, options *SessionOptions) (Encoder, error) {
+	onDuplicate := be.onDuplicate
+	if len(options.OnDuplicate) != 0 {
+		onDuplicate = options.OnDuplicate
+	}
+	writeMode := be.writeMode
+	if writeMode == config.WriteModeLoadData && !loadDataSupports(onDuplicate) {
+		writeMode = config.WriteModeInsert
+	}
+	return tidbEncoder{mode: options.SQLMode, writeMode: writeMode, policy: newTiDBDupPolicy(tbl, onDuplicate)}, nil
+}
+
+// loadDataSupports returns whether LOAD DATA can resolve duplicated rows with
+// the action. LOAD DATA LOCAL neither reports nor updates duplicated rows.
+func loadDataSupports(onDuplicate string) bool {
+	return onDuplicate == config.ReplaceOnDup || onDuplicate == config.IgnoreOnDup
 }
 
 func (be *tidbBackend) OpenEngine(context.Context, uuid.UUID) error {
@@ -273,15 +634,55 @@ func (be *tidbBackend) WriteRows(ctx context.Context, _ uuid.UUID, tableName str
 	if len(rows) == 0 {
 		return nil
 	}
+	// all rows of a batch belong to the same table.
+	policy := rows[0].policy
+	if policy == nil {
+		policy = newTiDBDupPolicy(nil, be.onDuplicate)
+	}
 
-	var insertStmt strings.Builder
-	switch be.onDuplicate {
+	// Rows which cannot be represented in the write mode are encoded as SQL
+	// tuples instead. The consecutive rows of the same kind are written
+	// together in the source order, so that the last of the duplicated rows
+	// still wins, and writing the whole batch again after a failure leaves the
+	// same rows with the `replace`, `ignore` and `upsert` actions.
+	for len(rows) > 0 {
+		kind := rows[0].kind
+		n := 1
+		for n < len(rows) && rows[n].kind == kind {
+			n++
+		}
+		var err error
+		switch kind {
+		case config.WriteModePrepared:
+			err = be.writePrepared(ctx, policy, tableName, columnNames, rows[:n])
+		case config.WriteModeLoadData:
+			err = be.writeLoadData(ctx, policy, tableName, columnNames, rows[:n])
+		default:
+			err = be.writeInsert(ctx, policy, tableName, columnNames, rows[:n])
+		}
+		if err != nil {
+			return err
+		}
+		rows = rows[n:]
+	}
+	return nil
+}
+
+func appendInsertPrefix(insertStmt *strings.Builder, policy *tidbDupPolicy, tableName string, columnNames []string) {
+	switch policy.onDuplicate {
 	case config.ReplaceOnDup:
 		insertStmt.WriteString("REPLACE INTO ")
 	case config.IgnoreOnDup:
 		insertStmt.WriteString("INSERT IGNORE INTO ")
 	case config.ErrorOnDup:
 		insertStmt.WriteString("INSERT INTO ")
+	case config.UpsertOnDup:
+		// if there is nothing to update, duplicated rows are just ignored.
+		if len(policy.updateColumns(columnNames)) == 0 {
+			insertStmt.WriteString("INSERT IGNORE INTO ")
+		} else {
+			insertStmt.WriteString("INSERT INTO ")
+		}
 	}
 
 	insertStmt.WriteString(tableName)
@@ -291,26 +692,312 @@ func (be *tidbBackend) WriteRows(ctx context.Context, _ uuid.UUID, tableName str
 			if i != 0 {
 				insertStmt.WriteByte(',')
 			}
-			common.WriteMySQLIdentifier(&insertStmt, colName)
+			common.WriteMySQLIdentifier(insertStmt, colName)
 		}
 		insertStmt.WriteByte(')')
 	}
 	insertStmt.WriteString(" VALUES")
+}
+
+// appendInsertSuffix appends the ON DUPLICATE KEY UPDATE clause of the
+// `upsert` policy, which overwrites all inserted non-key columns.
+func appendInsertSuffix(insertStmt *strings.Builder, policy *tidbDupPolicy, columnNames []string) {
+	if policy.onDuplicate != config.UpsertOnDup {
+		return
+	}
+	updateColumns := policy.updateColumns(columnNames)
+	for i, colName := range updateColumns {
+		if i == 0 {
+			insertStmt.WriteString(" ON DUPLICATE KEY UPDATE ")
+		} else {
+			insertStmt.WriteByte(',')
+		}
+		common.WriteMySQLIdentifier(insertStmt, colName)
+		insertStmt.WriteString("=VALUES(")
+		common.WriteMySQLIdentifier(insertStmt, colName)
+		insertStmt.WriteByte(')')
+	}
+}
 
-	// Note: we are not going to do interpolation (prepared statements) to avoid
-	// complication arised from data length overflow of BIT and BINARY columns
+// writeInsert writes the rows with a single INSERT statement containing the
+// literal values.
+func (be *tidbBackend) writeInsert(ctx context.Context, policy *tidbDupPolicy, tableName string, columnNames []string, rows tidbRows) error {
+	var insertStmt strings.Builder
+	appendInsertPrefix(&insertStmt, policy, tableName, columnNames)
+
+	// Note: the values which would be complicated to bind in prepared
+	// statements (e.g. BIT and BINARY literals) are always written here.
 
 	for i, row := range rows {
 		if i != 0 {
 			insertStmt.WriteByte(',')
 		}
-		insertStmt.WriteString(string(row))
+		insertStmt.WriteString(row.sql)
 	}
+	appendInsertSuffix(&insertStmt, policy, columnNames)
 
-	// Retry will be done externally, so we're not going to retry here.
-	_, err := be.db.ExecContext(ctx, insertStmt.String())
-	failpoint.Inject("FailIfImportedSomeRows", func() {
-		panic("forcing failure due to FailIfImportedSomeRows, before saving checkpoint")
+	query := insertStmt.String()
+	return be.execute(ctx, func(endpoint *tidbEndpoint) error {
+		_, err := endpoint.db.ExecContext(ctx, query)
+		return err
+	})
+}
+
+// writePrepared writes the rows with server-side prepared INSERT statements.
+// To reuse the statements, every statement inserts either maxPreparedRows
+// rows or a power-of-two number of rows.
+func (be *tidbBackend) writePrepared(ctx context.Context, policy *tidbDupPolicy, tableName string, columnNames []string, rows tidbRows) error {
+	for len(rows) > 0 {
+		// rows without the column names may have different lengths, only
+		// those of the same length can share a statement.
+		argsCount := len(rows[0].args)
+		sameLen := 1
+		for sameLen < len(rows) && len(rows[sameLen].args) == argsCount {
+			sameLen++
+		}
+
+		maxRows := maxPreparedRows
+		if argsCount > 0 && maxPlaceholders/argsCount < maxRows {
+			maxRows = maxPlaceholders / argsCount
+		}
+
+		group := rows[:sameLen]
+		rows = rows[sameLen:]
+		for len(group) > 0 {
+			n := maxRows
+			for n > len(group) {
+				n >>= 1
+			}
+			if err := be.execPrepared(ctx, policy, tableName, columnNames, group[:n]); err != nil {
+				return err
+			}
+			group = group[n:]
+		}
+	}
+	return nil
+}
+
+func (be *tidbBackend) execPrepared(ctx context.Context, policy *tidbDupPolicy, tableName string, columnNames []string, rows tidbRows) error {
+	var insertStmt strings.Builder
+	appendInsertPrefix(&insertStmt, policy, tableName, columnNames)
+
+	argsCount := len(rows[0].args)
+	args := make([]interface{}, 0, len(rows)*argsCount)
+	for i, row := range rows {
+		if i != 0 {
+			insertStmt.WriteByte(',')
+		}
+		insertStmt.WriteByte('(')
+		for j := 0; j < argsCount; j++ {
+			if j != 0 {
+				insertStmt.WriteByte(',')
+			}
+			insertStmt.WriteByte('?')
+		}
+		insertStmt.WriteByte(')')
+		args = append(args, row.args...)
+	}
+	appendInsertSuffix(&insertStmt, policy, columnNames)
+
+	query := insertStmt.String()
+	return be.execute(ctx, func(endpoint *tidbEndpoint) error {
+		stmt, err := endpoint.prepare(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer endpoint.release(stmt)
+		_, err = stmt.ExecContext(ctx, args...)
+		return err
 	})
-	return err
+}
+
+// writeLoadData writes the rows with a LOAD DATA LOCAL INFILE statement,
+// streaming the lines through a registered reader.
+func (be *tidbBackend) writeLoadData(ctx context.Context, policy *tidbDupPolicy, tableName string, columnNames []string, rows tidbRows) error {
+	size := 0
+	for _, row := range rows {
+		size += len(row.sql)
+	}
+	var data strings.Builder
+	data.Grow(size)
+	for _, row := range rows {
+		data.WriteString(row.sql)
+	}
+	content := data.String()
+
+	readerName := "lightning-" + strconv.FormatUint(atomic.AddUint64(&be.loadDataSeq, 1), 10)
+	gomysql.RegisterReaderHandler(readerName, func() io.Reader {
+		return strings.NewReader(content)
+	})
+	defer gomysql.DeregisterReaderHandler(readerName)
+
+	var loadStmt strings.Builder
+	loadStmt.WriteString("LOAD DATA LOCAL INFILE 'Reader::")
+	loadStmt.WriteString(readerName)
+	loadStmt.WriteString("' ")
+	switch policy.onDuplicate {
+	case config.ReplaceOnDup:
+		loadStmt.WriteString("REPLACE")
+	case config.IgnoreOnDup:
+		loadStmt.WriteString("IGNORE")
+	}
+	loadStmt.WriteString(" INTO TABLE ")
+	loadStmt.WriteString(tableName)
+	loadStmt.WriteString(` FIELDS TERMINATED BY '\t' ESCAPED BY '\\' LINES TERMINATED BY '\n'`)
+	if len(columnNames) > 0 {
+		loadStmt.WriteString(" (")
+		for i, colName := range columnNames {
+			if i != 0 {
+				loadStmt.WriteByte(',')
+			}
+			common.WriteMySQLIdentifier(&loadStmt, colName)
+		}
+		loadStmt.WriteByte(')')
+	}
+
+	query := loadStmt.String()
+	return be.execute(ctx, func(endpoint *tidbEndpoint) error {
+		_, err := endpoint.db.ExecContext(ctx, query)
+		return err
+	})
+}
+
+// execute runs the statements on the least loaded TiDB server. If the server
+// fails, the statements are executed again on another server.
+func (be *tidbBackend) execute(ctx context.Context, exec func(*tidbEndpoint) error) error {
+	// Retry on the same server will be done externally, so we're only going
+	// to retry on the other servers here.
+	var err error
+	failed := make([]bool, len(be.endpoints))
+	for {
+		index := be.pickEndpoint(time.Now(), failed)
+		if index < 0 {
+			return errors.Annotate(err, "all TiDB servers failed to write rows")
+		}
+
+		endpoint := be.endpoints[index]
+		atomic.AddInt64(&endpoint.inflight, 1)
+		err = exec(endpoint)
+		atomic.AddInt64(&endpoint.inflight, -1)
+		failpoint.Inject("FailIfImportedSomeRows", func() {
+			panic("forcing failure due to FailIfImportedSomeRows, before saving checkpoint")
+		})
+		if !isEndpointFailure(err) || len(be.endpoints) == 1 {
+			return err
+		}
+
+		atomic.StoreInt64(&endpoint.unhealthyUntil, time.Now().Add(endpointCooldown).UnixNano())
+		failed[index] = true
+		log.L().Warn("write rows to TiDB server failed, going to try another server",
+			zap.Int("endpoint", index), log.ShortError(err))
+	}
+}
+
+// prepare returns the cached prepared statement of the query, or prepares a
+// new one. The statement must be released after use.
+func (endpoint *tidbEndpoint) prepare(ctx context.Context, query string) (*preparedStmt, error) {
+	endpoint.stmtsMu.Lock()
+	defer endpoint.stmtsMu.Unlock()
+
+	if elem, ok := endpoint.stmts[query]; ok {
+		endpoint.stmtsLRU.MoveToFront(elem)
+		stmt := elem.Value.(*preparedStmt)
+		stmt.refs++
+		return stmt, nil
+	}
+	sqlStmt, err := endpoint.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.stmts == nil {
+		endpoint.stmts = make(map[string]*list.Element)
+		endpoint.stmtsLRU = list.New()
+	}
+	stmt := &preparedStmt{Stmt: sqlStmt, query: query, refs: 1}
+	endpoint.stmts[query] = endpoint.stmtsLRU.PushFront(stmt)
+	for endpoint.stmtsLRU.Len() > maxPreparedStmts {
+		endpoint.evict(endpoint.stmtsLRU.Back())
+	}
+	return stmt, nil
+}
+
+// release gives up the use of the statement returned by prepare.
+func (endpoint *tidbEndpoint) release(stmt *preparedStmt) {
+	endpoint.stmtsMu.Lock()
+	defer endpoint.stmtsMu.Unlock()
+
+	stmt.refs--
+	if stmt.evicted && stmt.refs == 0 {
+		stmt.close()
+	}
+}
+
+// evict removes the statement from the cache, and closes it unless it is
+// still being used. The caller must hold stmtsMu.
+func (endpoint *tidbEndpoint) evict(elem *list.Element) {
+	stmt := endpoint.stmtsLRU.Remove(elem).(*preparedStmt)
+	delete(endpoint.stmts, stmt.query)
+	stmt.evicted = true
+	if stmt.refs == 0 {
+		stmt.close()
+	}
+}
+
+// closeStmts evicts all cached prepared statements. The caller must hold
+// stmtsMu.
+func (endpoint *tidbEndpoint) closeStmts() {
+	for endpoint.stmtsLRU != nil && endpoint.stmtsLRU.Len() > 0 {
+		endpoint.evict(endpoint.stmtsLRU.Back())
+	}
+}
+
+func (stmt *preparedStmt) close() {
+	if err := stmt.Close(); err != nil {
+		log.L().Warn("close prepared statement failed", log.ShortError(err))
+	}
+}
+
+// pickEndpoint returns the index of the healthy endpoint with the least
+// statements in flight, skipping those which have `failed` for the current
+// batch. If all remaining endpoints are unhealthy, the one which recovers
+// the earliest is returned. Returns -1 if every endpoint has failed.
+func (be *tidbBackend) pickEndpoint(now time.Time, failed []bool) int {
+	n := len(be.endpoints)
+	start := int(atomic.AddUint32(&be.next, 1) % uint32(n))
+	nowNanos := now.UnixNano()
+
+	best, bestInflight := -1, int64(0)
+	fallback, fallbackUntil := -1, int64(0)
+	for i := 0; i < n; i++ {
+		index := (start + i) % n
+		if failed[index] {
+			continue
+		}
+		endpoint := be.endpoints[index]
+		if until := atomic.LoadInt64(&endpoint.unhealthyUntil); until > nowNanos {
+			if fallback < 0 || until < fallbackUntil {
+				fallback, fallbackUntil = index, until
+			}
+			continue
+		}
+		if inflight := atomic.LoadInt64(&endpoint.inflight); best < 0 || inflight < bestInflight {
+			best, bestInflight = index, inflight
+		}
+	}
+
+	if best < 0 {
+		return fallback
+	}
+	return best
+}
+
+// isEndpointFailure returns whether the error is caused by the TiDB server
+// (e.g. it is down or unreachable) rather than by the statement, so the
+// statement can be executed on another server instead.
+func isEndpointFailure(err error) bool {
+	if err == nil || common.IsContextCanceledError(err) {
+		return false
+	}
+	_, isMySQLError := errors.Cause(err).(*gomysql.MySQLError)
+	return !isMySQLError
 }
@@ -0,0 +1,67 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+func (s *kvSuite) TestTiDBEncodeLoadData(c *C) {
+	enc := tidbEncoder{writeMode: config.WriteModeLoadData}
+
+	row, err := enc.Encode(log.L(), []types.Datum{
+		types.NewIntDatum(-1),
+		types.NewUintDatum(18446744073709551615),
+		types.NewFloat64Datum(7.5),
+		types.NewDatum(nil),
+		types.NewStringDatum("a\tb\nc\r\\\x00d"),
+		types.NewMysqlEnumDatum(types.Enum{Name: "y", Value: 2}),
+	}, 1, nil)
+	c.Assert(err, IsNil)
+	c.Assert(row.(tidbRow), DeepEquals, tidbRow{
+		kind: config.WriteModeLoadData,
+		sql:  "-1\t18446744073709551615\t7.5\t\\N\ta\\tb\\nc\\r\\\\\\0d\ty\n",
+		size: 48,
+	})
+
+	// missing columns and hexadecimal literals fall back to INSERT.
+	row, err = enc.Encode(log.L(), types.MakeDatums(1), 1, []int{0, 1})
+	c.Assert(err, IsNil)
+	c.Assert(row.(tidbRow), DeepEquals, tidbRow{kind: config.WriteModeInsert, sql: "(1,DEFAULT)", size: 11})
+
+	row, err = enc.Encode(log.L(), []types.Datum{types.NewBinaryLiteralDatum(types.NewBinaryLiteralFromUint(0xab, 1))}, 1, nil)
+	c.Assert(err, IsNil)
+	c.Assert(row.(tidbRow), DeepEquals, tidbRow{kind: config.WriteModeInsert, sql: "(0xab)", size: 6})
+}
+
+func (s *kvSuite) TestTiDBEncodePrepared(c *C) {
+	enc := tidbEncoder{writeMode: config.WriteModePrepared}
+
+	row, err := enc.Encode(log.L(), []types.Datum{
+		types.NewIntDatum(-1),
+		types.NewStringDatum("abc"),
+		types.NewDatum(nil),
+		types.NewMysqlEnumDatum(types.Enum{Name: "y", Value: 2}),
+	}, 1, []int{1, 0, -1, 2, 3})
+	c.Assert(err, IsNil)
+	c.Assert(row.(tidbRow), DeepEquals, tidbRow{
+		kind: config.WriteModePrepared,
+		args: []interface{}{[]byte("abc"), int64(-1), nil, uint64(2)},
+		size: 23,
+	})
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/pingcap/check"
+)
+
+func (s *kvSuite) TestPreparedStmtCache(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	ctx := context.Background()
+	endpoint := &tidbEndpoint{db: db}
+	query := func(i int) string {
+		return fmt.Sprintf("INSERT INTO t VALUES(%d)", i)
+	}
+	expectPrepare := func(i int) *sqlmock.ExpectedPrepare {
+		return mock.ExpectPrepare(fmt.Sprintf("^\\Q%s\\E$", query(i))).WillBeClosed()
+	}
+	prepare := func(i int) *preparedStmt {
+		stmt, err := endpoint.prepare(ctx, query(i))
+		c.Assert(err, IsNil)
+		return stmt
+	}
+
+	expectPrepare(0).ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+	inUse := prepare(0)
+
+	// fill the cache, while keeping the statement 1 recently used.
+	for i := 1; i <= maxPreparedStmts; i++ {
+		expectPrepare(i)
+		endpoint.release(prepare(i))
+		endpoint.release(prepare(1))
+	}
+	c.Assert(endpoint.stmtsLRU.Len(), Equals, maxPreparedStmts)
+
+	// the statement in use is evicted but not closed until released.
+	c.Assert(inUse.evicted, IsTrue)
+	_, err = inUse.ExecContext(ctx)
+	c.Assert(err, IsNil)
+	endpoint.release(inUse)
+
+	// the least recently used statement is evicted next.
+	expectPrepare(maxPreparedStmts + 1)
+	endpoint.release(prepare(maxPreparedStmts + 1))
+	c.Assert(endpoint.stmts, Not(HasKey), query(2))
+	c.Assert(endpoint.stmts, HasKey, query(1))
+
+	endpoint.closeStmts()
+	c.Assert(endpoint.stmtsLRU.Len(), Equals, 0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
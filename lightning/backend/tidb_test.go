@@ -16,10 +16,19 @@ package backend_test
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	gomysql "github.com/go-sql-driver/mysql"
 	. "github.com/pingcap/check"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/ddl"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/table/tables"
 	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/mock"
 
 	kv "github.com/pingcap/tidb-lightning/lightning/backend"
 	"github.com/pingcap/tidb-lightning/lightning/config"
@@ -65,7 +74,8 @@ func (s *mysqlSuite) TestWriteRowsReplaceOnDup(c *C) {
 	indexRows := s.backend.MakeEmptyRows()
 	indexChecksum := verification.MakeKVChecksum(0, 0, 0)
 
-	encoder := s.backend.NewEncoder(nil, &kv.SessionOptions{SQLMode: 0, Timestamp: 1234567890, RowFormatVersion: "1"})
+	encoder, err := s.backend.NewEncoder(nil, &kv.SessionOptions{SQLMode: 0, Timestamp: 1234567890, RowFormatVersion: "1"})
+	c.Assert(err, IsNil)
 	row, err := encoder.Encode(logger, []types.Datum{
 		types.NewUintDatum(18446744073709551615),
 		types.NewIntDatum(-9223372036854775808),
@@ -105,7 +115,8 @@ func (s *mysqlSuite) TestWriteRowsIgnoreOnDup(c *C) {
 	indexRows := ignoreBackend.MakeEmptyRows()
 	indexChecksum := verification.MakeKVChecksum(0, 0, 0)
 
-	encoder := ignoreBackend.NewEncoder(nil, &kv.SessionOptions{})
+	encoder, err := ignoreBackend.NewEncoder(nil, &kv.SessionOptions{})
+	c.Assert(err, IsNil)
 	row, err := encoder.Encode(logger, []types.Datum{
 		types.NewIntDatum(1),
 	}, 1, nil)
@@ -133,7 +144,8 @@ func (s *mysqlSuite) TestWriteRowsErrorOnDup(c *C) {
 	indexRows := ignoreBackend.MakeEmptyRows()
 	indexChecksum := verification.MakeKVChecksum(0, 0, 0)
 
-	encoder := ignoreBackend.NewEncoder(nil, &kv.SessionOptions{})
+	encoder, err := ignoreBackend.NewEncoder(nil, &kv.SessionOptions{})
+	c.Assert(err, IsNil)
 	row, err := encoder.Encode(logger, []types.Datum{
 		types.NewIntDatum(1),
 	}, 1, nil)
@@ -143,3 +155,266 @@ func (s *mysqlSuite) TestWriteRowsErrorOnDup(c *C) {
 	err = engine.WriteRows(ctx, []string{"a"}, dataRows)
 	c.Assert(err, IsNil)
 }
+
+func (s *mysqlSuite) TestWriteRowsWithColumnPermutation(c *C) {
+	s.mockDB.
+		ExpectExec("\\QREPLACE INTO `foo`.`bar`(`a`,`c`) VALUES('x',1),(DEFAULT,2)\\E").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	ctx := context.Background()
+	logger := log.L()
+
+	engine, err := s.backend.OpenEngine(ctx, "`foo`.`bar`", 1)
+	c.Assert(err, IsNil)
+
+	dataRows := s.backend.MakeEmptyRows()
+	dataChecksum := verification.MakeKVChecksum(0, 0, 0)
+	indexRows := s.backend.MakeEmptyRows()
+	indexChecksum := verification.MakeKVChecksum(0, 0, 0)
+
+	// the source row is (c, ignored, a), and `b` is filled with the default value.
+	encoder, err := s.backend.NewEncoder(nil, &kv.SessionOptions{})
+	c.Assert(err, IsNil)
+	row, err := encoder.Encode(logger, types.MakeDatums(1, 2, "x"), 1, []int{2, -1, 0, -1})
+	c.Assert(err, IsNil)
+	row.ClassifyAndAppend(&dataRows, &dataChecksum, &indexRows, &indexChecksum)
+
+	// columns missing from a short row are filled with DEFAULT.
+	row, err = encoder.Encode(logger, types.MakeDatums(2), 2, []int{2, -1, 0, -1})
+	c.Assert(err, IsNil)
+	row.ClassifyAndAppend(&dataRows, &dataChecksum, &indexRows, &indexChecksum)
+
+	err = engine.WriteRows(ctx, []string{"a", "c"}, dataRows)
+	c.Assert(err, IsNil)
+}
+
+func (s *mysqlSuite) TestWriteRowsMultipleEndpoints(c *C) {
+	db1, mock1, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db1.Close()
+	db2, mock2, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db2.Close()
+
+	backend := kv.NewMultiTiDBBackend([]*sql.DB{db1, db2}, config.ReplaceOnDup, config.WriteModeInsert)
+	defer backend.Close()
+
+	ctx := context.Background()
+	engine, err := backend.OpenEngine(ctx, "`foo`.`bar`", 1)
+	c.Assert(err, IsNil)
+
+	encoder, err := backend.NewEncoder(nil, &kv.SessionOptions{})
+	c.Assert(err, IsNil)
+	row, err := encoder.Encode(log.L(), types.MakeDatums(1), 1, []int{0})
+	c.Assert(err, IsNil)
+	dataRows := backend.MakeEmptyRows()
+	dataChecksum := verification.MakeKVChecksum(0, 0, 0)
+	row.ClassifyAndAppend(&dataRows, &dataChecksum, nil, nil)
+
+	const stmt = "\\QREPLACE INTO `foo`.`bar`(`a`) VALUES(1)\\E"
+
+	// the writes are distributed round-robin among idle servers.
+	mock2.ExpectExec(stmt).WillReturnResult(sqlmock.NewResult(1, 1))
+	c.Assert(engine.WriteRows(ctx, []string{"a"}, dataRows), IsNil)
+
+	// the batch is retried on the other server if one server is broken...
+	mock1.ExpectExec(stmt).WillReturnError(driver.ErrBadConn)
+	mock2.ExpectExec(stmt).WillReturnResult(sqlmock.NewResult(1, 1))
+	c.Assert(engine.WriteRows(ctx, []string{"a"}, dataRows), IsNil)
+
+	// ... and the broken server is skipped afterwards.
+	mock2.ExpectExec(stmt).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock2.ExpectExec(stmt).WillReturnResult(sqlmock.NewResult(1, 1))
+	c.Assert(engine.WriteRows(ctx, []string{"a"}, dataRows), IsNil)
+	c.Assert(engine.WriteRows(ctx, []string{"a"}, dataRows), IsNil)
+
+	// errors caused by the statement itself are not retried elsewhere.
+	mock2.ExpectExec(stmt).WillReturnError(&gomysql.MySQLError{Number: 1146, Message: "Table 'foo.bar' doesn't exist"})
+	err = engine.WriteRows(ctx, []string{"a"}, dataRows)
+	c.Assert(err, ErrorMatches, ".*Table 'foo.bar' doesn't exist")
+
+	c.Assert(mock1.ExpectationsWereMet(), IsNil)
+	c.Assert(mock2.ExpectationsWereMet(), IsNil)
+}
+
+func (s *mysqlSuite) encodeRows(c *C, backend kv.Backend, columnPermutation []int, rows ...[]types.Datum) kv.Rows {
+	encoder, err := backend.NewEncoder(nil, &kv.SessionOptions{})
+	c.Assert(err, IsNil)
+	dataRows := backend.MakeEmptyRows()
+	dataChecksum := verification.MakeKVChecksum(0, 0, 0)
+	for i, row := range rows {
+		encoded, err := encoder.Encode(log.L(), row, int64(i+1), columnPermutation)
+		c.Assert(err, IsNil)
+		encoded.ClassifyAndAppend(&dataRows, &dataChecksum, nil, nil)
+	}
+	return dataRows
+}
+
+func (s *mysqlSuite) TestWriteRowsPrepared(c *C) {
+	backend := kv.NewMultiTiDBBackend([]*sql.DB{s.dbHandle}, config.IgnoreOnDup, config.WriteModePrepared)
+	defer backend.Close()
+
+	ctx := context.Background()
+	engine, err := backend.OpenEngine(ctx, "`foo`.`bar`", 1)
+	c.Assert(err, IsNil)
+
+	// the source row is (b, a). the row missing `a` is inserted with DEFAULT.
+	dataRows := s.encodeRows(c, backend, []int{1, 0},
+		types.MakeDatums(1, "x"),
+		types.MakeDatums(2),
+		types.MakeDatums(3, nil),
+		types.MakeDatums(4, "z\x00"),
+	)
+
+	// the rows are written in the source order.
+	s.mockDB.
+		ExpectPrepare("\\QINSERT IGNORE INTO `foo`.`bar`(`a`,`b`) VALUES(?,?)\\E").
+		ExpectExec().
+		WithArgs([]byte("x"), 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mockDB.
+		ExpectExec("\\QINSERT IGNORE INTO `foo`.`bar`(`a`,`b`) VALUES(DEFAULT,2)\\E").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mockDB.
+		ExpectPrepare("\\QINSERT IGNORE INTO `foo`.`bar`(`a`,`b`) VALUES(?,?),(?,?)\\E").
+		ExpectExec().
+		WithArgs(nil, 3, []byte("z\x00"), 4).
+		WillReturnResult(sqlmock.NewResult(2, 2))
+	err = engine.WriteRows(ctx, []string{"a", "b"}, dataRows)
+	c.Assert(err, IsNil)
+
+	// the prepared statements are reused.
+	dataRows = s.encodeRows(c, backend, []int{1, 0}, types.MakeDatums(5, "w"))
+	s.mockDB.
+		ExpectExec("\\QINSERT IGNORE INTO `foo`.`bar`(`a`,`b`) VALUES(?,?)\\E").
+		WithArgs([]byte("w"), 5).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	err = engine.WriteRows(ctx, []string{"a", "b"}, dataRows)
+	c.Assert(err, IsNil)
+}
+
+func (s *mysqlSuite) TestWriteRowsLoadData(c *C) {
+	backend := kv.NewMultiTiDBBackend([]*sql.DB{s.dbHandle}, config.ReplaceOnDup, config.WriteModeLoadData)
+	defer backend.Close()
+
+	ctx := context.Background()
+	engine, err := backend.OpenEngine(ctx, "`foo`.`bar`", 1)
+	c.Assert(err, IsNil)
+
+	dataRows := s.encodeRows(c, backend, nil,
+		types.MakeDatums(1, "x\ty"),
+		types.MakeDatums(2, nil),
+		[]types.Datum{types.NewIntDatum(3), types.NewBinaryLiteralDatum(types.NewBinaryLiteralFromUint(0xabcdef, 3))},
+	)
+
+	// the hexadecimal literal cannot be loaded as-is, so it is inserted
+	// after the rows before it are loaded.
+	s.mockDB.
+		ExpectExec("\\QLOAD DATA LOCAL INFILE 'Reader::lightning-\\E\\d+\\Q' REPLACE INTO TABLE `foo`.`bar` FIELDS TERMINATED BY '\\t' ESCAPED BY '\\\\' LINES TERMINATED BY '\\n'\\E$").
+		WillReturnResult(sqlmock.NewResult(2, 2))
+	s.mockDB.
+		ExpectExec("\\QREPLACE INTO `foo`.`bar` VALUES(3,0xabcdef)\\E").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	err = engine.WriteRows(ctx, nil, dataRows)
+	c.Assert(err, IsNil)
+
+	// the column names are listed after the options.
+	dataRows = s.encodeRows(c, backend, []int{0, 1}, types.MakeDatums(4, "w"))
+	s.mockDB.
+		ExpectExec("\\QLOAD DATA LOCAL INFILE 'Reader::lightning-\\E\\d+\\Q' REPLACE INTO TABLE `foo`.`bar` FIELDS TERMINATED BY '\\t' ESCAPED BY '\\\\' LINES TERMINATED BY '\\n' (`a`,`b`)\\E").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	err = engine.WriteRows(ctx, []string{"a", "b"}, dataRows)
+	c.Assert(err, IsNil)
+}
+
+func (s *mysqlSuite) mockTable(c *C, createSQL string) table.Table {
+	node, err := parser.New().ParseOneStmt(createSQL, "", "")
+	c.Assert(err, IsNil)
+	tblInfo, err := ddl.MockTableInfo(mock.NewContext(), node.(*ast.CreateTableStmt), 1)
+	c.Assert(err, IsNil)
+	tblInfo.State = model.StatePublic
+	tbl, err := tables.TableFromMeta(kv.NewPanickingAllocators(0), tblInfo)
+	c.Assert(err, IsNil)
+	return tbl
+}
+
+func (s *mysqlSuite) TestWriteRowsTableOnDuplicate(c *C) {
+	tbl := s.mockTable(c, "create table bar (id int primary key, u int unique key, v int, w int)")
+	ctx := context.Background()
+	engine, err := s.backend.OpenEngine(ctx, "`foo`.`bar`", 1)
+	c.Assert(err, IsNil)
+
+	encodeRows := func(onDuplicate string, row []types.Datum) kv.Rows {
+		encoder, err := s.backend.NewEncoder(tbl, &kv.SessionOptions{OnDuplicate: onDuplicate})
+		c.Assert(err, IsNil)
+		encoded, err := encoder.Encode(log.L(), row, 1, nil)
+		c.Assert(err, IsNil)
+		dataRows := s.backend.MakeEmptyRows()
+		dataChecksum := verification.MakeKVChecksum(0, 0, 0)
+		encoded.ClassifyAndAppend(&dataRows, &dataChecksum, nil, nil)
+		return dataRows
+	}
+
+	// the table overrides the action of the backend.
+	s.mockDB.
+		ExpectExec("\\QINSERT INTO `foo`.`bar`(`id`,`u`,`v`) VALUES(1,2,3)\\E$").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	err = engine.WriteRows(ctx, []string{"id", "u", "v"}, encodeRows(config.ErrorOnDup, types.MakeDatums(1, 2, 3)))
+	c.Assert(err, IsNil)
+
+	// upsert overwrites the inserted non-key columns.
+	s.mockDB.
+		ExpectExec("\\QINSERT INTO `foo`.`bar`(`ID`,`u`,`v`,`w`) VALUES(1,2,3,4) ON DUPLICATE KEY UPDATE `v`=VALUES(`v`),`w`=VALUES(`w`)\\E").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	err = engine.WriteRows(ctx, []string{"ID", "u", "v", "w"}, encodeRows(config.UpsertOnDup, types.MakeDatums(1, 2, 3, 4)))
+	c.Assert(err, IsNil)
+
+	// ... or ignores the duplicated rows if only keys are inserted.
+	s.mockDB.
+		ExpectExec("\\QINSERT IGNORE INTO `foo`.`bar`(`id`,`u`) VALUES(1,2)\\E$").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	err = engine.WriteRows(ctx, []string{"id", "u"}, encodeRows(config.UpsertOnDup, types.MakeDatums(1, 2)))
+	c.Assert(err, IsNil)
+
+	// without the override, the action of the backend is used.
+	s.mockDB.
+		ExpectExec("\\QREPLACE INTO `foo`.`bar`(`id`,`u`) VALUES(1,2)\\E$").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	err = engine.WriteRows(ctx, []string{"id", "u"}, encodeRows("", types.MakeDatums(1, 2)))
+	c.Assert(err, IsNil)
+}
+
+func (s *mysqlSuite) TestWriteRowsUpsertModes(c *C) {
+	tbl := s.mockTable(c, "create table bar (a int, b int, c int, unique key uk (b, a))")
+	ctx := context.Background()
+
+	for _, writeMode := range []string{config.WriteModePrepared, config.WriteModeLoadData} {
+		backend := kv.NewMultiTiDBBackend([]*sql.DB{s.dbHandle}, config.IgnoreOnDup, writeMode)
+		engine, err := backend.OpenEngine(ctx, "`foo`.`bar`", 1)
+		c.Assert(err, IsNil)
+
+		encoder, err := backend.NewEncoder(tbl, &kv.SessionOptions{OnDuplicate: config.UpsertOnDup})
+		c.Assert(err, IsNil)
+		encoded, err := encoder.Encode(log.L(), types.MakeDatums(1, 2, 3), 1, nil)
+		c.Assert(err, IsNil)
+		dataRows := backend.MakeEmptyRows()
+		dataChecksum := verification.MakeKVChecksum(0, 0, 0)
+		encoded.ClassifyAndAppend(&dataRows, &dataChecksum, nil, nil)
+
+		if writeMode == config.WriteModePrepared {
+			s.mockDB.
+				ExpectPrepare("\\QINSERT INTO `foo`.`bar`(`a`,`b`,`c`) VALUES(?,?,?) ON DUPLICATE KEY UPDATE `c`=VALUES(`c`)\\E").
+				ExpectExec().
+				WithArgs(1, 2, 3).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+		} else {
+			// LOAD DATA cannot update the duplicated rows, so INSERT is used.
+			s.mockDB.
+				ExpectExec("\\QINSERT INTO `foo`.`bar`(`a`,`b`,`c`) VALUES(1,2,3) ON DUPLICATE KEY UPDATE `c`=VALUES(`c`)\\E").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+		}
+		err = engine.WriteRows(ctx, []string{"a", "b", "c"}, dataRows)
+		c.Assert(err, IsNil, Commentf("write mode = %s", writeMode))
+		backend.Close()
+	}
+}
@@ -16,15 +16,18 @@ package checkpoints
 import (
 	"context"
 	"database/sql"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"math"
 	"os"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/joho/sqltocsv"
 	"github.com/pingcap/errors"
@@ -59,9 +62,10 @@ const WholeTableEngineID = math.MaxInt32
 const (
 	// the table names to store each kind of checkpoint in the checkpoint database
 	// remember to increase the version number in case of incompatible change.
-	checkpointTableNameTable  = "table_v5"
+	checkpointTableNameTable  = "table_v9"
 	checkpointTableNameEngine = "engine_v5"
-	checkpointTableNameChunk  = "chunk_v4"
+	checkpointTableNameChunk  = "chunk_v5"
+	checkpointTableNameTask   = "task_v1"
 )
 
 func (status CheckpointStatus) MetricName() string {
@@ -115,6 +119,9 @@ type ChunkCheckpoint struct {
 	Chunk             mydump.Chunk
 	Checksum          verify.KVChecksum
 	Timestamp         int64
+	// Fingerprint identifies the content of the source file when the chunk
+	// was created, see mydump.FileFingerprint. Empty if unknown.
+	Fingerprint string
 }
 
 func (ccp *ChunkCheckpoint) DeepCopy() *ChunkCheckpoint {
@@ -126,6 +133,7 @@ func (ccp *ChunkCheckpoint) DeepCopy() *ChunkCheckpoint {
 		Chunk:             ccp.Chunk,
 		Checksum:          ccp.Checksum,
 		Timestamp:         ccp.Timestamp,
+		Fingerprint:       ccp.Fingerprint,
 	}
 }
 
@@ -145,10 +153,37 @@ func (engine *EngineCheckpoint) DeepCopy() *EngineCheckpoint {
 	}
 }
 
+// TaskCheckpoint records the task-wide state which the table checkpoints
+// depend on.
+type TaskCheckpoint struct {
+	// TaskID is the ID of the task which created the checkpoints.
+	TaskID int64
+	// ConfigFingerprint is the normalized form of the data-affecting settings
+	// of the task, see (*config.Config).Fingerprint().
+	ConfigFingerprint string
+}
+
 type TableCheckpoint struct {
 	Status    CheckpointStatus
 	AllocBase int64
 	Engines   map[int32]*EngineCheckpoint
+	// BaseChecksum is the checksum of the data already existing in the table
+	// before an incremental import.
+	BaseChecksum verify.KVChecksum
+	// TimeZone is the time zone used to encode the table. Empty if the table
+	// has not been encoded yet.
+	TimeZone string
+	// OnDuplicate is the action on duplicated rows used to write the table
+	// with the TiDB backend. Empty if the table has not been written yet.
+	OnDuplicate string
+	// Masks is the fingerprint of the column masks used to encode the table.
+	// Empty if there are no masks.
+	Masks string
+	// TaskID is the ID of the task which last initialized the table.
+	TaskID int64
+	// RowIDBase is the row ID the first chunk of the table starts after, which
+	// is the largest handle existing before an incremental import.
+	RowIDBase int64
 }
 
 func (cp *TableCheckpoint) DeepCopy() *TableCheckpoint {
@@ -157,9 +192,15 @@ func (cp *TableCheckpoint) DeepCopy() *TableCheckpoint {
 		engines[engineID] = engine.DeepCopy()
 	}
 	return &TableCheckpoint{
-		Status:    cp.Status,
-		AllocBase: cp.AllocBase,
-		Engines:   engines,
+		Status:       cp.Status,
+		AllocBase:    cp.AllocBase,
+		Engines:      engines,
+		BaseChecksum: cp.BaseChecksum,
+		TimeZone:     cp.TimeZone,
+		OnDuplicate:  cp.OnDuplicate,
+		Masks:        cp.Masks,
+		TaskID:       cp.TaskID,
+		RowIDBase:    cp.RowIDBase,
 	}
 }
 func (cp *TableCheckpoint) CountChunks() int {
@@ -183,11 +224,15 @@ type engineCheckpointDiff struct {
 }
 
 type TableCheckpointDiff struct {
-	hasStatus bool
-	hasRebase bool
-	status    CheckpointStatus
-	allocBase int64
-	engines   map[int32]engineCheckpointDiff
+	hasStatus   bool
+	hasRebase   bool
+	hasSettings bool
+	hasTaskID   bool
+	status      CheckpointStatus
+	allocBase   int64
+	settings    TableSettingsCheckpointMerger
+	taskID      int64
+	engines     map[int32]engineCheckpointDiff
 }
 
 func NewTableCheckpointDiff() *TableCheckpointDiff {
@@ -225,6 +270,16 @@ func (cp *TableCheckpoint) Apply(cpd *TableCheckpointDiff) {
 	if cpd.hasRebase {
 		cp.AllocBase = cpd.allocBase
 	}
+	if cpd.hasSettings {
+		cp.BaseChecksum = cpd.settings.BaseChecksum
+		cp.TimeZone = cpd.settings.TimeZone
+		cp.OnDuplicate = cpd.settings.OnDuplicate
+		cp.Masks = cpd.settings.Masks
+		cp.RowIDBase = cpd.settings.RowIDBase
+	}
+	if cpd.hasTaskID {
+		cp.TaskID = cpd.taskID
+	}
 	for engineID, engineDiff := range cpd.engines {
 		engine := cp.Engines[engineID]
 		if engine == nil {
@@ -312,6 +367,31 @@ func (merger *RebaseCheckpointMerger) MergeInto(cpd *TableCheckpointDiff) {
 	cpd.allocBase = mathutil.MaxInt64(cpd.allocBase, merger.AllocBase)
 }
 
+// TableSettingsCheckpointMerger records the settings a table is imported
+// with, which must stay the same when the table is resumed. See the fields of
+// the same names in TableCheckpoint.
+type TableSettingsCheckpointMerger struct {
+	BaseChecksum verify.KVChecksum
+	TimeZone     string
+	OnDuplicate  string
+	Masks        string
+	RowIDBase    int64
+}
+
+func (merger *TableSettingsCheckpointMerger) MergeInto(cpd *TableCheckpointDiff) {
+	cpd.hasSettings = true
+	cpd.settings = *merger
+}
+
+type TaskIDCheckpointMerger struct {
+	TaskID int64
+}
+
+func (merger *TaskIDCheckpointMerger) MergeInto(cpd *TableCheckpointDiff) {
+	cpd.hasTaskID = true
+	cpd.taskID = merger.TaskID
+}
+
 type DestroyedTableCheckpoint struct {
 	TableName   string
 	MinEngineID int32
@@ -327,13 +407,26 @@ type CheckpointsDB interface {
 	// default values for the column permutations and checksums.
 	InsertEngineCheckpoints(ctx context.Context, tableName string, checkpoints map[int32]*EngineCheckpoint) error
 	Update(checkpointDiffs map[string]*TableCheckpointDiff)
+	// TaskCheckpoint returns the task checkpoint, or nil if it has not been
+	// recorded yet.
+	TaskCheckpoint(ctx context.Context) (*TaskCheckpoint, error)
+	// UpdateTaskCheckpoint records the task checkpoint, replacing the existing
+	// one.
+	UpdateTaskCheckpoint(ctx context.Context, cp *TaskCheckpoint) error
 
 	RemoveCheckpoint(ctx context.Context, tableName string) error
 	// MoveCheckpoints renames the checkpoint schema to include a suffix
 	// including the taskID (e.g. `tidb_lightning_checkpoints.1234567890.bak`).
 	MoveCheckpoints(ctx context.Context, taskID int64) error
 	IgnoreErrorCheckpoint(ctx context.Context, tableName string) error
+	// ResetEngineCheckpoints sets the table status back to
+	// CheckpointStatusLoaded, and overwrites the status of the given engines
+	// and the progress, checksum and fingerprint of the given chunks, so that
+	// they will be imported again. Chunks not given are unchanged.
+	ResetEngineCheckpoints(ctx context.Context, tableName string, engines map[int32]*EngineCheckpoint) error
 	DestroyErrorCheckpoint(ctx context.Context, tableName string) ([]DestroyedTableCheckpoint, error)
+	// ListTables returns the sorted names of all tables having checkpoints.
+	ListTables(ctx context.Context) ([]string, error)
 	DumpTables(ctx context.Context, csv io.Writer) error
 	DumpEngines(ctx context.Context, csv io.Writer) error
 	DumpChunks(ctx context.Context, csv io.Writer) error
@@ -366,6 +459,14 @@ func (*NullCheckpointsDB) InsertEngineCheckpoints(_ context.Context, _ string, _
 
 func (*NullCheckpointsDB) Update(map[string]*TableCheckpointDiff) {}
 
+func (*NullCheckpointsDB) TaskCheckpoint(context.Context) (*TaskCheckpoint, error) {
+	return nil, nil
+}
+
+func (*NullCheckpointsDB) UpdateTaskCheckpoint(context.Context, *TaskCheckpoint) error {
+	return nil
+}
+
 type MySQLCheckpointsDB struct {
 	db     *sql.DB
 	schema string
@@ -396,6 +497,13 @@ func NewMySQLCheckpointsDB(ctx context.Context, db *sql.DB, schemaName string, t
 			hash binary(32) NOT NULL,
 			status tinyint unsigned DEFAULT 30,
 			alloc_base bigint NOT NULL DEFAULT 0,
+			kvc_bytes bigint unsigned NOT NULL DEFAULT 0,
+			kvc_kvs bigint unsigned NOT NULL DEFAULT 0,
+			kvc_checksum bigint unsigned NOT NULL DEFAULT 0,
+			time_zone varchar(64) NOT NULL DEFAULT '',
+			on_duplicate varchar(16) NOT NULL DEFAULT '',
+			masks varchar(8192) NOT NULL DEFAULT '',
+			row_id_base bigint NOT NULL DEFAULT 0,
 			create_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			update_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 			INDEX(task_id)
@@ -434,6 +542,7 @@ func NewMySQLCheckpointsDB(ctx context.Context, db *sql.DB, schemaName string, t
 			kvc_bytes bigint unsigned NOT NULL DEFAULT 0,
 			kvc_kvs bigint unsigned NOT NULL DEFAULT 0,
 			kvc_checksum bigint unsigned NOT NULL DEFAULT 0,
+			fingerprint varchar(255) NOT NULL DEFAULT '',
 			create_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			update_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 			PRIMARY KEY(table_name, engine_id, path(500), offset)
@@ -443,6 +552,27 @@ func NewMySQLCheckpointsDB(ctx context.Context, db *sql.DB, schemaName string, t
 		return nil, errors.Trace(err)
 	}
 
+	err = sql.Exec(ctx, "create task checkpoints table", fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s.%s (
+			id tinyint unsigned NOT NULL PRIMARY KEY,
+			task_id bigint NOT NULL,
+			config_fingerprint text NOT NULL,
+			create_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			update_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+		);
+	`, schema, checkpointTableNameTask))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err := createTaskHistoryTable(ctx, sql, schema); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err := createTableLeaseTable(ctx, sql, schema); err != nil {
+		return nil, errors.Trace(err)
+	}
+
 	return &MySQLCheckpointsDB{
 		db:     db,
 		schema: schema,
@@ -539,7 +669,7 @@ func (cpdb *MySQLCheckpointsDB) Get(ctx context.Context, tableName string) (*Tab
 			SELECT
 				engine_id, path, offset, columns,
 				pos, end_offset, prev_rowid_max, rowid_max,
-				kvc_bytes, kvc_kvs, kvc_checksum, unix_timestamp(create_time)
+				kvc_bytes, kvc_kvs, kvc_checksum, fingerprint, unix_timestamp(create_time)
 			FROM %s.%s WHERE table_name = ?
 			ORDER BY engine_id, path, offset;
 		`, cpdb.schema, checkpointTableNameChunk)
@@ -560,7 +690,7 @@ func (cpdb *MySQLCheckpointsDB) Get(ctx context.Context, tableName string) (*Tab
 			if err := chunkRows.Scan(
 				&engineID, &value.Key.Path, &value.Key.Offset, &colPerm,
 				&value.Chunk.Offset, &value.Chunk.EndOffset, &value.Chunk.PrevRowIDMax, &value.Chunk.RowIDMax,
-				&kvcBytes, &kvcKVs, &kvcChecksum, &value.Timestamp,
+				&kvcBytes, &kvcKVs, &kvcChecksum, &value.Fingerprint, &value.Timestamp,
 			); err != nil {
 				return errors.Trace(err)
 			}
@@ -577,15 +707,21 @@ func (cpdb *MySQLCheckpointsDB) Get(ctx context.Context, tableName string) (*Tab
 		// 3. Fill in the remaining table info
 
 		tableQuery := fmt.Sprintf(`
-			SELECT status, alloc_base FROM %s.%s WHERE table_name = ?
+			SELECT status, alloc_base, kvc_bytes, kvc_kvs, kvc_checksum, time_zone, on_duplicate, masks, task_id, row_id_base FROM %s.%s WHERE table_name = ?
 		`, cpdb.schema, checkpointTableNameTable)
 		tableRow := tx.QueryRowContext(c, tableQuery, tableName)
 
-		var status uint8
-		if err := tableRow.Scan(&status, &cp.AllocBase); err != nil {
+		var (
+			status      uint8
+			kvcBytes    uint64
+			kvcKVs      uint64
+			kvcChecksum uint64
+		)
+		if err := tableRow.Scan(&status, &cp.AllocBase, &kvcBytes, &kvcKVs, &kvcChecksum, &cp.TimeZone, &cp.OnDuplicate, &cp.Masks, &cp.TaskID, &cp.RowIDBase); err != nil {
 			return errors.Trace(err)
 		}
 		cp.Status = CheckpointStatus(status)
+		cp.BaseChecksum = verify.MakeKVChecksum(kvcBytes, kvcKVs, kvcChecksum)
 		return nil
 	})
 	if err != nil {
@@ -614,12 +750,12 @@ func (cpdb *MySQLCheckpointsDB) InsertEngineCheckpoints(ctx context.Context, tab
 				table_name, engine_id,
 				path, offset, columns, should_include_row_id,
 				pos, end_offset, prev_rowid_max, rowid_max,
-				kvc_bytes, kvc_kvs, kvc_checksum, create_time
+				kvc_bytes, kvc_kvs, kvc_checksum, fingerprint, create_time
 			) VALUES (
 				?, ?,
 				?, ?, '[]', FALSE,
 				?, ?, ?, ?,
-				0, 0, 0, from_unixtime(?)
+				0, 0, 0, ?, from_unixtime(?)
 			);
 		`, cpdb.schema, checkpointTableNameChunk))
 		if err != nil {
@@ -637,7 +773,7 @@ func (cpdb *MySQLCheckpointsDB) InsertEngineCheckpoints(ctx context.Context, tab
 					c, tableName, engineID,
 					value.Key.Path, value.Key.Offset,
 					value.Chunk.Offset, value.Chunk.EndOffset, value.Chunk.PrevRowIDMax, value.Chunk.RowIDMax,
-					value.Timestamp,
+					value.Fingerprint, value.Timestamp,
 				)
 				if err != nil {
 					return errors.Trace(err)
@@ -665,6 +801,14 @@ func (cpdb *MySQLCheckpointsDB) Update(checkpointDiffs map[string]*TableCheckpoi
 	tableStatusQuery := fmt.Sprintf(`
 		UPDATE %s.%s SET status = ? WHERE table_name = ?;
 	`, cpdb.schema, checkpointTableNameTable)
+	tableSettingsQuery := fmt.Sprintf(`
+		UPDATE %s.%s SET kvc_bytes = ?, kvc_kvs = ?, kvc_checksum = ?, time_zone = ?, on_duplicate = ?, masks = ?,
+			row_id_base = ?
+		WHERE table_name = ?;
+	`, cpdb.schema, checkpointTableNameTable)
+	taskIDQuery := fmt.Sprintf(`
+		UPDATE %s.%s SET task_id = ? WHERE table_name = ?;
+	`, cpdb.schema, checkpointTableNameTable)
 	engineStatusQuery := fmt.Sprintf(`
 		UPDATE %s.%s SET status = ? WHERE (table_name, engine_id) = (?, ?);
 	`, cpdb.schema, checkpointTableNameEngine)
@@ -686,6 +830,16 @@ func (cpdb *MySQLCheckpointsDB) Update(checkpointDiffs map[string]*TableCheckpoi
 			return errors.Trace(e)
 		}
 		defer tableStatusStmt.Close()
+		tableSettingsStmt, e := tx.PrepareContext(c, tableSettingsQuery)
+		if e != nil {
+			return errors.Trace(e)
+		}
+		defer tableSettingsStmt.Close()
+		taskIDStmt, e := tx.PrepareContext(c, taskIDQuery)
+		if e != nil {
+			return errors.Trace(e)
+		}
+		defer taskIDStmt.Close()
 		engineStatusStmt, e := tx.PrepareContext(c, engineStatusQuery)
 		if e != nil {
 			return errors.Trace(e)
@@ -703,6 +857,23 @@ func (cpdb *MySQLCheckpointsDB) Update(checkpointDiffs map[string]*TableCheckpoi
 					return errors.Trace(e)
 				}
 			}
+			if cpd.hasSettings {
+				settings := &cpd.settings
+				if _, e := tableSettingsStmt.ExecContext(
+					c,
+					settings.BaseChecksum.SumSize(), settings.BaseChecksum.SumKVS(), settings.BaseChecksum.Sum(),
+					settings.TimeZone, settings.OnDuplicate, settings.Masks,
+					settings.RowIDBase,
+					tableName,
+				); e != nil {
+					return errors.Trace(e)
+				}
+			}
+			if cpd.hasTaskID {
+				if _, e := taskIDStmt.ExecContext(c, cpd.taskID, tableName); e != nil {
+					return errors.Trace(e)
+				}
+			}
 			for engineID, engineDiff := range cpd.engines {
 				if engineDiff.hasStatus {
 					if _, e := engineStatusStmt.ExecContext(c, engineDiff.status, tableName, engineID); e != nil {
@@ -728,58 +899,154 @@ func (cpdb *MySQLCheckpointsDB) Update(checkpointDiffs map[string]*TableCheckpoi
 	}
 }
 
+func (cpdb *MySQLCheckpointsDB) TaskCheckpoint(ctx context.Context) (*TaskCheckpoint, error) {
+	s := common.SQLWithRetry{
+		DB:     cpdb.db,
+		Logger: log.L(),
+	}
+
+	query := fmt.Sprintf(`
+		SELECT task_id, config_fingerprint FROM %s.%s WHERE id = 1;
+	`, cpdb.schema, checkpointTableNameTask)
+
+	var cp *TaskCheckpoint
+	err := s.Transact(ctx, "read task checkpoint", func(c context.Context, tx *sql.Tx) error {
+		cp = &TaskCheckpoint{}
+		err := tx.QueryRowContext(c, query).Scan(&cp.TaskID, &cp.ConfigFingerprint)
+		if err == sql.ErrNoRows {
+			// the task checkpoint is not recorded yet.
+			cp = nil
+			return nil
+		}
+		return errors.Trace(err)
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return cp, nil
+}
+
+func (cpdb *MySQLCheckpointsDB) UpdateTaskCheckpoint(ctx context.Context, cp *TaskCheckpoint) error {
+	s := common.SQLWithRetry{
+		DB:     cpdb.db,
+		Logger: log.With(zap.Int64("taskID", cp.TaskID)),
+	}
+	return s.Exec(ctx, "update task checkpoint", fmt.Sprintf(`
+		REPLACE INTO %s.%s (id, task_id, config_fingerprint) VALUES (1, ?, ?);
+	`, cpdb.schema, checkpointTableNameTask), cp.TaskID, cp.ConfigFingerprint)
+}
+
 type FileCheckpointsDB struct {
 	lock        sync.Mutex // we need to ensure only a thread can access to `checkpoints` at a time
 	checkpoints CheckpointsModel
 	path        string
+	taskID      int64
+
+	// journal is the opened journal file, or nil if it needs to be recreated.
+	journal      *os.File
+	journalSize  int64
+	snapshotSize int64
 }
 
-func NewFileCheckpointsDB(path string) *FileCheckpointsDB {
+// NewFileCheckpointsDB opens the checkpoints stored at `path`, replaying the
+// journal on top of the snapshot. A torn tail of the journal left by a crash
+// is discarded, while a broken snapshot is reported as an error.
+func NewFileCheckpointsDB(path string, taskID int64) (*FileCheckpointsDB, error) {
 	cpdb := &FileCheckpointsDB{
-		path: path,
+		path:   path,
+		taskID: taskID,
 		checkpoints: CheckpointsModel{
 			Checkpoints: map[string]*TableCheckpointModel{},
 		},
 	}
-	// ignore all errors -- file maybe not created yet (and it is fine).
-	content, err := ioutil.ReadFile(path)
-	if err == nil {
-		err2 := cpdb.checkpoints.Unmarshal(content)
-		if err2 != nil {
-			log.L().Error("checkpoint file is broken", zap.String("path", path), zap.Error(err2))
-		}
-		// FIXME: patch for empty map may need initialize manually, because currently
-		// FIXME: a map of zero size -> marshall -> unmarshall -> become nil, see checkpoint_test.go
-		if cpdb.checkpoints.Checkpoints == nil {
-			cpdb.checkpoints.Checkpoints = map[string]*TableCheckpointModel{}
-		}
-		for _, table := range cpdb.checkpoints.Checkpoints {
-			if table.Engines == nil {
-				table.Engines = map[int32]*EngineCheckpointModel{}
-			}
-			for _, engine := range table.Engines {
-				if engine.Chunks == nil {
-					engine.Chunks = map[string]*ChunkCheckpointModel{}
-				}
-			}
+	journalSize, err := loadFileCheckpoints(path, &cpdb.checkpoints)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cpdb.snapshotSize = int64(cpdb.checkpoints.Size())
+
+	if journalSize >= 0 {
+		journal, err := os.OpenFile(journalPath(path), os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, errors.Trace(err)
 		}
-	} else {
-		log.L().Info("open checkpoint file failed, going to create a new one",
-			zap.String("path", path),
-			log.ShortError(err),
-		)
+		if err := journal.Truncate(journalSize); err != nil {
+			journal.Close()
+			return nil, errors.Trace(err)
+		}
+		if _, err := journal.Seek(journalSize, io.SeekStart); err != nil {
+			journal.Close()
+			return nil, errors.Trace(err)
+		}
+		cpdb.journal = journal
+		cpdb.journalSize = journalSize
 	}
-	return cpdb
+	return cpdb, nil
 }
 
+// save compacts the checkpoints into a new snapshot and starts a new journal.
 func (cpdb *FileCheckpointsDB) save() error {
+	cpdb.checkpoints.JournalId++
 	serialized, err := cpdb.checkpoints.Marshal()
+	if err == nil {
+		err = writeFileAtomic(cpdb.path, serialized)
+	}
+	if err != nil {
+		cpdb.checkpoints.JournalId--
+		return errors.Trace(err)
+	}
+	cpdb.snapshotSize = int64(len(serialized))
+	return errors.Trace(cpdb.resetJournal())
+}
+
+// resetJournal truncates the journal to contain the header only.
+func (cpdb *FileCheckpointsDB) resetJournal() error {
+	cpdb.closeJournal()
+
+	journal, err := os.OpenFile(journalPath(cpdb.path), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	header := encodeJournalHeader(cpdb.checkpoints.JournalId)
+	if _, err = journal.Write(header); err == nil {
+		err = journal.Sync()
+	}
 	if err != nil {
+		journal.Close()
 		return errors.Trace(err)
 	}
-	if err := ioutil.WriteFile(cpdb.path, serialized, 0644); err != nil {
+	cpdb.journal = journal
+	cpdb.journalSize = int64(len(header))
+	return nil
+}
+
+func (cpdb *FileCheckpointsDB) closeJournal() {
+	if cpdb.journal != nil {
+		cpdb.journal.Close()
+		cpdb.journal = nil
+	}
+}
+
+// appendJournal durably appends a record to the journal.
+func (cpdb *FileCheckpointsDB) appendJournal(record *CheckpointsModel) error {
+	buf, err := encodeJournalRecord(record)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cpdb.journal == nil {
+		if err := cpdb.resetJournal(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if _, err = cpdb.journal.Write(buf); err == nil {
+		err = cpdb.journal.Sync()
+	}
+	if err != nil {
+		// the journal may now end with a partial record, don't append after it.
+		cpdb.closeJournal()
 		return errors.Trace(err)
 	}
+	cpdb.journalSize += int64(len(buf))
 	return nil
 }
 
@@ -794,12 +1061,15 @@ func (cpdb *FileCheckpointsDB) Initialize(ctx context.Context, dbInfo map[string
 	for _, db := range dbInfo {
 		for _, table := range db.Tables {
 			tableName := common.UniqueTable(db.Name, table.Name)
-			if _, ok := cpdb.checkpoints.Checkpoints[tableName]; !ok {
-				cpdb.checkpoints.Checkpoints[tableName] = &TableCheckpointModel{
+			tableModel, ok := cpdb.checkpoints.Checkpoints[tableName]
+			if !ok {
+				tableModel = &TableCheckpointModel{
 					Status:  uint32(CheckpointStatusLoaded),
 					Engines: map[int32]*EngineCheckpointModel{},
 				}
+				cpdb.checkpoints.Checkpoints[tableName] = tableModel
 			}
+			tableModel.TaskId = cpdb.taskID
 			// TODO check if hash matches
 		}
 	}
@@ -811,7 +1081,9 @@ func (cpdb *FileCheckpointsDB) Close() error {
 	cpdb.lock.Lock()
 	defer cpdb.lock.Unlock()
 
-	return errors.Trace(cpdb.save())
+	err := cpdb.save()
+	cpdb.closeJournal()
+	return errors.Trace(err)
 }
 
 func (cpdb *FileCheckpointsDB) Get(_ context.Context, tableName string) (*TableCheckpoint, error) {
@@ -824,9 +1096,15 @@ func (cpdb *FileCheckpointsDB) Get(_ context.Context, tableName string) (*TableC
 	}
 
 	cp := &TableCheckpoint{
-		Status:    CheckpointStatus(tableModel.Status),
-		AllocBase: tableModel.AllocBase,
-		Engines:   make(map[int32]*EngineCheckpoint, len(tableModel.Engines)),
+		Status:       CheckpointStatus(tableModel.Status),
+		AllocBase:    tableModel.AllocBase,
+		Engines:      make(map[int32]*EngineCheckpoint, len(tableModel.Engines)),
+		BaseChecksum: verify.MakeKVChecksum(tableModel.KvcBytes, tableModel.KvcKvs, tableModel.KvcChecksum),
+		TimeZone:     tableModel.TimeZone,
+		OnDuplicate:  tableModel.OnDuplicate,
+		Masks:        tableModel.Masks,
+		TaskID:       tableModel.TaskId,
+		RowIDBase:    tableModel.RowIdBase,
 	}
 
 	for engineID, engineModel := range tableModel.Engines {
@@ -852,8 +1130,9 @@ func (cpdb *FileCheckpointsDB) Get(_ context.Context, tableName string) (*TableC
 					PrevRowIDMax: chunkModel.PrevRowidMax,
 					RowIDMax:     chunkModel.RowidMax,
 				},
-				Checksum:  verify.MakeKVChecksum(chunkModel.KvcBytes, chunkModel.KvcKvs, chunkModel.KvcChecksum),
-				Timestamp: chunkModel.Timestamp,
+				Checksum:    verify.MakeKVChecksum(chunkModel.KvcBytes, chunkModel.KvcKvs, chunkModel.KvcChecksum),
+				Timestamp:   chunkModel.Timestamp,
+				Fingerprint: chunkModel.Fingerprint,
 			})
 		}
 
@@ -892,6 +1171,7 @@ func (cpdb *FileCheckpointsDB) InsertEngineCheckpoints(_ context.Context, tableN
 			chunk.PrevRowidMax = value.Chunk.PrevRowIDMax
 			chunk.RowidMax = value.Chunk.RowIDMax
 			chunk.Timestamp = value.Timestamp
+			chunk.Fingerprint = value.Fingerprint
 		}
 		tableModel.Engines[engineID] = engineModel
 	}
@@ -903,6 +1183,10 @@ func (cpdb *FileCheckpointsDB) Update(checkpointDiffs map[string]*TableCheckpoin
 	cpdb.lock.Lock()
 	defer cpdb.lock.Unlock()
 
+	record := &CheckpointsModel{
+		Checkpoints: make(map[string]*TableCheckpointModel, len(checkpointDiffs)),
+	}
+
 	for tableName, cpd := range checkpointDiffs {
 		tableModel := cpdb.checkpoints.Checkpoints[tableName]
 		if cpd.hasStatus {
@@ -911,6 +1195,18 @@ func (cpdb *FileCheckpointsDB) Update(checkpointDiffs map[string]*TableCheckpoin
 		if cpd.hasRebase {
 			tableModel.AllocBase = cpd.allocBase
 		}
+		if cpd.hasSettings {
+			tableModel.KvcBytes = cpd.settings.BaseChecksum.SumSize()
+			tableModel.KvcKvs = cpd.settings.BaseChecksum.SumKVS()
+			tableModel.KvcChecksum = cpd.settings.BaseChecksum.Sum()
+			tableModel.TimeZone = cpd.settings.TimeZone
+			tableModel.OnDuplicate = cpd.settings.OnDuplicate
+			tableModel.Masks = cpd.settings.Masks
+			tableModel.RowIdBase = cpd.settings.RowIDBase
+		}
+		if cpd.hasTaskID {
+			tableModel.TaskId = cpd.taskID
+		}
 		for engineID, engineDiff := range cpd.engines {
 			engineModel := tableModel.Engines[engineID]
 			if engineDiff.hasStatus {
@@ -926,11 +1222,76 @@ func (cpdb *FileCheckpointsDB) Update(checkpointDiffs map[string]*TableCheckpoin
 				chunkModel.KvcChecksum = diff.checksum.Sum()
 			}
 		}
+
+		record.Checkpoints[tableName] = journalTableRecord(tableModel, cpd)
 	}
 
-	if err := cpdb.save(); err != nil {
-		log.L().Error("save checkpoint failed", zap.Error(err))
+	if err := cpdb.appendJournal(record); err != nil {
+		log.L().Warn("append checkpoint journal failed, going to save a snapshot instead", zap.Error(err))
+		if err := cpdb.save(); err != nil {
+			log.L().Error("save checkpoint failed", zap.Error(err))
+		}
+		return
+	}
+
+	if cpdb.journalSize > journalCompactMinSize && cpdb.journalSize > cpdb.snapshotSize {
+		if err := cpdb.save(); err != nil {
+			log.L().Error("compact checkpoint journal failed", zap.Error(err))
+		}
+	}
+}
+
+// journalTableRecord extracts the parts of the table checkpoint touched by
+// the diff, to be recorded in the journal.
+func journalTableRecord(tableModel *TableCheckpointModel, cpd *TableCheckpointDiff) *TableCheckpointModel {
+	record := &TableCheckpointModel{
+		Status:      tableModel.Status,
+		AllocBase:   tableModel.AllocBase,
+		KvcBytes:    tableModel.KvcBytes,
+		KvcKvs:      tableModel.KvcKvs,
+		KvcChecksum: tableModel.KvcChecksum,
+		TimeZone:    tableModel.TimeZone,
+		OnDuplicate: tableModel.OnDuplicate,
+		Masks:       tableModel.Masks,
+		TaskId:      tableModel.TaskId,
+		RowIdBase:   tableModel.RowIdBase,
+		Engines:     make(map[int32]*EngineCheckpointModel, len(cpd.engines)),
 	}
+	for engineID, engineDiff := range cpd.engines {
+		engineModel := tableModel.Engines[engineID]
+		engineRecord := &EngineCheckpointModel{
+			Status: engineModel.Status,
+			Chunks: make(map[string]*ChunkCheckpointModel, len(engineDiff.chunks)),
+		}
+		for key := range engineDiff.chunks {
+			keyStr := key.String()
+			engineRecord.Chunks[keyStr] = engineModel.Chunks[keyStr]
+		}
+		record.Engines[engineID] = engineRecord
+	}
+	return record
+}
+
+func (cpdb *FileCheckpointsDB) TaskCheckpoint(context.Context) (*TaskCheckpoint, error) {
+	cpdb.lock.Lock()
+	defer cpdb.lock.Unlock()
+
+	if len(cpdb.checkpoints.ConfigFingerprint) == 0 {
+		return nil, nil
+	}
+	return &TaskCheckpoint{
+		TaskID:            cpdb.checkpoints.TaskId,
+		ConfigFingerprint: cpdb.checkpoints.ConfigFingerprint,
+	}, nil
+}
+
+func (cpdb *FileCheckpointsDB) UpdateTaskCheckpoint(_ context.Context, cp *TaskCheckpoint) error {
+	cpdb.lock.Lock()
+	defer cpdb.lock.Unlock()
+
+	cpdb.checkpoints.TaskId = cp.TaskID
+	cpdb.checkpoints.ConfigFingerprint = cp.ConfigFingerprint
+	return errors.Trace(cpdb.save())
 }
 
 // Management functions ----------------------------------------------------------------------------
@@ -946,9 +1307,15 @@ func (*NullCheckpointsDB) MoveCheckpoints(context.Context, int64) error {
 func (*NullCheckpointsDB) IgnoreErrorCheckpoint(context.Context, string) error {
 	return errors.Trace(cannotManageNullDB)
 }
+func (*NullCheckpointsDB) ResetEngineCheckpoints(context.Context, string, map[int32]*EngineCheckpoint) error {
+	return errors.Trace(cannotManageNullDB)
+}
 func (*NullCheckpointsDB) DestroyErrorCheckpoint(context.Context, string) ([]DestroyedTableCheckpoint, error) {
 	return nil, errors.Trace(cannotManageNullDB)
 }
+func (*NullCheckpointsDB) ListTables(context.Context) ([]string, error) {
+	return nil, errors.Trace(cannotManageNullDB)
+}
 func (*NullCheckpointsDB) DumpTables(context.Context, io.Writer) error {
 	return errors.Trace(cannotManageNullDB)
 }
@@ -966,7 +1333,12 @@ func (cpdb *MySQLCheckpointsDB) RemoveCheckpoint(ctx context.Context, tableName
 	}
 
 	if tableName == "all" {
-		return s.Exec(ctx, "remove all checkpoints", "DROP SCHEMA "+cpdb.schema)
+		// drop the checkpoint tables only, the task history should be kept.
+		return s.Exec(ctx, "remove all checkpoints", fmt.Sprintf(
+			"DROP TABLE IF EXISTS %[1]s.%[2]s, %[1]s.%[3]s, %[1]s.%[4]s, %[1]s.%[5]s, %[1]s.%[6]s",
+			cpdb.schema, checkpointTableNameChunk, checkpointTableNameEngine, checkpointTableNameTable, checkpointTableNameTask,
+			checkpointTableNameLease,
+		))
 	}
 
 	deleteChunkQuery := fmt.Sprintf("DELETE FROM %s.%s WHERE table_name = ?", cpdb.schema, checkpointTableNameChunk)
@@ -1001,6 +1373,8 @@ func (cpdb *MySQLCheckpointsDB) MoveCheckpoints(ctx context.Context, taskID int6
 	moveChunkQuery := fmt.Sprintf("RENAME TABLE %[1]s.%[3]s TO %[2]s.%[3]s", cpdb.schema, newSchema, checkpointTableNameChunk)
 	moveEngineQuery := fmt.Sprintf("RENAME TABLE %[1]s.%[3]s TO %[2]s.%[3]s", cpdb.schema, newSchema, checkpointTableNameEngine)
 	moveTableQuery := fmt.Sprintf("RENAME TABLE %[1]s.%[3]s TO %[2]s.%[3]s", cpdb.schema, newSchema, checkpointTableNameTable)
+	moveTaskQuery := fmt.Sprintf("RENAME TABLE %[1]s.%[3]s TO %[2]s.%[3]s", cpdb.schema, newSchema, checkpointTableNameTask)
+	moveLeaseQuery := fmt.Sprintf("RENAME TABLE %[1]s.%[3]s TO %[2]s.%[3]s", cpdb.schema, newSchema, checkpointTableNameLease)
 
 	if e := s.Exec(ctx, "create backup checkpoints schema", createSchemaQuery); e != nil {
 		return e
@@ -1014,6 +1388,12 @@ func (cpdb *MySQLCheckpointsDB) MoveCheckpoints(ctx context.Context, taskID int6
 	if e := s.Exec(ctx, "move table checkpoints table", moveTableQuery); e != nil {
 		return e
 	}
+	if e := s.Exec(ctx, "move task checkpoints table", moveTaskQuery); e != nil {
+		return e
+	}
+	if e := s.Exec(ctx, "move table leases table", moveLeaseQuery); e != nil {
+		return e
+	}
 	return nil
 }
 
@@ -1050,6 +1430,48 @@ func (cpdb *MySQLCheckpointsDB) IgnoreErrorCheckpoint(ctx context.Context, table
 	return errors.Trace(err)
 }
 
+func (cpdb *MySQLCheckpointsDB) ResetEngineCheckpoints(ctx context.Context, tableName string, engines map[int32]*EngineCheckpoint) error {
+	tableQuery := fmt.Sprintf(`
+		UPDATE %s.%s SET status = ? WHERE table_name = ?;
+	`, cpdb.schema, checkpointTableNameTable)
+	engineQuery := fmt.Sprintf(`
+		UPDATE %s.%s SET status = ? WHERE (table_name, engine_id) = (?, ?);
+	`, cpdb.schema, checkpointTableNameEngine)
+	chunkQuery := fmt.Sprintf(`
+		UPDATE %s.%s SET
+			pos = ?, end_offset = ?, prev_rowid_max = ?,
+			kvc_bytes = ?, kvc_kvs = ?, kvc_checksum = ?, fingerprint = ?
+		WHERE (table_name, engine_id, path, offset) = (?, ?, ?, ?);
+	`, cpdb.schema, checkpointTableNameChunk)
+
+	s := common.SQLWithRetry{
+		DB:     cpdb.db,
+		Logger: log.With(zap.String("table", tableName)),
+	}
+	err := s.Transact(ctx, "reset engine checkpoints", func(c context.Context, tx *sql.Tx) error {
+		if _, e := tx.ExecContext(c, tableQuery, CheckpointStatusLoaded, tableName); e != nil {
+			return errors.Trace(e)
+		}
+		for engineID, engine := range engines {
+			if _, e := tx.ExecContext(c, engineQuery, engine.Status, tableName, engineID); e != nil {
+				return errors.Trace(e)
+			}
+			for _, chunk := range engine.Chunks {
+				if _, e := tx.ExecContext(
+					c, chunkQuery,
+					chunk.Chunk.Offset, chunk.Chunk.EndOffset, chunk.Chunk.PrevRowIDMax,
+					chunk.Checksum.SumSize(), chunk.Checksum.SumKVS(), chunk.Checksum.Sum(), chunk.Fingerprint,
+					tableName, engineID, chunk.Key.Path, chunk.Key.Offset,
+				); e != nil {
+					return errors.Trace(e)
+				}
+			}
+		}
+		return nil
+	})
+	return errors.Trace(err)
+}
+
 func (cpdb *MySQLCheckpointsDB) DestroyErrorCheckpoint(ctx context.Context, tableName string) ([]DestroyedTableCheckpoint, error) {
 	var colName, aliasedColName string
 
@@ -1127,6 +1549,26 @@ func (cpdb *MySQLCheckpointsDB) DestroyErrorCheckpoint(ctx context.Context, tabl
 	return targetTables, nil
 }
 
+func (cpdb *MySQLCheckpointsDB) ListTables(ctx context.Context) ([]string, error) {
+	rows, err := cpdb.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT table_name FROM %s.%s ORDER BY table_name;
+	`, cpdb.schema, checkpointTableNameTable))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer rows.Close()
+
+	var tableNames []string
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			return nil, errors.Trace(err)
+		}
+		tableNames = append(tableNames, tableName)
+	}
+	return tableNames, errors.Trace(rows.Err())
+}
+
 func (cpdb *MySQLCheckpointsDB) DumpTables(ctx context.Context, writer io.Writer) error {
 	rows, err := cpdb.db.QueryContext(ctx, fmt.Sprintf(`
 		SELECT
@@ -1135,6 +1577,13 @@ func (cpdb *MySQLCheckpointsDB) DumpTables(ctx context.Context, writer io.Writer
 			hex(hash) AS hash,
 			status,
 			alloc_base,
+			kvc_bytes,
+			kvc_kvs,
+			kvc_checksum,
+			time_zone,
+			on_duplicate,
+			masks,
+			row_id_base,
 			create_time,
 			update_time
 		FROM %s.%s;
@@ -1179,6 +1628,7 @@ func (cpdb *MySQLCheckpointsDB) DumpChunks(ctx context.Context, writer io.Writer
 			kvc_bytes,
 			kvc_kvs,
 			kvc_checksum,
+			fingerprint,
 			create_time,
 			update_time
 		FROM %s.%s;
@@ -1197,6 +1647,10 @@ func (cpdb *FileCheckpointsDB) RemoveCheckpoint(_ context.Context, tableName str
 
 	if tableName == "all" {
 		cpdb.checkpoints.Reset()
+		cpdb.closeJournal()
+		if err := os.Remove(journalPath(cpdb.path)); err != nil && !os.IsNotExist(err) {
+			return errors.Trace(err)
+		}
 		return errors.Trace(os.Remove(cpdb.path))
 	}
 
@@ -1208,6 +1662,15 @@ func (cpdb *FileCheckpointsDB) MoveCheckpoints(ctx context.Context, taskID int64
 	cpdb.lock.Lock()
 	defer cpdb.lock.Unlock()
 
+	// compact the journal first so the backup is a single self-contained file.
+	if err := cpdb.save(); err != nil {
+		return errors.Trace(err)
+	}
+	cpdb.closeJournal()
+	if err := os.Remove(journalPath(cpdb.path)); err != nil {
+		return errors.Trace(err)
+	}
+
 	newPath := fmt.Sprintf("%s.%d.bak", cpdb.path, taskID)
 	return errors.Trace(os.Rename(cpdb.path, newPath))
 }
@@ -1232,6 +1695,38 @@ func (cpdb *FileCheckpointsDB) IgnoreErrorCheckpoint(_ context.Context, targetTa
 	return errors.Trace(cpdb.save())
 }
 
+func (cpdb *FileCheckpointsDB) ResetEngineCheckpoints(_ context.Context, tableName string, engines map[int32]*EngineCheckpoint) error {
+	cpdb.lock.Lock()
+	defer cpdb.lock.Unlock()
+
+	tableModel, ok := cpdb.checkpoints.Checkpoints[tableName]
+	if !ok {
+		return errors.Errorf("checkpoint of table %s not found", tableName)
+	}
+	tableModel.Status = uint32(CheckpointStatusLoaded)
+	for engineID, engine := range engines {
+		engineModel, ok := tableModel.Engines[engineID]
+		if !ok {
+			return errors.Errorf("checkpoint of engine %s:%d not found", tableName, engineID)
+		}
+		engineModel.Status = uint32(engine.Status)
+		for _, chunk := range engine.Chunks {
+			chunkModel, ok := engineModel.Chunks[chunk.Key.String()]
+			if !ok {
+				return errors.Errorf("checkpoint of chunk %s in engine %s:%d not found", chunk.Key.String(), tableName, engineID)
+			}
+			chunkModel.Pos = chunk.Chunk.Offset
+			chunkModel.EndOffset = chunk.Chunk.EndOffset
+			chunkModel.PrevRowidMax = chunk.Chunk.PrevRowIDMax
+			chunkModel.KvcBytes = chunk.Checksum.SumSize()
+			chunkModel.KvcKvs = chunk.Checksum.SumKVS()
+			chunkModel.KvcChecksum = chunk.Checksum.Sum()
+			chunkModel.Fingerprint = chunk.Fingerprint
+		}
+	}
+	return errors.Trace(cpdb.save())
+}
+
 func (cpdb *FileCheckpointsDB) DestroyErrorCheckpoint(_ context.Context, targetTableName string) ([]DestroyedTableCheckpoint, error) {
 	cpdb.lock.Lock()
 	defer cpdb.lock.Unlock()
@@ -1273,14 +1768,316 @@ func (cpdb *FileCheckpointsDB) DestroyErrorCheckpoint(_ context.Context, targetT
 	return targetTables, nil
 }
 
-func (cpdb *FileCheckpointsDB) DumpTables(context.Context, io.Writer) error {
-	return errors.Errorf("dumping file checkpoint into CSV not unsupported, you may copy %s instead", cpdb.path)
+func (cpdb *FileCheckpointsDB) ListTables(context.Context) ([]string, error) {
+	cpdb.lock.Lock()
+	defer cpdb.lock.Unlock()
+
+	return cpdb.sortedTableNames(), nil
+}
+
+func (cpdb *FileCheckpointsDB) sortedTableNames() []string {
+	tableNames := make([]string, 0, len(cpdb.checkpoints.Checkpoints))
+	for tableName := range cpdb.checkpoints.Checkpoints {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	return tableNames
+}
+
+func sortedEngineIDs(engines map[int32]*EngineCheckpointModel) []int32 {
+	engineIDs := make([]int32, 0, len(engines))
+	for engineID := range engines {
+		engineIDs = append(engineIDs, engineID)
+	}
+	sort.Slice(engineIDs, func(i, j int) bool { return engineIDs[i] < engineIDs[j] })
+	return engineIDs
+}
+
+func sortedChunks(chunks map[string]*ChunkCheckpointModel) []*ChunkCheckpointModel {
+	res := make([]*ChunkCheckpointModel, 0, len(chunks))
+	for _, chunk := range chunks {
+		res = append(res, chunk)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Path != res[j].Path {
+			return res[i].Path < res[j].Path
+		}
+		return res[i].Offset < res[j].Offset
+	})
+	return res
+}
+
+// The file checkpoints do not record the table hash and the update times, so
+// these columns are left empty in the dumps, which otherwise share the same
+// layout as the MySQL checkpoints.
+
+func (cpdb *FileCheckpointsDB) DumpTables(_ context.Context, writer io.Writer) error {
+	cpdb.lock.Lock()
+	defer cpdb.lock.Unlock()
+
+	csvWriter := csv.NewWriter(writer)
+	csvWriter.Write([]string{
+		"task_id", "table_name", "hash", "status", "alloc_base",
+		"kvc_bytes", "kvc_kvs", "kvc_checksum", "time_zone", "on_duplicate", "masks", "row_id_base",
+		"create_time", "update_time",
+	})
+	for _, tableName := range cpdb.sortedTableNames() {
+		table := cpdb.checkpoints.Checkpoints[tableName]
+		csvWriter.Write([]string{
+			strconv.FormatInt(table.TaskId, 10), tableName, "",
+			strconv.FormatUint(uint64(table.Status), 10),
+			strconv.FormatInt(table.AllocBase, 10),
+			strconv.FormatUint(table.KvcBytes, 10),
+			strconv.FormatUint(table.KvcKvs, 10),
+			strconv.FormatUint(table.KvcChecksum, 10),
+			table.TimeZone, table.OnDuplicate, table.Masks,
+			strconv.FormatInt(table.RowIdBase, 10),
+			"", "",
+		})
+	}
+	csvWriter.Flush()
+	return errors.Trace(csvWriter.Error())
 }
 
-func (cpdb *FileCheckpointsDB) DumpEngines(context.Context, io.Writer) error {
-	return errors.Errorf("dumping file checkpoint into CSV not unsupported, you may copy %s instead", cpdb.path)
+func (cpdb *FileCheckpointsDB) DumpEngines(_ context.Context, writer io.Writer) error {
+	cpdb.lock.Lock()
+	defer cpdb.lock.Unlock()
+
+	csvWriter := csv.NewWriter(writer)
+	csvWriter.Write([]string{"table_name", "engine_id", "status", "create_time", "update_time"})
+	for _, tableName := range cpdb.sortedTableNames() {
+		engines := cpdb.checkpoints.Checkpoints[tableName].Engines
+		for _, engineID := range sortedEngineIDs(engines) {
+			csvWriter.Write([]string{
+				tableName,
+				strconv.FormatInt(int64(engineID), 10),
+				strconv.FormatUint(uint64(engines[engineID].Status), 10),
+				"", "",
+			})
+		}
+	}
+	csvWriter.Flush()
+	return errors.Trace(csvWriter.Error())
 }
 
-func (cpdb *FileCheckpointsDB) DumpChunks(context.Context, io.Writer) error {
-	return errors.Errorf("dumping file checkpoint into CSV not unsupported, you may copy %s instead", cpdb.path)
+func (cpdb *FileCheckpointsDB) DumpChunks(_ context.Context, writer io.Writer) error {
+	cpdb.lock.Lock()
+	defer cpdb.lock.Unlock()
+
+	csvWriter := csv.NewWriter(writer)
+	csvWriter.Write([]string{
+		"table_name", "path", "offset", "columns",
+		"pos", "end_offset", "prev_rowid_max", "rowid_max",
+		"kvc_bytes", "kvc_kvs", "kvc_checksum", "fingerprint",
+		"create_time", "update_time",
+	})
+	for _, tableName := range cpdb.sortedTableNames() {
+		engines := cpdb.checkpoints.Checkpoints[tableName].Engines
+		for _, engineID := range sortedEngineIDs(engines) {
+			for _, chunk := range sortedChunks(engines[engineID].Chunks) {
+				colPerm := chunk.ColumnPermutation
+				if colPerm == nil {
+					colPerm = []int32{}
+				}
+				columns, err := json.Marshal(colPerm)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				var createTime string
+				if chunk.Timestamp != 0 {
+					createTime = time.Unix(chunk.Timestamp, 0).Format("2006-01-02 15:04:05")
+				}
+				csvWriter.Write([]string{
+					tableName, chunk.Path,
+					strconv.FormatInt(chunk.Offset, 10),
+					string(columns),
+					strconv.FormatInt(chunk.Pos, 10),
+					strconv.FormatInt(chunk.EndOffset, 10),
+					strconv.FormatInt(chunk.PrevRowidMax, 10),
+					strconv.FormatInt(chunk.RowidMax, 10),
+					strconv.FormatUint(chunk.KvcBytes, 10),
+					strconv.FormatUint(chunk.KvcKvs, 10),
+					strconv.FormatUint(chunk.KvcChecksum, 10),
+					chunk.Fingerprint,
+					createTime, "",
+				})
+			}
+		}
+	}
+	csvWriter.Flush()
+	return errors.Trace(csvWriter.Error())
+}
+
+// statusDisplayName returns the name of the status for display. An invalid
+// status is shown together with the step it failed at.
+func statusDisplayName(status CheckpointStatus) string {
+	if status != CheckpointStatusMissing && status <= CheckpointStatusMaxInvalid {
+		return fmt.Sprintf("failed (%s)", (status * 10).MetricName())
+	}
+	return status.MetricName()
+}
+
+func progressPercent(done, total int64) float64 {
+	if total <= 0 {
+		return 100
+	}
+	return float64(done) * 100 / float64(total)
+}
+
+// ListCheckpoints writes a human-readable summary of the checkpoints of all
+// tables, including the status of every engine and the progress of every
+// chunk.
+func ListCheckpoints(ctx context.Context, cpdb CheckpointsDB, writer io.Writer) error {
+	tableNames, err := cpdb.ListTables(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	for _, tableName := range tableNames {
+		cp, err := cpdb.Get(ctx, tableName)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if _, err := fmt.Fprintf(writer, "%s: %s\n", tableName, statusDisplayName(cp.Status)); err != nil {
+			return errors.Trace(err)
+		}
+
+		engineIDs := make([]int32, 0, len(cp.Engines))
+		for engineID := range cp.Engines {
+			engineIDs = append(engineIDs, engineID)
+		}
+		sort.Slice(engineIDs, func(i, j int) bool { return engineIDs[i] < engineIDs[j] })
+
+		for _, engineID := range engineIDs {
+			engine := cp.Engines[engineID]
+			if len(engine.Chunks) == 0 {
+				if _, err := fmt.Fprintf(writer, "  engine %d: %s\n", engineID, statusDisplayName(engine.Status)); err != nil {
+					return errors.Trace(err)
+				}
+				continue
+			}
+			var done, total int64
+			for _, chunk := range engine.Chunks {
+				done += chunk.Chunk.Offset - chunk.Key.Offset
+				total += chunk.Chunk.EndOffset - chunk.Key.Offset
+			}
+			if _, err := fmt.Fprintf(writer, "  engine %d: %s, %d chunks, %.2f%%\n",
+				engineID, statusDisplayName(engine.Status), len(engine.Chunks), progressPercent(done, total),
+			); err != nil {
+				return errors.Trace(err)
+			}
+			for _, chunk := range engine.Chunks {
+				if _, err := fmt.Fprintf(writer, "    %s: %.2f%% (%d/%d)\n",
+					chunk.Key.String(),
+					progressPercent(chunk.Chunk.Offset-chunk.Key.Offset, chunk.Chunk.EndOffset-chunk.Key.Offset),
+					chunk.Chunk.Offset, chunk.Chunk.EndOffset,
+				); err != nil {
+					return errors.Trace(err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// MigrateCheckpoints copies the checkpoints of every table from one
+// checkpoints database into another, which must not contain any checkpoints
+// yet. Every migrated table is read back to verify nothing was lost.
+func MigrateCheckpoints(ctx context.Context, from CheckpointsDB, to CheckpointsDB) error {
+	existingTables, err := to.ListTables(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(existingTables) > 0 {
+		return errors.Errorf("the target checkpoints already contain %d tables, please remove them before migrating", len(existingTables))
+	}
+
+	tableNames, err := from.ListTables(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, tableName := range tableNames {
+		cp, err := from.Get(ctx, tableName)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err := migrateTableCheckpoint(ctx, to, tableName, cp); err != nil {
+			return errors.Annotatef(err, "failed to migrate the checkpoint of table %s", tableName)
+		}
+		log.L().Info("migrated checkpoint", zap.String("table", tableName), zap.Int("engines", len(cp.Engines)))
+	}
+
+	taskCp, err := from.TaskCheckpoint(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if taskCp != nil {
+		if err := to.UpdateTaskCheckpoint(ctx, taskCp); err != nil {
+			return errors.Annotate(err, "failed to migrate the task checkpoint")
+		}
+	}
+	return nil
+}
+
+func migrateTableCheckpoint(ctx context.Context, to CheckpointsDB, tableName string, cp *TableCheckpoint) error {
+	schema, table, err := common.ParseUniqueTable(tableName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = to.Initialize(ctx, map[string]*TidbDBInfo{
+		schema: {
+			Name:   schema,
+			Tables: map[string]*TidbTableInfo{table: {Name: table}},
+		},
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(cp.Engines) > 0 {
+		if err := to.InsertEngineCheckpoints(ctx, tableName, cp.Engines); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	cpd := NewTableCheckpointDiff()
+	for engineID, engine := range cp.Engines {
+		scm := StatusCheckpointMerger{EngineID: engineID, Status: engine.Status}
+		scm.MergeInto(cpd)
+		for _, chunk := range engine.Chunks {
+			ccm := ChunkCheckpointMerger{
+				EngineID: engineID,
+				Key:      chunk.Key,
+				Checksum: chunk.Checksum,
+				Pos:      chunk.Chunk.Offset,
+				RowID:    chunk.Chunk.PrevRowIDMax,
+			}
+			ccm.MergeInto(cpd)
+		}
+	}
+	// the table status is merged last, since an invalid engine status would
+	// otherwise overwrite it.
+	for _, merger := range []TableCheckpointMerger{
+		&RebaseCheckpointMerger{AllocBase: cp.AllocBase},
+		&TableSettingsCheckpointMerger{
+			BaseChecksum: cp.BaseChecksum,
+			TimeZone:     cp.TimeZone,
+			OnDuplicate:  cp.OnDuplicate,
+			Masks:        cp.Masks,
+			RowIDBase:    cp.RowIDBase,
+		},
+		&TaskIDCheckpointMerger{TaskID: cp.TaskID},
+		&StatusCheckpointMerger{EngineID: WholeTableEngineID, Status: cp.Status},
+	} {
+		merger.MergeInto(cpd)
+	}
+	to.Update(map[string]*TableCheckpointDiff{tableName: cpd})
+
+	migrated, err := to.Get(ctx, tableName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !reflect.DeepEqual(migrated.DeepCopy(), cp.DeepCopy()) {
+		return errors.New("the migrated checkpoint differs from the source")
+	}
+	return nil
 }
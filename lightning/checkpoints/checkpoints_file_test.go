@@ -2,8 +2,11 @@ package checkpoints_test
 
 import (
 	"context"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
 
 	. "github.com/pingcap/check"
@@ -25,14 +28,16 @@ type cpFileSuite struct {
 
 func (s *cpFileSuite) SetUpTest(c *C) {
 	dir := c.MkDir()
-	s.cpdb = checkpoints.NewFileCheckpointsDB(filepath.Join(dir, "cp.pb"))
+	cpdb, err := checkpoints.NewFileCheckpointsDB(filepath.Join(dir, "cp.pb"), 1234)
+	c.Assert(err, IsNil)
+	s.path = filepath.Join(dir, "cp.pb")
+	s.cpdb = cpdb
 
 	ctx := context.Background()
-	cpdb := s.cpdb
 
 	// 2. initialize with checkpoint data.
 
-	err := cpdb.Initialize(ctx, map[string]*checkpoints.TidbDBInfo{
+	err = cpdb.Initialize(ctx, map[string]*checkpoints.TidbDBInfo{
 		"db1": {
 			Name: "db1",
 			Tables: map[string]*checkpoints.TidbTableInfo{
@@ -65,6 +70,7 @@ func (s *cpFileSuite) SetUpTest(c *C) {
 					PrevRowIDMax: 1,
 					RowIDMax:     5000,
 				},
+				Fingerprint: "102400:abcdef",
 			}},
 		},
 		-1: {
@@ -99,6 +105,14 @@ func (s *cpFileSuite) SetUpTest(c *C) {
 		AllocBase: 132861,
 	}
 	rcm.MergeInto(cpd)
+	tsm := checkpoints.TableSettingsCheckpointMerger{
+		BaseChecksum: verification.MakeKVChecksum(1234, 56, 7890),
+		TimeZone:     "Asia/Shanghai",
+		OnDuplicate:  "upsert",
+		Masks:        `{"a":{"type":"nullify"}}`,
+		RowIDBase:    500,
+	}
+	tsm.MergeInto(cpd)
 	ccm := checkpoints.ChunkCheckpointMerger{
 		EngineID: 0,
 		Key:      checkpoints.ChunkCheckpointKey{Path: "/tmp/path/1.sql", Offset: 0},
@@ -138,8 +152,14 @@ func (s *cpFileSuite) TestGet(c *C) {
 	cp, err := s.cpdb.Get(ctx, "`db1`.`t2`")
 	c.Assert(err, IsNil)
 	c.Assert(cp, DeepEquals, &checkpoints.TableCheckpoint{
-		Status:    checkpoints.CheckpointStatusAllWritten,
-		AllocBase: 132861,
+		Status:       checkpoints.CheckpointStatusAllWritten,
+		AllocBase:    132861,
+		BaseChecksum: verification.MakeKVChecksum(1234, 56, 7890),
+		TimeZone:     "Asia/Shanghai",
+		OnDuplicate:  "upsert",
+		Masks:        `{"a":{"type":"nullify"}}`,
+		TaskID:       1234,
+		RowIDBase:    500,
 		Engines: map[int32]*checkpoints.EngineCheckpoint{
 			-1: {
 				Status: checkpoints.CheckpointStatusLoaded,
@@ -159,7 +179,8 @@ func (s *cpFileSuite) TestGet(c *C) {
 						PrevRowIDMax: 681,
 						RowIDMax:     5000,
 					},
-					Checksum: verification.MakeKVChecksum(4491, 586, 486070148917),
+					Checksum:    verification.MakeKVChecksum(4491, 586, 486070148917),
+					Fingerprint: "102400:abcdef",
 				}},
 			},
 		},
@@ -169,6 +190,7 @@ func (s *cpFileSuite) TestGet(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(cp, DeepEquals, &checkpoints.TableCheckpoint{
 		Status: checkpoints.CheckpointStatusLoaded,
+		TaskID: 1234,
 		Engines: map[int32]*checkpoints.EngineCheckpoint{
 			-1: {
 				Status: checkpoints.CheckpointStatusLoaded,
@@ -301,3 +323,268 @@ func (s *cpFileSuite) TestDestroyOneErrorCheckpoint(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(cp.Status, Equals, checkpoints.CheckpointStatusAllWritten/10)
 }
+
+// reopen loads the checkpoints from the files without closing the current
+// instance, as if the process was killed.
+func (s *cpFileSuite) TestResetEngineCheckpoints(c *C) {
+	ctx := context.Background()
+
+	s.setInvalidStatus()
+
+	err := s.cpdb.ResetEngineCheckpoints(ctx, "`db1`.`t2`", map[int32]*checkpoints.EngineCheckpoint{
+		-1: {Status: checkpoints.CheckpointStatusLoaded},
+		0: {
+			Status: checkpoints.CheckpointStatusLoaded,
+			Chunks: []*checkpoints.ChunkCheckpoint{{
+				Key: checkpoints.ChunkCheckpointKey{Path: "/tmp/path/1.sql", Offset: 0},
+				Chunk: mydump.Chunk{
+					Offset:       0,
+					EndOffset:    102300,
+					PrevRowIDMax: 1,
+					RowIDMax:     5000,
+				},
+				Fingerprint: "102300:fedcba",
+			}},
+		},
+	})
+	c.Assert(err, IsNil)
+
+	for _, cpdb := range []*checkpoints.FileCheckpointsDB{s.cpdb, s.reopen(c)} {
+		cp, err := cpdb.Get(ctx, "`db1`.`t2`")
+		c.Assert(err, IsNil)
+		c.Assert(cp.Status, Equals, checkpoints.CheckpointStatusLoaded)
+		c.Assert(cp.Engines[-1].Status, Equals, checkpoints.CheckpointStatusLoaded)
+		c.Assert(cp.Engines[0].Status, Equals, checkpoints.CheckpointStatusLoaded)
+		c.Assert(cp.Engines[0].Chunks, HasLen, 1)
+		chunk := cp.Engines[0].Chunks[0]
+		c.Assert(chunk.Chunk, Equals, mydump.Chunk{Offset: 0, EndOffset: 102300, PrevRowIDMax: 1, RowIDMax: 5000})
+		c.Assert(chunk.Checksum, Equals, verification.KVChecksum{})
+		c.Assert(chunk.Fingerprint, Equals, "102300:fedcba")
+		// the other settings are kept.
+		c.Assert(cp.AllocBase, Equals, int64(132861))
+		c.Assert(cp.TimeZone, Equals, "Asia/Shanghai")
+	}
+
+	err = s.cpdb.ResetEngineCheckpoints(ctx, "`db1`.`t2`", map[int32]*checkpoints.EngineCheckpoint{
+		5: {Status: checkpoints.CheckpointStatusLoaded},
+	})
+	c.Assert(err, ErrorMatches, "checkpoint of engine `db1`.`t2`:5 not found")
+}
+
+func (s *cpFileSuite) reopen(c *C) *checkpoints.FileCheckpointsDB {
+	cpdb, err := checkpoints.NewFileCheckpointsDB(s.path, 1234)
+	c.Assert(err, IsNil)
+	return cpdb
+}
+
+func (s *cpFileSuite) getAll(c *C, cpdb *checkpoints.FileCheckpointsDB) []*checkpoints.TableCheckpoint {
+	ctx := context.Background()
+	var res []*checkpoints.TableCheckpoint
+	for _, tableName := range []string{"`db1`.`t1`", "`db1`.`t2`", "`db2`.`t3`"} {
+		cp, err := cpdb.Get(ctx, tableName)
+		c.Assert(err, IsNil)
+		res = append(res, cp)
+	}
+	return res
+}
+
+func (s *cpFileSuite) TestTaskCheckpoint(c *C) {
+	ctx := context.Background()
+
+	taskCp, err := s.cpdb.TaskCheckpoint(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(taskCp, IsNil)
+
+	expected := &checkpoints.TaskCheckpoint{
+		TaskID:            1234,
+		ConfigFingerprint: "mydumper.csv.separator = \",\"\n",
+	}
+	err = s.cpdb.UpdateTaskCheckpoint(ctx, expected)
+	c.Assert(err, IsNil)
+	taskCp, err = s.cpdb.TaskCheckpoint(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(taskCp, DeepEquals, expected)
+
+	cpdb := s.reopen(c)
+	defer cpdb.Close()
+	taskCp, err = cpdb.TaskCheckpoint(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(taskCp, DeepEquals, expected)
+}
+
+func (s *cpFileSuite) TestReplayJournal(c *C) {
+	// the update in SetUpTest is only written to the journal.
+	cpdb := s.reopen(c)
+	defer cpdb.Close()
+	c.Assert(s.getAll(c, cpdb), DeepEquals, s.getAll(c, s.cpdb))
+
+	s.setInvalidStatus()
+	cpdb2 := s.reopen(c)
+	defer cpdb2.Close()
+	cp, err := cpdb2.Get(context.Background(), "`db2`.`t3`")
+	c.Assert(err, IsNil)
+	c.Assert(cp.Status, Equals, checkpoints.CheckpointStatusAllWritten/10)
+	c.Assert(s.getAll(c, cpdb2), DeepEquals, s.getAll(c, s.cpdb))
+}
+
+func (s *cpFileSuite) TestTornJournalTail(c *C) {
+	expected := s.getAll(c, s.cpdb)
+
+	journalPath := s.path + ".journal"
+	journal, err := ioutil.ReadFile(journalPath)
+	c.Assert(err, IsNil)
+
+	// simulate a crash in the middle of appending a record, and in the middle
+	// of the record header.
+	for _, torn := range [][]byte{
+		{0, 0, 0, 100, 1, 2, 3, 4, 5, 6},
+		{0, 0},
+		{0, 0, 0, 2, 0, 0, 0, 0, 8, 1},
+	} {
+		c.Assert(ioutil.WriteFile(journalPath, append(append([]byte{}, journal...), torn...), 0644), IsNil)
+		cpdb := s.reopen(c)
+		c.Assert(s.getAll(c, cpdb), DeepEquals, expected)
+
+		// the torn tail is truncated so new records remain readable.
+		cpd := checkpoints.NewTableCheckpointDiff()
+		rcm := checkpoints.RebaseCheckpointMerger{AllocBase: 200000}
+		rcm.MergeInto(cpd)
+		cpdb.Update(map[string]*checkpoints.TableCheckpointDiff{"`db2`.`t3`": cpd})
+
+		cpdb2 := s.reopen(c)
+		cp, err := cpdb2.Get(context.Background(), "`db2`.`t3`")
+		c.Assert(err, IsNil)
+		c.Assert(cp.AllocBase, Equals, int64(200000))
+		c.Assert(s.getAll(c, cpdb2)[:2], DeepEquals, expected[:2])
+	}
+}
+
+func (s *cpFileSuite) TestIgnoreStaleJournal(c *C) {
+	journalPath := s.path + ".journal"
+	staleJournal, err := ioutil.ReadFile(journalPath)
+	c.Assert(err, IsNil)
+
+	// removing a checkpoint compacts the journal into the snapshot.
+	err = s.cpdb.RemoveCheckpoint(context.Background(), "`db1`.`t2`")
+	c.Assert(err, IsNil)
+	journal, err := ioutil.ReadFile(journalPath)
+	c.Assert(err, IsNil)
+	c.Assert(len(journal), Less, len(staleJournal))
+
+	// crashed after the snapshot was renamed but before the journal is reset.
+	c.Assert(ioutil.WriteFile(journalPath, staleJournal, 0644), IsNil)
+	cpdb := s.reopen(c)
+	defer cpdb.Close()
+	cp, err := cpdb.Get(context.Background(), "`db1`.`t2`")
+	c.Assert(err, IsNil)
+	c.Assert(cp.Status, Equals, checkpoints.CheckpointStatusMissing)
+}
+
+func (s *cpFileSuite) TestMissingJournal(c *C) {
+	c.Assert(s.cpdb.Close(), IsNil)
+	c.Assert(os.Remove(s.path+".journal"), IsNil)
+
+	s.cpdb = s.reopen(c)
+	cp, err := s.cpdb.Get(context.Background(), "`db1`.`t2`")
+	c.Assert(err, IsNil)
+	c.Assert(cp.AllocBase, Equals, int64(132861))
+}
+
+func (s *cpFileSuite) TestBrokenSnapshot(c *C) {
+	snapshot, err := ioutil.ReadFile(s.path)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(s.path, snapshot[:len(snapshot)/2], 0644), IsNil)
+
+	_, err = checkpoints.NewFileCheckpointsDB(s.path, 1234)
+	c.Assert(err, ErrorMatches, "checkpoint file .* is broken.*")
+}
+
+func (s *cpFileSuite) TestDump(c *C) {
+	ctx := context.Background()
+	var csvBuilder strings.Builder
+
+	err := s.cpdb.DumpChunks(ctx, &csvBuilder)
+	c.Assert(err, IsNil)
+	c.Assert(csvBuilder.String(), Equals,
+		"table_name,path,offset,columns,pos,end_offset,prev_rowid_max,rowid_max,kvc_bytes,kvc_kvs,kvc_checksum,fingerprint,create_time,update_time\n"+
+			"`db1`.`t2`,/tmp/path/1.sql,0,[],55904,102400,681,5000,4491,586,486070148917,102400:abcdef,,\n",
+	)
+
+	csvBuilder.Reset()
+	err = s.cpdb.DumpEngines(ctx, &csvBuilder)
+	c.Assert(err, IsNil)
+	c.Assert(csvBuilder.String(), Equals,
+		"table_name,engine_id,status,create_time,update_time\n"+
+			"`db1`.`t2`,-1,30,,\n"+
+			"`db1`.`t2`,0,120,,\n"+
+			"`db2`.`t3`,-1,30,,\n",
+	)
+
+	csvBuilder.Reset()
+	err = s.cpdb.DumpTables(ctx, &csvBuilder)
+	c.Assert(err, IsNil)
+	c.Assert(csvBuilder.String(), Equals,
+		"task_id,table_name,hash,status,alloc_base,kvc_bytes,kvc_kvs,kvc_checksum,time_zone,on_duplicate,masks,row_id_base,create_time,update_time\n"+
+			"1234,`db1`.`t1`,,30,0,0,0,0,,,,0,,\n"+
+			"1234,`db1`.`t2`,,60,132861,1234,56,7890,Asia/Shanghai,upsert,\"{\"\"a\"\":{\"\"type\"\":\"\"nullify\"\"}}\",500,,\n"+
+			"1234,`db2`.`t3`,,30,0,0,0,0,,,,0,,\n",
+	)
+}
+
+func (s *cpFileSuite) TestListCheckpoints(c *C) {
+	ctx := context.Background()
+
+	tableNames, err := s.cpdb.ListTables(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(tableNames, DeepEquals, []string{"`db1`.`t1`", "`db1`.`t2`", "`db2`.`t3`"})
+
+	s.setInvalidStatus()
+
+	var builder strings.Builder
+	err = checkpoints.ListCheckpoints(ctx, s.cpdb, &builder)
+	c.Assert(err, IsNil)
+	c.Assert(builder.String(), Equals, ""+
+		"`db1`.`t1`: pending\n"+
+		"`db1`.`t2`: failed (written)\n"+
+		"  engine -1: failed (written)\n"+
+		"  engine 0: imported, 1 chunks, 54.59%\n"+
+		"    /tmp/path/1.sql:0: 54.59% (55904/102400)\n"+
+		"`db2`.`t3`: failed (written)\n"+
+		"  engine -1: failed (written)\n",
+	)
+}
+
+func (s *cpFileSuite) TestMigrateCheckpoints(c *C) {
+	ctx := context.Background()
+
+	// the task ID of the source must be kept.
+	cpd := checkpoints.NewTableCheckpointDiff()
+	tim := checkpoints.TaskIDCheckpointMerger{TaskID: 5678}
+	tim.MergeInto(cpd)
+	s.cpdb.Update(map[string]*checkpoints.TableCheckpointDiff{"`db2`.`t3`": cpd})
+	s.setInvalidStatus()
+	err := s.cpdb.UpdateTaskCheckpoint(ctx, &checkpoints.TaskCheckpoint{TaskID: 1234, ConfigFingerprint: "a = 1\n"})
+	c.Assert(err, IsNil)
+
+	target, err := checkpoints.NewFileCheckpointsDB(filepath.Join(c.MkDir(), "target.pb"), 9999)
+	c.Assert(err, IsNil)
+	defer target.Close()
+
+	err = checkpoints.MigrateCheckpoints(ctx, s.cpdb, target)
+	c.Assert(err, IsNil)
+
+	tableNames, err := target.ListTables(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(tableNames, DeepEquals, []string{"`db1`.`t1`", "`db1`.`t2`", "`db2`.`t3`"})
+	c.Assert(s.getAll(c, target), DeepEquals, s.getAll(c, s.cpdb))
+	cp, err := target.Get(ctx, "`db2`.`t3`")
+	c.Assert(err, IsNil)
+	c.Assert(cp.TaskID, Equals, int64(5678))
+	taskCp, err := target.TaskCheckpoint(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(taskCp, DeepEquals, &checkpoints.TaskCheckpoint{TaskID: 1234, ConfigFingerprint: "a = 1\n"})
+
+	// migrating into non-empty checkpoints is rejected.
+	err = checkpoints.MigrateCheckpoints(ctx, s.cpdb, target)
+	c.Assert(err, ErrorMatches, "the target checkpoints already contain 3 tables.*")
+}
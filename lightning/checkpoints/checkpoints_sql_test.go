@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	gomysql "github.com/go-sql-driver/mysql"
 	. "github.com/pingcap/check"
 	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
 	"github.com/pingcap/tidb-lightning/lightning/mydump"
@@ -41,6 +42,15 @@ func (s *cpSQLSuite) SetUpTest(c *C) {
 	s.mock.
 		ExpectExec("CREATE TABLE IF NOT EXISTS `mock-schema`\\.chunk_v\\d+ .+").
 		WillReturnResult(sqlmock.NewResult(4, 1))
+	s.mock.
+		ExpectExec("CREATE TABLE IF NOT EXISTS `mock-schema`\\.task_v\\d+ .+").
+		WillReturnResult(sqlmock.NewResult(5, 1))
+	s.mock.
+		ExpectExec("CREATE TABLE IF NOT EXISTS `mock-schema`\\.task_history .+").
+		WillReturnResult(sqlmock.NewResult(6, 1))
+	s.mock.
+		ExpectExec("CREATE TABLE IF NOT EXISTS `mock-schema`\\.lease_v\\d+ .+").
+		WillReturnResult(sqlmock.NewResult(7, 1))
 
 	cpdb, err := checkpoints.NewMySQLCheckpointsDB(context.Background(), s.db, "mock-schema", 1234)
 	c.Assert(err, IsNil)
@@ -111,7 +121,7 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 		ExpectPrepare("REPLACE INTO `mock-schema`\\.chunk_v\\d+ .+")
 	insertChunkStmt.
 		ExpectExec().
-		WithArgs("`db1`.`t2`", 0, "/tmp/path/1.sql", 0, 12, 102400, 1, 5000, 1234567890).
+		WithArgs("`db1`.`t2`", 0, "/tmp/path/1.sql", 0, 12, 102400, 1, 5000, "102400:abcdef", 1234567890).
 		WillReturnResult(sqlmock.NewResult(10, 1))
 	s.mock.ExpectCommit()
 
@@ -130,7 +140,8 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 					PrevRowIDMax: 1,
 					RowIDMax:     5000,
 				},
-				Timestamp: 1234567890,
+				Timestamp:   1234567890,
+				Fingerprint: "102400:abcdef",
 			}},
 		},
 		-1: {
@@ -159,6 +170,18 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 		AllocBase: 132861,
 	}
 	rcm.MergeInto(cpd)
+	tsm := checkpoints.TableSettingsCheckpointMerger{
+		BaseChecksum: verification.MakeKVChecksum(1234, 56, 7890),
+		TimeZone:     "Asia/Shanghai",
+		OnDuplicate:  "upsert",
+		Masks:        `{"a":{"type":"nullify"}}`,
+		RowIDBase:    500,
+	}
+	tsm.MergeInto(cpd)
+	tim := checkpoints.TaskIDCheckpointMerger{
+		TaskID: 1555555555,
+	}
+	tim.MergeInto(cpd)
 	ccm := checkpoints.ChunkCheckpointMerger{
 		EngineID: 0,
 		Key:      checkpoints.ChunkCheckpointKey{Path: "/tmp/path/1.sql", Offset: 0},
@@ -182,6 +205,16 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 		ExpectExec().
 		WithArgs(132861, "`db1`.`t2`").
 		WillReturnResult(sqlmock.NewResult(12, 1))
+	s.mock.
+		ExpectPrepare("UPDATE `mock-schema`\\.table_v\\d+ SET kvc_bytes = .+").
+		ExpectExec().
+		WithArgs(1234, 56, 7890, "Asia/Shanghai", "upsert", `{"a":{"type":"nullify"}}`, 500, "`db1`.`t2`").
+		WillReturnResult(sqlmock.NewResult(15, 1))
+	s.mock.
+		ExpectPrepare("UPDATE `mock-schema`\\.table_v\\d+ SET task_id = .+").
+		ExpectExec().
+		WithArgs(1555555555, "`db1`.`t2`").
+		WillReturnResult(sqlmock.NewResult(19, 1))
 	s.mock.
 		ExpectPrepare("UPDATE `mock-schema`\\.engine_v\\d+ SET status = .+").
 		ExpectExec().
@@ -217,28 +250,34 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 			sqlmock.NewRows([]string{
 				"engine_id", "path", "offset", "columns",
 				"pos", "end_offset", "prev_rowid_max", "rowid_max",
-				"kvc_bytes", "kvc_kvs", "kvc_checksum", "unix_timestamp(create_time)",
+				"kvc_bytes", "kvc_kvs", "kvc_checksum", "fingerprint", "unix_timestamp(create_time)",
 			}).
 				AddRow(
 					0, "/tmp/path/1.sql", 0, "[]",
 					55904, 102400, 681, 5000,
-					4491, 586, 486070148917, 1234567894,
+					4491, 586, 486070148917, "102400:abcdef", 1234567894,
 				),
 		)
 	s.mock.
 		ExpectQuery("SELECT .+ FROM `mock-schema`\\.table_v\\d+").
 		WithArgs("`db1`.`t2`").
 		WillReturnRows(
-			sqlmock.NewRows([]string{"status", "alloc_base"}).
-				AddRow(60, 132861),
+			sqlmock.NewRows([]string{"status", "alloc_base", "kvc_bytes", "kvc_kvs", "kvc_checksum", "time_zone", "on_duplicate", "masks", "task_id", "row_id_base"}).
+				AddRow(60, 132861, 1234, 56, 7890, "Asia/Shanghai", "upsert", `{"a":{"type":"nullify"}}`, 1555555555, 500),
 		)
 	s.mock.ExpectCommit()
 
 	cp, err := cpdb.Get(ctx, "`db1`.`t2`")
 	c.Assert(err, IsNil)
 	c.Assert(cp, DeepEquals, &checkpoints.TableCheckpoint{
-		Status:    checkpoints.CheckpointStatusAllWritten,
-		AllocBase: 132861,
+		Status:       checkpoints.CheckpointStatusAllWritten,
+		AllocBase:    132861,
+		BaseChecksum: verification.MakeKVChecksum(1234, 56, 7890),
+		TimeZone:     "Asia/Shanghai",
+		OnDuplicate:  "upsert",
+		Masks:        `{"a":{"type":"nullify"}}`,
+		TaskID:       1555555555,
+		RowIDBase:    500,
 		Engines: map[int32]*checkpoints.EngineCheckpoint{
 			-1: {Status: checkpoints.CheckpointStatusLoaded},
 			0: {
@@ -255,8 +294,9 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 						PrevRowIDMax: 681,
 						RowIDMax:     5000,
 					},
-					Checksum:  verification.MakeKVChecksum(4491, 586, 486070148917),
-					Timestamp: 1234567894,
+					Checksum:    verification.MakeKVChecksum(4491, 586, 486070148917),
+					Timestamp:   1234567894,
+					Fingerprint: "102400:abcdef",
 				}},
 			},
 		},
@@ -265,7 +305,9 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 }
 
 func (s *cpSQLSuite) TestRemoveAllCheckpoints(c *C) {
-	s.mock.ExpectExec("DROP SCHEMA `mock-schema`").WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.
+		ExpectExec("DROP TABLE IF EXISTS `mock-schema`\\.chunk_v\\d+, `mock-schema`\\.engine_v\\d+, `mock-schema`\\.table_v\\d+, `mock-schema`\\.task_v\\d+, `mock-schema`\\.lease_v\\d+").
+		WillReturnResult(sqlmock.NewResult(0, 1))
 
 	err := s.cpdb.RemoveCheckpoint(context.Background(), "all")
 	c.Assert(err, IsNil)
@@ -323,6 +365,41 @@ func (s *cpSQLSuite) TestIgnoreOneErrorCheckpoint(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *cpSQLSuite) TestResetEngineCheckpoints(c *C) {
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectExec("UPDATE `mock-schema`\\.table_v\\d+ SET status = \\? WHERE table_name = \\?").
+		WithArgs(checkpoints.CheckpointStatusLoaded, "`db1`.`t2`").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.
+		ExpectExec("UPDATE `mock-schema`\\.engine_v\\d+ SET status = \\? WHERE \\(table_name, engine_id\\) = \\(\\?, \\?\\)").
+		WithArgs(checkpoints.CheckpointStatusLoaded, "`db1`.`t2`", 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.
+		ExpectExec("UPDATE `mock-schema`\\.chunk_v\\d+ SET\\s+pos = \\?, end_offset = \\?, prev_rowid_max = \\?,\\s+kvc_bytes = \\?, kvc_kvs = \\?, kvc_checksum = \\?, fingerprint = \\?\\s+WHERE \\(table_name, engine_id, path, offset\\) = \\(\\?, \\?, \\?, \\?\\)").
+		WithArgs(0, 102300, 1, 0, 0, 0, "102300:fedcba", "`db1`.`t2`", 0, "/tmp/path/1.sql", 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	err := s.cpdb.ResetEngineCheckpoints(context.Background(), "`db1`.`t2`", map[int32]*checkpoints.EngineCheckpoint{
+		0: {
+			Status: checkpoints.CheckpointStatusLoaded,
+			Chunks: []*checkpoints.ChunkCheckpoint{{
+				Key: checkpoints.ChunkCheckpointKey{Path: "/tmp/path/1.sql", Offset: 0},
+				Chunk: mydump.Chunk{
+					Offset:       0,
+					EndOffset:    102300,
+					PrevRowIDMax: 1,
+					RowIDMax:     5000,
+				},
+				Fingerprint: "102300:fedcba",
+			}},
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.mock.ExpectationsWereMet(), IsNil)
+}
+
 func (s *cpSQLSuite) TestDestroyAllErrorCheckpoints(c *C) {
 	s.mock.ExpectBegin()
 	s.mock.
@@ -397,12 +474,12 @@ func (s *cpSQLSuite) TestDump(c *C) {
 			sqlmock.NewRows([]string{
 				"table_name", "path", "offset", "columns",
 				"pos", "end_offset", "prev_rowid_max", "rowid_max",
-				"kvc_bytes", "kvc_kvs", "kvc_checksum",
+				"kvc_bytes", "kvc_kvs", "kvc_checksum", "fingerprint",
 				"create_time", "update_time",
 			}).AddRow(
 				"`db1`.`t2`", "/tmp/path/1.sql", 0, "[]",
 				55904, 102400, 681, 5000,
-				4491, 586, 486070148917,
+				4491, 586, 486070148917, "102400:abcdef",
 				t, t,
 			),
 		)
@@ -411,8 +488,8 @@ func (s *cpSQLSuite) TestDump(c *C) {
 	err := s.cpdb.DumpChunks(ctx, &csvBuilder)
 	c.Assert(err, IsNil)
 	c.Assert(csvBuilder.String(), Equals,
-		"table_name,path,offset,columns,pos,end_offset,prev_rowid_max,rowid_max,kvc_bytes,kvc_kvs,kvc_checksum,create_time,update_time\n"+
-			"`db1`.`t2`,/tmp/path/1.sql,0,[],55904,102400,681,5000,4491,586,486070148917,2019-04-18 02:45:55 +0000 UTC,2019-04-18 02:45:55 +0000 UTC\n",
+		"table_name,path,offset,columns,pos,end_offset,prev_rowid_max,rowid_max,kvc_bytes,kvc_kvs,kvc_checksum,fingerprint,create_time,update_time\n"+
+			"`db1`.`t2`,/tmp/path/1.sql,0,[],55904,102400,681,5000,4491,586,486070148917,102400:abcdef,2019-04-18 02:45:55 +0000 UTC,2019-04-18 02:45:55 +0000 UTC\n",
 	)
 
 	s.mock.
@@ -435,19 +512,67 @@ func (s *cpSQLSuite) TestDump(c *C) {
 	s.mock.
 		ExpectQuery("SELECT .+ FROM `mock-schema`\\.table_v\\d+").
 		WillReturnRows(
-			sqlmock.NewRows([]string{"task_id", "table_name", "hash", "status", "alloc_base", "create_time", "update_time"}).
-				AddRow(1555555555, "`db1`.`t2`", 0, 90, 132861, t, t),
+			sqlmock.NewRows([]string{"task_id", "table_name", "hash", "status", "alloc_base", "kvc_bytes", "kvc_kvs", "kvc_checksum", "time_zone", "on_duplicate", "masks", "row_id_base", "create_time", "update_time"}).
+				AddRow(1555555555, "`db1`.`t2`", 0, 90, 132861, 0, 0, 0, "UTC", "replace", "", 0, t, t),
 		)
 
 	csvBuilder.Reset()
 	err = s.cpdb.DumpTables(ctx, &csvBuilder)
 	c.Assert(err, IsNil)
 	c.Assert(csvBuilder.String(), Equals,
-		"task_id,table_name,hash,status,alloc_base,create_time,update_time\n"+
-			"1555555555,`db1`.`t2`,0,90,132861,2019-04-18 02:45:55 +0000 UTC,2019-04-18 02:45:55 +0000 UTC\n",
+		"task_id,table_name,hash,status,alloc_base,kvc_bytes,kvc_kvs,kvc_checksum,time_zone,on_duplicate,masks,row_id_base,create_time,update_time\n"+
+			"1555555555,`db1`.`t2`,0,90,132861,0,0,0,UTC,replace,,0,2019-04-18 02:45:55 +0000 UTC,2019-04-18 02:45:55 +0000 UTC\n",
 	)
 }
 
+func (s *cpSQLSuite) TestListTables(c *C) {
+	s.mock.
+		ExpectQuery("SELECT table_name FROM `mock-schema`\\.table_v\\d+ ORDER BY table_name").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"table_name"}).
+				AddRow("`db1`.`t1`").
+				AddRow("`db1`.`t2`"),
+		)
+
+	tableNames, err := s.cpdb.ListTables(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(tableNames, DeepEquals, []string{"`db1`.`t1`", "`db1`.`t2`"})
+}
+
+func (s *cpSQLSuite) TestTaskCheckpoint(c *C) {
+	ctx := context.Background()
+
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT task_id, config_fingerprint FROM `mock-schema`\\.task_v\\d+ WHERE id = 1").
+		WillReturnRows(sqlmock.NewRows([]string{"task_id", "config_fingerprint"}))
+	s.mock.ExpectCommit()
+	taskCp, err := s.cpdb.TaskCheckpoint(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(taskCp, IsNil)
+	c.Assert(s.mock.ExpectationsWereMet(), IsNil)
+
+	s.mock.
+		ExpectExec("REPLACE INTO `mock-schema`\\.task_v\\d+ \\(id, task_id, config_fingerprint\\) VALUES \\(1, \\?, \\?\\)").
+		WithArgs(1234, "a = 1\n").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	err = s.cpdb.UpdateTaskCheckpoint(ctx, &checkpoints.TaskCheckpoint{TaskID: 1234, ConfigFingerprint: "a = 1\n"})
+	c.Assert(err, IsNil)
+	c.Assert(s.mock.ExpectationsWereMet(), IsNil)
+
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT task_id, config_fingerprint FROM `mock-schema`\\.task_v\\d+ WHERE id = 1").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"task_id", "config_fingerprint"}).
+				AddRow(1234, "a = 1\n"),
+		)
+	s.mock.ExpectCommit()
+	taskCp, err = s.cpdb.TaskCheckpoint(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(taskCp, DeepEquals, &checkpoints.TaskCheckpoint{TaskID: 1234, ConfigFingerprint: "a = 1\n"})
+}
+
 func (s *cpSQLSuite) TestMoveCheckpoints(c *C) {
 	ctx := context.Background()
 
@@ -463,7 +588,239 @@ func (s *cpSQLSuite) TestMoveCheckpoints(c *C) {
 	s.mock.
 		ExpectExec("RENAME TABLE `mock-schema`\\.table_v\\d+ TO `mock-schema\\.12345678\\.bak`\\.table_v\\d+").
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.
+		ExpectExec("RENAME TABLE `mock-schema`\\.task_v\\d+ TO `mock-schema\\.12345678\\.bak`\\.task_v\\d+").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.
+		ExpectExec("RENAME TABLE `mock-schema`\\.lease_v\\d+ TO `mock-schema\\.12345678\\.bak`\\.lease_v\\d+").
+		WillReturnResult(sqlmock.NewResult(0, 1))
 
 	err := s.cpdb.MoveCheckpoints(ctx, 12345678)
 	c.Assert(err, IsNil)
 }
+
+func (s *cpSQLSuite) TestTaskHistory(c *C) {
+	ctx := context.Background()
+	historyDB, err := checkpoints.AsTaskHistoryDB(s.cpdb)
+	c.Assert(err, IsNil)
+
+	history := &checkpoints.TaskHistory{
+		TaskID:    1234,
+		Config:    "backend=importer",
+		StartTime: time.Unix(1565238374, 0),
+		EndTime:   time.Unix(1565238601, 0),
+		Status:    checkpoints.TaskStatusFailed,
+		Error:     "checksum mismatched",
+		Tables: []*checkpoints.TableHistory{
+			{TableName: "`db1`.`t1`", Status: checkpoints.TaskStatusSucceeded, Rows: 10, KVs: 20, Bytes: 300, Checksum: 4000},
+			{TableName: "`db1`.`t2`", Status: checkpoints.TaskStatusFailed, Error: "checksum mismatched"},
+		},
+	}
+	tables := `[{"table":"` + "`db1`.`t1`" + `","status":"succeeded","rows":10,"kvs":20,"bytes":300,"checksum":4000},` +
+		`{"table":"` + "`db1`.`t2`" + `","status":"failed","rows":0,"kvs":0,"bytes":0,"checksum":0,"error":"checksum mismatched"}]`
+
+	s.mock.
+		ExpectExec("REPLACE INTO `mock-schema`\\.task_history .+ VALUES \\(\\?, \\?, FROM_UNIXTIME\\(\\?\\), FROM_UNIXTIME\\(\\?\\), \\?, \\?, \\?\\)").
+		WithArgs(1234, "backend=importer", 1565238374, 1565238601, "failed", "checksum mismatched", []byte(tables)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	err = historyDB.SaveTaskHistory(ctx, history)
+	c.Assert(err, IsNil)
+	c.Assert(s.mock.ExpectationsWereMet(), IsNil)
+
+	// a running task has no end time.
+	s.mock.
+		ExpectExec("REPLACE INTO `mock-schema`\\.task_history .+").
+		WithArgs(5678, "backend=tidb", 1565239000, nil, "running", "", []byte("null")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	err = historyDB.SaveTaskHistory(ctx, &checkpoints.TaskHistory{
+		TaskID:    5678,
+		Config:    "backend=tidb",
+		StartTime: time.Unix(1565239000, 0),
+		Status:    checkpoints.TaskStatusRunning,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.mock.ExpectationsWereMet(), IsNil)
+
+	columns := []string{"task_id", "config", "start_time", "end_time", "status", "error_summary", "tables"}
+	s.mock.
+		ExpectQuery("SELECT task_id, .+ FROM `mock-schema`\\.task_history\\s+ORDER BY task_id DESC").
+		WillReturnRows(
+			sqlmock.NewRows(columns).
+				AddRow(5678, "backend=tidb", 1565239000, nil, "running", "", "null").
+				AddRow(1234, "backend=importer", 1565238374, 1565238601, "failed", "checksum mismatched", tables),
+		)
+	histories, err := historyDB.ListTaskHistory(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(histories, DeepEquals, []*checkpoints.TaskHistory{
+		{
+			TaskID:    5678,
+			Config:    "backend=tidb",
+			StartTime: time.Unix(1565239000, 0),
+			Status:    checkpoints.TaskStatusRunning,
+		},
+		history,
+	})
+
+	s.mock.
+		ExpectQuery("SELECT task_id, .+ FROM `mock-schema`\\.task_history WHERE task_id = \\? ORDER BY task_id DESC").
+		WithArgs(1234).
+		WillReturnRows(
+			sqlmock.NewRows(columns).
+				AddRow(1234, "backend=importer", 1565238374, 1565238601, "failed", "checksum mismatched", tables),
+		)
+	got, err := historyDB.GetTaskHistory(ctx, 1234)
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, history)
+
+	s.mock.
+		ExpectQuery("SELECT task_id, .+ FROM `mock-schema`\\.task_history WHERE task_id = \\? ORDER BY task_id DESC").
+		WithArgs(9999).
+		WillReturnRows(sqlmock.NewRows(columns))
+	got, err = historyDB.GetTaskHistory(ctx, 9999)
+	c.Assert(err, IsNil)
+	c.Assert(got, IsNil)
+}
+
+func (s *cpSQLSuite) TestTaskHistoryReader(c *C) {
+	ctx := context.Background()
+	// the reader creates nothing, any CREATE statement would be unexpected.
+	historyDB := checkpoints.NewMySQLTaskHistoryReader(s.db, "mock-schema")
+
+	s.mock.
+		ExpectQuery("SELECT task_id, .+ FROM `mock-schema`\\.task_history\\s+ORDER BY task_id DESC").
+		WillReturnError(&gomysql.MySQLError{Number: 1049, Message: "Unknown database 'mock-schema'"})
+	histories, err := historyDB.ListTaskHistory(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(histories, HasLen, 0)
+
+	s.mock.
+		ExpectQuery("SELECT task_id, .+ FROM `mock-schema`\\.task_history WHERE task_id = \\? ORDER BY task_id DESC").
+		WithArgs(1234).
+		WillReturnError(&gomysql.MySQLError{Number: 1146, Message: "Table 'mock-schema.task_history' doesn't exist"})
+	got, err := historyDB.GetTaskHistory(ctx, 1234)
+	c.Assert(err, IsNil)
+	c.Assert(got, IsNil)
+
+	// other errors are still reported.
+	s.mock.
+		ExpectQuery("SELECT task_id, .+ FROM `mock-schema`\\.task_history\\s+ORDER BY task_id DESC").
+		WillReturnError(&gomysql.MySQLError{Number: 1045, Message: "Access denied"})
+	_, err = historyDB.ListTaskHistory(ctx)
+	c.Assert(err, ErrorMatches, ".*Access denied")
+	c.Assert(s.mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *cpSQLSuite) TestPrintTaskHistory(c *C) {
+	history := &checkpoints.TaskHistory{
+		TaskID:    1234,
+		Config:    "backend=importer",
+		StartTime: time.Date(2019, 8, 8, 12, 26, 14, 0, time.Local),
+		Status:    checkpoints.TaskStatusRunning,
+		Tables: []*checkpoints.TableHistory{
+			{TableName: "`db1`.`t1`", Status: checkpoints.TaskStatusSucceeded, Rows: 10, KVs: 20, Bytes: 300, Checksum: 4000},
+		},
+	}
+
+	var output strings.Builder
+	err := checkpoints.PrintTaskHistoryList(&output, []*checkpoints.TaskHistory{history})
+	c.Assert(err, IsNil)
+	c.Assert(output.String(), Equals, ""+
+		"TASK ID  STATUS   START TIME           END TIME  CONFIG\n"+
+		"1234     running  2019-08-08 12:26:14  -         backend=importer\n")
+
+	output.Reset()
+	err = checkpoints.PrintTaskHistory(&output, history)
+	c.Assert(err, IsNil)
+	c.Assert(output.String(), Equals, ""+
+		"task ID:     1234\n"+
+		"status:      running\n"+
+		"start time:  2019-08-08 12:26:14\n"+
+		"end time:    -\n"+
+		"config:      backend=importer\n"+
+		"\n"+
+		"TABLE       STATUS     ROWS  KVS  BYTES  CHECKSUM  ERROR\n"+
+		"`db1`.`t1`  succeeded  10    20   300    4000      \n")
+}
+
+func (s *cpSQLSuite) TestTableLease(c *C) {
+	ctx := context.Background()
+	leaseDB, err := checkpoints.AsTableLeaseDB(s.cpdb)
+	c.Assert(err, IsNil)
+
+	lease := &checkpoints.TableLease{TableName: "`db1`.`t1`", Owner: "host-1/1234", Importer: "127.0.0.1:8287"}
+	columns := []string{"owner", "importer", "valid"}
+
+	// a table never leased before is acquired.
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT owner, importer, expire_time > NOW\\(\\) FROM `mock-schema`\\.lease_v\\d+ WHERE table_name = \\? FOR UPDATE").
+		WithArgs("`db1`.`t1`").
+		WillReturnRows(sqlmock.NewRows(columns))
+	s.mock.
+		ExpectExec("REPLACE INTO `mock-schema`\\.lease_v\\d+ .+ VALUES \\(\\?, \\?, \\?, NOW\\(\\) \\+ INTERVAL \\? SECOND\\)").
+		WithArgs("`db1`.`t1`", "host-1/1234", "127.0.0.1:8287", 2).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+	acquired, previous, err := leaseDB.AcquireTableLease(ctx, lease, 1500*time.Millisecond)
+	c.Assert(err, IsNil)
+	c.Assert(acquired, IsTrue)
+	c.Assert(previous, IsNil)
+	c.Assert(s.mock.ExpectationsWereMet(), IsNil)
+
+	// a valid lease of another owner cannot be taken over.
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT owner, importer, expire_time > NOW\\(\\) FROM `mock-schema`\\.lease_v\\d+ WHERE table_name = \\? FOR UPDATE").
+		WithArgs("`db1`.`t1`").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("host-2/5678", "127.0.0.2:8287", true))
+	s.mock.ExpectCommit()
+	acquired, previous, err = leaseDB.AcquireTableLease(ctx, lease, 30*time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(acquired, IsFalse)
+	c.Assert(previous, DeepEquals, &checkpoints.TableLease{TableName: "`db1`.`t1`", Owner: "host-2/5678", Importer: "127.0.0.2:8287"})
+	c.Assert(s.mock.ExpectationsWereMet(), IsNil)
+
+	// an expired lease is taken over.
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT owner, importer, expire_time > NOW\\(\\) FROM `mock-schema`\\.lease_v\\d+ WHERE table_name = \\? FOR UPDATE").
+		WithArgs("`db1`.`t1`").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("host-2/5678", "127.0.0.2:8287", false))
+	s.mock.
+		ExpectExec("REPLACE INTO `mock-schema`\\.lease_v\\d+ .+").
+		WithArgs("`db1`.`t1`", "host-1/1234", "127.0.0.1:8287", 30).
+		WillReturnResult(sqlmock.NewResult(1, 2))
+	s.mock.ExpectCommit()
+	acquired, previous, err = leaseDB.AcquireTableLease(ctx, lease, 30*time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(acquired, IsTrue)
+	c.Assert(previous, DeepEquals, &checkpoints.TableLease{TableName: "`db1`.`t1`", Owner: "host-2/5678", Importer: "127.0.0.2:8287"})
+	c.Assert(s.mock.ExpectationsWereMet(), IsNil)
+
+	// renewing reports the leases no longer held.
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectExec("UPDATE `mock-schema`\\.lease_v\\d+ SET expire_time = NOW\\(\\) \\+ INTERVAL \\? SECOND\\s+WHERE table_name = \\? AND owner = \\? AND expire_time > NOW\\(\\)").
+		WithArgs(30, "`db1`.`t1`", "host-1/1234").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.
+		ExpectExec("UPDATE `mock-schema`\\.lease_v\\d+ SET expire_time = .+").
+		WithArgs(30, "`db1`.`t2`", "host-1/1234").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.
+		ExpectQuery("SELECT table_name FROM `mock-schema`\\.lease_v\\d+ WHERE owner = \\? AND expire_time > NOW\\(\\)").
+		WithArgs("host-1/1234").
+		WillReturnRows(sqlmock.NewRows([]string{"table_name"}).AddRow("`db1`.`t1`"))
+	s.mock.ExpectCommit()
+	lost, err := leaseDB.RenewTableLeases(ctx, "host-1/1234", []string{"`db1`.`t1`", "`db1`.`t2`"}, 30*time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(lost, DeepEquals, []string{"`db1`.`t2`"})
+	c.Assert(s.mock.ExpectationsWereMet(), IsNil)
+
+	lost, err = leaseDB.RenewTableLeases(ctx, "host-1/1234", nil, 30*time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(lost, HasLen, 0)
+
+	_, err = checkpoints.AsTableLeaseDB(checkpoints.NewNullCheckpointsDB())
+	c.Assert(err, ErrorMatches, "the distributed import is only supported by the \"mysql\" checkpoint driver")
+}
@@ -1,6 +1,8 @@
 package checkpoints
 
 import (
+	"bytes"
+	"io/ioutil"
 	"path/filepath"
 	"testing"
 
@@ -188,6 +190,50 @@ func (s *checkpointSuite) TestRebaseCheckpoint(c *C) {
 	})
 }
 
+func (s *checkpointSuite) TestTableSettingsCheckpoint(c *C) {
+	cpd := NewTableCheckpointDiff()
+
+	m := TableSettingsCheckpointMerger{
+		BaseChecksum: verification.MakeKVChecksum(1234, 56, 7890),
+		TimeZone:     "+08:00",
+		OnDuplicate:  "upsert",
+		Masks:        `{"a":{"type":"nullify"}}`,
+		RowIDBase:    500,
+	}
+	m.MergeInto(cpd)
+
+	c.Assert(cpd, DeepEquals, &TableCheckpointDiff{
+		hasSettings: true,
+		settings:    m,
+		engines:     make(map[int32]engineCheckpointDiff),
+	})
+
+	cp := TableCheckpoint{Engines: map[int32]*EngineCheckpoint{}}
+	cp.Apply(cpd)
+	c.Assert(cp.BaseChecksum, Equals, verification.MakeKVChecksum(1234, 56, 7890))
+	c.Assert(cp.TimeZone, Equals, "+08:00")
+	c.Assert(cp.OnDuplicate, Equals, "upsert")
+	c.Assert(cp.Masks, Equals, `{"a":{"type":"nullify"}}`)
+	c.Assert(cp.RowIDBase, Equals, int64(500))
+}
+
+func (s *checkpointSuite) TestTaskIDCheckpoint(c *C) {
+	cpd := NewTableCheckpointDiff()
+
+	m := TaskIDCheckpointMerger{TaskID: 1555555555}
+	m.MergeInto(cpd)
+
+	c.Assert(cpd, DeepEquals, &TableCheckpointDiff{
+		hasTaskID: true,
+		taskID:    1555555555,
+		engines:   make(map[int32]engineCheckpointDiff),
+	})
+
+	cp := TableCheckpoint{Engines: map[int32]*EngineCheckpoint{}}
+	cp.Apply(cpd)
+	c.Assert(cp.TaskID, Equals, int64(1555555555))
+}
+
 func (s *checkpointSuite) TestApplyDiff(c *C) {
 	cp := TableCheckpoint{
 		Status:    CheckpointStatusLoaded,
@@ -292,14 +338,141 @@ func (s *checkpointSuite) TestApplyDiff(c *C) {
 
 func (s *checkpointSuite) TestCheckpointMarshallUnmarshall(c *C) {
 	path := filepath.Join(c.MkDir(), "filecheckpoint")
-	fileChkp := NewFileCheckpointsDB(path)
+	fileChkp, err := NewFileCheckpointsDB(path, 0)
+	c.Assert(err, IsNil)
 	fileChkp.checkpoints.Checkpoints["a"] = &TableCheckpointModel{
 		Status:  uint32(CheckpointStatusLoaded),
 		Engines: map[int32]*EngineCheckpointModel{},
 	}
-	fileChkp.Close()
+	c.Assert(fileChkp.Close(), IsNil)
 
-	fileChkp2 := NewFileCheckpointsDB(path)
+	fileChkp2, err := NewFileCheckpointsDB(path, 0)
+	c.Assert(err, IsNil)
 	// if not recover empty map explicitly, it will become nil
 	c.Assert(fileChkp2.checkpoints.Checkpoints["a"].Engines, NotNil)
 }
+
+func (s *checkpointSuite) TestPrepareReimport(c *C) {
+	dir := c.MkDir()
+	writeFile := func(name string, size int) string {
+		path := filepath.Join(dir, name)
+		c.Assert(ioutil.WriteFile(path, bytes.Repeat([]byte{'x'}, size), 0644), IsNil)
+		return path
+	}
+	pathA := writeFile("a.csv", 100)
+	pathB := writeFile("b.csv", 200)
+	pathC := writeFile("c.csv", 300)
+
+	newChunk := func(path string, offset, endOffset, prevRowIDMax, rowIDMax int64) *ChunkCheckpoint {
+		return &ChunkCheckpoint{
+			Key:         ChunkCheckpointKey{Path: path, Offset: offset},
+			Chunk:       mydump.Chunk{Offset: endOffset, EndOffset: endOffset, PrevRowIDMax: prevRowIDMax, RowIDMax: rowIDMax},
+			Checksum:    verification.MakeKVChecksum(1000, 10, 12345),
+			Fingerprint: "old",
+		}
+	}
+	newCheckpoint := func() *TableCheckpoint {
+		return &TableCheckpoint{
+			// the checksum has failed.
+			Status: CheckpointStatusChecksummed / 10,
+			Engines: map[int32]*EngineCheckpoint{
+				-1: {Status: CheckpointStatusImported},
+				0: {Status: CheckpointStatusImported, Chunks: []*ChunkCheckpoint{
+					newChunk(pathA, 0, 100, 40, 50),
+					newChunk(pathB, 0, 200, 130, 150),
+				}},
+				1: {Status: CheckpointStatusImported, Chunks: []*ChunkCheckpoint{
+					newChunk(pathC, 0, 150, 200, 225),
+					newChunk(pathC, 150, 300, 290, 300),
+				}},
+			},
+		}
+	}
+	fingerprint := func(path string) string {
+		fp, err := mydump.FileFingerprint(path)
+		c.Assert(err, IsNil)
+		return fp
+	}
+
+	// a replaced file which has become smaller.
+	writeFile("b.csv", 180)
+	engines, err := PrepareReimport("`db`.`t`", newCheckpoint(), nil, []string{pathB})
+	c.Assert(err, IsNil)
+	c.Assert(engines, DeepEquals, map[int32]*EngineCheckpoint{
+		-1: {Status: CheckpointStatusLoaded},
+		0: {Status: CheckpointStatusLoaded, Chunks: []*ChunkCheckpoint{{
+			Key:               ChunkCheckpointKey{Path: pathB},
+			ColumnPermutation: []int{},
+			Chunk:             mydump.Chunk{Offset: 0, EndOffset: 180, PrevRowIDMax: 50, RowIDMax: 150},
+			Fingerprint:       fingerprint(pathB),
+		}}},
+	})
+
+	// a whole engine, including a file split into several chunks.
+	engines, err = PrepareReimport("`db`.`t`", newCheckpoint(), []int32{1}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(engines, HasLen, 2)
+	c.Assert(engines[1].Chunks, HasLen, 2)
+	c.Assert(engines[1].Chunks[0].Chunk, Equals, mydump.Chunk{Offset: 0, EndOffset: 150, PrevRowIDMax: 150, RowIDMax: 225})
+	c.Assert(engines[1].Chunks[1].Chunk, Equals, mydump.Chunk{Offset: 150, EndOffset: 300, PrevRowIDMax: 225, RowIDMax: 300})
+
+	writeFile("a.csv", 120)
+	_, err = PrepareReimport("`db`.`t`", newCheckpoint(), []int32{0}, nil)
+	c.Assert(err, ErrorMatches, "cannot re-import table `db`.`t`: source file .*a.csv has grown from 100 to 120 bytes, .*")
+	writeFile("c.csv", 290)
+	_, err = PrepareReimport("`db`.`t`", newCheckpoint(), nil, []string{pathC})
+	c.Assert(err, ErrorMatches, "cannot re-import table `db`.`t`: source file .*c.csv was split into 2 chunks, but its size has changed from 300 to 290 bytes")
+
+	_, err = PrepareReimport("`db`.`t`", newCheckpoint(), nil, nil)
+	c.Assert(err, ErrorMatches, "nothing to re-import.*")
+	_, err = PrepareReimport("`db`.`t`", newCheckpoint(), []int32{2}, nil)
+	c.Assert(err, ErrorMatches, "engine `db`.`t`:2 not found")
+	_, err = PrepareReimport("`db`.`t`", newCheckpoint(), []int32{-1}, nil)
+	c.Assert(err, ErrorMatches, "the index engine of table `db`.`t` cannot be re-imported alone.*")
+	_, err = PrepareReimport("`db`.`t`", newCheckpoint(), nil, []string{"/tmp/d.csv"})
+	c.Assert(err, ErrorMatches, "no chunks of table `db`.`t` are read from /tmp/d.csv")
+
+	cp := newCheckpoint()
+	cp.Status = CheckpointStatusAllWritten
+	_, err = PrepareReimport("`db`.`t`", cp, []int32{0}, nil)
+	c.Assert(err, ErrorMatches, "table `db`.`t` has not been fully imported yet.*")
+	cp.Status = CheckpointStatusIndexImported / 10
+	_, err = PrepareReimport("`db`.`t`", cp, []int32{0}, nil)
+	c.Assert(err, ErrorMatches, "table `db`.`t` has not been fully imported yet.*")
+}
+
+func (s *checkpointSuite) TestRewindTable(c *C) {
+	newChunk := func(path string, offset, endOffset, prevRowIDMax, rowIDMax int64) *ChunkCheckpoint {
+		return &ChunkCheckpoint{
+			Key:         ChunkCheckpointKey{Path: path, Offset: offset},
+			Chunk:       mydump.Chunk{Offset: endOffset, EndOffset: endOffset, PrevRowIDMax: prevRowIDMax, RowIDMax: rowIDMax},
+			Checksum:    verification.MakeKVChecksum(1000, 10, 12345),
+			Fingerprint: "fp",
+		}
+	}
+	cp := &TableCheckpoint{
+		Status: CheckpointStatusAllWritten,
+		Engines: map[int32]*EngineCheckpoint{
+			-1: {Status: CheckpointStatusLoaded},
+			0: {Status: CheckpointStatusImported, Chunks: []*ChunkCheckpoint{
+				newChunk("/tmp/a.csv", 0, 100, 50, 50),
+			}},
+			1: {Status: CheckpointStatusClosed, Chunks: []*ChunkCheckpoint{
+				newChunk("/tmp/b.csv", 0, 200, 80, 150),
+			}},
+		},
+	}
+
+	engines := RewindTable(cp)
+	c.Assert(engines, HasLen, 3)
+	c.Assert(engines[-1], DeepEquals, &EngineCheckpoint{Status: CheckpointStatusLoaded, Chunks: []*ChunkCheckpoint{}})
+	c.Assert(engines[0].Status, Equals, CheckpointStatusLoaded)
+	c.Assert(engines[0].Chunks[0].Chunk, Equals, mydump.Chunk{Offset: 0, EndOffset: 100, PrevRowIDMax: 0, RowIDMax: 50})
+	c.Assert(engines[0].Chunks[0].Checksum, Equals, verification.KVChecksum{})
+	c.Assert(engines[0].Chunks[0].Fingerprint, Equals, "fp")
+	c.Assert(engines[1].Status, Equals, CheckpointStatusLoaded)
+	c.Assert(engines[1].Chunks[0].Chunk, Equals, mydump.Chunk{Offset: 0, EndOffset: 200, PrevRowIDMax: 50, RowIDMax: 150})
+
+	// the original checkpoint is unchanged.
+	c.Assert(cp.Engines[1].Chunks[0].Chunk.PrevRowIDMax, Equals, int64(80))
+}
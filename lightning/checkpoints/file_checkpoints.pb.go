@@ -27,6 +27,12 @@ const _ = proto.GoGoProtoPackageIsVersion3 // please upgrade the proto package
 type CheckpointsModel struct {
 	// key is table_name
 	Checkpoints map[string]*TableCheckpointModel `protobuf:"bytes,1,rep,name=checkpoints,proto3" json:"checkpoints,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// ID of the journal containing the changes after this snapshot
+	JournalId uint64 `protobuf:"varint,2,opt,name=journal_id,json=journalId,proto3" json:"journal_id,omitempty"`
+	// ID of the task which created the checkpoints
+	TaskId int64 `protobuf:"varint,3,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	// normalized data-affecting settings of the task
+	ConfigFingerprint string `protobuf:"bytes,4,opt,name=config_fingerprint,json=configFingerprint,proto3" json:"config_fingerprint,omitempty"`
 }
 
 func (m *CheckpointsModel) Reset()         { *m = CheckpointsModel{} }
@@ -64,9 +70,22 @@ var xxx_messageInfo_CheckpointsModel proto.InternalMessageInfo
 
 type TableCheckpointModel struct {
 	Hash      []byte                           `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	TaskId    int64                            `protobuf:"varint,2,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
 	Status    uint32                           `protobuf:"varint,3,opt,name=status,proto3" json:"status,omitempty"`
 	AllocBase int64                            `protobuf:"varint,4,opt,name=alloc_base,json=allocBase,proto3" json:"alloc_base,omitempty"`
 	Engines   map[int32]*EngineCheckpointModel `protobuf:"bytes,8,rep,name=engines,proto3" json:"engines,omitempty" protobuf_key:"zigzag32,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// checksum of the existing data before an incremental import
+	KvcBytes    uint64 `protobuf:"varint,9,opt,name=kvc_bytes,json=kvcBytes,proto3" json:"kvc_bytes,omitempty"`
+	KvcKvs      uint64 `protobuf:"varint,10,opt,name=kvc_kvs,json=kvcKvs,proto3" json:"kvc_kvs,omitempty"`
+	KvcChecksum uint64 `protobuf:"fixed64,11,opt,name=kvc_checksum,json=kvcChecksum,proto3" json:"kvc_checksum,omitempty"`
+	// row ID the first chunk starts after
+	RowIdBase int64 `protobuf:"varint,12,opt,name=row_id_base,json=rowIdBase,proto3" json:"row_id_base,omitempty"`
+	// time zone used to encode the table
+	TimeZone string `protobuf:"bytes,13,opt,name=time_zone,json=timeZone,proto3" json:"time_zone,omitempty"`
+	// action on duplicated rows used to write the table
+	OnDuplicate string `protobuf:"bytes,14,opt,name=on_duplicate,json=onDuplicate,proto3" json:"on_duplicate,omitempty"`
+	// fingerprint of the column masks used to encode the table
+	Masks string `protobuf:"bytes,15,opt,name=masks,proto3" json:"masks,omitempty"`
 }
 
 func (m *TableCheckpointModel) Reset()         { *m = TableCheckpointModel{} }
@@ -153,6 +172,8 @@ type ChunkCheckpointModel struct {
 	KvcKvs            uint64  `protobuf:"varint,10,opt,name=kvc_kvs,json=kvcKvs,proto3" json:"kvc_kvs,omitempty"`
 	KvcChecksum       uint64  `protobuf:"fixed64,11,opt,name=kvc_checksum,json=kvcChecksum,proto3" json:"kvc_checksum,omitempty"`
 	Timestamp         int64   `protobuf:"fixed64,13,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// fingerprint of the source file when the chunk was created
+	Fingerprint string `protobuf:"bytes,14,opt,name=fingerprint,proto3" json:"fingerprint,omitempty"`
 }
 
 func (m *ChunkCheckpointModel) Reset()         { *m = ChunkCheckpointModel{} }
@@ -203,43 +224,44 @@ func init() {
 }
 
 var fileDescriptor_deb32a9bf46ada61 = []byte{
-	// 575 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x53, 0xcd, 0x6e, 0xd3, 0x4c,
+	// 581 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x54, 0xcd, 0x6e, 0xd3, 0x4c,
 	0x14, 0xcd, 0xc4, 0x6d, 0x9a, 0xdc, 0xa4, 0x9f, 0xd2, 0x51, 0xdb, 0x6f, 0x14, 0xc0, 0x32, 0x15,
 	0x0b, 0x23, 0xda, 0x44, 0x2a, 0x1b, 0x54, 0xb1, 0x6a, 0xe9, 0x0a, 0x55, 0x54, 0x23, 0xd8, 0xb0,
 	0xb1, 0xc6, 0xce, 0xc4, 0xb6, 0xfc, 0x33, 0x96, 0x67, 0x6c, 0xda, 0xb7, 0xe0, 0x4d, 0x78, 0x02,
-	0xf6, 0x5d, 0x76, 0xc9, 0x82, 0x05, 0xb4, 0x8f, 0xc0, 0x0b, 0x20, 0x8f, 0x8d, 0xe2, 0xa0, 0xa8,
-	0x62, 0x77, 0xef, 0x39, 0xe7, 0x9e, 0x99, 0xa3, 0xab, 0x0b, 0x87, 0x71, 0xe8, 0x07, 0x2a, 0x0d,
-	0x53, 0x7f, 0xe6, 0x05, 0xdc, 0x8b, 0x32, 0x11, 0xa6, 0x4a, 0xce, 0x16, 0x61, 0xcc, 0x9d, 0x16,
-	0x30, 0xcd, 0x72, 0xa1, 0xc4, 0xe4, 0xc8, 0x0f, 0x55, 0x50, 0xb8, 0x53, 0x4f, 0x24, 0x33, 0x5f,
-	0xf8, 0x62, 0xa6, 0x61, 0xb7, 0x58, 0xe8, 0x4e, 0x37, 0xba, 0xaa, 0xe5, 0x07, 0x5f, 0x10, 0x8c,
-	0xcf, 0x96, 0x26, 0x17, 0x62, 0xce, 0x63, 0xfc, 0x06, 0x86, 0x2d, 0x63, 0x82, 0x2c, 0xc3, 0x1e,
-	0x1e, 0x1f, 0x4c, 0xff, 0xd6, 0xb5, 0x81, 0xf3, 0x54, 0xe5, 0xd7, 0xb4, 0x3d, 0x36, 0xf9, 0xb0,
-	0xe2, 0xac, 0x05, 0x78, 0x0c, 0x46, 0xc4, 0xaf, 0x09, 0xb2, 0x90, 0x3d, 0xa0, 0x55, 0x89, 0x5f,
-	0xc0, 0x66, 0xc9, 0xe2, 0x82, 0x93, 0xae, 0x85, 0xec, 0xe1, 0xf1, 0xde, 0xf4, 0x3d, 0x73, 0x63,
-	0xbe, 0x1c, 0xd4, 0x2f, 0xd1, 0x5a, 0x73, 0xd2, 0x7d, 0x85, 0x0e, 0x7e, 0x21, 0xd8, 0x5d, 0xa7,
-	0xc1, 0x18, 0x36, 0x02, 0x26, 0x03, 0x6d, 0x3e, 0xa2, 0xba, 0xc6, 0xfb, 0xd0, 0x93, 0x8a, 0xa9,
-	0x42, 0x12, 0xc3, 0x42, 0xf6, 0x36, 0x6d, 0x3a, 0xfc, 0x04, 0x80, 0xc5, 0xb1, 0xf0, 0x1c, 0x97,
-	0x49, 0x4e, 0x36, 0x2c, 0x64, 0x1b, 0x74, 0xa0, 0x91, 0x53, 0x26, 0x39, 0x7e, 0x0d, 0x5b, 0x3c,
-	0xf5, 0xc3, 0x94, 0x4b, 0xd2, 0x6f, 0xc2, 0xaf, 0x7b, 0x72, 0x7a, 0x5e, 0x8b, 0xea, 0xf0, 0x7f,
-	0x46, 0x26, 0x14, 0x46, 0x6d, 0xa2, 0x1d, 0x7a, 0xa7, 0x0e, 0x7d, 0xb8, 0x1a, 0x7a, 0xbf, 0x31,
-	0x7a, 0x20, 0xf5, 0x57, 0x04, 0x7b, 0x6b, 0x45, 0xad, 0x88, 0x68, 0x25, 0xe2, 0x09, 0xf4, 0xbc,
-	0xa0, 0x48, 0x23, 0x49, 0xba, 0x4d, 0x84, 0xb5, 0xf3, 0xd3, 0x33, 0x2d, 0xaa, 0x23, 0x34, 0x13,
-	0x93, 0x4b, 0x18, 0xb6, 0xe0, 0x7f, 0xd9, 0x9a, 0x96, 0x3f, 0xf0, 0xff, 0xef, 0x5d, 0xd8, 0x5d,
-	0xa7, 0xa9, 0xb6, 0x96, 0x31, 0x15, 0x34, 0xe6, 0xba, 0xae, 0x22, 0x89, 0xc5, 0x42, 0x72, 0xa5,
-	0xed, 0x0d, 0xda, 0x74, 0xf8, 0x08, 0xb0, 0x27, 0xe2, 0x22, 0x49, 0x9d, 0x8c, 0xe7, 0x49, 0xa1,
-	0x98, 0x0a, 0x45, 0x4a, 0x46, 0x96, 0x61, 0x6f, 0xd2, 0x9d, 0x9a, 0xb9, 0x5c, 0x12, 0xd5, 0x92,
-	0x79, 0x3a, 0x77, 0x1a, 0xab, 0xcd, 0x7a, 0xc9, 0x3c, 0x9d, 0xbf, 0xab, 0xdd, 0xc6, 0x60, 0x64,
-	0x42, 0x92, 0x9e, 0xc6, 0xab, 0x12, 0x3f, 0x83, 0xff, 0xb2, 0x9c, 0x97, 0x4e, 0x2e, 0x3e, 0x85,
-	0x73, 0x27, 0x61, 0x57, 0x64, 0x4b, 0x93, 0xa3, 0x0a, 0xa5, 0x15, 0x78, 0xc1, 0xae, 0xf0, 0x23,
-	0x18, 0x2c, 0x05, 0x7d, 0x2d, 0xe8, 0xe7, 0x2d, 0x32, 0x2a, 0x3d, 0xc7, 0xbd, 0x56, 0x5c, 0x92,
-	0x81, 0x85, 0xec, 0x0d, 0xda, 0x8f, 0x4a, 0xef, 0xb4, 0xea, 0xf1, 0xff, 0xb0, 0x55, 0x91, 0x51,
-	0x29, 0x09, 0x68, 0xaa, 0x17, 0x95, 0xde, 0xdb, 0x52, 0xe2, 0xa7, 0x30, 0xaa, 0x08, 0x7d, 0x3d,
-	0xb2, 0x48, 0xc8, 0xd0, 0x42, 0x76, 0x8f, 0x0e, 0xa3, 0xd2, 0x3b, 0x6b, 0x20, 0xfc, 0x18, 0x06,
-	0x2a, 0x4c, 0xb8, 0x54, 0x2c, 0xc9, 0xc8, 0xb6, 0x85, 0xec, 0x31, 0x5d, 0x02, 0xa7, 0xcf, 0x6f,
-	0x7e, 0x9a, 0x9d, 0x9b, 0x3b, 0x13, 0xdd, 0xde, 0x99, 0xe8, 0xc7, 0x9d, 0x89, 0x3e, 0xdf, 0x9b,
-	0x9d, 0xdb, 0x7b, 0xb3, 0xf3, 0xed, 0xde, 0xec, 0x7c, 0x6c, 0x9f, 0xa5, 0xdb, 0xd3, 0x87, 0xff,
-	0xf2, 0x77, 0x00, 0x00, 0x00, 0xff, 0xff, 0x1e, 0x3f, 0x8e, 0x94, 0x57, 0x04, 0x00, 0x00,
+	0xf6, 0x5d, 0xb0, 0xe8, 0x92, 0x05, 0x0b, 0x68, 0x5f, 0x04, 0x79, 0x6c, 0x14, 0xb7, 0x8a, 0x2a,
+	0x36, 0xec, 0xee, 0x3d, 0xe7, 0xdc, 0x33, 0x33, 0x47, 0xd7, 0x86, 0xfd, 0x38, 0xf4, 0x03, 0x95,
+	0x86, 0xa9, 0x3f, 0xf3, 0x02, 0xee, 0x45, 0x99, 0x08, 0x53, 0x25, 0x67, 0x8b, 0x30, 0xe6, 0x4e,
+	0x0b, 0x98, 0x66, 0xb9, 0x50, 0x62, 0x72, 0xe0, 0x87, 0x2a, 0x28, 0xdc, 0xa9, 0x27, 0x92, 0x99,
+	0x2f, 0x7c, 0x31, 0xd3, 0xb0, 0x5b, 0x2c, 0x74, 0xa7, 0x1b, 0x5d, 0xd5, 0xf2, 0xbd, 0x2f, 0x08,
+	0xc6, 0x27, 0x4b, 0x93, 0x33, 0x31, 0xe7, 0x31, 0x7e, 0x03, 0xc3, 0x96, 0x31, 0x41, 0x96, 0x61,
+	0x0f, 0x0f, 0xf7, 0xa6, 0xf7, 0x75, 0x6d, 0xe0, 0x34, 0x55, 0xf9, 0x25, 0x6d, 0x8f, 0x4d, 0x3e,
+	0xc0, 0xf8, 0xbe, 0x00, 0x8f, 0xc1, 0x88, 0xf8, 0x25, 0x41, 0x16, 0xb2, 0x07, 0xb4, 0x2a, 0xf1,
+	0x0b, 0x58, 0x2f, 0x59, 0x5c, 0x70, 0xd2, 0xb5, 0x90, 0x3d, 0x3c, 0xdc, 0x99, 0xbe, 0x67, 0x6e,
+	0xcc, 0x97, 0x83, 0xfa, 0x24, 0x5a, 0x6b, 0x8e, 0xba, 0xaf, 0xd0, 0xde, 0xb7, 0x2e, 0x6c, 0xaf,
+	0xd2, 0x60, 0x0c, 0x6b, 0x01, 0x93, 0x81, 0x36, 0x1f, 0x51, 0x5d, 0xe3, 0x5d, 0xe8, 0x49, 0xc5,
+	0x54, 0x21, 0x89, 0x61, 0x21, 0x7b, 0x93, 0x36, 0x1d, 0x7e, 0x02, 0xc0, 0xe2, 0x58, 0x78, 0x8e,
+	0xcb, 0x24, 0x27, 0x6b, 0x16, 0xb2, 0x0d, 0x3a, 0xd0, 0xc8, 0x31, 0x93, 0x1c, 0xbf, 0x86, 0x0d,
+	0x9e, 0xfa, 0x61, 0xca, 0x25, 0xe9, 0x37, 0x8f, 0x5f, 0x75, 0xe4, 0xf4, 0xb4, 0x16, 0xd5, 0x8f,
+	0xff, 0x33, 0x82, 0x1f, 0xc1, 0x20, 0x2a, 0x3d, 0xc7, 0xbd, 0x54, 0x5c, 0x92, 0x81, 0x85, 0xec,
+	0x35, 0xda, 0x8f, 0x4a, 0xef, 0xb8, 0xea, 0xf1, 0xff, 0xb0, 0x51, 0x91, 0x51, 0x29, 0x09, 0x68,
+	0xaa, 0x17, 0x95, 0xde, 0xdb, 0x52, 0xe2, 0xa7, 0x30, 0xaa, 0x08, 0x9d, 0xa0, 0x2c, 0x12, 0x32,
+	0xb4, 0x90, 0xdd, 0xa3, 0xc3, 0xa8, 0xf4, 0x4e, 0x1a, 0x68, 0x42, 0x61, 0xd4, 0x3e, 0xb1, 0x9d,
+	0xe6, 0x56, 0x9d, 0xe6, 0xfe, 0xdd, 0x34, 0x77, 0x9b, 0x1b, 0x3e, 0x10, 0xe7, 0x57, 0x04, 0x3b,
+	0x2b, 0x45, 0xad, 0xec, 0xd0, 0x9d, 0xec, 0x8e, 0xa0, 0xe7, 0x05, 0x45, 0x1a, 0x49, 0xd2, 0x6d,
+	0xb2, 0x59, 0x39, 0x3f, 0x3d, 0xd1, 0xa2, 0x3a, 0x9b, 0x66, 0x62, 0x72, 0x0e, 0xc3, 0x16, 0xfc,
+	0x37, 0xeb, 0xa0, 0xe5, 0x0f, 0xdc, 0xff, 0x47, 0x17, 0xb6, 0x57, 0x69, 0xaa, 0x75, 0xc8, 0x98,
+	0x0a, 0x1a, 0x73, 0x5d, 0x57, 0x4f, 0x12, 0x8b, 0x85, 0xe4, 0x4a, 0xdb, 0x1b, 0xb4, 0xe9, 0xf0,
+	0x01, 0x60, 0x4f, 0xc4, 0x45, 0x92, 0x3a, 0x19, 0xcf, 0x93, 0x42, 0x31, 0x15, 0x8a, 0x94, 0x8c,
+	0x2c, 0xc3, 0x5e, 0xa7, 0x5b, 0x35, 0x73, 0xbe, 0x24, 0xaa, 0xed, 0xe1, 0xe9, 0xdc, 0x69, 0xac,
+	0xd6, 0xeb, 0xed, 0xe1, 0xe9, 0xfc, 0x5d, 0xed, 0x36, 0x06, 0x23, 0x13, 0x92, 0xf4, 0x34, 0x5e,
+	0x95, 0xf8, 0x19, 0xfc, 0x97, 0xe5, 0xbc, 0x74, 0x72, 0xf1, 0x29, 0x9c, 0x3b, 0x09, 0xbb, 0x20,
+	0x1b, 0x9a, 0x1c, 0x55, 0x28, 0xad, 0xc0, 0x33, 0x76, 0x51, 0xed, 0xcd, 0x52, 0xd0, 0xd7, 0x82,
+	0x7e, 0xde, 0x22, 0xff, 0xc9, 0x52, 0xe1, 0xc7, 0x30, 0x50, 0x61, 0xc2, 0xa5, 0x62, 0x49, 0x46,
+	0x36, 0x2d, 0x64, 0x8f, 0xe9, 0x12, 0x38, 0x7e, 0x7e, 0xf5, 0xcb, 0xec, 0x5c, 0xdd, 0x98, 0xe8,
+	0xfa, 0xc6, 0x44, 0x3f, 0x6f, 0x4c, 0xf4, 0xf9, 0xd6, 0xec, 0x5c, 0xdf, 0x9a, 0x9d, 0xef, 0xb7,
+	0x66, 0xe7, 0x63, 0xfb, 0x7b, 0x77, 0x7b, 0xfa, 0x8f, 0xf2, 0xf2, 0xf7, 0x00, 0x8b, 0xca, 0x95,
+	0xc3, 0xb0, 0x04, 0x00, 0x00,
 }
 
 func (m *CheckpointsModel) Marshal() (dAtA []byte, err error) {
@@ -262,6 +284,23 @@ func (m *CheckpointsModel) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.ConfigFingerprint) > 0 {
+		i -= len(m.ConfigFingerprint)
+		copy(dAtA[i:], m.ConfigFingerprint)
+		i = encodeVarintFileCheckpoints(dAtA, i, uint64(len(m.ConfigFingerprint)))
+		i--
+		dAtA[i] = 0x22
+	}
+	if m.TaskId != 0 {
+		i = encodeVarintFileCheckpoints(dAtA, i, uint64(m.TaskId))
+		i--
+		dAtA[i] = 0x18
+	}
+	if m.JournalId != 0 {
+		i = encodeVarintFileCheckpoints(dAtA, i, uint64(m.JournalId))
+		i--
+		dAtA[i] = 0x10
+	}
 	if len(m.Checkpoints) > 0 {
 		for k := range m.Checkpoints {
 			v := m.Checkpoints[k]
@@ -311,6 +350,48 @@ func (m *TableCheckpointModel) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.Masks) > 0 {
+		i -= len(m.Masks)
+		copy(dAtA[i:], m.Masks)
+		i = encodeVarintFileCheckpoints(dAtA, i, uint64(len(m.Masks)))
+		i--
+		dAtA[i] = 0x7a
+	}
+	if len(m.OnDuplicate) > 0 {
+		i -= len(m.OnDuplicate)
+		copy(dAtA[i:], m.OnDuplicate)
+		i = encodeVarintFileCheckpoints(dAtA, i, uint64(len(m.OnDuplicate)))
+		i--
+		dAtA[i] = 0x72
+	}
+	if len(m.TimeZone) > 0 {
+		i -= len(m.TimeZone)
+		copy(dAtA[i:], m.TimeZone)
+		i = encodeVarintFileCheckpoints(dAtA, i, uint64(len(m.TimeZone)))
+		i--
+		dAtA[i] = 0x6a
+	}
+	if m.RowIdBase != 0 {
+		i = encodeVarintFileCheckpoints(dAtA, i, uint64(m.RowIdBase))
+		i--
+		dAtA[i] = 0x60
+	}
+	if m.KvcChecksum != 0 {
+		i -= 8
+		encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(m.KvcChecksum))
+		i--
+		dAtA[i] = 0x59
+	}
+	if m.KvcKvs != 0 {
+		i = encodeVarintFileCheckpoints(dAtA, i, uint64(m.KvcKvs))
+		i--
+		dAtA[i] = 0x50
+	}
+	if m.KvcBytes != 0 {
+		i = encodeVarintFileCheckpoints(dAtA, i, uint64(m.KvcBytes))
+		i--
+		dAtA[i] = 0x48
+	}
 	if len(m.Engines) > 0 {
 		for k := range m.Engines {
 			v := m.Engines[k]
@@ -345,6 +426,11 @@ func (m *TableCheckpointModel) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i--
 		dAtA[i] = 0x18
 	}
+	if m.TaskId != 0 {
+		i = encodeVarintFileCheckpoints(dAtA, i, uint64(m.TaskId))
+		i--
+		dAtA[i] = 0x10
+	}
 	if len(m.Hash) > 0 {
 		i -= len(m.Hash)
 		copy(dAtA[i:], m.Hash)
@@ -429,6 +515,13 @@ func (m *ChunkCheckpointModel) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.Fingerprint) > 0 {
+		i -= len(m.Fingerprint)
+		copy(dAtA[i:], m.Fingerprint)
+		i = encodeVarintFileCheckpoints(dAtA, i, uint64(len(m.Fingerprint)))
+		i--
+		dAtA[i] = 0x72
+	}
 	if m.Timestamp != 0 {
 		i -= 8
 		encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(m.Timestamp))
@@ -535,6 +628,16 @@ func (m *CheckpointsModel) Size() (n int) {
 			n += mapEntrySize + 1 + sovFileCheckpoints(uint64(mapEntrySize))
 		}
 	}
+	if m.JournalId != 0 {
+		n += 1 + sovFileCheckpoints(uint64(m.JournalId))
+	}
+	if m.TaskId != 0 {
+		n += 1 + sovFileCheckpoints(uint64(m.TaskId))
+	}
+	l = len(m.ConfigFingerprint)
+	if l > 0 {
+		n += 1 + l + sovFileCheckpoints(uint64(l))
+	}
 	return n
 }
 
@@ -548,6 +651,9 @@ func (m *TableCheckpointModel) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovFileCheckpoints(uint64(l))
 	}
+	if m.TaskId != 0 {
+		n += 1 + sovFileCheckpoints(uint64(m.TaskId))
+	}
 	if m.Status != 0 {
 		n += 1 + sovFileCheckpoints(uint64(m.Status))
 	}
@@ -567,6 +673,30 @@ func (m *TableCheckpointModel) Size() (n int) {
 			n += mapEntrySize + 1 + sovFileCheckpoints(uint64(mapEntrySize))
 		}
 	}
+	if m.KvcBytes != 0 {
+		n += 1 + sovFileCheckpoints(uint64(m.KvcBytes))
+	}
+	if m.KvcKvs != 0 {
+		n += 1 + sovFileCheckpoints(uint64(m.KvcKvs))
+	}
+	if m.KvcChecksum != 0 {
+		n += 9
+	}
+	if m.RowIdBase != 0 {
+		n += 1 + sovFileCheckpoints(uint64(m.RowIdBase))
+	}
+	l = len(m.TimeZone)
+	if l > 0 {
+		n += 1 + l + sovFileCheckpoints(uint64(l))
+	}
+	l = len(m.OnDuplicate)
+	if l > 0 {
+		n += 1 + l + sovFileCheckpoints(uint64(l))
+	}
+	l = len(m.Masks)
+	if l > 0 {
+		n += 1 + l + sovFileCheckpoints(uint64(l))
+	}
 	return n
 }
 
@@ -639,6 +769,10 @@ func (m *ChunkCheckpointModel) Size() (n int) {
 	if m.Timestamp != 0 {
 		n += 9
 	}
+	l = len(m.Fingerprint)
+	if l > 0 {
+		n += 1 + l + sovFileCheckpoints(uint64(l))
+	}
 	return n
 }
 
@@ -806,6 +940,76 @@ func (m *CheckpointsModel) Unmarshal(dAtA []byte) error {
 			}
 			m.Checkpoints[mapkey] = mapvalue
 			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field JournalId", wireType)
+			}
+			m.JournalId = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFileCheckpoints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.JournalId |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TaskId", wireType)
+			}
+			m.TaskId = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFileCheckpoints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.TaskId |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ConfigFingerprint", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFileCheckpoints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthFileCheckpoints
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthFileCheckpoints
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ConfigFingerprint = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipFileCheckpoints(dAtA[iNdEx:])
@@ -893,6 +1097,25 @@ func (m *TableCheckpointModel) Unmarshal(dAtA []byte) error {
 				m.Hash = []byte{}
 			}
 			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TaskId", wireType)
+			}
+			m.TaskId = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFileCheckpoints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.TaskId |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		case 3:
 			if wireType != 0 {
 				return fmt.Errorf("proto: wrong wireType = %d for field Status", wireType)
@@ -1049,6 +1272,169 @@ func (m *TableCheckpointModel) Unmarshal(dAtA []byte) error {
 			}
 			m.Engines[mapkey] = mapvalue
 			iNdEx = postIndex
+		case 9:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field KvcBytes", wireType)
+			}
+			m.KvcBytes = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFileCheckpoints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.KvcBytes |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 10:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field KvcKvs", wireType)
+			}
+			m.KvcKvs = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFileCheckpoints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.KvcKvs |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 11:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field KvcChecksum", wireType)
+			}
+			m.KvcChecksum = 0
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.KvcChecksum = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+		case 12:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RowIdBase", wireType)
+			}
+			m.RowIdBase = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFileCheckpoints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.RowIdBase |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 13:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TimeZone", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFileCheckpoints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthFileCheckpoints
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthFileCheckpoints
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.TimeZone = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 14:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field OnDuplicate", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFileCheckpoints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthFileCheckpoints
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthFileCheckpoints
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.OnDuplicate = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 15:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Masks", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFileCheckpoints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthFileCheckpoints
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthFileCheckpoints
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Masks = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipFileCheckpoints(dAtA[iNdEx:])
@@ -1564,6 +1950,38 @@ func (m *ChunkCheckpointModel) Unmarshal(dAtA []byte) error {
 			}
 			m.Timestamp = int64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
 			iNdEx += 8
+		case 14:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Fingerprint", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFileCheckpoints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthFileCheckpoints
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthFileCheckpoints
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Fingerprint = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipFileCheckpoints(dAtA[iNdEx:])
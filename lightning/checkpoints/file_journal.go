@@ -0,0 +1,262 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package checkpoints
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// The file checkpoints consist of a snapshot and a journal.
+//
+// The snapshot is a serialized CheckpointsModel, which is only ever replaced
+// atomically by writing a temporary file and renaming it over the old one.
+//
+// The journal is an append-only file recording the changes made by Update()
+// since the snapshot was written. It starts with a header:
+//
+//	+---------------+-----------------------+
+//	| magic (8 B)   | journal ID (8 B, BE)  |
+//	+---------------+-----------------------+
+//
+// followed by the records:
+//
+//	+------------------+-----------------+-------------------+
+//	| length (4 B, BE) | CRC32C (4 B, BE) | payload (length B) |
+//	+------------------+-----------------+-------------------+
+//
+// Each payload is a serialized CheckpointsModel containing the new values of
+// the tables, engines and chunks touched by a TableCheckpointDiff.
+//
+// The journal ID must be equal to the JournalId stored in the snapshot,
+// otherwise the journal was written before the snapshot and is ignored.
+
+var journalMagic = []byte("LTNGJRNL")
+
+var journalCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+const (
+	journalHeaderSize       = 16
+	journalRecordHeaderSize = 8
+
+	// the journal is compacted into the snapshot when it grows larger than
+	// both this size and the snapshot itself.
+	journalCompactMinSize = 16 << 20
+)
+
+func journalPath(path string) string {
+	return path + ".journal"
+}
+
+func encodeJournalHeader(journalID uint64) []byte {
+	header := make([]byte, journalHeaderSize)
+	copy(header, journalMagic)
+	binary.BigEndian.PutUint64(header[len(journalMagic):], journalID)
+	return header
+}
+
+func encodeJournalRecord(record *CheckpointsModel) ([]byte, error) {
+	payload, err := record.Marshal()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	buf := make([]byte, journalRecordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.Checksum(payload, journalCRCTable))
+	copy(buf[journalRecordHeaderSize:], payload)
+	return buf, nil
+}
+
+// replayJournal applies the valid records of the journal content to the
+// checkpoints, and returns the size of the valid prefix. A record which is
+// truncated or fails the checksum is treated as the torn tail left by a
+// crash: it and everything after it are discarded.
+func replayJournal(content []byte, cps *CheckpointsModel, logger log.Logger) (int64, bool) {
+	if len(content) < journalHeaderSize || !bytes.Equal(content[:len(journalMagic)], journalMagic) {
+		logger.Warn("checkpoint journal header is broken, ignoring the journal")
+		return 0, false
+	}
+	journalID := binary.BigEndian.Uint64(content[len(journalMagic):])
+	if journalID != cps.JournalId {
+		logger.Info("checkpoint journal is older than the snapshot, ignoring the journal",
+			zap.Uint64("journalID", journalID), zap.Uint64("snapshotJournalID", cps.JournalId))
+		return 0, false
+	}
+
+	offset := journalHeaderSize
+	records := 0
+	for offset < len(content) {
+		rest := content[offset:]
+		if len(rest) < journalRecordHeaderSize {
+			logger.Warn("checkpoint journal has a torn record header, discarding the tail",
+				zap.Int("offset", offset), zap.Int("size", len(rest)))
+			break
+		}
+		length := int(binary.BigEndian.Uint32(rest))
+		checksum := binary.BigEndian.Uint32(rest[4:])
+		if len(rest)-journalRecordHeaderSize < length {
+			logger.Warn("checkpoint journal has a torn record, discarding the tail",
+				zap.Int("offset", offset), zap.Int("size", len(rest)), zap.Int("length", length))
+			break
+		}
+		payload := rest[journalRecordHeaderSize : journalRecordHeaderSize+length]
+		if crc32.Checksum(payload, journalCRCTable) != checksum {
+			logger.Warn("checkpoint journal record checksum mismatch, discarding the tail",
+				zap.Int("offset", offset), zap.Int("length", length))
+			break
+		}
+		var record CheckpointsModel
+		if err := record.Unmarshal(payload); err != nil {
+			logger.Warn("checkpoint journal record is broken, discarding the tail",
+				zap.Int("offset", offset), zap.Error(err))
+			break
+		}
+		mergeCheckpointsModel(cps, &record)
+		offset += journalRecordHeaderSize + length
+		records++
+	}
+
+	logger.Info("replayed checkpoint journal", zap.Int("records", records), zap.Int("size", offset))
+	return int64(offset), true
+}
+
+// mergeCheckpointsModel overwrites the tables, engines and chunks in `dst` by
+// those recorded in `src`.
+func mergeCheckpointsModel(dst *CheckpointsModel, src *CheckpointsModel) {
+	for tableName, srcTable := range src.Checkpoints {
+		table, ok := dst.Checkpoints[tableName]
+		if !ok {
+			table = &TableCheckpointModel{Engines: map[int32]*EngineCheckpointModel{}}
+			dst.Checkpoints[tableName] = table
+		}
+		table.Status = srcTable.Status
+		table.AllocBase = srcTable.AllocBase
+		table.KvcBytes = srcTable.KvcBytes
+		table.KvcKvs = srcTable.KvcKvs
+		table.KvcChecksum = srcTable.KvcChecksum
+		table.TimeZone = srcTable.TimeZone
+		table.OnDuplicate = srcTable.OnDuplicate
+		table.Masks = srcTable.Masks
+		table.TaskId = srcTable.TaskId
+		table.RowIdBase = srcTable.RowIdBase
+
+		for engineID, srcEngine := range srcTable.Engines {
+			engine, ok := table.Engines[engineID]
+			if !ok {
+				engine = &EngineCheckpointModel{Chunks: map[string]*ChunkCheckpointModel{}}
+				table.Engines[engineID] = engine
+			}
+			engine.Status = srcEngine.Status
+			for key, chunk := range srcEngine.Chunks {
+				engine.Chunks[key] = chunk
+			}
+		}
+	}
+}
+
+// writeFileAtomic replaces the file at `path` by `content`, such that the
+// file contains either the old or the new content after a crash.
+func writeFileAtomic(path string, content []byte) error {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = file.Write(content); err == nil {
+		err = file.Sync()
+	}
+	if err2 := file.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return errors.Trace(err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(syncDir(filepath.Dir(path)))
+}
+
+// syncDir persists the directory entries, e.g. after a rename.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = d.Sync()
+	if err2 := d.Close(); err == nil {
+		err = err2
+	}
+	return errors.Trace(err)
+}
+
+// loadFileCheckpoints reads the snapshot and replays the journal. It returns
+// the size of the valid prefix of the journal, or -1 if the journal should
+// be recreated.
+func loadFileCheckpoints(path string, cps *CheckpointsModel) (int64, error) {
+	logger := log.With(zap.String("path", path))
+
+	content, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := cps.Unmarshal(content); err != nil {
+			return 0, errors.Annotatef(err,
+				"checkpoint file %s is broken, please fix or remove it to start over", path)
+		}
+	case os.IsNotExist(err):
+		logger.Info("checkpoint file not found, going to create a new one")
+	default:
+		return 0, errors.Annotatef(err, "failed to read checkpoint file %s", path)
+	}
+
+	// FIXME: patch for empty map may need initialize manually, because currently
+	// FIXME: a map of zero size -> marshall -> unmarshall -> become nil, see checkpoint_test.go
+	if cps.Checkpoints == nil {
+		cps.Checkpoints = map[string]*TableCheckpointModel{}
+	}
+	for _, table := range cps.Checkpoints {
+		if table.Engines == nil {
+			table.Engines = map[int32]*EngineCheckpointModel{}
+		}
+		for _, engine := range table.Engines {
+			if engine.Chunks == nil {
+				engine.Chunks = map[string]*ChunkCheckpointModel{}
+			}
+		}
+	}
+
+	journal, err := ioutil.ReadFile(journalPath(path))
+	switch {
+	case err == nil:
+		size, ok := replayJournal(journal, cps, logger)
+		if !ok {
+			return -1, nil
+		}
+		return size, nil
+	case os.IsNotExist(err):
+		return -1, nil
+	default:
+		return 0, errors.Annotatef(err, "failed to read checkpoint journal %s", journalPath(path))
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package checkpoints
+
+import (
+	"os"
+	"sort"
+
+	"github.com/pingcap/errors"
+
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+	verify "github.com/pingcap/tidb-lightning/lightning/verification"
+)
+
+// the ID of the index engine, see restore.indexEngineID.
+const reimportIndexEngineID = -1
+
+// PrepareReimport computes the engine checkpoints to be passed to
+// ResetEngineCheckpoints, such that the chunks of the given data engines, and
+// the chunks read from the given source files, are imported again. The index
+// engine is always imported again since the re-imported chunks produce index
+// KVs too.
+//
+// Only tables which have been fully imported (but possibly failed in the
+// post-processing, e.g. the checksum) can be re-imported. The re-imported
+// chunks are read from the current content of the source files, whose new
+// fingerprints are recorded. The rows previously imported from the chunks
+// must be deleted before the checkpoints are reset, otherwise they are left
+// in the table together with their index entries.
+func PrepareReimport(tableName string, cp *TableCheckpoint, engineIDs []int32, paths []string) (map[int32]*EngineCheckpoint, error) {
+	if len(engineIDs) == 0 && len(paths) == 0 {
+		return nil, errors.New("nothing to re-import, please specify the engine IDs or the source files")
+	}
+	if _, ok := cp.Engines[reimportIndexEngineID]; !ok {
+		return nil, errors.Errorf("table %s has not been imported yet", tableName)
+	}
+	// an invalid status records the failed step as `status * 10`.
+	if (cp.Status > CheckpointStatusMaxInvalid && cp.Status < CheckpointStatusIndexImported) ||
+		(cp.Status <= CheckpointStatusMaxInvalid && cp.Status*10 <= CheckpointStatusIndexImported) {
+		return nil, errors.Errorf("table %s has not been fully imported yet, the index engine may still contain the KVs of the chunks to be re-imported; please resume the import or use `--checkpoint-error-destroy` instead", tableName)
+	}
+
+	resetAll := make(map[int32]struct{}, len(engineIDs))
+	for _, engineID := range engineIDs {
+		if engineID == reimportIndexEngineID {
+			return nil, errors.Errorf("the index engine of table %s cannot be re-imported alone, please specify the data engines instead", tableName)
+		}
+		if _, ok := cp.Engines[engineID]; !ok {
+			return nil, errors.Errorf("engine %s:%d not found", tableName, engineID)
+		}
+		resetAll[engineID] = struct{}{}
+	}
+	resetPaths := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		resetPaths[path] = struct{}{}
+	}
+
+	files := make(map[string]*sourceFileInfo)
+	for engineID, engine := range cp.Engines {
+		if engineID == reimportIndexEngineID {
+			continue
+		}
+		for _, chunk := range engine.Chunks {
+			file, ok := files[chunk.Key.Path]
+			if !ok {
+				file = &sourceFileInfo{}
+				files[chunk.Key.Path] = file
+			}
+			file.chunks++
+			if chunk.Chunk.EndOffset > file.size {
+				file.size = chunk.Chunk.EndOffset
+			}
+		}
+	}
+	startRowIDs := chunkStartRowIDs(cp)
+
+	engines := map[int32]*EngineCheckpoint{
+		reimportIndexEngineID: {Status: CheckpointStatusLoaded},
+	}
+	foundPaths := make(map[string]struct{}, len(paths))
+	for engineID, engine := range cp.Engines {
+		if engineID == reimportIndexEngineID {
+			continue
+		}
+		_, all := resetAll[engineID]
+		var chunks []*ChunkCheckpoint
+		for _, chunk := range engine.Chunks {
+			_, ok := resetPaths[chunk.Key.Path]
+			if ok {
+				foundPaths[chunk.Key.Path] = struct{}{}
+			}
+			if !ok && !all {
+				continue
+			}
+			reset, err := resetChunk(chunk, startRowIDs[chunk.Key], files[chunk.Key.Path])
+			if err != nil {
+				return nil, errors.Annotatef(err, "cannot re-import table %s", tableName)
+			}
+			chunks = append(chunks, reset)
+		}
+		if len(chunks) > 0 {
+			engines[engineID] = &EngineCheckpoint{Status: CheckpointStatusLoaded, Chunks: chunks}
+		}
+	}
+
+	for _, path := range paths {
+		if _, ok := foundPaths[path]; !ok {
+			return nil, errors.Errorf("no chunks of table %s are read from %s", tableName, path)
+		}
+	}
+	return engines, nil
+}
+
+// RewindTable computes the engine checkpoints to be passed to
+// ResetEngineCheckpoints, such that every engine of the table, including the
+// index engine, is restored from the beginning of its chunks. This is needed
+// when the engines written so far are no longer available, e.g. they were
+// kept by another importer.
+func RewindTable(cp *TableCheckpoint) map[int32]*EngineCheckpoint {
+	startRowIDs := chunkStartRowIDs(cp)
+	engines := make(map[int32]*EngineCheckpoint, len(cp.Engines))
+	for engineID, engine := range cp.Engines {
+		chunks := make([]*ChunkCheckpoint, 0, len(engine.Chunks))
+		for _, chunk := range engine.Chunks {
+			rewound := chunk.DeepCopy()
+			rewound.Chunk.Offset = chunk.Key.Offset
+			rewound.Chunk.PrevRowIDMax = startRowIDs[chunk.Key]
+			rewound.Checksum = verify.KVChecksum{}
+			chunks = append(chunks, rewound)
+		}
+		engines[engineID] = &EngineCheckpoint{Status: CheckpointStatusLoaded, Chunks: chunks}
+	}
+	return engines
+}
+
+// chunkStartRowIDs returns the row ID each chunk of the table started from.
+// The chunks of a table are allocated consecutive ranges of row IDs from the
+// RowIDBase, so each chunk started from the RowIDMax of the previous one.
+func chunkStartRowIDs(cp *TableCheckpoint) map[ChunkCheckpointKey]int64 {
+	var allChunks []*ChunkCheckpoint
+	for _, engine := range cp.Engines {
+		allChunks = append(allChunks, engine.Chunks...)
+	}
+	sort.Slice(allChunks, func(i, j int) bool {
+		return allChunks[i].Chunk.RowIDMax < allChunks[j].Chunk.RowIDMax
+	})
+	startRowIDs := make(map[ChunkCheckpointKey]int64, len(allChunks))
+	startRowID := cp.RowIDBase
+	for _, chunk := range allChunks {
+		startRowIDs[chunk.Key] = startRowID
+		startRowID = chunk.Chunk.RowIDMax
+	}
+	return startRowIDs
+}
+
+// sourceFileInfo describes a source file as recorded in the checkpoint.
+type sourceFileInfo struct {
+	// chunks is the number of chunks the file was split into.
+	chunks int
+	// size is the size of the file when the chunks were created.
+	size int64
+}
+
+// resetChunk returns the chunk rewound to its beginning, with the end offset
+// and fingerprint taken from the current source file.
+func resetChunk(chunk *ChunkCheckpoint, startRowID int64, file *sourceFileInfo) (*ChunkCheckpoint, error) {
+	path := chunk.Key.Path
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot read source file %s", path)
+	}
+	fingerprint, err := mydump.FileFingerprint(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot read source file %s", path)
+	}
+
+	reset := chunk.DeepCopy()
+	reset.Chunk.Offset = chunk.Key.Offset
+	reset.Chunk.PrevRowIDMax = startRowID
+	reset.Checksum = verify.KVChecksum{}
+	reset.Fingerprint = fingerprint
+
+	switch size := info.Size(); {
+	case size == file.size:
+	case file.chunks > 1:
+		// the chunk boundaries were found by scanning the old content.
+		return nil, errors.Errorf("source file %s was split into %d chunks, but its size has changed from %d to %d bytes", path, file.chunks, file.size, size)
+	case size > file.size:
+		// the row IDs allocated to the chunk are estimated from the file
+		// size, so a larger file may use up the range and collide with the
+		// next chunk.
+		return nil, errors.Errorf("source file %s has grown from %d to %d bytes, and may contain more rows than the allocated row IDs", path, file.size, size)
+	default:
+		reset.Chunk.EndOffset = size
+	}
+	return reset, nil
+}
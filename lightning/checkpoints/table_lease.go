@@ -0,0 +1,189 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package checkpoints
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// the table storing the leases of the distributed import.
+const checkpointTableNameLease = "lease_v1"
+
+// TableLease is the claim of a Lightning instance to restore a table in the
+// distributed import. The lease expires unless it is renewed periodically, so
+// that the table can be taken over by another instance after a crash.
+type TableLease struct {
+	TableName string
+	// Owner identifies the instance holding the lease.
+	Owner string
+	// Importer is the address of the importer into which the instance writes
+	// the engines of the table. Empty for the TiDB backend.
+	Importer string
+}
+
+// TableLeaseDB is implemented by the checkpoints databases which can
+// coordinate several instances importing the same source.
+type TableLeaseDB interface {
+	// AcquireTableLease claims the table unless it is leased by another owner
+	// and the lease has not expired. It returns whether the lease is acquired,
+	// and the previous lease of the table, which is nil if the table has never
+	// been leased.
+	AcquireTableLease(ctx context.Context, lease *TableLease, duration time.Duration) (bool, *TableLease, error)
+	// RenewTableLeases extends the leases of the given tables held by the
+	// owner, and returns the tables whose leases have been lost, i.e. expired
+	// or taken over by other owners.
+	RenewTableLeases(ctx context.Context, owner string, tableNames []string, duration time.Duration) ([]string, error)
+}
+
+// AsTableLeaseDB returns the table leases kept by the checkpoints database.
+func AsTableLeaseDB(cpdb CheckpointsDB) (TableLeaseDB, error) {
+	if leaseDB, ok := cpdb.(TableLeaseDB); ok {
+		return leaseDB, nil
+	}
+	return nil, errors.New("the distributed import is only supported by the \"mysql\" checkpoint driver")
+}
+
+func createTableLeaseTable(ctx context.Context, s common.SQLWithRetry, schema string) error {
+	return s.Exec(ctx, "create table lease table", fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s.%s (
+			table_name varchar(261) NOT NULL PRIMARY KEY,
+			owner varchar(255) NOT NULL,
+			importer varchar(255) NOT NULL,
+			expire_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			update_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+		);
+	`, schema, checkpointTableNameLease))
+}
+
+// leaseSeconds rounds the lease duration up to whole seconds, the precision
+// of the expire time.
+func leaseSeconds(duration time.Duration) int64 {
+	return int64((duration + time.Second - 1) / time.Second)
+}
+
+func (cpdb *MySQLCheckpointsDB) AcquireTableLease(ctx context.Context, lease *TableLease, duration time.Duration) (bool, *TableLease, error) {
+	// the expire time is always compared with the clock of the database, so
+	// the clocks of the instances need not be synchronized.
+	selectQuery := fmt.Sprintf(`
+		SELECT owner, importer, expire_time > NOW() FROM %s.%s WHERE table_name = ? FOR UPDATE;
+	`, cpdb.schema, checkpointTableNameLease)
+	replaceQuery := fmt.Sprintf(`
+		REPLACE INTO %s.%s (table_name, owner, importer, expire_time)
+		VALUES (?, ?, ?, NOW() + INTERVAL ? SECOND);
+	`, cpdb.schema, checkpointTableNameLease)
+
+	s := common.SQLWithRetry{
+		DB:     cpdb.db,
+		Logger: log.With(zap.String("table", lease.TableName), zap.String("owner", lease.Owner)),
+	}
+
+	var (
+		acquired bool
+		previous *TableLease
+	)
+	err := s.Transact(ctx, "acquire table lease", func(c context.Context, tx *sql.Tx) error {
+		acquired = false
+		previous = nil
+
+		prev := TableLease{TableName: lease.TableName}
+		var valid bool
+		err := tx.QueryRowContext(c, selectQuery, lease.TableName).Scan(&prev.Owner, &prev.Importer, &valid)
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return errors.Trace(err)
+		case valid && prev.Owner != lease.Owner:
+			previous = &prev
+			return nil
+		default:
+			previous = &prev
+		}
+
+		if _, err := tx.ExecContext(c, replaceQuery, lease.TableName, lease.Owner, lease.Importer, leaseSeconds(duration)); err != nil {
+			return errors.Trace(err)
+		}
+		acquired = true
+		return nil
+	})
+	if err != nil {
+		return false, nil, errors.Trace(err)
+	}
+	return acquired, previous, nil
+}
+
+func (cpdb *MySQLCheckpointsDB) RenewTableLeases(ctx context.Context, owner string, tableNames []string, duration time.Duration) ([]string, error) {
+	if len(tableNames) == 0 {
+		return nil, nil
+	}
+
+	renewQuery := fmt.Sprintf(`
+		UPDATE %s.%s SET expire_time = NOW() + INTERVAL ? SECOND
+		WHERE table_name = ? AND owner = ? AND expire_time > NOW();
+	`, cpdb.schema, checkpointTableNameLease)
+	selectQuery := fmt.Sprintf(`
+		SELECT table_name FROM %s.%s WHERE owner = ? AND expire_time > NOW();
+	`, cpdb.schema, checkpointTableNameLease)
+
+	s := common.SQLWithRetry{
+		DB:     cpdb.db,
+		Logger: log.With(zap.String("owner", owner)),
+	}
+
+	var lost []string
+	err := s.Transact(ctx, "renew table leases", func(c context.Context, tx *sql.Tx) error {
+		lost = lost[:0]
+		for _, tableName := range tableNames {
+			if _, err := tx.ExecContext(c, renewQuery, leaseSeconds(duration), tableName, owner); err != nil {
+				return errors.Trace(err)
+			}
+		}
+
+		rows, err := tx.QueryContext(c, selectQuery, owner)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer rows.Close()
+		held := make(map[string]struct{}, len(tableNames))
+		for rows.Next() {
+			var tableName string
+			if err := rows.Scan(&tableName); err != nil {
+				return errors.Trace(err)
+			}
+			held[tableName] = struct{}{}
+		}
+		if err := rows.Err(); err != nil {
+			return errors.Trace(err)
+		}
+
+		for _, tableName := range tableNames {
+			if _, ok := held[tableName]; !ok {
+				lost = append(lost, tableName)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return lost, nil
+}
@@ -0,0 +1,255 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package checkpoints
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/pingcap/errors"
+	tmysql "github.com/pingcap/parser/mysql"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+const (
+	TaskStatusRunning   = "running"
+	TaskStatusSucceeded = "succeeded"
+	TaskStatusFailed    = "failed"
+	TaskStatusCanceled  = "canceled"
+)
+
+// the table storing the task history. It is neither moved nor dropped
+// together with the checkpoints, so that the past tasks are kept.
+const checkpointTableNameTaskHistory = "task_history"
+
+// TaskHistory is the record of a task in the task history.
+type TaskHistory struct {
+	TaskID int64 `json:"task-id"`
+	// Config is a short summary of the task configuration.
+	Config    string    `json:"config"`
+	StartTime time.Time `json:"start-time"`
+	// EndTime is zero if the task is still running (or was killed).
+	EndTime time.Time       `json:"end-time"`
+	Status  string          `json:"status"`
+	Error   string          `json:"error,omitempty"`
+	Tables  []*TableHistory `json:"tables"`
+}
+
+// TableHistory is the result of importing a table in a task.
+type TableHistory struct {
+	TableName string `json:"table"`
+	Status    string `json:"status"`
+	Rows      int64  `json:"rows"`
+	KVs       uint64 `json:"kvs"`
+	Bytes     uint64 `json:"bytes"`
+	Checksum  uint64 `json:"checksum"`
+	Error     string `json:"error,omitempty"`
+}
+
+// TaskHistoryDB is implemented by the checkpoints databases which keep the
+// history of the tasks.
+type TaskHistoryDB interface {
+	// SaveTaskHistory records the task, replacing the existing record of the
+	// same task ID.
+	SaveTaskHistory(ctx context.Context, history *TaskHistory) error
+	// ListTaskHistory returns all recorded tasks, latest first.
+	ListTaskHistory(ctx context.Context) ([]*TaskHistory, error)
+	// GetTaskHistory returns the record of the task, or nil if the task is
+	// not found.
+	GetTaskHistory(ctx context.Context, taskID int64) (*TaskHistory, error)
+}
+
+// AsTaskHistoryDB returns the task history kept by the checkpoints database.
+func AsTaskHistoryDB(cpdb CheckpointsDB) (TaskHistoryDB, error) {
+	if historyDB, ok := cpdb.(TaskHistoryDB); ok {
+		return historyDB, nil
+	}
+	return nil, errors.New("the task history is only kept by the \"mysql\" checkpoint driver")
+}
+
+// NewMySQLTaskHistoryReader opens the task history kept in the given schema
+// for reading only. Unlike NewMySQLCheckpointsDB, it does not create the
+// schema or any table, and a missing schema is read as an empty history.
+func NewMySQLTaskHistoryReader(db *sql.DB, schemaName string) *MySQLCheckpointsDB {
+	var escapedSchemaName strings.Builder
+	common.WriteMySQLIdentifier(&escapedSchemaName, schemaName)
+	return &MySQLCheckpointsDB{
+		db:     db,
+		schema: escapedSchemaName.String(),
+	}
+}
+
+func createTaskHistoryTable(ctx context.Context, s common.SQLWithRetry, schema string) error {
+	return s.Exec(ctx, "create task history table", fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s.%s (
+			task_id bigint NOT NULL PRIMARY KEY,
+			config text NOT NULL,
+			start_time timestamp NULL DEFAULT NULL,
+			end_time timestamp NULL DEFAULT NULL,
+			status varchar(16) NOT NULL,
+			error_summary text NOT NULL,
+			tables mediumtext NOT NULL
+		);
+	`, schema, checkpointTableNameTaskHistory))
+}
+
+func unixTimeOrNull(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Unix()
+}
+
+func (cpdb *MySQLCheckpointsDB) SaveTaskHistory(ctx context.Context, history *TaskHistory) error {
+	tables, err := json.Marshal(history.Tables)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	s := common.SQLWithRetry{
+		DB:     cpdb.db,
+		Logger: log.With(zap.Int64("taskID", history.TaskID)),
+	}
+	return s.Exec(ctx, "save task history", fmt.Sprintf(`
+		REPLACE INTO %s.%s (task_id, config, start_time, end_time, status, error_summary, tables)
+		VALUES (?, ?, FROM_UNIXTIME(?), FROM_UNIXTIME(?), ?, ?, ?);
+	`, cpdb.schema, checkpointTableNameTaskHistory),
+		history.TaskID, history.Config, unixTimeOrNull(history.StartTime), unixTimeOrNull(history.EndTime),
+		history.Status, history.Error, tables,
+	)
+}
+
+func (cpdb *MySQLCheckpointsDB) queryTaskHistory(ctx context.Context, condition string, args ...interface{}) ([]*TaskHistory, error) {
+	rows, err := cpdb.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT task_id, config, UNIX_TIMESTAMP(start_time), UNIX_TIMESTAMP(end_time), status, error_summary, tables
+		FROM %s.%s %s ORDER BY task_id DESC;
+	`, cpdb.schema, checkpointTableNameTaskHistory, condition), args...)
+	if err != nil {
+		// nothing is recorded yet if the history was never created.
+		if isNoSuchTableError(err) {
+			return nil, nil
+		}
+		return nil, errors.Trace(err)
+	}
+	defer rows.Close()
+
+	var histories []*TaskHistory
+	for rows.Next() {
+		var (
+			history            TaskHistory
+			startTime, endTime sql.NullInt64
+			tables             []byte
+		)
+		if err := rows.Scan(
+			&history.TaskID, &history.Config, &startTime, &endTime,
+			&history.Status, &history.Error, &tables,
+		); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if startTime.Valid {
+			history.StartTime = time.Unix(startTime.Int64, 0)
+		}
+		if endTime.Valid {
+			history.EndTime = time.Unix(endTime.Int64, 0)
+		}
+		if err := json.Unmarshal(tables, &history.Tables); err != nil {
+			return nil, errors.Annotatef(err, "invalid table results of task %d", history.TaskID)
+		}
+		histories = append(histories, &history)
+	}
+	return histories, errors.Trace(rows.Err())
+}
+
+func isNoSuchTableError(err error) bool {
+	if mysqlErr, ok := errors.Cause(err).(*gomysql.MySQLError); ok {
+		return mysqlErr.Number == tmysql.ErrNoSuchTable || mysqlErr.Number == tmysql.ErrBadDB
+	}
+	return false
+}
+
+func (cpdb *MySQLCheckpointsDB) ListTaskHistory(ctx context.Context) ([]*TaskHistory, error) {
+	return cpdb.queryTaskHistory(ctx, "")
+}
+
+func (cpdb *MySQLCheckpointsDB) GetTaskHistory(ctx context.Context, taskID int64) (*TaskHistory, error) {
+	histories, err := cpdb.queryTaskHistory(ctx, "WHERE task_id = ?", taskID)
+	if err != nil || len(histories) == 0 {
+		return nil, err
+	}
+	return histories[0], nil
+}
+
+func formatHistoryTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// PrintTaskHistoryList writes one line for every task in a human-readable
+// format, e.g.
+//
+//	TASK ID               STATUS     START TIME           END TIME             CONFIG
+//	1565238374522018389   succeeded  2019-08-08 12:26:14  2019-08-08 12:30:01  backend=importer ...
+func PrintTaskHistoryList(writer io.Writer, histories []*TaskHistory) error {
+	w := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK ID\tSTATUS\tSTART TIME\tEND TIME\tCONFIG")
+	for _, history := range histories {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n",
+			history.TaskID, history.Status,
+			formatHistoryTime(history.StartTime), formatHistoryTime(history.EndTime),
+			history.Config,
+		)
+	}
+	return errors.Trace(w.Flush())
+}
+
+// PrintTaskHistory writes the details of a task, including the result of
+// every table, in a human-readable format.
+func PrintTaskHistory(writer io.Writer, history *TaskHistory) error {
+	w := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "task ID:\t%d\n", history.TaskID)
+	fmt.Fprintf(w, "status:\t%s\n", history.Status)
+	fmt.Fprintf(w, "start time:\t%s\n", formatHistoryTime(history.StartTime))
+	fmt.Fprintf(w, "end time:\t%s\n", formatHistoryTime(history.EndTime))
+	fmt.Fprintf(w, "config:\t%s\n", history.Config)
+	if len(history.Error) > 0 {
+		fmt.Fprintf(w, "error:\t%s\n", history.Error)
+	}
+	if err := w.Flush(); err != nil {
+		return errors.Trace(err)
+	}
+
+	if len(history.Tables) == 0 {
+		return nil
+	}
+	fmt.Fprintln(writer)
+	w = tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tSTATUS\tROWS\tKVS\tBYTES\tCHECKSUM\tERROR")
+	for _, table := range history.Tables {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%s\n",
+			table.TableName, table.Status, table.Rows, table.KVs, table.Bytes, table.Checksum, table.Error)
+	}
+	return errors.Trace(w.Flush())
+}
@@ -235,6 +235,43 @@ func UniqueTable(schema string, table string) string {
 	return builder.String()
 }
 
+// ParseUniqueTable splits an unique table name produced by UniqueTable back
+// into the schema and table names.
+func ParseUniqueTable(name string) (schema string, table string, err error) {
+	schema, rest, ok := parseMySQLIdentifier(name)
+	if ok && len(rest) > 0 && rest[0] == '.' {
+		var tail string
+		table, tail, ok = parseMySQLIdentifier(rest[1:])
+		if ok && len(tail) == 0 {
+			return schema, table, nil
+		}
+	}
+	return "", "", errors.Errorf("invalid unique table name %s", name)
+}
+
+// parseMySQLIdentifier parses an identifier of the form "`foo`" at the start
+// of the string, and returns the unescaped identifier and the remaining
+// string.
+func parseMySQLIdentifier(s string) (identifier string, rest string, ok bool) {
+	if len(s) == 0 || s[0] != '`' {
+		return "", s, false
+	}
+	var builder strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] != '`' {
+			builder.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '`' {
+			builder.WriteByte('`')
+			i++
+			continue
+		}
+		return builder.String(), s[i+1:], true
+	}
+	return "", s, false
+}
+
 // Writes a MySQL identifier into the string builder.
 // The identifier is always escaped into the form "`foo`".
 func WriteMySQLIdentifier(builder *strings.Builder, identifier string) {
@@ -149,6 +149,24 @@ func (s *utilSuite) TestUniqueTable(c *C) {
 	c.Assert(tableName, Equals, "`test`.`t``1`")
 }
 
+func (s *utilSuite) TestParseUniqueTable(c *C) {
+	for _, names := range [][2]string{
+		{"test", "t1"},
+		{"test", "t`1"},
+		{"te.st", "``"},
+		{"", "t.1"},
+	} {
+		schema, table, err := common.ParseUniqueTable(common.UniqueTable(names[0], names[1]))
+		c.Assert(err, IsNil)
+		c.Assert([2]string{schema, table}, Equals, names)
+	}
+
+	for _, name := range []string{"", "test.t1", "`test`", "`test`.t1", "`test`.`t1", "`test`.`t1`x", "`te`st`.`t1`"} {
+		_, _, err := common.ParseUniqueTable(name)
+		c.Assert(err, ErrorMatches, "invalid unique table name .*")
+	}
+}
+
 func (s *utilSuite) TestSQLWithRetry(c *C) {
 	db, mock, err := sqlmock.New()
 	c.Assert(err, IsNil)
@@ -31,6 +31,8 @@ import (
 	"github.com/pingcap/tidb-tools/pkg/filter"
 	router "github.com/pingcap/tidb-tools/pkg/table-router"
 	tidbcfg "github.com/pingcap/tidb/config"
+	"github.com/pingcap/tidb/sessionctx/variable"
+	"github.com/pingcap/tidb/util/timeutil"
 	"go.uber.org/zap"
 )
 
@@ -56,6 +58,15 @@ const (
 	IgnoreOnDup = "ignore"
 	// ErrorOnDup indicates using INSERT INTO to insert data, which would violate PK or UNIQUE constraint
 	ErrorOnDup = "error"
+	// UpsertOnDup indicates using INSERT INTO ... ON DUPLICATE KEY UPDATE to insert data, which overwrites the non-key columns of the old record
+	UpsertOnDup = "upsert"
+
+	// WriteModeInsert indicates writing rows with INSERT statements containing the literal values
+	WriteModeInsert = "insert"
+	// WriteModePrepared indicates writing rows with server-side prepared INSERT statements
+	WriteModePrepared = "prepared"
+	// WriteModeLoadData indicates writing rows with LOAD DATA LOCAL INFILE statements
+	WriteModeLoadData = "load-data"
 )
 
 var defaultConfigPaths = []string{"tidb-lightning.toml", "conf/tidb-lightning.toml"}
@@ -68,8 +79,10 @@ type DBStore struct {
 	StatusPort int       `toml:"status-port" json:"status-port"`
 	PdAddr     string    `toml:"pd-addr" json:"pd-addr"`
 	StrSQLMode string    `toml:"sql-mode" json:"sql-mode"`
+	TimeZone   string    `toml:"time-zone" json:"time-zone"`
 	TLS        string    `toml:"tls" json:"tls"`
 	Security   *Security `toml:"security" json:"security"`
+	Endpoints  []string  `toml:"endpoints" json:"endpoints"`
 
 	SQLMode          mysql.SQLMode `toml:"-" json:"-"`
 	MaxAllowedPacket uint64        `toml:"max-allowed-packet" json:"max-allowed-packet"`
@@ -93,6 +106,7 @@ type Config struct {
 	PostRestore  PostRestore         `toml:"post-restore" json:"post-restore"`
 	Cron         Cron                `toml:"cron" json:"cron"`
 	Routes       []*router.TableRule `toml:"routes" json:"routes"`
+	Tables       []*TableRule        `toml:"tables" json:"tables"`
 	Security     Security            `toml:"security" json:"security"`
 }
 
@@ -110,11 +124,18 @@ func (c *Config) ToTLS() (*common.TLS, error) {
 }
 
 type Lightning struct {
-	TableConcurrency  int  `toml:"table-concurrency" json:"table-concurrency"`
-	IndexConcurrency  int  `toml:"index-concurrency" json:"index-concurrency"`
-	RegionConcurrency int  `toml:"region-concurrency" json:"region-concurrency"`
-	IOConcurrency     int  `toml:"io-concurrency" json:"io-concurrency"`
-	CheckRequirements bool `toml:"check-requirements" json:"check-requirements"`
+	TableConcurrency  int   `toml:"table-concurrency" json:"table-concurrency"`
+	IndexConcurrency  int   `toml:"index-concurrency" json:"index-concurrency"`
+	RegionConcurrency int   `toml:"region-concurrency" json:"region-concurrency"`
+	IOConcurrency     int   `toml:"io-concurrency" json:"io-concurrency"`
+	EncodeConcurrency int   `toml:"encode-concurrency" json:"encode-concurrency"`
+	KVQueueBytes      int64 `toml:"kv-queue-bytes" json:"kv-queue-bytes"`
+	MinDeliverBytes   int64 `toml:"min-deliver-bytes" json:"min-deliver-bytes"`
+	MaxDeliverBytes   int64 `toml:"max-deliver-bytes" json:"max-deliver-bytes"`
+	CheckRequirements bool  `toml:"check-requirements" json:"check-requirements"`
+
+	ReportPath         string `toml:"report-path" json:"report-path"`
+	ReportMarkdownPath string `toml:"report-markdown-path" json:"report-markdown-path"`
 }
 
 // PostRestore has some options which will be executed after kv restored.
@@ -144,12 +165,19 @@ type MydumperRuntime struct {
 	CharacterSet     string    `toml:"character-set" json:"character-set"`
 	CSV              CSVConfig `toml:"csv" json:"csv"`
 	CaseSensitive    bool      `toml:"case-sensitive" json:"case-sensitive"`
+	MaxEncodeErrors  int64     `toml:"max-encode-errors" json:"max-encode-errors"`
+	RejectedRowsDir  string    `toml:"rejected-rows-dir" json:"rejected-rows-dir"`
 }
 
 type TikvImporter struct {
-	Addr        string `toml:"addr" json:"addr"`
-	Backend     string `toml:"backend" json:"backend"`
-	OnDuplicate string `toml:"on-duplicate" json:"on-duplicate"`
+	Addr               string `toml:"addr" json:"addr"`
+	Backend            string `toml:"backend" json:"backend"`
+	OnDuplicate        string `toml:"on-duplicate" json:"on-duplicate"`
+	Incremental        bool   `toml:"incremental" json:"incremental"`
+	DuplicateDetection bool   `toml:"duplicate-detection" json:"duplicate-detection"`
+	DuplicateDir       string `toml:"duplicate-dir" json:"duplicate-dir"`
+	WriteFlushBytes    int64  `toml:"write-flush-bytes" json:"write-flush-bytes"`
+	WriteMode          string `toml:"write-mode" json:"write-mode"`
 }
 
 type Checkpoint struct {
@@ -158,6 +186,13 @@ type Checkpoint struct {
 	DSN              string `toml:"dsn" json:"-"` // DSN may contain password, don't expose this to JSON.
 	Driver           string `toml:"driver" json:"driver"`
 	KeepAfterSuccess bool   `toml:"keep-after-success" json:"keep-after-success"`
+	// AllowConfigChange permits resuming from the checkpoints even if the
+	// data-affecting settings (see Fingerprint) have been changed.
+	AllowConfigChange bool `toml:"allow-config-change" json:"allow-config-change"`
+	// Distributed lets several instances import the same source together,
+	// each claiming the tables it restores by a lease in the checkpoints.
+	Distributed   bool     `toml:"distributed" json:"distributed"`
+	LeaseDuration Duration `toml:"lease-duration" json:"lease-duration"`
 }
 
 type Cron struct {
@@ -213,10 +248,15 @@ func NewConfig() *Config {
 			TableConcurrency:  0,
 			IndexConcurrency:  0,
 			IOConcurrency:     5,
+			EncodeConcurrency: 1,
+			KVQueueBytes:      KVQueueBytes,
+			MinDeliverBytes:   MinDeliverBytes,
+			MaxDeliverBytes:   MaxDeliverBytes,
 			CheckRequirements: true,
 		},
 		Checkpoint: Checkpoint{
-			Enable: true,
+			Enable:        true,
+			LeaseDuration: Duration{Duration: 30 * time.Second},
 		},
 		TiDB: DBStore{
 			Host:                       "127.0.0.1",
@@ -246,8 +286,10 @@ func NewConfig() *Config {
 			},
 		},
 		TikvImporter: TikvImporter{
-			Backend:     BackendImporter,
-			OnDuplicate: ReplaceOnDup,
+			Backend:         BackendImporter,
+			OnDuplicate:     ReplaceOnDup,
+			WriteFlushBytes: WriteFlushBytes,
+			WriteMode:       WriteModeInsert,
 		},
 		PostRestore: PostRestore{
 			Checksum: true,
@@ -274,6 +316,7 @@ func (cfg *Config) LoadFromGlobal(global *GlobalConfig) error {
 	cfg.TikvImporter.Addr = global.TikvImporter.Addr
 	cfg.TikvImporter.Backend = global.TikvImporter.Backend
 	cfg.Checkpoint.Enable = global.Checkpoint.Enable
+	cfg.Checkpoint.AllowConfigChange = global.Checkpoint.AllowConfigChange
 	cfg.PostRestore.Checksum = global.PostRestore.Checksum
 	cfg.PostRestore.Analyze = global.PostRestore.Analyze
 	cfg.App.CheckRequirements = global.App.CheckRequirements
@@ -343,6 +386,15 @@ func (cfg *Config) LoadFromTOML(data []byte) error {
 	return nil
 }
 
+func isValidOnDuplicate(onDuplicate string) bool {
+	switch onDuplicate {
+	case ReplaceOnDup, IgnoreOnDup, ErrorOnDup, UpsertOnDup:
+		return true
+	default:
+		return false
+	}
+}
+
 // Adjust fixes the invalid or unspecified settings to reasonable valid values.
 func (cfg *Config) Adjust() error {
 	// Reject problematic CSV configurations.
@@ -368,6 +420,22 @@ func (cfg *Config) Adjust() error {
 		}
 	}
 
+	if cfg.App.EncodeConcurrency <= 0 {
+		cfg.App.EncodeConcurrency = 1
+	}
+	if cfg.App.KVQueueBytes <= 0 {
+		cfg.App.KVQueueBytes = KVQueueBytes
+	}
+	if cfg.App.MinDeliverBytes <= 0 {
+		cfg.App.MinDeliverBytes = MinDeliverBytes
+	}
+	if cfg.App.MaxDeliverBytes <= 0 {
+		cfg.App.MaxDeliverBytes = MaxDeliverBytes
+	}
+	if cfg.App.MinDeliverBytes > cfg.App.MaxDeliverBytes {
+		return errors.Errorf("invalid config: `lightning.min-deliver-bytes` (%d) must not be larger than `lightning.max-deliver-bytes` (%d)", cfg.App.MinDeliverBytes, cfg.App.MaxDeliverBytes)
+	}
+
 	cfg.TikvImporter.Backend = strings.ToLower(cfg.TikvImporter.Backend)
 	switch cfg.TikvImporter.Backend {
 	case BackendTiDB:
@@ -377,6 +445,9 @@ func (cfg *Config) Adjust() error {
 		if cfg.App.TableConcurrency == 0 {
 			cfg.App.TableConcurrency = cfg.App.RegionConcurrency
 		}
+		// rows written via SQL are committed immediately, so we save a
+		// checkpoint after every batch to avoid writing them twice.
+		cfg.TikvImporter.WriteFlushBytes = 0
 	case BackendImporter:
 		if cfg.App.IndexConcurrency == 0 {
 			cfg.App.IndexConcurrency = 2
@@ -384,17 +455,33 @@ func (cfg *Config) Adjust() error {
 		if cfg.App.TableConcurrency == 0 {
 			cfg.App.TableConcurrency = 6
 		}
+		if cfg.TikvImporter.WriteFlushBytes <= 0 {
+			cfg.TikvImporter.WriteFlushBytes = WriteFlushBytes
+		}
 	default:
 		return errors.Errorf("invalid config: unsupported `tikv-importer.backend` (%s)", cfg.TikvImporter.Backend)
 	}
 
 	if cfg.TikvImporter.Backend == BackendTiDB {
 		cfg.TikvImporter.OnDuplicate = strings.ToLower(cfg.TikvImporter.OnDuplicate)
-		switch cfg.TikvImporter.OnDuplicate {
-		case ReplaceOnDup, IgnoreOnDup, ErrorOnDup:
-		default:
+		if !isValidOnDuplicate(cfg.TikvImporter.OnDuplicate) {
 			return errors.Errorf("invalid config: unsupported `tikv-importer.on-duplicate` (%s)", cfg.TikvImporter.OnDuplicate)
 		}
+
+		cfg.TikvImporter.WriteMode = strings.ToLower(cfg.TikvImporter.WriteMode)
+		switch cfg.TikvImporter.WriteMode {
+		case WriteModeInsert, WriteModePrepared:
+		case WriteModeLoadData:
+			switch cfg.TikvImporter.OnDuplicate {
+			case ErrorOnDup, UpsertOnDup:
+				return errors.Errorf("invalid config: `tikv-importer.write-mode` cannot be 'load-data' when `tikv-importer.on-duplicate` is '%s'", cfg.TikvImporter.OnDuplicate)
+			}
+		default:
+			return errors.Errorf("invalid config: unsupported `tikv-importer.write-mode` (%s)", cfg.TikvImporter.WriteMode)
+		}
+	}
+	if len(cfg.TikvImporter.DuplicateDir) == 0 {
+		cfg.TikvImporter.DuplicateDir = "/tmp/tidb_lightning_duplicates"
 	}
 
 	var err error
@@ -403,6 +490,28 @@ func (cfg *Config) Adjust() error {
 		return errors.Annotate(err, "invalid config: `mydumper.tidb.sql_mode` must be a valid SQL_MODE")
 	}
 
+	// resolve the time zone now, so that the result does not depend on the
+	// host when it is recorded in the checkpoints. An unset time zone is left
+	// empty, so that the `time_zone` of the TiDB connection is not changed.
+	if len(cfg.TiDB.TimeZone) > 0 {
+		if strings.EqualFold(cfg.TiDB.TimeZone, "SYSTEM") {
+			cfg.TiDB.TimeZone = timeutil.InferSystemTZ()
+		}
+		if err := variable.NewSessionVars().SetSystemVar(variable.TimeZone, cfg.TiDB.TimeZone); err != nil {
+			return errors.Annotatef(err, "invalid config: `tidb.time-zone` (%s) must be a valid time zone", cfg.TiDB.TimeZone)
+		}
+	}
+
+	for _, endpoint := range cfg.TiDB.Endpoints {
+		_, port, err := net.SplitHostPort(endpoint)
+		if err == nil {
+			_, err = strconv.ParseUint(port, 10, 16)
+		}
+		if err != nil {
+			return errors.Annotatef(err, "invalid config: `tidb.endpoints` contains an invalid address (%s)", endpoint)
+		}
+	}
+
 	if cfg.TiDB.Security == nil {
 		cfg.TiDB.Security = &cfg.Security
 	}
@@ -439,6 +548,11 @@ func (cfg *Config) Adjust() error {
 			return errors.Trace(err)
 		}
 	}
+	for _, rule := range cfg.Tables {
+		if err := rule.adjust(cfg.Mydumper.CaseSensitive); err != nil {
+			return err
+		}
+	}
 
 	// automatically determine the TiDB port & PD address from TiDB settings
 	if cfg.TiDB.Port <= 0 || len(cfg.TiDB.PdAddr) == 0 {
@@ -480,6 +594,12 @@ func (cfg *Config) Adjust() error {
 	if len(cfg.Mydumper.CharacterSet) == 0 {
 		cfg.Mydumper.CharacterSet = "auto"
 	}
+	if cfg.Mydumper.MaxEncodeErrors < 0 {
+		return errors.New("invalid config: `mydumper.max-encode-errors` must not be negative")
+	}
+	if len(cfg.Mydumper.RejectedRowsDir) == 0 {
+		cfg.Mydumper.RejectedRowsDir = "/tmp/tidb_lightning_rejected_rows"
+	}
 
 	if len(cfg.Checkpoint.Schema) == 0 {
 		cfg.Checkpoint.Schema = "tidb_lightning_checkpoint"
@@ -504,6 +624,14 @@ func (cfg *Config) Adjust() error {
 			cfg.Checkpoint.DSN = "/tmp/" + cfg.Checkpoint.Schema + ".pb"
 		}
 	}
+	if cfg.Checkpoint.Distributed {
+		if !cfg.Checkpoint.Enable || cfg.Checkpoint.Driver != CheckpointDriverMySQL {
+			return errors.New("invalid config: `checkpoint.distributed` requires the checkpoints be enabled with the \"mysql\" driver")
+		}
+		if cfg.Checkpoint.LeaseDuration.Duration < time.Second {
+			return errors.New("invalid config: `checkpoint.lease-duration` must be at least 1s")
+		}
+	}
 
 	return nil
 }
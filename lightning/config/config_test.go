@@ -23,6 +23,7 @@ import (
 	"regexp"
 	"strconv"
 	"testing"
+	"time"
 
 	. "github.com/pingcap/check"
 	"github.com/pingcap/parser/mysql"
@@ -520,6 +521,105 @@ func (s *configTestSuite) TestDefaultCouldBeOverwritten(c *C) {
 	c.Assert(cfg.App.TableConcurrency, Equals, 60)
 }
 
+func (s *configTestSuite) TestAdjustTimeZone(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TiDB.TimeZone = "+08:00"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TiDB.TimeZone, Equals, "+08:00")
+
+	// the system time zone is resolved into a concrete name.
+	cfg = config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TiDB.TimeZone = "system"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TiDB.TimeZone, Not(Equals), "")
+	c.Assert(cfg.TiDB.TimeZone, Not(Equals), "system")
+
+	// an unset time zone is kept unset.
+	cfg = config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TiDB.TimeZone, Equals, "")
+
+	cfg = config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TiDB.TimeZone = "Mars/Olympus_Mons"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `tidb.time-zone` \\(Mars/Olympus_Mons\\) must be a valid time zone.*")
+}
+
+func (s *configTestSuite) TestAdjustDeliverBytes(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.App.KVQueueBytes = 0
+	cfg.App.MinDeliverBytes = -1
+	cfg.App.MaxDeliverBytes = 0
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.App.KVQueueBytes, Equals, config.KVQueueBytes)
+	c.Assert(cfg.App.MinDeliverBytes, Equals, config.MinDeliverBytes)
+	c.Assert(cfg.App.MaxDeliverBytes, Equals, config.MaxDeliverBytes)
+
+	cfg = config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.App.MinDeliverBytes = 2048
+	cfg.App.MaxDeliverBytes = 1024
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `lightning.min-deliver-bytes` \\(2048\\) must not be larger than `lightning.max-deliver-bytes` \\(1024\\)")
+}
+
+func (s *configTestSuite) TestAdjustDistributed(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.Checkpoint.Distributed = true
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `checkpoint.distributed` requires the checkpoints be enabled with the \"mysql\" driver")
+
+	cfg = config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.Checkpoint.Distributed = true
+	cfg.Checkpoint.Driver = config.CheckpointDriverMySQL
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Checkpoint.LeaseDuration.Duration, Equals, 30*time.Second)
+
+	cfg = config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.Checkpoint.Distributed = true
+	cfg.Checkpoint.Driver = config.CheckpointDriverMySQL
+	cfg.Checkpoint.LeaseDuration.Duration = 500 * time.Millisecond
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `checkpoint.lease-duration` must be at least 1s")
+}
+
+func (s *configTestSuite) TestAdjustWriteFlushBytes(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TikvImporter.WriteFlushBytes = 0
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TikvImporter.WriteFlushBytes, Equals, config.WriteFlushBytes)
+
+	// the TiDB backend always flushes every batch.
+	cfg = config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TikvImporter.Backend = config.BackendTiDB
+	cfg.TikvImporter.WriteFlushBytes = 123456
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TikvImporter.WriteFlushBytes, Equals, int64(0))
+}
+
+func (s *configTestSuite) TestAdjustEndpoints(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TiDB.Endpoints = []string{"10.0.0.1:4000", "[::1]:4001"}
+	c.Assert(cfg.Adjust(), IsNil)
+
+	cfg = config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TiDB.Endpoints = []string{"10.0.0.1:4000", "10.0.0.2"}
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `tidb.endpoints` contains an invalid address \\(10.0.0.2\\).*")
+
+	cfg = config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TiDB.Endpoints = []string{"10.0.0.1:99999"}
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `tidb.endpoints` contains an invalid address \\(10.0.0.1:99999\\).*")
+}
+
 func (s *configTestSuite) TestLoadFromInvalidConfig(c *C) {
 	taskCfg := config.NewConfig()
 	err := taskCfg.LoadFromGlobal(&config.GlobalConfig{
@@ -527,3 +627,127 @@ func (s *configTestSuite) TestLoadFromInvalidConfig(c *C) {
 	})
 	c.Assert(err, ErrorMatches, "Near line 1.*")
 }
+
+func (s *configTestSuite) TestAdjustWriteMode(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TikvImporter.Backend = config.BackendTiDB
+	cfg.TikvImporter.WriteMode = "Load-Data"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.TikvImporter.WriteMode, Equals, config.WriteModeLoadData)
+
+	cfg = config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TikvImporter.Backend = config.BackendTiDB
+	cfg.TikvImporter.WriteMode = "batch"
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: unsupported `tikv-importer.write-mode` \\(batch\\)")
+
+	cfg = config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TikvImporter.Backend = config.BackendTiDB
+	cfg.TikvImporter.WriteMode = config.WriteModeLoadData
+	cfg.TikvImporter.OnDuplicate = config.ErrorOnDup
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `tikv-importer.write-mode` cannot be 'load-data' when `tikv-importer.on-duplicate` is 'error'")
+
+	cfg = config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.TikvImporter.Backend = config.BackendTiDB
+	cfg.TikvImporter.WriteMode = config.WriteModeLoadData
+	cfg.TikvImporter.OnDuplicate = config.UpsertOnDup
+	c.Assert(cfg.Adjust(), ErrorMatches, "invalid config: `tikv-importer.write-mode` cannot be 'load-data' when `tikv-importer.on-duplicate` is 'upsert'")
+}
+
+func (s *configTestSuite) TestFingerprint(c *C) {
+	newAdjustedConfig := func(input string) *config.Config {
+		cfg := config.NewConfig()
+		assignMinimalLegalValue(cfg)
+		c.Assert(cfg.LoadFromTOML([]byte(input)), IsNil)
+		c.Assert(cfg.Adjust(), IsNil)
+		return cfg
+	}
+
+	cfg := newAdjustedConfig(`
+		[lightning]
+		region-concurrency = 4
+		[tidb]
+		sql-mode = "ANSI"
+	`)
+	fingerprint := cfg.Fingerprint()
+	c.Assert(fingerprint, Matches, `(?s).*\nmydumper\.csv\.separator = ","\n.*`)
+	c.Assert(fingerprint, Matches, `(?s).*\nroutes = \[\]\n.*`)
+	c.Assert(fingerprint, Matches, `(?s).*\ntidb\.sql-mode = "ANSI_QUOTES,IGNORE_SPACE,ONLY_FULL_GROUP_BY,PIPES_AS_CONCAT,REAL_AS_FLOAT"\n.*`)
+
+	// settings which do not affect the data, and equivalent spellings, do not
+	// change the fingerprint.
+	cfg = newAdjustedConfig(`
+		[lightning]
+		region-concurrency = 8
+		[tidb]
+		sql-mode = "REAL_AS_FLOAT,PIPES_AS_CONCAT,ANSI_QUOTES,IGNORE_SPACE,ONLY_FULL_GROUP_BY"
+		[tikv-importer]
+		backend = "Importer"
+	`)
+	c.Assert(cfg.Fingerprint(), Equals, fingerprint)
+	c.Assert(config.DiffFingerprints(fingerprint, cfg.Fingerprint()), HasLen, 0)
+
+	cfg = newAdjustedConfig(`
+		[tidb]
+		sql-mode = "ANSI"
+		[mydumper]
+		batch-size = 1000
+		[mydumper.csv]
+		separator = "|"
+		[[routes]]
+		schema-pattern = "a"
+		target-schema = "b"
+	`)
+	c.Assert(config.DiffFingerprints(fingerprint, cfg.Fingerprint()), DeepEquals, []string{
+		`mydumper.batch-size: 107374182400 -> 1000`,
+		`mydumper.csv.separator: "," -> "|"`,
+		`routes: [] -> [{"schema-pattern":"a","table-pattern":"","target-schema":"b","target-table":""}]`,
+	})
+
+	// the column rules of [[tables]] are included, without revealing the salts.
+	cfg = newAdjustedConfig(`
+		[tidb]
+		sql-mode = "ANSI"
+		[[tables]]
+		schema-pattern = "db"
+		table-pattern = "t"
+		ignore-columns = ["x"]
+		[tables.column-mapping]
+		old_b = "b"
+		[tables.masks.c]
+		type = "hash"
+		salt = "pepper"
+	`)
+	diffs := config.DiffFingerprints(fingerprint, cfg.Fingerprint())
+	c.Assert(diffs, HasLen, 1)
+	c.Assert(diffs[0], Matches, `tables: \[\] -> \[\{.*"column-mapping":\{"old_b":"b"\}.*"ignore-columns":\["x"\].*\}\]`)
+	c.Assert(diffs[0], Matches, `.*"masks":"\{\\"c\\":\{\\"type\\":\\"hash\\",\\"salt\\":\\"sha256:.*`)
+	c.Assert(diffs[0], Not(Matches), `.*pepper.*`)
+
+	// so is the black-white-list.
+	cfg = newAdjustedConfig(`
+		[tidb]
+		sql-mode = "ANSI"
+		[black-white-list]
+		do-dbs = ["db"]
+	`)
+	diffs = config.DiffFingerprints(fingerprint, cfg.Fingerprint())
+	c.Assert(diffs, HasLen, 1)
+	c.Assert(diffs[0], Matches, `black-white-list: \{"do-tables":null,"do-dbs":null,.* -> \{"do-tables":null,"do-dbs":\["db"\],.*`)
+
+	c.Assert(config.DiffFingerprints("a = 1\nb = 2\n", "b = 2\nc = 3\n"), DeepEquals, []string{
+		"a: 1 -> <unset>",
+		"c: <unset> -> 3",
+	})
+}
+
+func (s *configTestSuite) TestSummary(c *C) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	cfg.Mydumper.SourceDir = "/data/export"
+	c.Assert(cfg.Adjust(), IsNil)
+	c.Assert(cfg.Summary(), Equals, "backend=importer source=/data/export target=123.45.67.89:4567")
+}
@@ -24,5 +24,13 @@ const (
 
 	BufferSizeScale = 5
 
+	// lightning
+	KVQueueBytes    int64 = 4 * _M
+	MinDeliverBytes int64 = 64 * _K
+	MaxDeliverBytes int64 = 1 * _M
+
+	// tikv-importer
+	WriteFlushBytes int64 = 2 * _M
+
 	defaultMaxAllowedPacket = 64 * 1024 * 1024
 )
@@ -0,0 +1,175 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/parser/mysql"
+)
+
+const fingerprintUnset = "<unset>"
+
+// tableRuleFingerprint is a [[tables]] rule with the masks replaced by their
+// fingerprint, so that the salts are not revealed.
+type tableRuleFingerprint struct {
+	*TableRule
+	Masks string `json:"masks"`
+}
+
+// dataSettings returns the normalized values of the settings which affect how
+// the source files are parsed, which tables are imported, how the rows are
+// distributed into engines, and how they are encoded and delivered. Resuming
+// a task with any of these changed would reuse chunks and column permutations
+// computed under the old settings.
+func (cfg *Config) dataSettings() map[string]string {
+	csv := &cfg.Mydumper.CSV
+	routes := []byte("[]")
+	if len(cfg.Routes) > 0 {
+		routes, _ = json.Marshal(cfg.Routes)
+	}
+	tables := []byte("[]")
+	if len(cfg.Tables) > 0 {
+		rules := make([]tableRuleFingerprint, 0, len(cfg.Tables))
+		for _, rule := range cfg.Tables {
+			rules = append(rules, tableRuleFingerprint{TableRule: rule, Masks: MaskFingerprint(rule.Masks)})
+		}
+		tables, _ = json.Marshal(rules)
+	}
+	bwList, _ := json.Marshal(cfg.BWList)
+
+	return map[string]string{
+		"mydumper.batch-size":              strconv.FormatInt(cfg.Mydumper.BatchSize, 10),
+		"mydumper.batch-import-ratio":      strconv.FormatFloat(cfg.Mydumper.BatchImportRatio, 'g', -1, 64),
+		"mydumper.character-set":           strconv.Quote(strings.ToLower(cfg.Mydumper.CharacterSet)),
+		"mydumper.case-sensitive":          strconv.FormatBool(cfg.Mydumper.CaseSensitive),
+		"mydumper.csv.separator":           strconv.Quote(csv.Separator),
+		"mydumper.csv.delimiter":           strconv.Quote(csv.Delimiter),
+		"mydumper.csv.header":              strconv.FormatBool(csv.Header),
+		"mydumper.csv.trim-last-separator": strconv.FormatBool(csv.TrimLastSep),
+		"mydumper.csv.not-null":            strconv.FormatBool(csv.NotNull),
+		"mydumper.csv.null":                strconv.Quote(csv.Null),
+		"mydumper.csv.backslash-escape":    strconv.FormatBool(csv.BackslashEscape),
+		"black-white-list":                 string(bwList),
+		"routes":                           string(routes),
+		"tables":                           string(tables),
+		"tidb.sql-mode":                    strconv.Quote(normalizeSQLMode(cfg.TiDB.StrSQLMode)),
+		"tikv-importer.backend":            strconv.Quote(cfg.TikvImporter.Backend),
+	}
+}
+
+// normalizeSQLMode expands the combination modes and sorts the individual
+// modes, so that equivalent spellings like "ANSI" and "REAL_AS_FLOAT,..."
+// produce the same result.
+func normalizeSQLMode(sqlMode string) string {
+	modes := make(map[string]struct{})
+	for _, mode := range strings.Split(mysql.FormatSQLModeStr(sqlMode), ",") {
+		if len(mode) == 0 {
+			continue
+		}
+		// FormatSQLModeStr() has already added the expanded modes, so the
+		// name of the combination itself is dropped unless it is also an
+		// individual mode (e.g. MYSQL40).
+		if combination, ok := mysql.CombinationSQLMode[mode]; ok && !containsString(combination, mode) {
+			continue
+		}
+		modes[mode] = struct{}{}
+	}
+
+	names := make([]string, 0, len(modes))
+	for mode := range modes {
+		names = append(names, mode)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Fingerprint returns the normalized form of the settings which must not be
+// changed when resuming from the checkpoints. The result lists the settings
+// one per line as "key = value" in sorted order. It should be called after
+// Adjust().
+func (cfg *Config) Fingerprint() string {
+	settings := cfg.dataSettings()
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&sb, "%s = %s\n", key, settings[key])
+	}
+	return sb.String()
+}
+
+func parseFingerprint(fingerprint string) map[string]string {
+	settings := make(map[string]string)
+	for _, line := range strings.Split(fingerprint, "\n") {
+		parts := strings.SplitN(line, " = ", 2)
+		if len(parts) == 2 {
+			settings[parts[0]] = parts[1]
+		}
+	}
+	return settings
+}
+
+// DiffFingerprints compares two results of Fingerprint() and describes the
+// changed settings as "key: old -> new", sorted by the key.
+func DiffFingerprints(oldFingerprint string, newFingerprint string) []string {
+	oldSettings := parseFingerprint(oldFingerprint)
+	newSettings := parseFingerprint(newFingerprint)
+
+	var diffs []string
+	for key, oldValue := range oldSettings {
+		newValue, ok := newSettings[key]
+		if !ok {
+			newValue = fingerprintUnset
+		}
+		if oldValue != newValue {
+			diffs = append(diffs, fmt.Sprintf("%s: %s -> %s", key, oldValue, newValue))
+		}
+	}
+	for key, newValue := range newSettings {
+		if _, ok := oldSettings[key]; !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: %s -> %s", key, fingerprintUnset, newValue))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+// Summary returns a one-line description of the task, identifying the backend,
+// the data source and the target, e.g. for the task history.
+func (cfg *Config) Summary() string {
+	return fmt.Sprintf("backend=%s source=%s target=%s",
+		cfg.TikvImporter.Backend,
+		cfg.Mydumper.SourceDir,
+		net.JoinHostPort(cfg.TiDB.Host, strconv.Itoa(cfg.TiDB.Port)),
+	)
+}
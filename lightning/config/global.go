@@ -69,7 +69,8 @@ type GlobalConfig struct {
 }
 
 type GlobalCheckpoint struct {
-	Enable bool `toml:"enable" json:"enable"`
+	Enable            bool `toml:"enable" json:"enable"`
+	AllowConfigChange bool `toml:"allow-config-change" json:"allow-config-change"`
 }
 
 type GlobalPostRestore struct {
@@ -141,6 +142,7 @@ func LoadGlobalConfig(args []string, extraFlags func(*flag.FlagSet)) (*GlobalCon
 	importerAddr := fs.String("importer", "", "address (host:port) to connect to tikv-importer")
 	backend := fs.String("backend", "", `delivery backend ("importer" or "tidb")`)
 	enableCheckpoint := fs.Bool("enable-checkpoint", true, "whether to enable checkpoints")
+	allowConfigChange := fs.Bool("allow-config-change", false, "resume from the checkpoints even if the data-affecting configuration has changed")
 	noSchema := fs.Bool("no-schema", false, "ignore schema files, get schema directly from TiDB instead")
 	checksum := fs.Bool("checksum", true, "compare checksum after importing")
 	analyze := fs.Bool("analyze", true, "analyze table after importing")
@@ -217,6 +219,9 @@ func LoadGlobalConfig(args []string, extraFlags func(*flag.FlagSet)) (*GlobalCon
 	if !*enableCheckpoint {
 		cfg.Checkpoint.Enable = false
 	}
+	if *allowConfigChange {
+		cfg.Checkpoint.AllowConfigChange = true
+	}
 	if *noSchema {
 		cfg.Mydumper.NoSchema = true
 	}
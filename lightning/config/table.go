@@ -0,0 +1,240 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/pingcap/errors"
+	selector "github.com/pingcap/tidb-tools/pkg/table-rule-selector"
+)
+
+// TableRule contains the settings specific to the target tables matching the
+// schema and table patterns. The patterns support wildcards like [[routes]].
+type TableRule struct {
+	SchemaPattern string `toml:"schema-pattern" json:"schema-pattern"`
+	TablePattern  string `toml:"table-pattern" json:"table-pattern"`
+
+	// ColumnMapping renames the source columns (keys) into the target columns
+	// (values).
+	ColumnMapping map[string]string `toml:"column-mapping" json:"column-mapping"`
+	// IgnoreColumns lists the source columns which should be discarded.
+	IgnoreColumns []string `toml:"ignore-columns" json:"ignore-columns"`
+	// ConstantColumns fills the target columns (keys) with the constant values,
+	// regardless of the source content.
+	ConstantColumns map[string]string `toml:"constant-columns" json:"constant-columns"`
+	// DefaultColumns lists the target columns which should always be filled
+	// with their default values, regardless of the source content.
+	DefaultColumns []string `toml:"default-columns" json:"default-columns"`
+	// OnDuplicate overrides `tikv-importer.on-duplicate` for the tables when
+	// the backend is "tidb". Empty means using the global setting.
+	OnDuplicate string `toml:"on-duplicate" json:"on-duplicate"`
+	// Masks transforms the values of the target columns (keys) before
+	// encoding, to remove sensitive data from the imported tables.
+	Masks map[string]*ColumnMask `toml:"masks" json:"masks"`
+}
+
+const (
+	// MaskHash replaces the value by the hex SHA-256 HMAC of the value.
+	MaskHash = "hash"
+	// MaskPseudonym replaces every letter and digit of the value by a
+	// pseudo-random letter or digit, preserving the length and format.
+	MaskPseudonym = "pseudonym"
+	// MaskEmail pseudonymizes the local part of an email address, keeping
+	// the domain.
+	MaskEmail = "email"
+	// MaskPhone pseudonymizes the digits of a phone number, keeping the
+	// separators.
+	MaskPhone = "phone"
+	// MaskTruncate keeps only the first `length` characters of the value.
+	MaskTruncate = "truncate"
+	// MaskNullify replaces the value by NULL.
+	MaskNullify = "nullify"
+	// MaskConstant replaces the value by `value`.
+	MaskConstant = "constant"
+)
+
+// ColumnMask describes how the values of a column are masked. The transforms
+// are deterministic, so columns masked with the same type and salt in
+// different tables can still be joined.
+type ColumnMask struct {
+	Type string `toml:"type" json:"type"`
+	// Salt is the secret key of the hash, pseudonym, email and phone masks.
+	Salt string `toml:"salt" json:"salt,omitempty"`
+	// Length is the number of characters kept by the truncate mask, or the
+	// maximum length of the hash mask (0 means the full 64 characters).
+	Length int `toml:"length" json:"length,omitempty"`
+	// Value is the replacement of the constant mask.
+	Value string `toml:"value" json:"value,omitempty"`
+}
+
+func (mask *ColumnMask) adjust() error {
+	mask.Type = strings.ToLower(mask.Type)
+	switch mask.Type {
+	case MaskHash, MaskPseudonym, MaskEmail, MaskPhone, MaskNullify, MaskConstant:
+		if mask.Length < 0 {
+			return errors.New("`length` must not be negative")
+		}
+	case MaskTruncate:
+		if mask.Length <= 0 {
+			return errors.New("`length` must be positive")
+		}
+	default:
+		return errors.Errorf("unsupported type (%s)", mask.Type)
+	}
+	return nil
+}
+
+// MaskFingerprint returns the masks in a canonical form, in which the salts
+// are replaced by their digests. The fingerprint is recorded in the checkpoint
+// to ensure a table is masked consistently across restarts, without revealing
+// the salts. Returns an empty string if there are no masks.
+func MaskFingerprint(masks map[string]*ColumnMask) string {
+	if len(masks) == 0 {
+		return ""
+	}
+	canonical := make(map[string]ColumnMask, len(masks))
+	for column, mask := range masks {
+		m := *mask
+		if len(m.Salt) != 0 {
+			digest := sha256.Sum256([]byte(m.Salt))
+			m.Salt = "sha256:" + hex.EncodeToString(digest[:])
+		}
+		canonical[column] = m
+	}
+	// encoding a map always sorts the keys.
+	res, _ := json.Marshal(canonical)
+	return string(res)
+}
+
+func lowerKeys(m map[string]string) map[string]string {
+	res := make(map[string]string, len(m))
+	for k, v := range m {
+		res[strings.ToLower(k)] = v
+	}
+	return res
+}
+
+func lowerValues(m map[string]string) {
+	for k, v := range m {
+		m[k] = strings.ToLower(v)
+	}
+}
+
+func lowerAll(s []string) {
+	for i, v := range s {
+		s[i] = strings.ToLower(v)
+	}
+}
+
+// adjust validates the rule, and turns all column names into lower case as
+// columns names are always case-insensitive.
+func (rule *TableRule) adjust(caseSensitive bool) error {
+	if len(rule.SchemaPattern) == 0 {
+		return errors.New("invalid config: `schema-pattern` of [[tables]] must not be empty")
+	}
+	if len(rule.TablePattern) == 0 {
+		return errors.Errorf("invalid config: `table-pattern` of [[tables]] (schema-pattern = %q) must not be empty", rule.SchemaPattern)
+	}
+	if !caseSensitive {
+		rule.SchemaPattern = strings.ToLower(rule.SchemaPattern)
+		rule.TablePattern = strings.ToLower(rule.TablePattern)
+	}
+
+	rule.ColumnMapping = lowerKeys(rule.ColumnMapping)
+	lowerValues(rule.ColumnMapping)
+	rule.ConstantColumns = lowerKeys(rule.ConstantColumns)
+	lowerAll(rule.IgnoreColumns)
+	lowerAll(rule.DefaultColumns)
+	rule.OnDuplicate = strings.ToLower(rule.OnDuplicate)
+
+	if len(rule.OnDuplicate) != 0 && !isValidOnDuplicate(rule.OnDuplicate) {
+		return errors.Errorf("invalid config: unsupported `on-duplicate` of [[tables]] (%s.%s) (%s)", rule.SchemaPattern, rule.TablePattern, rule.OnDuplicate)
+	}
+
+	var masks map[string]*ColumnMask
+	if rule.Masks != nil {
+		masks = make(map[string]*ColumnMask, len(rule.Masks))
+	}
+	for column, mask := range rule.Masks {
+		column = strings.ToLower(column)
+		if err := mask.adjust(); err != nil {
+			return errors.Annotatef(err, "invalid config: mask of column `%s` of [[tables]] (%s.%s)", column, rule.SchemaPattern, rule.TablePattern)
+		}
+		if _, ok := rule.ConstantColumns[column]; ok {
+			return errors.Errorf("invalid config: target column `%s` of [[tables]] (%s.%s) cannot be both a constant and masked", column, rule.SchemaPattern, rule.TablePattern)
+		}
+		masks[column] = mask
+	}
+	rule.Masks = masks
+	for _, column := range rule.DefaultColumns {
+		if _, ok := rule.Masks[column]; ok {
+			return errors.Errorf("invalid config: target column `%s` of [[tables]] (%s.%s) cannot be both a default and masked", column, rule.SchemaPattern, rule.TablePattern)
+		}
+	}
+
+	for _, column := range rule.IgnoreColumns {
+		if _, ok := rule.ColumnMapping[column]; ok {
+			return errors.Errorf("invalid config: source column `%s` of [[tables]] (%s.%s) cannot be both ignored and mapped", column, rule.SchemaPattern, rule.TablePattern)
+		}
+	}
+	for _, column := range rule.DefaultColumns {
+		if _, ok := rule.ConstantColumns[column]; ok {
+			return errors.Errorf("invalid config: target column `%s` of [[tables]] (%s.%s) cannot be both a constant and a default", column, rule.SchemaPattern, rule.TablePattern)
+		}
+	}
+	return nil
+}
+
+// TableRules looks up the TableRule of a target table.
+type TableRules struct {
+	selector      selector.Selector
+	caseSensitive bool
+}
+
+// NewTableRules creates a TableRules from the [[tables]] config. The rules
+// should have been adjusted already.
+func NewTableRules(caseSensitive bool, rules []*TableRule) (*TableRules, error) {
+	tr := &TableRules{
+		selector:      selector.NewTrieSelector(),
+		caseSensitive: caseSensitive,
+	}
+	for _, rule := range rules {
+		if err := tr.selector.Insert(rule.SchemaPattern, rule.TablePattern, rule, selector.Insert); err != nil {
+			return nil, errors.Annotatef(err, "invalid config: [[tables]] (%s.%s)", rule.SchemaPattern, rule.TablePattern)
+		}
+	}
+	return tr, nil
+}
+
+// Match returns the rule of the target table. If multiple rules match the
+// table, the one with the longest literal prefix is returned. Returns nil if
+// no rules match.
+func (tr *TableRules) Match(schema string, table string) *TableRule {
+	if tr == nil {
+		return nil
+	}
+	if !tr.caseSensitive {
+		schema = strings.ToLower(schema)
+		table = strings.ToLower(table)
+	}
+	rules := tr.selector.Match(schema, table)
+	if len(rules) == 0 {
+		return nil
+	}
+	return rules[len(rules)-1].(*TableRule)
+}
@@ -0,0 +1,188 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config_test
+
+import (
+	"regexp"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+var _ = Suite(&tableRulesSuite{})
+
+type tableRulesSuite struct{}
+
+func (s *tableRulesSuite) loadAndAdjust(c *C, input string) (*config.Config, error) {
+	cfg := config.NewConfig()
+	assignMinimalLegalValue(cfg)
+	err := cfg.LoadFromTOML([]byte(input))
+	c.Assert(err, IsNil)
+	return cfg, cfg.Adjust()
+}
+
+func (s *tableRulesSuite) TestAdjust(c *C) {
+	cfg, err := s.loadAndAdjust(c, `
+		[[tables]]
+		schema-pattern = "DB"
+		table-pattern = "T*"
+		ignore-columns = ["X"]
+		default-columns = ["Y"]
+		on-duplicate = "Upsert"
+		[tables.column-mapping]
+		Old_A = "New_A"
+		[tables.constant-columns]
+		Z = "Hello"
+		[tables.masks.Email]
+		type = "EMAIL"
+		salt = "pepper"
+	`)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Tables, DeepEquals, []*config.TableRule{{
+		SchemaPattern:   "db",
+		TablePattern:    "t*",
+		ColumnMapping:   map[string]string{"old_a": "new_a"},
+		IgnoreColumns:   []string{"x"},
+		ConstantColumns: map[string]string{"z": "Hello"},
+		DefaultColumns:  []string{"y"},
+		OnDuplicate:     config.UpsertOnDup,
+		Masks:           map[string]*config.ColumnMask{"email": {Type: config.MaskEmail, Salt: "pepper"}},
+	}})
+}
+
+func (s *tableRulesSuite) TestInvalidRules(c *C) {
+	testCases := []struct {
+		input string
+		err   string
+	}{
+		{
+			input: `
+				[[tables]]
+				table-pattern = "t"
+			`,
+			err: "invalid config: `schema-pattern` of [[tables]] must not be empty",
+		},
+		{
+			input: `
+				[[tables]]
+				schema-pattern = "db"
+			`,
+			err: "invalid config: `table-pattern` of [[tables]] (schema-pattern = \"db\") must not be empty",
+		},
+		{
+			input: `
+				[[tables]]
+				schema-pattern = "db"
+				table-pattern = "t"
+				ignore-columns = ["a"]
+				column-mapping = { a = "b" }
+			`,
+			err: "invalid config: source column `a` of [[tables]] (db.t) cannot be both ignored and mapped",
+		},
+		{
+			input: `
+				[[tables]]
+				schema-pattern = "db"
+				table-pattern = "t"
+				default-columns = ["a"]
+				constant-columns = { a = "1" }
+			`,
+			err: "invalid config: target column `a` of [[tables]] (db.t) cannot be both a constant and a default",
+		},
+		{
+			input: `
+				[[tables]]
+				schema-pattern = "db"
+				table-pattern = "t"
+				on-duplicate = "merge"
+			`,
+			err: "invalid config: unsupported `on-duplicate` of [[tables]] (db.t) (merge)",
+		},
+		{
+			input: `
+				[[tables]]
+				schema-pattern = "db"
+				table-pattern = "t"
+				masks = { a = { type = "scramble" } }
+			`,
+			err: "invalid config: mask of column `a` of [[tables]] (db.t): unsupported type (scramble)",
+		},
+		{
+			input: `
+				[[tables]]
+				schema-pattern = "db"
+				table-pattern = "t"
+				masks = { a = { type = "truncate" } }
+			`,
+			err: "invalid config: mask of column `a` of [[tables]] (db.t): `length` must be positive",
+		},
+		{
+			input: `
+				[[tables]]
+				schema-pattern = "db"
+				table-pattern = "t"
+				constant-columns = { a = "1" }
+				masks = { a = { type = "nullify" } }
+			`,
+			err: "invalid config: target column `a` of [[tables]] (db.t) cannot be both a constant and masked",
+		},
+	}
+
+	for _, tc := range testCases {
+		_, err := s.loadAndAdjust(c, tc.input)
+		c.Assert(err, ErrorMatches, regexp.QuoteMeta(tc.err), Commentf("input = %s", tc.input))
+	}
+}
+
+func (s *tableRulesSuite) TestMatch(c *C) {
+	cfg, err := s.loadAndAdjust(c, `
+		[[tables]]
+		schema-pattern = "db"
+		table-pattern = "*"
+		ignore-columns = ["any"]
+
+		[[tables]]
+		schema-pattern = "db"
+		table-pattern = "tbl"
+		ignore-columns = ["exact"]
+	`)
+	c.Assert(err, IsNil)
+
+	rules, err := config.NewTableRules(false, cfg.Tables)
+	c.Assert(err, IsNil)
+	c.Assert(rules.Match("DB", "TBL").IgnoreColumns, DeepEquals, []string{"exact"})
+	c.Assert(rules.Match("db", "other").IgnoreColumns, DeepEquals, []string{"any"})
+	c.Assert(rules.Match("other", "tbl"), IsNil)
+
+	var nilRules *config.TableRules
+	c.Assert(nilRules.Match("db", "tbl"), IsNil)
+
+	_, err = config.NewTableRules(false, append(cfg.Tables, cfg.Tables[0]))
+	c.Assert(err, ErrorMatches, `invalid config: \[\[tables\]\] \(db\.\*\).*`)
+}
+
+func (s *tableRulesSuite) TestMaskFingerprint(c *C) {
+	c.Assert(config.MaskFingerprint(nil), Equals, "")
+
+	masks := map[string]*config.ColumnMask{
+		"b": {Type: config.MaskTruncate, Length: 3},
+		"a": {Type: config.MaskHash, Salt: "pepper"},
+	}
+	fingerprint := config.MaskFingerprint(masks)
+	c.Assert(fingerprint, Equals, `{"a":{"type":"hash","salt":"sha256:`+
+		`8cbbcf29d9cef89675c5f5c1dcfe827d0570416a5aaba30dd0de159661ad905b"},"b":{"type":"truncate","length":3}}`)
+	// the masks themselves are unchanged.
+	c.Assert(masks["a"].Salt, Equals, "pepper")
+}
@@ -34,6 +34,7 @@ import (
 	"github.com/shurcooL/httpgzip"
 	"go.uber.org/zap"
 
+	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
 	"github.com/pingcap/tidb-lightning/lightning/common"
 	"github.com/pingcap/tidb-lightning/lightning/config"
 	"github.com/pingcap/tidb-lightning/lightning/log"
@@ -99,8 +100,12 @@ func (l *Lightning) GoServe() error {
 	handleTasks := http.StripPrefix("/tasks", http.HandlerFunc(l.handleTask))
 	mux.Handle("/tasks", handleTasks)
 	mux.Handle("/tasks/", handleTasks)
+	handleHistory := http.StripPrefix("/history", http.HandlerFunc(l.handleHistory))
+	mux.Handle("/history", handleHistory)
+	mux.Handle("/history/", handleHistory)
 	mux.HandleFunc("/progress/task", handleProgressTask)
 	mux.HandleFunc("/progress/table", handleProgressTable)
+	mux.HandleFunc("/report", handleReport)
 	mux.HandleFunc("/pause", handlePause)
 	mux.HandleFunc("/resume", handleResume)
 
@@ -440,6 +445,94 @@ func (l *Lightning) handlePatchOneTask(w http.ResponseWriter, req *http.Request)
 	}
 }
 
+// historyConfig returns the configuration locating the checkpoints database
+// which keeps the task history, i.e. that of the current task if any, or
+// otherwise the global one.
+func (l *Lightning) historyConfig() (*config.Config, error) {
+	l.cancelLock.Lock()
+	cfg := l.curTask
+	l.cancelLock.Unlock()
+	if cfg != nil {
+		return cfg, nil
+	}
+
+	cfg = config.NewConfig()
+	if err := cfg.LoadFromGlobal(l.globalCfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Adjust(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (l *Lightning) handleHistory(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "only GET is allowed", nil)
+		return
+	}
+
+	taskID, _, err := parseTaskID(req)
+	listAll := false
+	if e, ok := err.(*strconv.NumError); ok && e.Num == "" {
+		listAll = true
+	} else if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid task ID", err)
+		return
+	}
+
+	cfg, err := l.historyConfig()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "invalid configuration", err)
+		return
+	}
+	if !cfg.Checkpoint.Enable || cfg.Checkpoint.Driver != config.CheckpointDriverMySQL {
+		writeJSONError(w, http.StatusNotImplemented, "the task history is only kept by the \"mysql\" checkpoint driver", nil)
+		return
+	}
+
+	historyDB, err := restore.OpenTaskHistoryDB(cfg)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to open task history", err)
+		return
+	}
+	defer historyDB.Close()
+
+	var response interface{}
+	if listAll {
+		histories, err := historyDB.ListTaskHistory(req.Context())
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "failed to list task history", err)
+			return
+		}
+		if histories == nil {
+			histories = []*checkpoints.TaskHistory{}
+		}
+		response = histories
+	} else {
+		history, err := historyDB.GetTaskHistory(req.Context(), taskID)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "failed to get task history", err)
+			return
+		}
+		if history == nil {
+			writeJSONError(w, http.StatusNotFound, "task ID not found in the task history", nil)
+			return
+		}
+		response = history
+	}
+
+	json, err := json.Marshal(response)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "unable to serialize task history", err)
+		return
+	}
+	writeBytesCompressed(w, req, json)
+}
+
 func writeBytesCompressed(w http.ResponseWriter, req *http.Request, b []byte) {
 	if !strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") {
 		w.Write(b)
@@ -480,6 +573,38 @@ func handleProgressTable(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+func handleReport(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "only GET is allowed", nil)
+		return
+	}
+
+	markdown := false
+	switch format := req.URL.Query().Get("format"); format {
+	case "", "json":
+	case "markdown", "md":
+		markdown = true
+	default:
+		writeJSONError(w, http.StatusBadRequest, "unknown format "+format, nil)
+		return
+	}
+
+	res, err := web.MarshalReport(markdown)
+	switch {
+	case errors.IsNotFound(err):
+		writeJSONError(w, http.StatusNotFound, "no task has finished yet", nil)
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "cannot get the import report", err)
+	default:
+		if markdown {
+			w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		}
+		writeBytesCompressed(w, req, res)
+	}
+}
+
 func handlePause(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
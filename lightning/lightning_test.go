@@ -324,6 +324,30 @@ func (s *lightningServerSuite) TestGetDeleteTask(c *C) {
 	})
 }
 
+func (s *lightningServerSuite) TestHistoryAPI(c *C) {
+	url := "http://" + s.lightning.serverAddr.String() + "/history"
+
+	// the default "file" checkpoint driver does not keep the task history.
+	resp, err := http.Get(url)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusNotImplemented)
+	var result map[string]string
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(result["error"], Matches, "the task history is only kept by the \"mysql\" checkpoint driver")
+
+	resp, err = http.Get(url + "/abc")
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusBadRequest)
+	resp.Body.Close()
+
+	resp, err = http.Post(url, "application/json", strings.NewReader("{}"))
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusMethodNotAllowed)
+	resp.Body.Close()
+}
+
 func (s *lightningServerSuite) TestHTTPAPIOutsideServerMode(c *C) {
 	s.lightning.globalCfg.App.ServerMode = false
 
@@ -43,6 +43,10 @@ const (
 
 	BlockDeliverKindIndex = "index"
 	BlockDeliverKindData  = "data"
+
+	// kinds used for the ThroughputGauge labels
+	ThroughputKindSource = "source"
+	ThroughputKindKV     = "kv"
 )
 
 var (
@@ -60,6 +64,32 @@ var (
 			Help:      "counting idle workers",
 		}, []string{"name"})
 
+	KVQueueBytesGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "lightning",
+			Name:      "kv_queue_bytes",
+			Help:      "number of bytes of encoded KV pairs waiting to be delivered",
+		})
+
+	TaskETASecondsGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "lightning",
+			Name:      "task_eta_seconds",
+			Help:      "estimated number of seconds to complete the task, NaN if unknown",
+		})
+	TableETASecondsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "lightning",
+			Name:      "table_eta_seconds",
+			Help:      "estimated number of seconds to complete each unfinished table, NaN if unknown",
+		}, []string{"table"})
+	ThroughputGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "lightning",
+			Name:      "throughput_bytes",
+			Help:      "number of bytes of source data read (source) or KV pairs delivered (kv) per second recently",
+		}, []string{"kind"})
+
 	KvEncoderCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: "lightning",
@@ -173,6 +203,13 @@ var (
 			Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
 		}, []string{"kind"},
 	)
+	RejectedRowsCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "lightning",
+			Name:      "rejected_rows",
+			Help:      "count number of rows skipped due to encode errors",
+		},
+	)
 	ChecksumSecondsHistogram = prometheus.NewHistogram(
 		prometheus.HistogramOpts{
 			Namespace: "lightning",
@@ -185,6 +222,10 @@ var (
 
 func init() {
 	prometheus.MustRegister(IdleWorkersGauge)
+	prometheus.MustRegister(KVQueueBytesGauge)
+	prometheus.MustRegister(TaskETASecondsGauge)
+	prometheus.MustRegister(TableETASecondsGauge)
+	prometheus.MustRegister(ThroughputGauge)
 	prometheus.MustRegister(ImporterEngineCounter)
 	prometheus.MustRegister(KvEncoderCounter)
 	prometheus.MustRegister(TableCounter)
@@ -198,6 +239,7 @@ func init() {
 	prometheus.MustRegister(BlockDeliverSecondsHistogram)
 	prometheus.MustRegister(BlockDeliverBytesHistogram)
 	prometheus.MustRegister(BlockDeliverKVPairsHistogram)
+	prometheus.MustRegister(RejectedRowsCounter)
 	prometheus.MustRegister(ChecksumSecondsHistogram)
 	prometheus.MustRegister(ChunkParserReadBlockSecondsHistogram)
 	prometheus.MustRegister(ApplyWorkerSecondsHistogram)
@@ -14,10 +14,14 @@
 package mydump_test
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"time"
 
 	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
 	. "github.com/pingcap/tidb-lightning/lightning/mydump"
 )
 
@@ -126,3 +130,41 @@ func (s *testMydumpReaderSuite) TestExportStatementGibberishError(c *C) {
 	c.Assert(data, IsNil)
 	c.Assert(err, NotNil)
 }
+
+func (s *testMydumpReaderSuite) TestFileFingerprint(c *C) {
+	path := filepath.Join(c.MkDir(), "db.t.sql")
+	write := func(content []byte) string {
+		c.Assert(ioutil.WriteFile(path, content, 0644), IsNil)
+		fingerprint, err := FileFingerprint(path)
+		c.Assert(err, IsNil)
+		return fingerprint
+	}
+
+	small := write([]byte("INSERT INTO t VALUES (1);"))
+	c.Assert(small, Equals, "25:"+
+		"b6d8999b6132ae89d10494a6d59f0946541325a587721d987444cbbf65c8160d")
+
+	// the modification time is not part of the fingerprint.
+	mtime := time.Unix(1555555555, 0)
+	c.Assert(os.Chtimes(path, mtime, mtime), IsNil)
+	fingerprint, err := FileFingerprint(path)
+	c.Assert(err, IsNil)
+	c.Assert(fingerprint, Equals, small)
+
+	c.Assert(write([]byte("INSERT INTO t VALUES (1);")), Equals, small)
+	c.Assert(write([]byte("INSERT INTO t VALUES (2);")), Not(Equals), small)
+
+	// only the sampled blocks of a large file are hashed.
+	large := bytes.Repeat([]byte{'x'}, 2*1024*1024)
+	fingerprint = write(large)
+	large[100*1024] = 'y'
+	c.Assert(write(large), Equals, fingerprint)
+	large[1100*1024] = 'y'
+	middle := write(large)
+	c.Assert(middle, Not(Equals), fingerprint)
+	large[len(large)-1] = 'y'
+	c.Assert(write(large), Not(Equals), middle)
+
+	_, err = FileFingerprint(filepath.Join(c.MkDir(), "missing.sql"))
+	c.Assert(os.IsNotExist(errors.Cause(err)), IsTrue)
+}
@@ -0,0 +1,450 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/tablecodec"
+	"go.uber.org/zap"
+
+	kv "github.com/pingcap/tidb-lightning/lightning/backend"
+	. "github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// maxDuplicateBufferSize is the total key size kept in memory by a
+// duplicateDetector before the keys are sorted and spilled into a run file.
+const maxDuplicateBufferSize = 64 * 1024 * 1024
+
+// duplicateDetector records the encoded primary and unique keys of a table, so
+// that rows with duplicated keys (which silently overwrite each other when
+// using the importer backend) can be reported before the checksum step.
+//
+// The keys are kept in memory, and spilled into sorted run files inside the
+// `tikv-importer.duplicate-dir` when the buffer becomes too large. The runs
+// are merged in `finish()`, and every conflicting pair is written into the file
+// "<dir>/<db>.<table>.conflicts.log". The file left by a previous run is
+// removed when the table starts.
+//
+// A nil *duplicateDetector is valid and records nothing.
+type duplicateDetector struct {
+	dir    string
+	prefix string
+	logger log.Logger
+
+	// maps index ID to index name of all unique indices.
+	uniqueIndices map[int64]string
+	// whether the record keys may collide, i.e. the handle is the primary key.
+	checkRecords bool
+
+	lock       sync.Mutex
+	paths      []string
+	pathIndex  map[string]int
+	buffer     []duplicateEntry
+	bufferSize int
+	runs       []string
+	// whether some rows were written in a previous run and thus not recorded.
+	incomplete bool
+}
+
+type duplicateEntry struct {
+	key    []byte
+	file   int
+	offset int64
+}
+
+func compareDuplicateEntries(a, b *duplicateEntry) int {
+	if cmp := bytes.Compare(a.key, b.key); cmp != 0 {
+		return cmp
+	}
+	switch {
+	case a.file < b.file:
+		return -1
+	case a.file > b.file:
+		return 1
+	case a.offset < b.offset:
+		return -1
+	case a.offset > b.offset:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func newDuplicateDetector(dir string, dbName string, tableInfo *model.TableInfo, logger log.Logger) *duplicateDetector {
+	uniqueIndices := make(map[int64]string)
+	for _, index := range tableInfo.Indices {
+		if index.Unique || index.Primary {
+			uniqueIndices[index.ID] = index.Name.O
+		}
+	}
+	if !tableInfo.PKIsHandle && len(uniqueIndices) == 0 {
+		// nothing could ever collide.
+		return nil
+	}
+
+	return &duplicateDetector{
+		dir:           dir,
+		prefix:        dbName + "." + tableInfo.Name.O,
+		logger:        logger,
+		uniqueIndices: uniqueIndices,
+		checkRecords:  tableInfo.PKIsHandle,
+		pathIndex:     make(map[string]int),
+	}
+}
+
+// shouldRecord returns whether the key can possibly collide with the key of
+// another row. Keys of non-unique indices and records using the implicit
+// `_tidb_rowid` handle always contain the unique handle and never collide.
+func (dd *duplicateDetector) shouldRecord(key []byte) bool {
+	if tablecodec.IsIndexKey(key) {
+		_, indexID, _, err := tablecodec.DecodeKeyHead(key)
+		if err != nil {
+			return false
+		}
+		_, ok := dd.uniqueIndices[indexID]
+		return ok
+	}
+	return dd.checkRecords
+}
+
+// markIncomplete records that some rows of the table were written before this
+// process started, so conflicts involving them cannot be detected.
+func (dd *duplicateDetector) markIncomplete() {
+	if dd == nil {
+		return
+	}
+	dd.lock.Lock()
+	dd.incomplete = true
+	dd.lock.Unlock()
+}
+
+// record adds the keys of an encoded row which starts at the given offset of
+// the chunk.
+func (dd *duplicateDetector) record(key *ChunkCheckpointKey, offset int64, row kv.Row) error {
+	if dd == nil {
+		return nil
+	}
+
+	pairs := kv.KvPairsFromRow(row)
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	dd.lock.Lock()
+	defer dd.lock.Unlock()
+
+	file, ok := dd.pathIndex[key.Path]
+	if !ok {
+		file = len(dd.paths)
+		dd.paths = append(dd.paths, key.Path)
+		dd.pathIndex[key.Path] = file
+	}
+
+	for _, pair := range pairs {
+		if !dd.shouldRecord(pair.Key) {
+			continue
+		}
+		dd.buffer = append(dd.buffer, duplicateEntry{key: pair.Key, file: file, offset: offset})
+		dd.bufferSize += len(pair.Key)
+	}
+
+	if dd.bufferSize >= maxDuplicateBufferSize {
+		return errors.Annotate(dd.spill(), "cannot record keys for duplicate detection")
+	}
+	return nil
+}
+
+// spill sorts the in-memory buffer and writes it out as a new run file.
+func (dd *duplicateDetector) spill() error {
+	if err := os.MkdirAll(dd.dir, 0755); err != nil {
+		return errors.Trace(err)
+	}
+
+	sortDuplicateEntries(dd.buffer)
+
+	runPath := filepath.Join(dd.dir, fmt.Sprintf("%s.%d.run", dd.prefix, len(dd.runs)))
+	f, err := os.Create(runPath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	var scratch [binary.MaxVarintLen64]byte
+	for _, entry := range dd.buffer {
+		w.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(entry.key)))])
+		w.Write(entry.key)
+		w.Write(scratch[:binary.PutUvarint(scratch[:], uint64(entry.file))])
+		w.Write(scratch[:binary.PutVarint(scratch[:], entry.offset)])
+	}
+	if err := w.Flush(); err != nil {
+		return errors.Trace(err)
+	}
+
+	dd.runs = append(dd.runs, runPath)
+	dd.buffer = nil
+	dd.bufferSize = 0
+	return nil
+}
+
+func sortDuplicateEntries(entries []duplicateEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return compareDuplicateEntries(&entries[i], &entries[j]) < 0
+	})
+}
+
+// finish merges all recorded keys, and writes every conflicting pair into the
+// conflicts file. Returns the number of conflicts and the path of the file.
+func (dd *duplicateDetector) finish() (int, string, error) {
+	if dd == nil {
+		return 0, "", nil
+	}
+
+	dd.lock.Lock()
+	defer dd.lock.Unlock()
+
+	if dd.incomplete {
+		dd.logger.Warn("some rows were written before restarting and cannot be checked for duplicated keys")
+	}
+
+	sortDuplicateEntries(dd.buffer)
+	cursors := make(duplicateCursors, 0, len(dd.runs)+1)
+	defer func() {
+		for _, cursor := range cursors {
+			cursor.close()
+		}
+	}()
+	if len(dd.buffer) > 0 {
+		cursors = append(cursors, &duplicateCursor{entries: dd.buffer})
+	}
+	for _, runPath := range dd.runs {
+		f, err := os.Open(runPath)
+		if err != nil {
+			return 0, "", errors.Trace(err)
+		}
+		cursors = append(cursors, &duplicateCursor{file: f, reader: bufio.NewReader(f)})
+	}
+
+	// prime all cursors and build the heap.
+	live := cursors[:0:0]
+	for _, cursor := range cursors {
+		ok, err := cursor.advance()
+		if err != nil {
+			return 0, "", errors.Trace(err)
+		}
+		if ok {
+			live = append(live, cursor)
+		}
+	}
+	heap.Init(&live)
+
+	conflictsPath := dd.conflictsPath()
+	var (
+		conflicts int
+		report    *bufio.Writer
+		reportF   *os.File
+		first     duplicateEntry
+		hasFirst  bool
+	)
+	defer func() {
+		if reportF != nil {
+			reportF.Close()
+		}
+	}()
+
+	for live.Len() > 0 {
+		cursor := live[0]
+		entry := cursor.cur
+
+		if hasFirst && bytes.Equal(first.key, entry.key) {
+			if report == nil {
+				if err := os.MkdirAll(dd.dir, 0755); err != nil {
+					return 0, "", errors.Trace(err)
+				}
+				f, err := os.Create(conflictsPath)
+				if err != nil {
+					return 0, "", errors.Trace(err)
+				}
+				reportF = f
+				report = bufio.NewWriter(f)
+			}
+			conflicts++
+			fmt.Fprintf(report, "%s\t%s:%d\t%s:%d\n",
+				dd.describeKey(entry.key),
+				dd.paths[first.file], first.offset,
+				dd.paths[entry.file], entry.offset,
+			)
+		} else {
+			first = entry
+			hasFirst = true
+		}
+
+		ok, err := cursor.advance()
+		if err != nil {
+			return 0, "", errors.Trace(err)
+		}
+		if ok {
+			heap.Fix(&live, 0)
+		} else {
+			heap.Pop(&live)
+		}
+	}
+
+	if report != nil {
+		if err := report.Flush(); err != nil {
+			return 0, "", errors.Trace(err)
+		}
+	}
+	return conflicts, conflictsPath, nil
+}
+
+func (dd *duplicateDetector) conflictsPath() string {
+	return filepath.Join(dd.dir, dd.prefix+".conflicts.log")
+}
+
+// removeStaleConflicts removes the conflicts file left by a previous run,
+// which would otherwise report conflicts no longer existing.
+func (dd *duplicateDetector) removeStaleConflicts() error {
+	if dd == nil {
+		return nil
+	}
+	err := os.Remove(dd.conflictsPath())
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Annotate(err, "cannot remove the stale conflicts file")
+	}
+	return nil
+}
+
+// describeKey converts an encoded key into a human readable form like
+// "`uk`(1, abc)".
+func (dd *duplicateDetector) describeKey(key []byte) string {
+	var sb strings.Builder
+	if tablecodec.IsIndexKey(key) {
+		_, indexID, values, err := tablecodec.DecodeIndexKey(key)
+		if err == nil {
+			sb.WriteByte('`')
+			sb.WriteString(dd.uniqueIndices[indexID])
+			sb.WriteString("`(")
+			sb.WriteString(strings.Join(values, ", "))
+			sb.WriteByte(')')
+			return sb.String()
+		}
+	} else {
+		_, handle, err := tablecodec.DecodeRecordKey(key)
+		if err == nil {
+			sb.WriteString("`PRIMARY`(")
+			sb.WriteString(strconv.FormatInt(handle, 10))
+			sb.WriteByte(')')
+			return sb.String()
+		}
+	}
+	return fmt.Sprintf("%X", key)
+}
+
+// cleanup removes all spilled run files.
+func (dd *duplicateDetector) cleanup() {
+	if dd == nil {
+		return
+	}
+	dd.lock.Lock()
+	defer dd.lock.Unlock()
+
+	for _, runPath := range dd.runs {
+		if err := os.Remove(runPath); err != nil && !os.IsNotExist(err) {
+			dd.logger.Warn("failed to remove duplicate detection run file", zap.String("path", runPath), log.ShortError(err))
+		}
+	}
+	dd.runs = nil
+	dd.buffer = nil
+	dd.bufferSize = 0
+}
+
+// duplicateCursor iterates the entries of either the in-memory buffer or a run
+// file in sorted order.
+type duplicateCursor struct {
+	entries []duplicateEntry
+	file    *os.File
+	reader  *bufio.Reader
+	cur     duplicateEntry
+}
+
+func (c *duplicateCursor) advance() (bool, error) {
+	if c.reader == nil {
+		if len(c.entries) == 0 {
+			return false, nil
+		}
+		c.cur = c.entries[0]
+		c.entries = c.entries[1:]
+		return true, nil
+	}
+
+	keyLen, err := binary.ReadUvarint(c.reader)
+	if err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Trace(err)
+	}
+	key := make([]byte, keyLen)
+	if _, err := io.ReadFull(c.reader, key); err != nil {
+		return false, errors.Trace(err)
+	}
+	file, err := binary.ReadUvarint(c.reader)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	offset, err := binary.ReadVarint(c.reader)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	c.cur = duplicateEntry{key: key, file: int(file), offset: offset}
+	return true, nil
+}
+
+func (c *duplicateCursor) close() {
+	if c.file != nil {
+		c.file.Close()
+	}
+}
+
+// duplicateCursors is a min-heap of cursors ordered by their current entry.
+type duplicateCursors []*duplicateCursor
+
+func (h duplicateCursors) Len() int { return len(h) }
+func (h duplicateCursors) Less(i, j int) bool {
+	return compareDuplicateEntries(&h[i].cur, &h[j].cur) < 0
+}
+func (h duplicateCursors) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *duplicateCursors) Push(x interface{}) { *h = append(*h, x.(*duplicateCursor)) }
+func (h *duplicateCursors) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
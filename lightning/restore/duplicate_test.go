@@ -0,0 +1,135 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/ddl"
+	"github.com/pingcap/tidb/table/tables"
+	"github.com/pingcap/tidb/types"
+	tmock "github.com/pingcap/tidb/util/mock"
+
+	kv "github.com/pingcap/tidb-lightning/lightning/backend"
+	. "github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+var _ = Suite(&duplicateSuite{})
+
+type duplicateSuite struct{}
+
+func (s *duplicateSuite) mockTableInfo(c *C, createSQL string) *model.TableInfo {
+	node, err := parser.New().ParseOneStmt(createSQL, "", "")
+	c.Assert(err, IsNil)
+	tableInfo, err := ddl.MockTableInfo(tmock.NewContext(), node.(*ast.CreateTableStmt), 1)
+	c.Assert(err, IsNil)
+	tableInfo.State = model.StatePublic
+	return tableInfo
+}
+
+func (s *duplicateSuite) TestNoUniqueKeys(c *C) {
+	tableInfo := s.mockTableInfo(c, "create table t (a int, b int, key (b))")
+	c.Assert(newDuplicateDetector(c.MkDir(), "db", tableInfo, log.L()), IsNil)
+}
+
+func (s *duplicateSuite) TestDetectDuplicates(c *C) {
+	tableInfo := s.mockTableInfo(c, "create table t (a int primary key, b int, c int, unique key uk (b), key (c))")
+	tbl, err := tables.TableFromMeta(kv.NewPanickingAllocators(0), tableInfo)
+	c.Assert(err, IsNil)
+	encoder, err := kv.NewTableKVEncoder(tbl, &kv.SessionOptions{})
+	c.Assert(err, IsNil)
+	defer encoder.Close()
+
+	dir := c.MkDir()
+	dd := newDuplicateDetector(dir, "db", tableInfo, log.L())
+	c.Assert(dd, NotNil)
+
+	key1 := &ChunkCheckpointKey{Path: "/data/db.t.1.sql"}
+	key2 := &ChunkCheckpointKey{Path: "/data/db.t.2.sql"}
+	records := []struct {
+		key    *ChunkCheckpointKey
+		offset int64
+		row    []types.Datum
+	}{
+		{key1, 10, types.MakeDatums(1, 1, 1)},
+		{key1, 20, types.MakeDatums(2, 2, 1)},
+		{key2, 30, types.MakeDatums(3, 1, 1)}, // conflicts with offset 10 on `uk`
+		{key2, 40, types.MakeDatums(2, 4, 1)}, // conflicts with offset 20 on `PRIMARY`
+		{key2, 50, types.MakeDatums(5, 5, 1)},
+	}
+	for i, r := range records {
+		row, err := encoder.Encode(log.L(), r.row, int64(i+1), []int{0, 1, 2, -1})
+		c.Assert(err, IsNil)
+		c.Assert(dd.record(r.key, r.offset, row), IsNil)
+		if i == 1 {
+			// force some rows to be spilled into run files.
+			c.Assert(dd.spill(), IsNil)
+		}
+	}
+
+	conflicts, conflictsPath, err := dd.finish()
+	c.Assert(err, IsNil)
+	c.Assert(conflicts, Equals, 2)
+	c.Assert(conflictsPath, Equals, filepath.Join(dir, "db.t.conflicts.log"))
+
+	content, err := ioutil.ReadFile(conflictsPath)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, ""+
+		"`uk`(1)\t/data/db.t.1.sql:10\t/data/db.t.2.sql:30\n"+
+		"`PRIMARY`(2)\t/data/db.t.1.sql:20\t/data/db.t.2.sql:40\n")
+
+	dd.cleanup()
+	files, err := filepath.Glob(filepath.Join(dir, "*.run"))
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 0)
+}
+
+func (s *duplicateSuite) TestNoDuplicates(c *C) {
+	tableInfo := s.mockTableInfo(c, "create table t (a int, b int, unique key uk (b))")
+	tbl, err := tables.TableFromMeta(kv.NewPanickingAllocators(0), tableInfo)
+	c.Assert(err, IsNil)
+	encoder, err := kv.NewTableKVEncoder(tbl, &kv.SessionOptions{})
+	c.Assert(err, IsNil)
+	defer encoder.Close()
+
+	dir := c.MkDir()
+	dd := newDuplicateDetector(dir, "db", tableInfo, log.L())
+
+	// the conflicts file of a previous run is removed.
+	conflictsPath := filepath.Join(dir, "db.t.conflicts.log")
+	c.Assert(ioutil.WriteFile(conflictsPath, []byte("`uk`(1)\t/data/db.t.1.sql:1\t/data/db.t.1.sql:2\n"), 0644), IsNil)
+	c.Assert(dd.removeStaleConflicts(), IsNil)
+	_, err = os.Stat(conflictsPath)
+	c.Assert(os.IsNotExist(err), IsTrue)
+	c.Assert(dd.removeStaleConflicts(), IsNil)
+
+	key := &ChunkCheckpointKey{Path: "/data/db.t.1.sql"}
+	for i := int64(1); i <= 3; i++ {
+		// NULL values in unique keys never conflict.
+		row, err := encoder.Encode(log.L(), types.MakeDatums(i, nil), i, []int{0, 1, -1})
+		c.Assert(err, IsNil)
+		c.Assert(dd.record(key, i, row), IsNil)
+	}
+
+	conflicts, _, err := dd.finish()
+	c.Assert(err, IsNil)
+	c.Assert(conflicts, Equals, 0)
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	. "github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	verify "github.com/pingcap/tidb-lightning/lightning/verification"
+)
+
+// taskHistoryRecorder keeps the record of the running task up-to-date in the
+// task history. Failing to record the history is only logged, it never fails
+// the task itself.
+type taskHistoryRecorder struct {
+	mu      sync.Mutex
+	db      TaskHistoryDB
+	history TaskHistory
+}
+
+// newTaskHistoryRecorder returns nil if the checkpoints database does not
+// keep the task history. All methods of a nil recorder are no-op.
+func newTaskHistoryRecorder(cpdb CheckpointsDB, taskID int64, summary string) *taskHistoryRecorder {
+	historyDB, err := AsTaskHistoryDB(cpdb)
+	if err != nil {
+		return nil
+	}
+	return &taskHistoryRecorder{
+		db: historyDB,
+		history: TaskHistory{
+			TaskID: taskID,
+			Config: summary,
+			Status: TaskStatusRunning,
+		},
+	}
+}
+
+// save writes the current record into the task history. It must be called
+// with the lock held.
+func (r *taskHistoryRecorder) save(ctx context.Context) {
+	if err := r.db.SaveTaskHistory(ctx, &r.history); err != nil {
+		log.L().Warn("failed to save task history", zap.Int64("taskID", r.history.TaskID), log.ShortError(err))
+	}
+}
+
+func (r *taskHistoryRecorder) start(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.history.StartTime = time.Now()
+	r.save(ctx)
+}
+
+// recordTable records the result of restoring a table, computed from its
+// checkpoint. The rejected rows are not counted as imported.
+func (r *taskHistoryRecorder) recordTable(ctx context.Context, tableName string, cp *TableCheckpoint, rejectedRows int64, err error) {
+	if r == nil {
+		return
+	}
+
+	table := &TableHistory{
+		TableName: tableName,
+		Status:    TaskStatusSucceeded,
+		Rows:      importedRows(cp) - rejectedRows,
+	}
+	var checksum verify.KVChecksum
+	for _, engine := range cp.Engines {
+		for _, chunk := range engine.Chunks {
+			checksum.Add(&chunk.Checksum)
+		}
+	}
+	table.KVs = checksum.SumKVS()
+	table.Bytes = checksum.SumSize()
+	table.Checksum = checksum.Sum()
+	switch {
+	case err == nil:
+	case log.IsContextCanceledError(err):
+		table.Status = TaskStatusCanceled
+	default:
+		table.Status = TaskStatusFailed
+		table.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.history.Tables = append(r.history.Tables, table)
+	r.save(ctx)
+}
+
+// finish records the final status of the task. The record is saved even if
+// the task has been canceled.
+func (r *taskHistoryRecorder) finish(err error) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.history.EndTime = time.Now()
+	switch {
+	case err == nil:
+		r.history.Status = TaskStatusSucceeded
+	case log.IsContextCanceledError(err):
+		r.history.Status = TaskStatusCanceled
+	default:
+		r.history.Status = TaskStatusFailed
+		r.history.Error = err.Error()
+	}
+	r.save(context.Background())
+}
+
+// importedRows counts the rows already imported from the chunks of the table.
+// The chunks of a table are allocated consecutive ranges of row IDs from the
+// RowIDBase, so every chunk started from the RowIDMax of the previous one, and
+// has advanced its PrevRowIDMax by one for every row read.
+func importedRows(cp *TableCheckpoint) int64 {
+	var chunks []*ChunkCheckpoint
+	for _, engine := range cp.Engines {
+		chunks = append(chunks, engine.Chunks...)
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].Chunk.RowIDMax < chunks[j].Chunk.RowIDMax
+	})
+
+	var rows int64
+	startRowID := cp.RowIDBase
+	for _, chunk := range chunks {
+		rows += chunk.Chunk.PrevRowIDMax - startRowID
+		startRowID = chunk.Chunk.RowIDMax
+	}
+	return rows
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+
+	. "github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+	verify "github.com/pingcap/tidb-lightning/lightning/verification"
+)
+
+var _ = Suite(&historySuite{})
+
+type historySuite struct{}
+
+// memoryHistoryDB is a checkpoints database keeping the task history in
+// memory.
+type memoryHistoryDB struct {
+	NullCheckpointsDB
+	saved []TaskHistory
+}
+
+func (db *memoryHistoryDB) SaveTaskHistory(_ context.Context, history *TaskHistory) error {
+	saved := *history
+	saved.Tables = append([]*TableHistory(nil), history.Tables...)
+	db.saved = append(db.saved, saved)
+	return nil
+}
+
+func (db *memoryHistoryDB) ListTaskHistory(context.Context) ([]*TaskHistory, error) {
+	return nil, nil
+}
+
+func (db *memoryHistoryDB) GetTaskHistory(context.Context, int64) (*TaskHistory, error) {
+	return nil, nil
+}
+
+func (s *historySuite) TestImportedRows(c *C) {
+	newChunk := func(prevRowIDMax, rowIDMax int64) *ChunkCheckpoint {
+		return &ChunkCheckpoint{Chunk: mydump.Chunk{PrevRowIDMax: prevRowIDMax, RowIDMax: rowIDMax}}
+	}
+
+	// the chunks were allocated the row IDs (0, 100], (100, 250] and
+	// (250, 300], and have read 100, 20 and 0 rows respectively.
+	cp := &TableCheckpoint{
+		Engines: map[int32]*EngineCheckpoint{
+			0: {Chunks: []*ChunkCheckpoint{newChunk(250, 300), newChunk(100, 100)}},
+			1: {Chunks: []*ChunkCheckpoint{newChunk(120, 250)}},
+		},
+	}
+	c.Assert(importedRows(cp), Equals, int64(120))
+	c.Assert(importedRows(&TableCheckpoint{}), Equals, int64(0))
+
+	// the row IDs of an incremental import start after the existing handles.
+	cp = &TableCheckpoint{
+		RowIDBase: 1000,
+		Engines: map[int32]*EngineCheckpoint{
+			0: {Chunks: []*ChunkCheckpoint{newChunk(1030, 1100), newChunk(1150, 1150)}},
+		},
+	}
+	c.Assert(importedRows(cp), Equals, int64(80))
+}
+
+func (s *historySuite) TestRecorder(c *C) {
+	ctx := context.Background()
+
+	// the task history is not kept by other drivers.
+	recorder := newTaskHistoryRecorder(NewNullCheckpointsDB(), 1234, "backend=tidb")
+	c.Assert(recorder, IsNil)
+	recorder.start(ctx)
+	recorder.recordTable(ctx, "`db`.`t`", &TableCheckpoint{}, 0, nil)
+	recorder.finish(nil)
+
+	db := &memoryHistoryDB{}
+	recorder = newTaskHistoryRecorder(db, 1234, "backend=tidb")
+	c.Assert(recorder, NotNil)
+
+	recorder.start(ctx)
+	c.Assert(db.saved, HasLen, 1)
+	c.Assert(db.saved[0].TaskID, Equals, int64(1234))
+	c.Assert(db.saved[0].Config, Equals, "backend=tidb")
+	c.Assert(db.saved[0].Status, Equals, TaskStatusRunning)
+	c.Assert(db.saved[0].StartTime.IsZero(), IsFalse)
+	c.Assert(db.saved[0].EndTime.IsZero(), IsTrue)
+
+	cp := &TableCheckpoint{
+		Engines: map[int32]*EngineCheckpoint{
+			0: {Chunks: []*ChunkCheckpoint{{
+				Chunk:    mydump.Chunk{PrevRowIDMax: 3, RowIDMax: 5},
+				Checksum: verify.MakeKVChecksum(30, 3, 12345),
+			}}},
+		},
+		BaseChecksum: verify.MakeKVChecksum(1, 1, 1),
+	}
+	recorder.recordTable(ctx, "`db`.`t1`", cp, 1, nil)
+	recorder.recordTable(ctx, "`db`.`t2`", &TableCheckpoint{}, 0, errors.New("checksum mismatched"))
+	c.Assert(db.saved, HasLen, 3)
+	c.Assert(db.saved[2].Tables, DeepEquals, []*TableHistory{
+		{TableName: "`db`.`t1`", Status: TaskStatusSucceeded, Rows: 2, KVs: 3, Bytes: 30, Checksum: 12345},
+		{TableName: "`db`.`t2`", Status: TaskStatusFailed, Error: "checksum mismatched"},
+	})
+
+	recorder.finish(errors.Trace(context.Canceled))
+	c.Assert(db.saved, HasLen, 4)
+	c.Assert(db.saved[3].Status, Equals, TaskStatusCanceled)
+	c.Assert(db.saved[3].EndTime.IsZero(), IsFalse)
+	c.Assert(db.saved[3].Tables, HasLen, 2)
+
+	recorder.finish(errors.New("checksum mismatched"))
+	c.Assert(db.saved[4].Status, Equals, TaskStatusFailed)
+	c.Assert(db.saved[4].Error, Equals, "checksum mismatched")
+}
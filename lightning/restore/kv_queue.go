@@ -0,0 +1,123 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pingcap/tidb-lightning/lightning/metric"
+)
+
+// kvQueue is the FIFO queue of encoded rows between the encode loop and the
+// deliver loop of a chunk. Unlike a buffered channel, the queue is bounded by
+// the total byte size of the queued rows instead of the number of rows, so the
+// memory used by a chunk does not depend on how wide the table is.
+//
+// The queue supports a single producer and a single consumer.
+type kvQueue struct {
+	mu       sync.Mutex
+	items    []deliveredKVs
+	size     uint64
+	capacity uint64
+	closed   bool
+
+	// notEmpty and notFull are signaled (without blocking) whenever an item
+	// is pushed or popped respectively.
+	notEmpty chan struct{}
+	notFull  chan struct{}
+}
+
+func newKVQueue(capacity uint64) *kvQueue {
+	return &kvQueue{
+		capacity: capacity,
+		notEmpty: make(chan struct{}, 1),
+		notFull:  make(chan struct{}, 1),
+	}
+}
+
+func signal(ch chan<- struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// tryPush appends the rows to the queue if there is enough space. A row
+// larger than the whole capacity is still accepted when the queue is empty,
+// otherwise it would block forever.
+func (q *kvQueue) tryPush(kvs deliveredKVs) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return true
+	}
+	if len(q.items) != 0 && q.size+kvs.size > q.capacity {
+		return false
+	}
+	q.items = append(q.items, kvs)
+	q.size += kvs.size
+	metric.KVQueueBytesGauge.Add(float64(kvs.size))
+	signal(q.notEmpty)
+	return true
+}
+
+// tryPop removes the first rows from the queue. If the queue is closed, an
+// empty deliveredKVs indicating the end of the chunk is returned.
+func (q *kvQueue) tryPop() (deliveredKVs, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) == 0 {
+		return deliveredKVs{}, q.closed
+	}
+	kvs := q.items[0]
+	q.items[0] = deliveredKVs{}
+	q.items = q.items[1:]
+	q.size -= kvs.size
+	metric.KVQueueBytesGauge.Sub(float64(kvs.size))
+	signal(q.notFull)
+	return kvs, true
+}
+
+// pop removes the first rows from the queue, waiting until there is any.
+func (q *kvQueue) pop(ctx context.Context) (deliveredKVs, error) {
+	for {
+		if kvs, ok := q.tryPop(); ok {
+			return kvs, nil
+		}
+		select {
+		case <-q.notEmpty:
+		case <-ctx.Done():
+			return deliveredKVs{}, ctx.Err()
+		}
+	}
+}
+
+// close discards all rows still in the queue. Afterwards, pushing is a no-op
+// and popping always returns the end of the chunk.
+func (q *kvQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.closed = true
+	metric.KVQueueBytesGauge.Sub(float64(q.size))
+	q.items = nil
+	q.size = 0
+	signal(q.notEmpty)
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/pingcap/tidb-lightning/lightning/metric"
+)
+
+var _ = Suite(&kvQueueSuite{})
+
+type kvQueueSuite struct{}
+
+// queuedBytes reports the total byte size of the rows in all queues.
+func queuedBytes(c *C) float64 {
+	var m dto.Metric
+	c.Assert(metric.KVQueueBytesGauge.Write(&m), IsNil)
+	return m.Gauge.GetValue()
+}
+
+// popAll removes all rows from the queue, which must not be closed.
+func popAll(q *kvQueue) []deliveredKVs {
+	var items []deliveredKVs
+	for {
+		kvs, ok := q.tryPop()
+		if !ok {
+			return items
+		}
+		items = append(items, kvs)
+	}
+}
+
+func (s *kvQueueSuite) TestPushPop(c *C) {
+	q := newKVQueue(100)
+	defer q.close()
+	initialBytes := queuedBytes(c)
+
+	c.Assert(q.tryPush(deliveredKVs{size: 60, rowID: 1}), IsTrue)
+	c.Assert(q.tryPush(deliveredKVs{size: 40, rowID: 2}), IsTrue)
+	c.Assert(q.tryPush(deliveredKVs{size: 1, rowID: 3}), IsFalse)
+	c.Assert(queuedBytes(c)-initialBytes, Equals, 100.0)
+
+	kvs, ok := q.tryPop()
+	c.Assert(ok, IsTrue)
+	c.Assert(kvs.rowID, Equals, int64(1))
+	c.Assert(queuedBytes(c)-initialBytes, Equals, 40.0)
+	c.Assert(q.tryPush(deliveredKVs{size: 1, rowID: 3}), IsTrue)
+
+	kvs, err := q.pop(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(kvs.rowID, Equals, int64(2))
+	kvs, err = q.pop(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(kvs.rowID, Equals, int64(3))
+
+	_, ok = q.tryPop()
+	c.Assert(ok, IsFalse)
+	c.Assert(queuedBytes(c), Equals, initialBytes)
+
+	// a row larger than the capacity is accepted only when the queue is empty.
+	c.Assert(q.tryPush(deliveredKVs{size: 1000, rowID: 4}), IsTrue)
+	c.Assert(q.tryPush(deliveredKVs{size: 0}), IsFalse)
+}
+
+func (s *kvQueueSuite) TestPopWait(c *C) {
+	q := newKVQueue(100)
+	defer q.close()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.tryPush(deliveredKVs{size: 10, rowID: 5})
+	}()
+	kvs, err := q.pop(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(kvs.rowID, Equals, int64(5))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancel()
+	_, err = q.pop(ctx)
+	c.Assert(err, Equals, context.Canceled)
+}
+
+func (s *kvQueueSuite) TestClose(c *C) {
+	q := newKVQueue(100)
+	initialBytes := queuedBytes(c)
+	c.Assert(q.tryPush(deliveredKVs{size: 10, rowID: 6}), IsTrue)
+
+	// the queued rows are discarded.
+	q.close()
+	c.Assert(queuedBytes(c), Equals, initialBytes)
+	kvs, err := q.pop(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(kvs.rowID, Equals, int64(0))
+
+	// the closed queue always returns the end of the chunk.
+	c.Assert(q.tryPush(deliveredKVs{size: 10, rowID: 7}), IsTrue)
+	c.Assert(queuedBytes(c), Equals, initialBytes)
+	kvs, err = q.pop(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(kvs.kvs, IsNil)
+	c.Assert(kvs.rowID, Equals, int64(0))
+}
@@ -0,0 +1,418 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	. "github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// finalizeLeaseName is the pseudo table leased by the instance performing the
+// final steps (compaction, switching back to normal mode and cleaning up the
+// checkpoints) of the distributed import. Real table names are always quoted,
+// so it never collides with them.
+const finalizeLeaseName = "*"
+
+// tableLeaser keeps the leases of the tables restored by this instance in the
+// distributed import. A nil leaser is used when the import is not
+// distributed, for which every table is restored locally.
+type tableLeaser struct {
+	db       TableLeaseDB
+	owner    string
+	importer string
+	duration time.Duration
+	logger   log.Logger
+
+	mu       sync.Mutex
+	held     map[string]*heldLease
+	finished map[string]struct{}
+	// finalizer is whether this instance performs the final steps.
+	finalizer bool
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+type heldLease struct {
+	cancel context.CancelFunc
+	lost   bool
+	// renewed is when the lease was last extended, taken before sending the
+	// request, so the lease is known to be valid until renewed + duration.
+	renewed time.Time
+}
+
+func newTableLeaser(cfg *config.Config, cpdb CheckpointsDB) (*tableLeaser, error) {
+	if !cfg.Checkpoint.Distributed {
+		return nil, nil
+	}
+	db, err := AsTableLeaseDB(cpdb)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	owner := fmt.Sprintf("%s/%d", hostname, cfg.TaskID)
+	var importer string
+	if cfg.TikvImporter.Backend == config.BackendImporter {
+		importer = cfg.TikvImporter.Addr
+	}
+
+	return &tableLeaser{
+		db:       db,
+		owner:    owner,
+		importer: importer,
+		duration: cfg.Checkpoint.LeaseDuration.Duration,
+		logger:   log.With(zap.String("owner", owner)),
+		held:     make(map[string]*heldLease),
+		finished: make(map[string]struct{}),
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}, nil
+}
+
+// interval is the period of renewing the leases and polling for the tables
+// to restore.
+func (l *tableLeaser) interval() time.Duration {
+	return l.duration / 3
+}
+
+// start begins renewing the held leases in the background until stop is
+// called.
+func (l *tableLeaser) start(ctx context.Context) {
+	if l == nil {
+		return
+	}
+	go func() {
+		defer close(l.doneCh)
+		ticker := time.NewTicker(l.interval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-l.stopCh:
+				return
+			case <-ticker.C:
+				l.renew(ctx)
+			}
+		}
+	}()
+}
+
+func (l *tableLeaser) stop() {
+	if l == nil {
+		return
+	}
+	l.stopOnce.Do(func() {
+		close(l.stopCh)
+	})
+}
+
+// expiryMargin is how long before the end of a lease it is considered lost
+// when it cannot be renewed. The next renewal is tried one interval later,
+// by which time the lease may have expired and been taken over already.
+func (l *tableLeaser) expiryMargin() time.Duration {
+	return l.interval()
+}
+
+// renew extends all held leases, and cancels the restoration of the tables
+// whose leases have been lost, or may expire before they could be renewed.
+func (l *tableLeaser) renew(ctx context.Context) {
+	renewTime := time.Now()
+	l.mu.Lock()
+	tableNames := make([]string, 0, len(l.held))
+	for tableName, lease := range l.held {
+		if !lease.lost {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	l.mu.Unlock()
+
+	lost, err := l.db.RenewTableLeases(ctx, l.owner, tableNames, l.duration)
+	if err != nil {
+		// the leases are tried again in the next round, unless they could
+		// expire in the mean time, in which case other instances may take
+		// over the tables while this instance is still writing them.
+		l.logger.Warn("failed to renew table leases", log.ShortError(err))
+		l.expire(time.Now())
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, tableName := range tableNames {
+		if lease, ok := l.held[tableName]; ok && lease.renewed.Before(renewTime) {
+			lease.renewed = renewTime
+		}
+	}
+	for _, tableName := range lost {
+		if lease, ok := l.held[tableName]; ok && !lease.lost {
+			l.logger.Warn("table lease lost, leaving the table to other instances", zap.String("table", tableName))
+			lease.lost = true
+			lease.cancel()
+		}
+	}
+}
+
+// expire cancels the restoration of the tables whose leases have not been
+// renewed for too long.
+func (l *tableLeaser) expire(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for tableName, lease := range l.held {
+		if !lease.lost && now.Sub(lease.renewed) >= l.duration-l.expiryMargin() {
+			l.logger.Warn("table lease not renewed in time, leaving the table to other instances",
+				zap.String("table", tableName), zap.Time("lastRenewed", lease.renewed))
+			lease.lost = true
+			lease.cancel()
+		}
+	}
+}
+
+// acquire claims the table. If the lease is acquired, it returns a context
+// which is canceled when the lease is lost, and the previous lease of the
+// table. Otherwise the returned context is nil.
+func (l *tableLeaser) acquire(ctx context.Context, tableName string) (context.Context, *TableLease, error) {
+	lease := &TableLease{TableName: tableName, Owner: l.owner, Importer: l.importer}
+	acquireTime := time.Now()
+	acquired, previous, err := l.db.AcquireTableLease(ctx, lease, l.duration)
+	if err != nil || !acquired {
+		return nil, previous, errors.Trace(err)
+	}
+
+	tableCtx, cancel := context.WithCancel(ctx)
+	l.mu.Lock()
+	l.held[tableName] = &heldLease{cancel: cancel, renewed: acquireTime}
+	l.mu.Unlock()
+	l.logger.Info("table lease acquired", zap.String("table", tableName))
+	return tableCtx, previous, nil
+}
+
+// release stops renewing the lease of the table after restoring it. The lease
+// is left to expire, so that the checkpoints still being saved are not
+// overwritten by another instance. It returns whether the lease had been lost
+// before, in which case the result of the restoration should be discarded.
+func (l *tableLeaser) release(tableName string, err error) bool {
+	if l == nil {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lease, ok := l.held[tableName]
+	if !ok {
+		return false
+	}
+	delete(l.held, tableName)
+	lease.cancel()
+	if lease.lost {
+		return true
+	}
+	if err == nil {
+		l.finished[tableName] = struct{}{}
+	}
+	return false
+}
+
+// isRunning returns whether the table is being restored by this instance.
+func (l *tableLeaser) isRunning(tableName string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.held[tableName]
+	return ok
+}
+
+// isFinished returns whether the table has been restored by this instance.
+// The checkpoint of the table may not have been saved yet.
+func (l *tableLeaser) isFinished(tableName string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.finished[tableName]
+	return ok
+}
+
+// numRunning returns the number of tables being restored by this instance.
+func (l *tableLeaser) numRunning() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.held)
+}
+
+// acquireFinalizer races with the other instances for performing the final
+// steps after all tables have been restored.
+func (l *tableLeaser) acquireFinalizer(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	tableCtx, previous, err := l.acquire(ctx, finalizeLeaseName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if tableCtx == nil {
+		l.logger.Info("the final steps are performed by another instance", zap.String("finalizer", previous.Owner))
+		return nil
+	}
+	l.mu.Lock()
+	l.finalizer = true
+	l.mu.Unlock()
+	return nil
+}
+
+// canFinalize returns whether this instance should perform the final steps.
+func (l *tableLeaser) canFinalize() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.finalizer
+}
+
+// waitForOthers waits until the other instances have noticed that all tables
+// are restored, before the checkpoints (and the leases) are removed.
+func (l *tableLeaser) waitForOthers(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.logger.Info("waiting for the other instances to finish", zap.Duration("duration", l.duration))
+	select {
+	case <-time.After(l.duration):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	l.stop()
+	<-l.doneCh
+	return nil
+}
+
+// needsRewind returns whether the engines of a table taken over from another
+// instance must be restored from the beginning. The engines not yet imported
+// are kept by the importer of the previous owner, and cannot be resumed from
+// a different importer. Since the index engine collects the KVs of every
+// chunk, the whole table is restored again unless the index has been
+// imported.
+func (l *tableLeaser) needsRewind(previous *TableLease, cp *TableCheckpoint) bool {
+	if previous == nil || previous.Owner == l.owner || previous.Importer == l.importer {
+		return false
+	}
+	if len(cp.Engines) == 0 {
+		return false
+	}
+	status := cp.Status
+	if status <= CheckpointStatusMaxInvalid {
+		status *= 10
+	}
+	return status < CheckpointStatusIndexImported
+}
+
+// dispatchLeasedTables repeatedly scans the tables to restore, and dispatches
+// those whose leases are acquired, until every table has been restored by
+// some instance. After a table fails, no more tables are dispatched, and it
+// returns once the running tables are finished.
+func (rc *RestoreController) dispatchLeasedTables(
+	ctx context.Context,
+	dispatch func(tableCtx context.Context, tableName string, cp *TableCheckpoint) error,
+	failed func() bool,
+) error {
+	l := rc.leaser
+	ticker := time.NewTicker(l.interval())
+	defer ticker.Stop()
+
+	for {
+		allDone := true
+		for _, dbMeta := range rc.dbMetas {
+			dbInfo := rc.dbInfos[dbMeta.Name]
+			for _, tableMeta := range dbMeta.Tables {
+				tableInfo := dbInfo.Tables[tableMeta.Name]
+				tableName := common.UniqueTable(dbInfo.Name, tableInfo.Name)
+				if l.isFinished(tableName) {
+					continue
+				}
+				if l.isRunning(tableName) {
+					allDone = false
+					continue
+				}
+
+				cp, err := rc.checkpointsDB.Get(ctx, tableName)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				if cp.Status >= CheckpointStatusAnalyzeSkipped {
+					continue
+				}
+				allDone = false
+				if failed() {
+					continue
+				}
+				if cp.Status <= CheckpointStatusMaxInvalid {
+					return errors.Errorf("failed to restore table %s by another instance; please resolve the error first", tableName)
+				}
+				// only claim the tables which can be restored right away, and
+				// leave the rest to the other instances.
+				if l.numRunning() >= rc.cfg.App.IndexConcurrency {
+					continue
+				}
+
+				tableCtx, previous, err := l.acquire(ctx, tableName)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				if tableCtx == nil {
+					continue
+				}
+				if l.needsRewind(previous, cp) {
+					l.logger.Warn("table taken over from another importer, restoring it from the beginning",
+						zap.String("table", tableName), zap.String("previousOwner", previous.Owner), zap.String("previousImporter", previous.Importer))
+					if err := rc.checkpointsDB.ResetEngineCheckpoints(ctx, tableName, RewindTable(cp)); err != nil {
+						l.release(tableName, err)
+						return errors.Trace(err)
+					}
+					if cp, err = rc.checkpointsDB.Get(ctx, tableName); err != nil {
+						l.release(tableName, err)
+						return errors.Trace(err)
+					}
+				}
+				if err := dispatch(tableCtx, tableName, cp); err != nil {
+					l.release(tableName, err)
+					return errors.Trace(err)
+				}
+			}
+		}
+
+		if allDone || (failed() && l.numRunning() == 0) {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+
+	. "github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+var _ = Suite(&leaseSuite{})
+
+type leaseSuite struct{}
+
+// memoryLeaseDB is a checkpoints database keeping the table leases in memory,
+// where a lease never expires unless it is removed from `leases`.
+type memoryLeaseDB struct {
+	NullCheckpointsDB
+	leases map[string]TableLease
+	// renewErr is returned by RenewTableLeases if not nil.
+	renewErr error
+}
+
+func (db *memoryLeaseDB) AcquireTableLease(_ context.Context, lease *TableLease, _ time.Duration) (bool, *TableLease, error) {
+	prev, ok := db.leases[lease.TableName]
+	if ok && prev.Owner != lease.Owner {
+		return false, &prev, nil
+	}
+	db.leases[lease.TableName] = *lease
+	if !ok {
+		return true, nil, nil
+	}
+	return true, &prev, nil
+}
+
+func (db *memoryLeaseDB) RenewTableLeases(_ context.Context, owner string, tableNames []string, _ time.Duration) ([]string, error) {
+	if db.renewErr != nil {
+		return nil, db.renewErr
+	}
+	var lost []string
+	for _, tableName := range tableNames {
+		if lease, ok := db.leases[tableName]; !ok || lease.Owner != owner {
+			lost = append(lost, tableName)
+		}
+	}
+	return lost, nil
+}
+
+func (s *leaseSuite) newLeaser(c *C, db CheckpointsDB) *tableLeaser {
+	cfg := config.NewConfig()
+	cfg.TaskID = 1234
+	cfg.Checkpoint.Distributed = true
+	cfg.TikvImporter.Addr = "127.0.0.1:8287"
+	leaser, err := newTableLeaser(cfg, db)
+	c.Assert(err, IsNil)
+	c.Assert(leaser, NotNil)
+	return leaser
+}
+
+func (s *leaseSuite) TestNewTableLeaser(c *C) {
+	cfg := config.NewConfig()
+	leaser, err := newTableLeaser(cfg, NewNullCheckpointsDB())
+	c.Assert(err, IsNil)
+	c.Assert(leaser, IsNil)
+	c.Assert(leaser.canFinalize(), IsTrue)
+	c.Assert(leaser.release("`db`.`t`", nil), IsFalse)
+	c.Assert(leaser.acquireFinalizer(context.Background()), IsNil)
+
+	cfg.Checkpoint.Distributed = true
+	_, err = newTableLeaser(cfg, NewNullCheckpointsDB())
+	c.Assert(err, ErrorMatches, "the distributed import is only supported by the \"mysql\" checkpoint driver")
+
+	leaser = s.newLeaser(c, &memoryLeaseDB{})
+	c.Assert(leaser.owner, Matches, ".+/1234")
+	c.Assert(leaser.importer, Equals, "127.0.0.1:8287")
+	c.Assert(leaser.interval(), Equals, 10*time.Second)
+}
+
+func (s *leaseSuite) TestAcquireAndRenew(c *C) {
+	ctx := context.Background()
+	db := &memoryLeaseDB{leases: map[string]TableLease{
+		"`db`.`t3`": {TableName: "`db`.`t3`", Owner: "other/5678"},
+	}}
+	leaser := s.newLeaser(c, db)
+
+	ctx1, previous, err := leaser.acquire(ctx, "`db`.`t1`")
+	c.Assert(err, IsNil)
+	c.Assert(ctx1, NotNil)
+	c.Assert(previous, IsNil)
+	ctx2, _, err := leaser.acquire(ctx, "`db`.`t2`")
+	c.Assert(err, IsNil)
+	c.Assert(ctx2, NotNil)
+	ctx3, previous, err := leaser.acquire(ctx, "`db`.`t3`")
+	c.Assert(err, IsNil)
+	c.Assert(ctx3, IsNil)
+	c.Assert(previous.Owner, Equals, "other/5678")
+	c.Assert(leaser.numRunning(), Equals, 2)
+
+	// the lease of t2 is taken over by another instance.
+	db.leases["`db`.`t2`"] = TableLease{TableName: "`db`.`t2`", Owner: "other/5678"}
+	leaser.renew(ctx)
+	c.Assert(ctx1.Err(), IsNil)
+	c.Assert(ctx2.Err(), Equals, context.Canceled)
+
+	c.Assert(leaser.release("`db`.`t1`", nil), IsFalse)
+	c.Assert(leaser.isFinished("`db`.`t1`"), IsTrue)
+	c.Assert(leaser.release("`db`.`t2`", context.Canceled), IsTrue)
+	c.Assert(leaser.isFinished("`db`.`t2`"), IsFalse)
+	c.Assert(leaser.isRunning("`db`.`t2`"), IsFalse)
+	c.Assert(leaser.numRunning(), Equals, 0)
+
+	c.Assert(leaser.canFinalize(), IsFalse)
+	c.Assert(leaser.acquireFinalizer(ctx), IsNil)
+	c.Assert(leaser.canFinalize(), IsTrue)
+
+	// only one instance performs the final steps.
+	other := s.newLeaser(c, db)
+	other.owner = "other/5678"
+	c.Assert(other.acquireFinalizer(ctx), IsNil)
+	c.Assert(other.canFinalize(), IsFalse)
+}
+
+func (s *leaseSuite) TestRenewFailed(c *C) {
+	ctx := context.Background()
+	db := &memoryLeaseDB{leases: map[string]TableLease{}}
+	leaser := s.newLeaser(c, db)
+
+	ctx1, _, err := leaser.acquire(ctx, "`db`.`t1`")
+	c.Assert(err, IsNil)
+	ctx2, _, err := leaser.acquire(ctx, "`db`.`t2`")
+	c.Assert(err, IsNil)
+
+	// a successful renewal extends the leases.
+	leaser.mu.Lock()
+	leaser.held["`db`.`t1`"].renewed = time.Now().Add(-time.Minute)
+	leaser.mu.Unlock()
+	leaser.renew(ctx)
+	c.Assert(ctx1.Err(), IsNil)
+	leaser.mu.Lock()
+	c.Assert(time.Since(leaser.held["`db`.`t1`"].renewed) < time.Minute, IsTrue)
+	leaser.mu.Unlock()
+
+	// the leases are kept while they are far from expiring.
+	db.renewErr = errors.New("connection refused")
+	leaser.renew(ctx)
+	c.Assert(ctx1.Err(), IsNil)
+	c.Assert(ctx2.Err(), IsNil)
+
+	// t1 could expire before the next renewal, so it is given up.
+	leaser.mu.Lock()
+	leaser.held["`db`.`t1`"].renewed = time.Now().Add(-leaser.duration + leaser.expiryMargin())
+	leaser.mu.Unlock()
+	leaser.renew(ctx)
+	c.Assert(ctx1.Err(), Equals, context.Canceled)
+	c.Assert(ctx2.Err(), IsNil)
+	c.Assert(leaser.release("`db`.`t1`", context.Canceled), IsTrue)
+	c.Assert(leaser.isFinished("`db`.`t1`"), IsFalse)
+
+	// t2 is given up once its whole duration has passed too.
+	leaser.expire(time.Now().Add(leaser.duration))
+	c.Assert(ctx2.Err(), Equals, context.Canceled)
+	c.Assert(leaser.release("`db`.`t2`", context.Canceled), IsTrue)
+	c.Assert(leaser.numRunning(), Equals, 0)
+}
+
+func (s *leaseSuite) TestNeedsRewind(c *C) {
+	leaser := s.newLeaser(c, &memoryLeaseDB{})
+	cp := &TableCheckpoint{
+		Status:  CheckpointStatusAllWritten,
+		Engines: map[int32]*EngineCheckpoint{-1: {Status: CheckpointStatusLoaded}},
+	}
+	otherImporter := &TableLease{Owner: "other/5678", Importer: "127.0.0.2:8287"}
+	sameImporter := &TableLease{Owner: "other/5678", Importer: "127.0.0.1:8287"}
+
+	c.Assert(leaser.needsRewind(nil, cp), IsFalse)
+	c.Assert(leaser.needsRewind(sameImporter, cp), IsFalse)
+	c.Assert(leaser.needsRewind(otherImporter, cp), IsTrue)
+	c.Assert(leaser.needsRewind(otherImporter, &TableCheckpoint{Status: CheckpointStatusLoaded}), IsFalse)
+
+	cp.Status = CheckpointStatusIndexImported
+	c.Assert(leaser.needsRewind(otherImporter, cp), IsFalse)
+	cp.Status = CheckpointStatusChecksummed / 10
+	c.Assert(leaser.needsRewind(otherImporter, cp), IsFalse)
+	cp.Status = CheckpointStatusClosed / 10
+	c.Assert(leaser.needsRewind(otherImporter, cp), IsTrue)
+}
@@ -0,0 +1,178 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"unicode"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+// columnMasker transforms a source value in place.
+type columnMasker func(datum *types.Datum) error
+
+// maskedColumn is a target column whose source values should be masked.
+type maskedColumn struct {
+	// offset is the index of the column in the table.
+	offset int
+	mask   columnMasker
+}
+
+func newColumnMasker(mask *config.ColumnMask) (columnMasker, error) {
+	salt := []byte(mask.Salt)
+	switch mask.Type {
+	case config.MaskHash:
+		return stringMasker(func(value string) string {
+			mac := hmac.New(sha256.New, salt)
+			mac.Write([]byte(value))
+			res := hex.EncodeToString(mac.Sum(nil))
+			if mask.Length > 0 && mask.Length < len(res) {
+				res = res[:mask.Length]
+			}
+			return res
+		}), nil
+	case config.MaskPseudonym:
+		return stringMasker(func(value string) string {
+			return pseudonymize(salt, value, value, true)
+		}), nil
+	case config.MaskEmail:
+		return stringMasker(func(value string) string {
+			at := strings.LastIndexByte(value, '@')
+			if at < 0 {
+				return pseudonymize(salt, value, value, true)
+			}
+			return pseudonymize(salt, value, value[:at], true) + value[at:]
+		}), nil
+	case config.MaskPhone:
+		return stringMasker(func(value string) string {
+			return pseudonymize(salt, value, value, false)
+		}), nil
+	case config.MaskTruncate:
+		return stringMasker(func(value string) string {
+			i := 0
+			for n := range value {
+				if i == mask.Length {
+					return value[:n]
+				}
+				i++
+			}
+			return value
+		}), nil
+	case config.MaskNullify:
+		return func(datum *types.Datum) error {
+			*datum = types.NewDatum(nil)
+			return nil
+		}, nil
+	case config.MaskConstant:
+		return func(datum *types.Datum) error {
+			*datum = types.NewStringDatum(mask.Value)
+			return nil
+		}, nil
+	default:
+		return nil, errors.Errorf("unsupported mask type (%s)", mask.Type)
+	}
+}
+
+// stringMasker creates a columnMasker transforming the string form of the
+// values. NULL is kept unchanged.
+func stringMasker(transform func(string) string) columnMasker {
+	return func(datum *types.Datum) error {
+		if datum.IsNull() {
+			return nil
+		}
+		value, err := datum.ToString()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		*datum = types.NewStringDatum(transform(value))
+		return nil
+	}
+}
+
+// keyStream generates the pseudo-random bytes determined by the salt and the
+// source value.
+type keyStream struct {
+	salt    []byte
+	value   string
+	counter uint64
+	block   []byte
+}
+
+func (ks *keyStream) next() byte {
+	if len(ks.block) == 0 {
+		mac := hmac.New(sha256.New, ks.salt)
+		var counter [8]byte
+		binary.BigEndian.PutUint64(counter[:], ks.counter)
+		mac.Write(counter[:])
+		mac.Write([]byte(ks.value))
+		ks.block = mac.Sum(nil)
+		ks.counter++
+	}
+	b := ks.block[0]
+	ks.block = ks.block[1:]
+	return b
+}
+
+// pseudonymize replaces every digit of `part` by a pseudo-random digit, and
+// every letter (if `letters` is true) by a pseudo-random letter of the same
+// case, keeping other characters. Non-ASCII letters are replaced by lower-case
+// ASCII letters. The replacements are determined by the salt and the whole
+// source value.
+func pseudonymize(salt []byte, value string, part string, letters bool) string {
+	ks := keyStream{salt: salt, value: value}
+	var sb strings.Builder
+	sb.Grow(len(part))
+	for _, r := range part {
+		switch {
+		case '0' <= r && r <= '9':
+			sb.WriteByte('0' + ks.next()%10)
+		case !letters:
+			sb.WriteRune(r)
+		case 'A' <= r && r <= 'Z':
+			sb.WriteByte('A' + ks.next()%26)
+		case unicode.IsLetter(r):
+			sb.WriteByte('a' + ks.next()%26)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// maskRow masks the source values of the row in place, so the rejected rows
+// are masked as well. The column permutation must have been initialized.
+func (tr *TableRestore) maskRow(row []types.Datum, columnPermutation []int) error {
+	shift := len(tr.constants)
+	for _, col := range tr.maskedColumns {
+		if col.offset >= len(columnPermutation) {
+			continue
+		}
+		i := columnPermutation[col.offset] - shift
+		if i < 0 || i >= len(row) {
+			continue
+		}
+		if err := col.mask(&row[i]); err != nil {
+			return errors.Annotatef(err, "failed to mask column `%s`", tr.tableInfo.Core.Columns[col.offset].Name.O)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"regexp"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/types"
+
+	. "github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+var _ = Suite(&maskSuite{})
+
+type maskSuite struct{}
+
+func (s *maskSuite) mask(c *C, mask *config.ColumnMask, value interface{}) *types.Datum {
+	masker, err := newColumnMasker(mask)
+	c.Assert(err, IsNil)
+	datum := types.NewDatum(value)
+	c.Assert(masker(&datum), IsNil)
+	return &datum
+}
+
+func (s *maskSuite) TestHash(c *C) {
+	mask := &config.ColumnMask{Type: config.MaskHash, Salt: "pepper"}
+	res := s.mask(c, mask, "alice")
+	c.Assert(res.GetString(), Matches, "[0-9a-f]{64}")
+
+	// deterministic, and depends on the salt.
+	c.Assert(s.mask(c, mask, "alice"), DeepEquals, res)
+	c.Assert(s.mask(c, mask, "bob").GetString(), Not(Equals), res.GetString())
+	c.Assert(s.mask(c, &config.ColumnMask{Type: config.MaskHash}, "alice").GetString(), Not(Equals), res.GetString())
+
+	// numbers are hashed by their string form.
+	c.Assert(s.mask(c, mask, 12345), DeepEquals, s.mask(c, mask, "12345"))
+
+	mask.Length = 10
+	c.Assert(s.mask(c, mask, "alice").GetString(), Equals, res.GetString()[:10])
+
+	// NULL is kept.
+	c.Assert(s.mask(c, mask, nil).IsNull(), IsTrue)
+}
+
+func (s *maskSuite) TestPseudonym(c *C) {
+	mask := &config.ColumnMask{Type: config.MaskPseudonym, Salt: "pepper"}
+	res := s.mask(c, mask, "Alice Smith-42").GetString()
+	c.Assert(res, Matches, "[A-Z][a-z]{4} [A-Z][a-z]{4}-[0-9]{2}")
+	c.Assert(res, Not(Equals), "Alice Smith-42")
+	c.Assert(s.mask(c, mask, "Alice Smith-42").GetString(), Equals, res)
+
+	// non-ASCII letters are replaced too.
+	c.Assert(s.mask(c, mask, "张三").GetString(), Matches, "[a-z]{2}")
+}
+
+func (s *maskSuite) TestEmailAndPhone(c *C) {
+	email := &config.ColumnMask{Type: config.MaskEmail, Salt: "pepper"}
+	res := s.mask(c, email, "john.doe99@example.com").GetString()
+	c.Assert(res, Matches, "[a-z]{4}\\.[a-z]{3}[0-9]{2}"+regexp.QuoteMeta("@example.com"))
+	c.Assert(res, Not(Equals), "john.doe99@example.com")
+	c.Assert(s.mask(c, email, "john.doe99@example.com").GetString(), Equals, res)
+
+	phone := &config.ColumnMask{Type: config.MaskPhone, Salt: "pepper"}
+	res = s.mask(c, phone, "+1 (555) 010-9999 ext 12").GetString()
+	c.Assert(res, Matches, "\\+[0-9] \\([0-9]{3}\\) [0-9]{3}-[0-9]{4} ext [0-9]{2}")
+	c.Assert(res, Not(Equals), "+1 (555) 010-9999 ext 12")
+}
+
+func (s *maskSuite) TestTruncateNullifyConstant(c *C) {
+	truncate := &config.ColumnMask{Type: config.MaskTruncate, Length: 3}
+	c.Assert(s.mask(c, truncate, "甲乙丙丁").GetString(), Equals, "甲乙丙")
+	c.Assert(s.mask(c, truncate, "ab").GetString(), Equals, "ab")
+
+	c.Assert(s.mask(c, &config.ColumnMask{Type: config.MaskNullify}, "secret").IsNull(), IsTrue)
+
+	constant := &config.ColumnMask{Type: config.MaskConstant, Value: "redacted"}
+	c.Assert(s.mask(c, constant, "secret").GetString(), Equals, "redacted")
+	c.Assert(s.mask(c, constant, nil).GetString(), Equals, "redacted")
+}
+
+func (s *tableRestoreSuite) TestMaskRow(c *C) {
+	err := s.tr.applyRule(&config.TableRule{
+		ConstantColumns: map[string]string{"a": "1"},
+		Masks: map[string]*config.ColumnMask{
+			"b": {Type: config.MaskConstant, Value: "0"},
+			"c": {Type: config.MaskNullify},
+		},
+	})
+	c.Assert(err, IsNil)
+	defer s.tr.applyRule(nil)
+
+	ccp := &ChunkCheckpoint{}
+	s.tr.initializeColumns([]string{"c", "b"}, ccp)
+	row := types.MakeDatums(3, 2)
+	c.Assert(s.tr.maskRow(row, ccp.ColumnPermutation), IsNil)
+	c.Assert(row, DeepEquals, []types.Datum{{}, types.NewStringDatum("0")})
+
+	err = s.tr.applyRule(&config.TableRule{
+		Masks: map[string]*config.ColumnMask{"z": {Type: config.MaskNullify}},
+	})
+	c.Assert(err, ErrorMatches, "masked column `z` of table `db`.`table` does not exist")
+}
+
+func (s *tableRestoreSuite) TestResolveMasks(c *C) {
+	tr := &TableRestore{tableName: "`db`.`t`", logger: s.tr.logger}
+	tr.rule = &config.TableRule{
+		Masks: map[string]*config.ColumnMask{"b": {Type: config.MaskHash, Salt: "pepper"}},
+	}
+	fingerprint := config.MaskFingerprint(tr.rule.Masks)
+	c.Assert(fingerprint, Not(Matches), ".*pepper.*")
+
+	// a new table records the masks.
+	cp := &TableCheckpoint{}
+	c.Assert(tr.resolveMasks(cp), IsNil)
+	c.Assert(cp.Masks, Equals, fingerprint)
+
+	// a partially imported table cannot change the masks.
+	cp.Engines = map[int32]*EngineCheckpoint{0: {}}
+	c.Assert(tr.resolveMasks(cp), IsNil)
+	tr.rule.Masks["b"].Salt = "salt"
+	err := tr.resolveMasks(cp)
+	c.Assert(err, ErrorMatches, "table `db`.`t` was partially imported with different column masks.*")
+
+	// ... unless all rows have been written.
+	cp.Status = CheckpointStatusAllWritten
+	c.Assert(tr.resolveMasks(cp), IsNil)
+	c.Assert(cp.Masks, Equals, fingerprint)
+}
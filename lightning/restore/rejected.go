@@ -0,0 +1,431 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+	"go.uber.org/zap"
+
+	. "github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/metric"
+)
+
+// rejectedRows records the rows which failed to be encoded, so that a small
+// amount of dirty data can be skipped instead of aborting the whole table.
+//
+// For every data file containing rejected rows, two files are created inside
+// the `mydumper.rejected-rows-dir`:
+//
+//   - "<name>.rejected.sql" or "<name>.rejected.csv", containing the rejected
+//     rows in the same format as the source file, and
+//   - "<name>.rejected.log", containing the offset and reason of every row.
+//
+// where "<name>" is the path of the data file relative to the
+// `mydumper.data-source-dir` without the extension, so that data files of the
+// same name in different subdirectories do not share the rejected rows files.
+//
+// The files are kept across runs. When a table is resumed from the
+// checkpoints, the rows recorded before the checkpointed offset of each chunk
+// count towards `mydumper.max-encode-errors` again, and the rows rejected again
+// after re-reading a chunk are not recorded twice.
+//
+// A nil *rejectedRows is valid and tolerates no errors at all.
+type rejectedRows struct {
+	dir       string
+	sourceDir string
+	maxErrors int64
+	csv       *config.CSVConfig
+	total     int64 // accessed atomically
+
+	lock     sync.Mutex
+	files    map[string]*rejectedRowsFile
+	perTable map[string]int64
+	resumed  map[string]struct{}
+}
+
+type rejectedRowsFile struct {
+	data    *os.File
+	reasons *os.File
+	isCSV   bool
+	hasRows bool
+	// recorded is the set of offsets of the rows already in the files.
+	recorded map[int64]struct{}
+}
+
+func newRejectedRows(cfg *config.Config) *rejectedRows {
+	return &rejectedRows{
+		dir:       cfg.Mydumper.RejectedRowsDir,
+		sourceDir: cfg.Mydumper.SourceDir,
+		maxErrors: cfg.Mydumper.MaxEncodeErrors,
+		csv:       &cfg.Mydumper.CSV,
+		files:     make(map[string]*rejectedRowsFile),
+		perTable:  make(map[string]int64),
+		resumed:   make(map[string]struct{}),
+	}
+}
+
+// resume counts the rows recorded by previous runs before the checkpointed
+// offsets of the chunks of the table, which will not be read again.
+func (rr *rejectedRows) resume(t *TableRestore, cp *TableCheckpoint) error {
+	if rr == nil || rr.maxErrors <= 0 {
+		return nil
+	}
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+
+	if _, ok := rr.resumed[t.tableName]; ok {
+		return nil
+	}
+	rr.resumed[t.tableName] = struct{}{}
+
+	var count int64
+	for _, engine := range cp.Engines {
+		for _, chunk := range engine.Chunks {
+			if _, err := os.Stat(rr.filePrefix(chunk.Key.Path) + ".log"); os.IsNotExist(err) {
+				continue
+			}
+			file, err := rr.openFile(chunk.Key.Path)
+			if err != nil {
+				return errors.Annotate(err, "cannot load rejected rows")
+			}
+			for offset := range file.recorded {
+				if offset >= chunk.Key.Offset && offset < chunk.Chunk.Offset {
+					count++
+				}
+			}
+		}
+	}
+	if count > 0 {
+		atomic.AddInt64(&rr.total, count)
+		rr.perTable[t.tableName] += count
+		t.logger.Info("resumed rejected rows", zap.Int64("count", count))
+	}
+	return nil
+}
+
+// reset removes the files left by previous runs for a table which is
+// restored from scratch.
+func (rr *rejectedRows) reset(t *TableRestore, cp *TableCheckpoint) error {
+	if rr == nil || rr.maxErrors <= 0 {
+		return nil
+	}
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+
+	rr.resumed[t.tableName] = struct{}{}
+	for _, engine := range cp.Engines {
+		for _, chunk := range engine.Chunks {
+			if file, ok := rr.files[chunk.Key.Path]; ok {
+				file.data.Close()
+				file.reasons.Close()
+				delete(rr.files, chunk.Key.Path)
+			}
+			prefix := rr.filePrefix(chunk.Key.Path)
+			dataPath, _ := rr.dataFilePath(chunk.Key.Path)
+			for _, path := range []string{dataPath, prefix + ".log"} {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					return errors.Annotate(err, "cannot remove rejected rows")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// reject tries to skip a row which failed to be encoded. If the number of
+// rejected rows of the whole task exceeds `mydumper.max-encode-errors`, the
+// encode error is returned and the table should fail.
+func (rr *rejectedRows) reject(
+	t *TableRestore,
+	key *ChunkCheckpointKey,
+	columns []string,
+	row []types.Datum,
+	offset int64,
+	encodeErr error,
+) error {
+	if rr == nil || rr.maxErrors <= 0 {
+		return encodeErr
+	}
+
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+
+	if atomic.AddInt64(&rr.total, 1) > rr.maxErrors {
+		return errors.Annotatef(encodeErr, "too many rows failed to be encoded (max-encode-errors = %d)", rr.maxErrors)
+	}
+	metric.RejectedRowsCounter.Inc()
+	rr.perTable[t.tableName]++
+
+	file, err := rr.openFile(key.Path)
+	if err != nil {
+		return errors.Annotate(err, "cannot record rejected row")
+	}
+	if _, ok := file.recorded[offset]; ok {
+		// the row was recorded before the chunk is resumed.
+		return nil
+	}
+
+	w := bufio.NewWriter(file.data)
+	if file.isCSV {
+		if !file.hasRows && rr.csv.Header && len(columns) > 0 {
+			rr.writeCSVHeader(w, columns)
+		}
+		err = rr.writeCSVRow(w, row)
+	} else {
+		err = writeSQLRow(w, t.tableMeta.Name, columns, row)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return errors.Annotate(err, "cannot record rejected row")
+	}
+	file.hasRows = true
+
+	reason := strings.Replace(encodeErr.Error(), "\n", " ", -1)
+	if _, err := fmt.Fprintf(file.reasons, "%d\t%s\n", offset, reason); err != nil {
+		return errors.Annotate(err, "cannot record rejected row")
+	}
+	file.recorded[offset] = struct{}{}
+	return nil
+}
+
+// filePrefix returns the path of the files recording the rejected rows of the
+// data file, without the extension.
+func (rr *rejectedRows) filePrefix(dataPath string) string {
+	name, err := filepath.Rel(rr.sourceDir, dataPath)
+	if err != nil || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		name = filepath.Base(dataPath)
+	}
+	return filepath.Join(rr.dir, strings.TrimSuffix(name, path.Ext(name))+".rejected")
+}
+
+// dataFilePath returns the path of the file containing the rejected rows of
+// the data file, and whether it is a CSV file.
+func (rr *rejectedRows) dataFilePath(dataPath string) (string, bool) {
+	ext := path.Ext(dataPath)
+	isCSV := strings.ToLower(ext) == ".csv"
+	if !isCSV {
+		ext = ".sql"
+	}
+	return rr.filePrefix(dataPath) + ext, isCSV
+}
+
+// loadRecordedOffsets reads the offsets of the rows from a reasons file.
+func loadRecordedOffsets(reasonsPath string) (map[int64]struct{}, error) {
+	recorded := make(map[int64]struct{})
+	content, err := ioutil.ReadFile(reasonsPath)
+	if os.IsNotExist(err) {
+		return recorded, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		i := strings.IndexByte(line, '\t')
+		if i < 0 {
+			// an incomplete line written when the previous run was killed.
+			continue
+		}
+		offset, err := strconv.ParseInt(line[:i], 10, 64)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid rejected row in %s", reasonsPath)
+		}
+		recorded[offset] = struct{}{}
+	}
+	return recorded, nil
+}
+
+func (rr *rejectedRows) openFile(dataPath string) (*rejectedRowsFile, error) {
+	if file, ok := rr.files[dataPath]; ok {
+		return file, nil
+	}
+
+	prefix := rr.filePrefix(dataPath)
+	if err := os.MkdirAll(filepath.Dir(prefix), 0755); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	recorded, err := loadRecordedOffsets(prefix + ".log")
+	if err != nil {
+		return nil, err
+	}
+
+	dataFilePath, isCSV := rr.dataFilePath(dataPath)
+	const flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	data, err := os.OpenFile(dataFilePath, flags, 0644)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	reasons, err := os.OpenFile(prefix+".log", flags, 0644)
+	if err != nil {
+		data.Close()
+		return nil, errors.Trace(err)
+	}
+	stat, err := data.Stat()
+	if err != nil {
+		data.Close()
+		reasons.Close()
+		return nil, errors.Trace(err)
+	}
+
+	file := &rejectedRowsFile{data: data, reasons: reasons, isCSV: isCSV, hasRows: stat.Size() > 0, recorded: recorded}
+	rr.files[dataPath] = file
+	return file, nil
+}
+
+func (rr *rejectedRows) writeCSVHeader(w *bufio.Writer, columns []string) {
+	for i, column := range columns {
+		if i != 0 {
+			w.WriteString(rr.csv.Separator)
+		}
+		rr.writeCSVField(w, column)
+	}
+	w.WriteByte('\n')
+}
+
+func (rr *rejectedRows) writeCSVRow(w *bufio.Writer, row []types.Datum) error {
+	for i := range row {
+		if i != 0 {
+			w.WriteString(rr.csv.Separator)
+		}
+		if row[i].IsNull() {
+			w.WriteString(rr.csv.Null)
+			continue
+		}
+		value, err := row[i].ToString()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		rr.writeCSVField(w, value)
+	}
+	w.WriteByte('\n')
+	return nil
+}
+
+func (rr *rejectedRows) writeCSVField(w *bufio.Writer, value string) {
+	delim := rr.csv.Delimiter
+	if rr.csv.BackslashEscape {
+		value = strings.Replace(value, `\`, `\\`, -1)
+	}
+	if len(delim) > 0 {
+		value = delim + strings.Replace(value, delim, delim+delim, -1) + delim
+	}
+	w.WriteString(value)
+}
+
+func writeSQLRow(w *bufio.Writer, tableName string, columns []string, row []types.Datum) error {
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	common.WriteMySQLIdentifier(&sb, tableName)
+	if len(columns) > 0 {
+		sb.WriteString(" (")
+		for i, column := range columns {
+			if i != 0 {
+				sb.WriteByte(',')
+			}
+			common.WriteMySQLIdentifier(&sb, column)
+		}
+		sb.WriteByte(')')
+	}
+	sb.WriteString(" VALUES (")
+	for i := range row {
+		if i != 0 {
+			sb.WriteByte(',')
+		}
+		datum := &row[i]
+		switch datum.Kind() {
+		case types.KindNull:
+			sb.WriteString("NULL")
+		case types.KindInt64:
+			sb.WriteString(strconv.FormatInt(datum.GetInt64(), 10))
+		case types.KindUint64:
+			sb.WriteString(strconv.FormatUint(datum.GetUint64(), 10))
+		case types.KindBinaryLiteral, types.KindMysqlBit:
+			sb.WriteString("0x")
+			sb.WriteString(hex.EncodeToString(datum.GetBinaryLiteral()))
+		default:
+			value, err := datum.ToString()
+			if err != nil {
+				return errors.Trace(err)
+			}
+			sb.WriteByte('\'')
+			sb.WriteString(strings.NewReplacer(`\`, `\\`, `'`, `''`).Replace(value))
+			sb.WriteByte('\'')
+		}
+	}
+	sb.WriteString(");\n")
+	_, err := w.WriteString(sb.String())
+	return errors.Trace(err)
+}
+
+// count returns the number of rejected rows of the given table.
+func (rr *rejectedRows) count(tableName string) int64 {
+	if rr == nil {
+		return 0
+	}
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+	return rr.perTable[tableName]
+}
+
+func (rr *rejectedRows) emitLog() {
+	if rr == nil {
+		return
+	}
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+
+	if len(rr.perTable) == 0 {
+		return
+	}
+	logger := log.L()
+	logger.Warn("some rows failed to be encoded and are skipped",
+		zap.Int64("count", atomic.LoadInt64(&rr.total)),
+		zap.Int64("max-encode-errors", rr.maxErrors),
+		zap.String("dir", rr.dir),
+	)
+	for tableName, count := range rr.perTable {
+		logger.Warn("-", zap.String("table", tableName), zap.Int64("rejectedRows", count))
+	}
+}
+
+func (rr *rejectedRows) close() {
+	if rr == nil {
+		return
+	}
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+
+	for _, file := range rr.files {
+		file.data.Close()
+		file.reasons.Close()
+	}
+	rr.files = make(map[string]*rejectedRowsFile)
+}
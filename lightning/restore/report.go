@@ -0,0 +1,348 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	. "github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+	verify "github.com/pingcap/tidb-lightning/lightning/verification"
+	"github.com/pingcap/tidb-lightning/lightning/web"
+)
+
+// ImportReport is the machine-readable summary of a task, generated when the
+// task ends.
+type ImportReport struct {
+	TaskID    int64     `json:"task_id"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	// Status is one of "succeeded", "failed" and "canceled".
+	Status       string         `json:"status"`
+	Error        string         `json:"error,omitempty"`
+	Bytes        int64          `json:"bytes"`
+	Rows         int64          `json:"rows"`
+	RejectedRows int64          `json:"rejected_rows"`
+	Tables       []*TableReport `json:"tables"`
+}
+
+// TableReport is the summary of a table in the ImportReport. The durations
+// only cover the work done in this run, excluding the parts resumed from the
+// checkpoints.
+type TableReport struct {
+	TableName   string   `json:"table"`
+	SourceFiles []string `json:"source_files"`
+	// Bytes is the total size of the source files.
+	Bytes int64 `json:"bytes"`
+	// Rows is the number of rows read from the source files.
+	Rows int64 `json:"rows"`
+	// RejectedRows is the number of rows skipped due to encode errors.
+	RejectedRows int64 `json:"rejected_rows"`
+	// Status is the final checkpoint status of the table, "invalid" if the
+	// table failed, in which case FailedStep is the failed step.
+	Status     string `json:"status"`
+	FailedStep string `json:"failed_step,omitempty"`
+	Error      string `json:"error,omitempty"`
+
+	ReadSeconds     float64 `json:"read_seconds"`
+	EncodeSeconds   float64 `json:"encode_seconds"`
+	DeliverSeconds  float64 `json:"deliver_seconds"`
+	ImportSeconds   float64 `json:"import_seconds"`
+	ChecksumSeconds float64 `json:"checksum_seconds"`
+	AnalyzeSeconds  float64 `json:"analyze_seconds"`
+
+	LocalChecksum *ChecksumReport `json:"local_checksum,omitempty"`
+	// RemoteChecksum is absent if the checksum was not performed in this run.
+	RemoteChecksum *ChecksumReport `json:"remote_checksum,omitempty"`
+}
+
+// ChecksumReport is a checksum of the KV pairs of a table.
+type ChecksumReport struct {
+	Checksum uint64 `json:"checksum"`
+	KVs      uint64 `json:"kvs"`
+	Bytes    uint64 `json:"bytes"`
+}
+
+// reportRecorder collects the summary of every table while the task is
+// running. All methods of a nil recorder are no-op.
+type reportRecorder struct {
+	mu     sync.Mutex
+	report ImportReport
+	tables map[string]*TableReport
+}
+
+func newReportRecorder(taskID int64, dbMetas []*mydump.MDDatabaseMeta) *reportRecorder {
+	r := &reportRecorder{
+		report: ImportReport{TaskID: taskID, StartTime: time.Now()},
+		tables: make(map[string]*TableReport),
+	}
+	for _, dbMeta := range dbMetas {
+		for _, tableMeta := range dbMeta.Tables {
+			tableName := common.UniqueTable(dbMeta.Name, tableMeta.Name)
+			table := &TableReport{
+				TableName:   tableName,
+				SourceFiles: append([]string{}, tableMeta.DataFiles...),
+				Bytes:       tableMeta.TotalSize,
+				Status:      CheckpointStatusMissing.MetricName(),
+			}
+			r.tables[tableName] = table
+			r.report.Tables = append(r.report.Tables, table)
+		}
+	}
+	return r
+}
+
+// table returns the report of the table. It must be called with the lock
+// held.
+func (r *reportRecorder) table(tableName string) *TableReport {
+	table, ok := r.tables[tableName]
+	if !ok {
+		table = &TableReport{TableName: tableName, SourceFiles: []string{}}
+		r.tables[tableName] = table
+		r.report.Tables = append(r.report.Tables, table)
+	}
+	return table
+}
+
+// recordStatus records the checkpoint status of the table.
+func (r *reportRecorder) recordStatus(tableName string, status CheckpointStatus) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	table := r.table(tableName)
+	if status <= CheckpointStatusMaxInvalid {
+		table.Status = CheckpointStatusMaxInvalid.MetricName()
+		table.FailedStep = (status * 10).MetricName()
+	} else {
+		table.Status = status.MetricName()
+		table.FailedStep = ""
+	}
+}
+
+// addChunkDurations accumulates the time spent on restoring a chunk.
+func (r *reportRecorder) addChunkDurations(tableName string, read, encode, deliver time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	table := r.table(tableName)
+	table.ReadSeconds += read.Seconds()
+	table.EncodeSeconds += encode.Seconds()
+	table.DeliverSeconds += deliver.Seconds()
+}
+
+// addImportDuration accumulates the time spent on importing an engine.
+func (r *reportRecorder) addImportDuration(tableName string, dur time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.table(tableName).ImportSeconds += dur.Seconds()
+}
+
+// recordChecksum records the result of the checksum step. The remote
+// checksum is nil if the checksum failed to be computed.
+func (r *reportRecorder) recordChecksum(tableName string, dur time.Duration, remote *RemoteChecksum) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	table := r.table(tableName)
+	table.ChecksumSeconds += dur.Seconds()
+	if remote != nil {
+		table.RemoteChecksum = &ChecksumReport{Checksum: remote.Checksum, KVs: remote.TotalKVs, Bytes: remote.TotalBytes}
+	}
+}
+
+func (r *reportRecorder) addAnalyzeDuration(tableName string, dur time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.table(tableName).AnalyzeSeconds += dur.Seconds()
+}
+
+// finishTable records the rows and the local checksum computed from the
+// checkpoint after restoring the table.
+func (r *reportRecorder) finishTable(tableName string, cp *TableCheckpoint, rejectedRows int64, err error) {
+	if r == nil {
+		return
+	}
+
+	var checksum verify.KVChecksum
+	for _, engine := range cp.Engines {
+		for _, chunk := range engine.Chunks {
+			checksum.Add(&chunk.Checksum)
+		}
+	}
+	checksum.Add(&cp.BaseChecksum)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	table := r.table(tableName)
+	table.Rows = importedRows(cp)
+	table.RejectedRows = rejectedRows
+	table.LocalChecksum = &ChecksumReport{Checksum: checksum.Sum(), KVs: checksum.SumKVS(), Bytes: checksum.SumSize()}
+	if err != nil {
+		table.Error = err.Error()
+	}
+}
+
+// finish completes the report with the final status of the task.
+func (r *reportRecorder) finish(err error) *ImportReport {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := &r.report
+	report.EndTime = time.Now()
+	switch {
+	case err == nil:
+		report.Status = TaskStatusSucceeded
+	case log.IsContextCanceledError(err):
+		report.Status = TaskStatusCanceled
+	default:
+		report.Status = TaskStatusFailed
+		report.Error = err.Error()
+	}
+
+	report.Bytes, report.Rows, report.RejectedRows = 0, 0, 0
+	sort.Slice(report.Tables, func(i, j int) bool {
+		return report.Tables[i].TableName < report.Tables[j].TableName
+	})
+	for _, table := range report.Tables {
+		report.Bytes += table.Bytes
+		report.Rows += table.Rows
+		report.RejectedRows += table.RejectedRows
+	}
+
+	// copy the report so it is not changed by the tables still running.
+	finished := *report
+	finished.Tables = make([]*TableReport, 0, len(report.Tables))
+	for _, table := range report.Tables {
+		t := *table
+		finished.Tables = append(finished.Tables, &t)
+	}
+	return &finished
+}
+
+// WriteMarkdown writes the report as a Markdown document.
+func (report *ImportReport) WriteMarkdown(w io.Writer) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# TiDB Lightning import report\n\n")
+	fmt.Fprintf(&sb, "- Task ID: %d\n", report.TaskID)
+	fmt.Fprintf(&sb, "- Status: %s\n", report.Status)
+	if len(report.Error) > 0 {
+		fmt.Fprintf(&sb, "- Error: %s\n", markdownEscaper.Replace(report.Error))
+	}
+	fmt.Fprintf(&sb, "- Start time: %s\n", report.StartTime.Format(time.RFC3339))
+	fmt.Fprintf(&sb, "- End time: %s\n", report.EndTime.Format(time.RFC3339))
+	fmt.Fprintf(&sb, "- Duration: %s\n", report.EndTime.Sub(report.StartTime).Round(time.Second))
+	fmt.Fprintf(&sb, "- Tables: %d\n", len(report.Tables))
+	fmt.Fprintf(&sb, "- Bytes: %d\n", report.Bytes)
+	fmt.Fprintf(&sb, "- Rows: %d\n", report.Rows)
+	fmt.Fprintf(&sb, "- Rejected rows: %d\n\n", report.RejectedRows)
+
+	sb.WriteString("| Table | Status | Files | Bytes | Rows | Rejected rows | Read (s) | Encode (s) | Deliver (s) | Import (s) | Checksum (s) | Analyze (s) | Local checksum | Remote checksum |\n")
+	sb.WriteString("|---|---|--:|--:|--:|--:|--:|--:|--:|--:|--:|--:|---|---|\n")
+	for _, table := range report.Tables {
+		status := table.Status
+		if len(table.FailedStep) > 0 {
+			status = fmt.Sprintf("%s (%s)", status, table.FailedStep)
+		}
+		fmt.Fprintf(&sb, "| %s | %s | %d | %d | %d | %d | %.1f | %.1f | %.1f | %.1f | %.1f | %.1f | %s | %s |\n",
+			markdownEscaper.Replace(table.TableName), status, len(table.SourceFiles), table.Bytes, table.Rows, table.RejectedRows,
+			table.ReadSeconds, table.EncodeSeconds, table.DeliverSeconds, table.ImportSeconds, table.ChecksumSeconds, table.AnalyzeSeconds,
+			table.LocalChecksum, table.RemoteChecksum,
+		)
+	}
+
+	var failed bool
+	for _, table := range report.Tables {
+		if len(table.Error) > 0 {
+			if !failed {
+				sb.WriteString("\n## Errors\n\n")
+				failed = true
+			}
+			fmt.Fprintf(&sb, "- %s: %s\n", markdownEscaper.Replace(table.TableName), markdownEscaper.Replace(table.Error))
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return errors.Trace(err)
+}
+
+var markdownEscaper = strings.NewReplacer("|", `\|`, "\n", " ")
+
+func (c *ChecksumReport) String() string {
+	if c == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d (%d KVs, %d bytes)", c.Checksum, c.KVs, c.Bytes)
+}
+
+// publishReport writes the report into the configured files, and serves it
+// from the HTTP server. Failing to write the report is only logged.
+func (rc *RestoreController) publishReport(report *ImportReport) {
+	if report == nil {
+		return
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.L().Warn("failed to generate import report", log.ShortError(err))
+		return
+	}
+	var markdown strings.Builder
+	if err := report.WriteMarkdown(&markdown); err != nil {
+		log.L().Warn("failed to generate import report", log.ShortError(err))
+		return
+	}
+	web.BroadcastReport(data, []byte(markdown.String()))
+
+	if path := rc.cfg.App.ReportPath; len(path) > 0 {
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			log.L().Warn("failed to write import report", zap.String("path", path), log.ShortError(err))
+		} else {
+			log.L().Info("import report written", zap.String("path", path))
+		}
+	}
+	if path := rc.cfg.App.ReportMarkdownPath; len(path) > 0 {
+		if err := ioutil.WriteFile(path, []byte(markdown.String()), 0644); err != nil {
+			log.L().Warn("failed to write import report", zap.String("path", path), log.ShortError(err))
+		} else {
+			log.L().Info("import report written", zap.String("path", path))
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+
+	. "github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+	verify "github.com/pingcap/tidb-lightning/lightning/verification"
+	"github.com/pingcap/tidb-lightning/lightning/web"
+)
+
+var _ = Suite(&reportSuite{})
+
+type reportSuite struct{}
+
+func (s *reportSuite) newRecorder() *reportRecorder {
+	return newReportRecorder(1234, []*mydump.MDDatabaseMeta{
+		{
+			Name: "db",
+			Tables: []*mydump.MDTableMeta{
+				{DB: "db", Name: "t2", DataFiles: []string{"db.t2.1.sql", "db.t2.2.sql"}, TotalSize: 300},
+				{DB: "db", Name: "t1", DataFiles: []string{"db.t1.sql"}, TotalSize: 100},
+			},
+		},
+	})
+}
+
+func (s *reportSuite) TestNilRecorder(c *C) {
+	var recorder *reportRecorder
+	recorder.recordStatus("`db`.`t`", CheckpointStatusLoaded)
+	recorder.addChunkDurations("`db`.`t`", time.Second, time.Second, time.Second)
+	recorder.addImportDuration("`db`.`t`", time.Second)
+	recorder.recordChecksum("`db`.`t`", time.Second, nil)
+	recorder.addAnalyzeDuration("`db`.`t`", time.Second)
+	recorder.finishTable("`db`.`t`", &TableCheckpoint{}, 0, nil)
+	c.Assert(recorder.finish(nil), IsNil)
+}
+
+func (s *reportSuite) TestRecorder(c *C) {
+	recorder := s.newRecorder()
+
+	// t1 is imported and verified.
+	recorder.recordStatus("`db`.`t1`", CheckpointStatusLoaded)
+	recorder.addChunkDurations("`db`.`t1`", time.Second, 2*time.Second, 3*time.Second)
+	recorder.addImportDuration("`db`.`t1`", 4*time.Second)
+	recorder.recordStatus("`db`.`t1`", CheckpointStatusAlteredAutoInc)
+	recorder.recordChecksum("`db`.`t1`", 5*time.Second, &RemoteChecksum{Checksum: 4000, TotalKVs: 20, TotalBytes: 300})
+	recorder.recordStatus("`db`.`t1`", CheckpointStatusChecksummed)
+	recorder.recordStatus("`db`.`t1`", CheckpointStatusAnalyzeSkipped)
+	recorder.finishTable("`db`.`t1`", &TableCheckpoint{
+		Engines: map[int32]*EngineCheckpoint{
+			0: {Chunks: []*ChunkCheckpoint{{
+				Chunk:    mydump.Chunk{PrevRowIDMax: 12, RowIDMax: 12},
+				Checksum: verify.MakeKVChecksum(200, 15, 4000^1000),
+			}}},
+		},
+		BaseChecksum: verify.MakeKVChecksum(100, 5, 1000),
+	}, 2, nil)
+
+	// t2 fails at the checksum.
+	recorder.recordStatus("`db`.`t2`", CheckpointStatusAlteredAutoInc)
+	recorder.recordChecksum("`db`.`t2`", time.Second, nil)
+	recorder.recordStatus("`db`.`t2`", CheckpointStatusChecksummed/10)
+	recorder.finishTable("`db`.`t2`", &TableCheckpoint{}, 0, errors.New("checksum mismatched"))
+
+	report := recorder.finish(errors.New("checksum mismatched"))
+	c.Assert(report.TaskID, Equals, int64(1234))
+	c.Assert(report.Status, Equals, TaskStatusFailed)
+	c.Assert(report.Error, Equals, "checksum mismatched")
+	c.Assert(report.EndTime.Before(report.StartTime), IsFalse)
+	c.Assert(report.Bytes, Equals, int64(400))
+	c.Assert(report.Rows, Equals, int64(12))
+	c.Assert(report.RejectedRows, Equals, int64(2))
+	c.Assert(report.Tables, HasLen, 2)
+
+	t1, t2 := report.Tables[0], report.Tables[1]
+	c.Assert(t1, DeepEquals, &TableReport{
+		TableName:       "`db`.`t1`",
+		SourceFiles:     []string{"db.t1.sql"},
+		Bytes:           100,
+		Rows:            12,
+		RejectedRows:    2,
+		Status:          "analyzed",
+		ReadSeconds:     1,
+		EncodeSeconds:   2,
+		DeliverSeconds:  3,
+		ImportSeconds:   4,
+		ChecksumSeconds: 5,
+		LocalChecksum:   &ChecksumReport{Checksum: 4000, KVs: 20, Bytes: 300},
+		RemoteChecksum:  &ChecksumReport{Checksum: 4000, KVs: 20, Bytes: 300},
+	})
+	c.Assert(t2.TableName, Equals, "`db`.`t2`")
+	c.Assert(t2.SourceFiles, DeepEquals, []string{"db.t2.1.sql", "db.t2.2.sql"})
+	c.Assert(t2.Status, Equals, "invalid")
+	c.Assert(t2.FailedStep, Equals, "checksum")
+	c.Assert(t2.Error, Equals, "checksum mismatched")
+	c.Assert(t2.RemoteChecksum, IsNil)
+
+	// the finished report is not changed by the recorder afterwards.
+	recorder.addImportDuration("`db`.`t1`", time.Second)
+	c.Assert(t1.ImportSeconds, Equals, 4.0)
+
+	c.Assert(recorder.finish(nil).Status, Equals, TaskStatusSucceeded)
+	c.Assert(recorder.finish(context.Canceled).Status, Equals, TaskStatusCanceled)
+}
+
+func (s *reportSuite) TestPublishReport(c *C) {
+	dir := c.MkDir()
+	cfg := config.NewConfig()
+	cfg.App.ReportPath = filepath.Join(dir, "report.json")
+	cfg.App.ReportMarkdownPath = filepath.Join(dir, "report.md")
+	rc := &RestoreController{cfg: cfg}
+
+	recorder := s.newRecorder()
+	recorder.recordStatus("`db`.`t1`", CheckpointStatusAnalyzed)
+	recorder.finishTable("`db`.`t1`", &TableCheckpoint{}, 0, nil)
+	recorder.recordStatus("`db`.`t2`", CheckpointStatusImported/10)
+	recorder.finishTable("`db`.`t2`", &TableCheckpoint{}, 0, errors.New("import | failed"))
+	rc.publishReport(recorder.finish(nil))
+
+	data, err := ioutil.ReadFile(cfg.App.ReportPath)
+	c.Assert(err, IsNil)
+	var decoded ImportReport
+	c.Assert(json.Unmarshal(data, &decoded), IsNil)
+	c.Assert(decoded.TaskID, Equals, int64(1234))
+	c.Assert(decoded.Status, Equals, TaskStatusSucceeded)
+	c.Assert(decoded.Tables, HasLen, 2)
+	c.Assert(decoded.Tables[1].FailedStep, Equals, "imported")
+
+	served, err := web.MarshalReport(false)
+	c.Assert(err, IsNil)
+	c.Assert(served, DeepEquals, data)
+
+	markdown, err := ioutil.ReadFile(cfg.App.ReportMarkdownPath)
+	c.Assert(err, IsNil)
+	lines := strings.Split(string(markdown), "\n")
+	c.Assert(lines[0], Equals, "# TiDB Lightning import report")
+	c.Assert(string(markdown), Matches, `(?s).*\| `+"`db`.`t1`"+` \| analyzed \| 1 \| 100 \| 0 \| 0 \| .*`)
+	c.Assert(string(markdown), Matches, `(?s).*\| `+"`db`.`t2`"+` \| invalid \(imported\) \| 2 \| 300 \| .*`)
+	c.Assert(string(markdown), Matches, `(?s).*## Errors\n\n- `+"`db`.`t2`"+`: import \\\| failed\n`)
+
+	servedMarkdown, err := web.MarshalReport(true)
+	c.Assert(err, IsNil)
+	c.Assert(servedMarkdown, DeepEquals, markdown)
+
+	// failing to write the report does not fail the task.
+	cfg.App.ReportPath = filepath.Join(dir, "missing", "report.json")
+	rc.publishReport(recorder.finish(nil))
+	_, err = os.Stat(cfg.App.ReportPath)
+	c.Assert(os.IsNotExist(err), IsTrue)
+}
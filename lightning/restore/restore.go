@@ -20,6 +20,7 @@ import (
 	"io"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -34,6 +35,8 @@ import (
 	"github.com/pingcap/tidb/meta/autoid"
 	"github.com/pingcap/tidb/table"
 	"github.com/pingcap/tidb/table/tables"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/timeutil"
 	"go.uber.org/zap"
 	"modernc.org/mathutil"
 
@@ -146,10 +149,15 @@ type RestoreController struct {
 	tls             *common.TLS
 
 	errorSummaries errorSummaries
+	rejectedRows   *rejectedRows
+	tableRules     *config.TableRules
 
 	checkpointsDB CheckpointsDB
 	saveCpCh      chan saveCp
 	checkpointsWg sync.WaitGroup
+	history       *taskHistoryRecorder
+	leaser        *tableLeaser
+	report        *reportRecorder
 
 	closedEngineLimit *worker.Pool
 }
@@ -177,6 +185,11 @@ func NewRestoreControllerWithPauser(ctx context.Context, dbMetas []*mydump.MDDat
 		return nil, errors.Trace(err)
 	}
 
+	tableRules, err := config.NewTableRules(cfg.Mydumper.CaseSensitive, cfg.Tables)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
 	var backend kv.Backend
 	switch cfg.TikvImporter.Backend {
 	case config.BackendImporter:
@@ -186,11 +199,21 @@ func NewRestoreControllerWithPauser(ctx context.Context, dbMetas []*mydump.MDDat
 			return nil, err
 		}
 	case config.BackendTiDB:
-		backend = kv.NewTiDBBackend(tidbMgr.db, cfg.TikvImporter.OnDuplicate)
+		dbs, err := tidbMgr.ConnectEndpoints(cfg.TiDB)
+		if err != nil {
+			tidbMgr.Close()
+			return nil, errors.Trace(err)
+		}
+		backend = kv.NewMultiTiDBBackend(dbs, cfg.TikvImporter.OnDuplicate, cfg.TikvImporter.WriteMode)
 	default:
 		return nil, errors.New("unknown backend: " + cfg.TikvImporter.Backend)
 	}
 
+	leaser, err := newTableLeaser(cfg, cpdb)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
 	rc := &RestoreController{
 		cfg:           cfg,
 		dbMetas:       dbMetas,
@@ -205,8 +228,13 @@ func NewRestoreControllerWithPauser(ctx context.Context, dbMetas []*mydump.MDDat
 		tls:           tls,
 
 		errorSummaries:    makeErrorSummaries(log.L()),
+		rejectedRows:      newRejectedRows(cfg),
+		tableRules:        tableRules,
 		checkpointsDB:     cpdb,
 		saveCpCh:          make(chan saveCp),
+		history:           newTaskHistoryRecorder(cpdb, cfg.TaskID, cfg.Summary()),
+		leaser:            leaser,
+		report:            newReportRecorder(cfg.TaskID, dbMetas),
 		closedEngineLimit: worker.NewPool(ctx, cfg.App.TableConcurrency*2, "closed-engine"),
 	}
 
@@ -232,13 +260,30 @@ func OpenCheckpointsDB(ctx context.Context, cfg *config.Config) (CheckpointsDB,
 		return cpdb, nil
 
 	case config.CheckpointDriverFile:
-		return NewFileCheckpointsDB(cfg.Checkpoint.DSN), nil
+		cpdb, err := NewFileCheckpointsDB(cfg.Checkpoint.DSN, cfg.TaskID)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return cpdb, nil
 
 	default:
 		return nil, errors.Errorf("Unknown checkpoint driver %s", cfg.Checkpoint.Driver)
 	}
 }
 
+// OpenTaskHistoryDB opens the task history for reading, without creating the
+// checkpoints schema.
+func OpenTaskHistoryDB(cfg *config.Config) (*MySQLCheckpointsDB, error) {
+	if !cfg.Checkpoint.Enable || cfg.Checkpoint.Driver != config.CheckpointDriverMySQL {
+		return nil, errors.New("the task history is only kept by the \"mysql\" checkpoint driver")
+	}
+	db, err := sql.Open("mysql", cfg.Checkpoint.DSN)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return NewMySQLTaskHistoryReader(db, cfg.Checkpoint.Schema), nil
+}
+
 func (rc *RestoreController) Wait() {
 	close(rc.saveCpCh)
 	rc.checkpointsWg.Wait()
@@ -247,12 +292,15 @@ func (rc *RestoreController) Wait() {
 func (rc *RestoreController) Close() {
 	rc.backend.Close()
 	rc.tidbMgr.Close()
+	rc.rejectedRows.close()
 }
 
 func (rc *RestoreController) Run(ctx context.Context) error {
 	opts := []func(context.Context) error{
+		rc.checkTaskCheckpoint,
 		rc.checkRequirements,
 		rc.restoreSchema,
+		rc.checkTablesEmpty,
 		rc.restoreTables,
 		rc.fullCompact,
 		rc.switchToNormalMode,
@@ -260,8 +308,11 @@ func (rc *RestoreController) Run(ctx context.Context) error {
 	}
 
 	task := log.L().Begin(zap.InfoLevel, "the whole procedure")
+	rc.history.start(ctx)
+	rc.leaser.start(ctx)
+	defer rc.leaser.stop()
 
-	var err error
+	var err, historyErr error
 outside:
 	for i, process := range opts {
 		err = process(ctx)
@@ -271,17 +322,22 @@ outside:
 		case err == nil:
 		case log.IsContextCanceledError(err):
 			logger.Info("user terminated")
+			historyErr = err
 			err = nil
 			break outside
 		default:
 			logger.Error("run failed")
 			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			historyErr = err
 			break outside // ps : not continue
 		}
 	}
+	rc.history.finish(historyErr)
+	rc.publishReport(rc.report.finish(historyErr))
 
 	task.End(zap.ErrorLevel, err)
 	rc.errorSummaries.emitLog()
+	rc.rejectedRows.emitLog()
 
 	return errors.Trace(err)
 }
@@ -356,6 +412,7 @@ func (rc *RestoreController) saveStatusCheckpoint(tableName string, engineID int
 	}
 
 	if engineID == WholeTableEngineID {
+		rc.report.recordStatus(tableName, merger.Status)
 		metric.RecordTableCount(statusIfSucceed.MetricName(), err)
 	} else {
 		metric.RecordEngineCount(statusIfSucceed.MetricName(), err)
@@ -573,6 +630,9 @@ func (rc *RestoreController) restoreTables(ctx context.Context) error {
 	type task struct {
 		tr *TableRestore
 		cp *TableCheckpoint
+		// ctx is canceled when the lease of the table is lost in the
+		// distributed import.
+		ctx context.Context
 	}
 	taskCh := make(chan task, rc.cfg.App.IndexConcurrency)
 	defer close(taskCh)
@@ -584,8 +644,18 @@ func (rc *RestoreController) restoreTables(ctx context.Context) error {
 			for task := range taskCh {
 				tableLogTask := task.tr.logger.Begin(zap.InfoLevel, "restore table")
 				web.BroadcastTableCheckpoint(task.tr.tableName, task.cp)
-				err := task.tr.restoreTable(ctx2, rc, task.cp)
+				rc.report.recordStatus(task.tr.tableName, task.cp.Status)
+				err := task.tr.restoreTable(task.ctx, rc, task.cp)
+				if rc.leaser.release(task.tr.tableName, err) {
+					// the table is left to the instance taking over the lease.
+					tableLogTask.End(zap.WarnLevel, err)
+					wg.Done()
+					continue
+				}
 				tableLogTask.End(zap.ErrorLevel, err)
+				rejectedRows := rc.rejectedRows.count(task.tr.tableName)
+				rc.history.recordTable(ctx, task.tr.tableName, task.cp, rejectedRows, err)
+				rc.report.finishTable(task.tr.tableName, task.cp, rejectedRows, err)
 				web.BroadcastError(task.tr.tableName, err)
 				metric.RecordTableCount("completed", err)
 				restoreErr.Set(err)
@@ -615,6 +685,7 @@ func (rc *RestoreController) restoreTables(ctx context.Context) error {
 			if cp.Status <= CheckpointStatusMaxInvalid {
 				allInvalidCheckpoints[tableName] = cp.Status
 			}
+			rc.report.recordStatus(tableName, cp.Status)
 		}
 	}
 
@@ -653,33 +724,76 @@ func (rc *RestoreController) restoreTables(ctx context.Context) error {
 		return errors.New("TiDB Lightning has failed last time; please resolve these errors first")
 	}
 
+	type tableMetaInfo struct {
+		tableMeta *mydump.MDTableMeta
+		dbInfo    *TidbDBInfo
+		tableInfo *TidbTableInfo
+	}
+	tableMetas := make(map[string]tableMetaInfo)
 	for _, dbMeta := range rc.dbMetas {
 		dbInfo := rc.dbInfos[dbMeta.Name]
 		for _, tableMeta := range dbMeta.Tables {
 			tableInfo := dbInfo.Tables[tableMeta.Name]
 			tableName := common.UniqueTable(dbInfo.Name, tableInfo.Name)
-			cp, err := rc.checkpointsDB.Get(ctx, tableName)
-			if err != nil {
-				return errors.Trace(err)
-			}
-			tr, err := NewTableRestore(tableName, tableMeta, dbInfo, tableInfo, cp)
-			if err != nil {
-				return errors.Trace(err)
-			}
+			tableMetas[tableName] = tableMetaInfo{tableMeta: tableMeta, dbInfo: dbInfo, tableInfo: tableInfo}
+		}
+	}
+	dispatch := func(tableCtx context.Context, tableName string, cp *TableCheckpoint) error {
+		meta := tableMetas[tableName]
+		tableMeta, dbInfo, tableInfo := meta.tableMeta, meta.dbInfo, meta.tableInfo
+		tr, err := NewTableRestore(tableName, tableMeta, dbInfo, tableInfo, cp)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err := tr.applyRule(rc.tableRules.Match(dbInfo.Name, tableInfo.Name)); err != nil {
+			return errors.Trace(err)
+		}
+		if rc.cfg.TikvImporter.DuplicateDetection && rc.cfg.TikvImporter.Backend == config.BackendImporter {
+			tr.dupDetector = newDuplicateDetector(rc.cfg.TikvImporter.DuplicateDir, dbInfo.Name, tableInfo.Core, tr.logger)
+		}
 
-			wg.Add(1)
-			select {
-			case taskCh <- task{tr: tr, cp: cp}:
-			case <-ctx.Done():
-				return ctx.Err()
+		wg.Add(1)
+		select {
+		case taskCh <- task{tr: tr, cp: cp, ctx: tableCtx}:
+			return nil
+		case <-ctx.Done():
+			wg.Done()
+			return ctx.Err()
+		}
+	}
+
+	if rc.leaser == nil {
+		for _, dbMeta := range rc.dbMetas {
+			dbInfo := rc.dbInfos[dbMeta.Name]
+			for _, tableMeta := range dbMeta.Tables {
+				tableInfo := dbInfo.Tables[tableMeta.Name]
+				tableName := common.UniqueTable(dbInfo.Name, tableInfo.Name)
+				cp, err := rc.checkpointsDB.Get(ctx, tableName)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				if err := dispatch(ctx2, tableName, cp); err != nil {
+					return err
+				}
 			}
 		}
+	} else {
+		failed := func() bool { return restoreErr.Get() != nil }
+		if err := rc.dispatchLeasedTables(ctx2, dispatch, failed); err != nil {
+			wg.Wait()
+			stopPeriodicActions <- struct{}{}
+			logTask.End(zap.ErrorLevel, err)
+			return err
+		}
 	}
 
 	wg.Wait()
 	stopPeriodicActions <- struct{}{}
 
 	err := restoreErr.Get()
+	if err == nil {
+		err = rc.leaser.acquireFinalizer(ctx)
+	}
 	logTask.End(zap.ErrorLevel, err)
 	return err
 }
@@ -697,16 +811,37 @@ func (t *TableRestore) restoreTable(
 	default:
 	}
 
+	defer t.dupDetector.cleanup()
+	if err := t.dupDetector.removeStaleConflicts(); err != nil {
+		return errors.Trace(err)
+	}
+
+	if err := t.resolveSettings(ctx, rc, cp); err != nil {
+		return errors.Trace(err)
+	}
+
 	// no need to do anything if the chunks are already populated
 	if len(cp.Engines) > 0 {
 		t.logger.Info("reusing engines and files info from checkpoint",
 			zap.Int("enginesCnt", len(cp.Engines)),
 			zap.Int("filesCnt", cp.CountChunks()),
 		)
+		if cp.Status < CheckpointStatusAllWritten {
+			if err := t.verifyFingerprints(cp); err != nil {
+				rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, err, CheckpointStatusAllWritten)
+				return errors.Trace(err)
+			}
+		}
+		if err := rc.rejectedRows.resume(t, cp); err != nil {
+			return errors.Trace(err)
+		}
 	} else if cp.Status < CheckpointStatusAllWritten {
 		if err := t.populateChunks(rc.cfg, cp); err != nil {
 			return errors.Trace(err)
 		}
+		if err := rc.rejectedRows.reset(t, cp); err != nil {
+			return errors.Trace(err)
+		}
 		if err := rc.checkpointsDB.InsertEngineCheckpoints(ctx, t.tableName, cp.Engines); err != nil {
 			return errors.Trace(err)
 		}
@@ -733,6 +868,186 @@ func (t *TableRestore) restoreTable(
 	return errors.Trace(t.postProcess(ctx, rc, cp))
 }
 
+// resolveSettings decides the settings used to import the table, and saves
+// them into the checkpoint together if any of them has changed.
+func (t *TableRestore) resolveSettings(ctx context.Context, rc *RestoreController, cp *TableCheckpoint) error {
+	oldSettings := tableSettings(cp)
+
+	if err := t.resolveTimeZone(rc, cp); err != nil {
+		return errors.Trace(err)
+	}
+	t.resolveOnDuplicate(rc, cp)
+	if err := t.resolveMasks(cp); err != nil {
+		return errors.Trace(err)
+	}
+	// the existing data is only examined before the chunks are populated.
+	if len(cp.Engines) == 0 && cp.Status < CheckpointStatusAllWritten &&
+		rc.cfg.TikvImporter.Incremental && rc.cfg.TikvImporter.Backend == config.BackendImporter {
+		if err := t.prepareIncremental(ctx, rc, cp); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if settings := tableSettings(cp); settings != oldSettings {
+		rc.saveCpCh <- saveCp{
+			tableName: t.tableName,
+			merger:    &settings,
+		}
+	}
+	return nil
+}
+
+func tableSettings(cp *TableCheckpoint) TableSettingsCheckpointMerger {
+	return TableSettingsCheckpointMerger{
+		BaseChecksum: cp.BaseChecksum,
+		TimeZone:     cp.TimeZone,
+		OnDuplicate:  cp.OnDuplicate,
+		Masks:        cp.Masks,
+		RowIDBase:    cp.RowIDBase,
+	}
+}
+
+// resolveTimeZone decides the time zone used to encode the table, which is
+// the system time zone of the host unless `tidb.time-zone` is set. A table
+// which has been partially imported keeps using the time zone recorded in the
+// checkpoint, so that the resumed chunks produce identical KVs.
+func (t *TableRestore) resolveTimeZone(rc *RestoreController, cp *TableCheckpoint) error {
+	t.timeZone = rc.cfg.TiDB.TimeZone
+	if len(t.timeZone) == 0 {
+		t.timeZone = timeutil.InferSystemTZ()
+	}
+	switch {
+	case len(cp.TimeZone) == 0:
+		cp.TimeZone = t.timeZone
+	case cp.TimeZone != t.timeZone:
+		// the TiDB backend connection is shared by all tables, so we
+		// cannot switch the time zone just for this table.
+		if rc.cfg.TikvImporter.Backend == config.BackendTiDB {
+			return errors.Errorf("table %s was partially imported with the time zone %s, please set `tidb.time-zone` to %q to resume", t.tableName, cp.TimeZone, cp.TimeZone)
+		}
+		t.logger.Warn("time zone differs from the checkpoint, using the one in the checkpoint",
+			zap.String("checkpoint", cp.TimeZone),
+			zap.String("config", t.timeZone),
+		)
+		t.timeZone = cp.TimeZone
+	}
+	return nil
+}
+
+// resolveOnDuplicate decides the action on duplicated rows of the table when
+// the backend is TiDB, which is either overridden by the [[tables]] rule or the
+// global `tikv-importer.on-duplicate`, and records it in the checkpoint.
+func (t *TableRestore) resolveOnDuplicate(rc *RestoreController, cp *TableCheckpoint) {
+	if rc.cfg.TikvImporter.Backend != config.BackendTiDB {
+		return
+	}
+	t.onDuplicate = rc.cfg.TikvImporter.OnDuplicate
+	if t.rule != nil && len(t.rule.OnDuplicate) != 0 {
+		t.onDuplicate = t.rule.OnDuplicate
+	}
+	if cp.OnDuplicate == t.onDuplicate {
+		return
+	}
+	// unlike the time zone, the action only affects the rows written from
+	// now on, so we could simply follow the latest config, e.g. to resume a
+	// table which failed due to duplicated rows.
+	if len(cp.OnDuplicate) != 0 {
+		t.logger.Warn("action on duplicated rows differs from the checkpoint, using the one in the config",
+			zap.String("checkpoint", cp.OnDuplicate),
+			zap.String("config", t.onDuplicate),
+		)
+	}
+	cp.OnDuplicate = t.onDuplicate
+}
+
+// resolveMasks records the fingerprint of the column masks in the checkpoint.
+// A table which has been partially imported must be resumed with the same
+// masks, otherwise the imported rows would be masked inconsistently, or worse,
+// some sensitive data would be imported as-is.
+func (t *TableRestore) resolveMasks(cp *TableCheckpoint) error {
+	var masks map[string]*config.ColumnMask
+	if t.rule != nil {
+		masks = t.rule.Masks
+	}
+	fingerprint := config.MaskFingerprint(masks)
+	if cp.Masks == fingerprint || cp.Status >= CheckpointStatusAllWritten {
+		return nil
+	}
+	if len(cp.Engines) > 0 {
+		return errors.Errorf("table %s was partially imported with different column masks, please restore the `masks` of [[tables]], or remove the checkpoint with `tidb-lightning-ctl --checkpoint-error-destroy='%s'` to start over", t.tableName, t.tableName)
+	}
+	cp.Masks = fingerprint
+	return nil
+}
+
+// verifyFingerprints checks that the source files of a partially imported
+// table have not changed since the chunks were recorded in the checkpoint,
+// since resuming at the recorded offsets would mix the old and new data.
+func (t *TableRestore) verifyFingerprints(cp *TableCheckpoint) error {
+	fingerprints := make(map[string]string)
+	changedFiles := make(map[string]struct{})
+	for _, engine := range cp.Engines {
+		for _, chunk := range engine.Chunks {
+			// chunks recorded by older versions have no fingerprints.
+			if len(chunk.Fingerprint) == 0 {
+				continue
+			}
+			path := chunk.Key.Path
+			fingerprint, ok := fingerprints[path]
+			if !ok {
+				var err error
+				fingerprint, err = mydump.FileFingerprint(path)
+				if err != nil && !os.IsNotExist(errors.Cause(err)) {
+					return errors.Annotatef(err, "failed to verify source file %s", path)
+				}
+				fingerprints[path] = fingerprint
+			}
+			if fingerprint != chunk.Fingerprint {
+				changedFiles[path] = struct{}{}
+			}
+		}
+	}
+	if len(changedFiles) == 0 {
+		return nil
+	}
+
+	paths := make([]string, 0, len(changedFiles))
+	for path := range changedFiles {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	t.logger.Error("source files changed since the checkpoint was created", zap.Strings("files", paths))
+	return errors.Errorf("source files of table %s have changed since the checkpoint was created: %s; please remove the imported data and the checkpoint with `tidb-lightning-ctl --checkpoint-error-destroy='%s'` to start over", t.tableName, strings.Join(paths, ", "), t.tableName)
+}
+
+// prepareIncremental records the checksum of the existing data in the target
+// table, and moves the row IDs of the chunks and the allocator base beyond the
+// largest existing handle, so that the imported rows can be appended to the
+// table without colliding.
+func (t *TableRestore) prepareIncremental(ctx context.Context, rc *RestoreController, cp *TableCheckpoint) error {
+	task := t.logger.Begin(zap.InfoLevel, "prepare incremental import")
+
+	baseChecksum, err := DoChecksum(ctx, rc.tidbMgr.db, t.tableName)
+	if err != nil {
+		task.End(zap.ErrorLevel, err)
+		return errors.Trace(err)
+	}
+	cp.BaseChecksum = verify.MakeKVChecksum(baseChecksum.TotalBytes, baseChecksum.TotalKVs, baseChecksum.Checksum)
+
+	maxHandle, err := ObtainMaxHandle(ctx, rc.tidbMgr.db, t.tableName, t.tableInfo.Core)
+	if err != nil {
+		task.End(zap.ErrorLevel, err)
+		return errors.Trace(err)
+	}
+	// the chunks are shifted when populated, and the actual rebase happens
+	// together with the non-incremental case.
+	cp.RowIDBase = maxHandle
+	cp.AllocBase = mathutil.MaxInt64(cp.AllocBase, maxHandle)
+
+	task.End(zap.ErrorLevel, nil, zap.Object("baseChecksum", &cp.BaseChecksum), zap.Int64("maxHandle", maxHandle))
+	return nil
+}
+
 func (t *TableRestore) restoreEngines(ctx context.Context, rc *RestoreController, cp *TableCheckpoint) error {
 	indexEngineCp := cp.Engines[indexEngineID]
 	if indexEngineCp == nil {
@@ -859,6 +1174,7 @@ func (t *TableRestore) restoreEngine(
 	cp *EngineCheckpoint,
 ) (*kv.ClosedEngine, *worker.Worker, error) {
 	if cp.Status >= CheckpointStatusClosed {
+		t.dupDetector.markIncomplete()
 		w := rc.closedEngineLimit.Apply()
 		closedEngine, err := rc.backend.UnsafeCloseEngine(ctx, t.tableName, engineID)
 		// If any error occurred, recycle worker immediately
@@ -881,6 +1197,9 @@ func (t *TableRestore) restoreEngine(
 
 	// Restore table data
 	for chunkIndex, chunk := range cp.Chunks {
+		if chunk.Chunk.Offset > chunk.Key.Offset {
+			t.dupDetector.markIncomplete()
+		}
 		if chunk.Chunk.Offset >= chunk.Chunk.EndOffset {
 			continue
 		}
@@ -974,7 +1293,9 @@ func (t *TableRestore) importEngine(
 
 	// the lock ensures the import() step will not be concurrent.
 	rc.postProcessLock.Lock()
+	importStart := time.Now()
 	err := t.importKV(ctx, closedEngine)
+	rc.report.addImportDuration(t.tableName, time.Since(importStart))
 	rc.postProcessLock.Unlock()
 	rc.saveStatusCheckpoint(t.tableName, engineID, err, CheckpointStatusImported)
 	if err != nil {
@@ -1002,10 +1323,15 @@ func (t *TableRestore) postProcess(ctx context.Context, rc *RestoreController, c
 		return nil
 	}
 
-	// 3. alter table set auto_increment
+	// 3. alter table set auto_increment (or auto_random_base)
 	if cp.Status < CheckpointStatusAlteredAutoInc {
+		var err error
 		rc.alterTableLock.Lock()
-		err := AlterAutoIncrement(ctx, rc.tidbMgr.db, t.tableName, t.alloc[0].Base()+1)
+		if t.tableInfo.Core.ContainsAutoRandomBits() {
+			err = AlterAutoRandom(ctx, rc.tidbMgr.db, t.tableName, t.alloc[0].Base()+1)
+		} else {
+			err = AlterAutoIncrement(ctx, rc.tidbMgr.db, t.tableName, t.alloc[0].Base()+1)
+		}
 		rc.alterTableLock.Unlock()
 		rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, err, CheckpointStatusAlteredAutoInc)
 		if err != nil {
@@ -1020,14 +1346,23 @@ func (t *TableRestore) postProcess(ctx context.Context, rc *RestoreController, c
 			localChecksum.Add(&chunk.Checksum)
 		}
 	}
+	// in incremental mode, the remote checksum also covers the rows existed
+	// before the import.
+	localChecksum.Add(&cp.BaseChecksum)
 
 	t.logger.Info("local checksum", zap.Object("checksum", &localChecksum))
 	if cp.Status < CheckpointStatusChecksummed {
+		if err := t.checkDuplicates(); err != nil {
+			rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, err, CheckpointStatusChecksummed)
+			return errors.Trace(err)
+		}
 		if !rc.cfg.PostRestore.Checksum {
 			t.logger.Info("skip checksum")
 			rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, nil, CheckpointStatusChecksumSkipped)
 		} else {
+			checksumStart := time.Now()
 			err := t.compareChecksum(ctx, rc.tidbMgr.db, localChecksum)
+			rc.report.recordChecksum(t.tableName, time.Since(checksumStart), t.remoteChecksum)
 			rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, err, CheckpointStatusChecksummed)
 			if err != nil {
 				return errors.Trace(err)
@@ -1041,7 +1376,9 @@ func (t *TableRestore) postProcess(ctx context.Context, rc *RestoreController, c
 			t.logger.Info("skip analyze")
 			rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, nil, CheckpointStatusAnalyzeSkipped)
 		} else {
+			analyzeStart := time.Now()
 			err := t.analyzeTable(ctx, rc.tidbMgr.db)
+			rc.report.addAnalyzeDuration(t.tableName, time.Since(analyzeStart))
 			rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, err, CheckpointStatusAnalyzed)
 			if err != nil {
 				return errors.Trace(err)
@@ -1058,6 +1395,10 @@ func (rc *RestoreController) fullCompact(ctx context.Context) error {
 		log.L().Info("skip full compaction")
 		return nil
 	}
+	if !rc.leaser.canFinalize() {
+		log.L().Info("skip full compaction, which is performed by another instance")
+		return nil
+	}
 
 	// wait until any existing level-1 compact to complete first.
 	task := log.L().Begin(zap.InfoLevel, "wait for completion of existing level 1 compaction")
@@ -1086,6 +1427,9 @@ func (rc *RestoreController) switchToImportMode(ctx context.Context) {
 }
 
 func (rc *RestoreController) switchToNormalMode(ctx context.Context) error {
+	if !rc.leaser.canFinalize() {
+		return nil
+	}
 	rc.switchTiKVMode(ctx, sstpb.SwitchMode_Normal)
 	return nil
 }
@@ -1133,6 +1477,103 @@ func (rc *RestoreController) checkRequirements(_ context.Context) error {
 	return nil
 }
 
+// checkTaskCheckpoint ensures the data-affecting settings are unchanged since
+// the checkpoints were created, since the chunks and column permutations
+// recorded in the checkpoints depend on them. The settings are recorded if
+// this is a new task.
+func (rc *RestoreController) checkTaskCheckpoint(ctx context.Context) error {
+	taskCp, err := rc.checkpointsDB.TaskCheckpoint(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	fingerprint := rc.cfg.Fingerprint()
+	if taskCp == nil {
+		taskCp = &TaskCheckpoint{TaskID: rc.cfg.TaskID}
+	} else if taskCp.ConfigFingerprint == fingerprint {
+		return nil
+	} else {
+		diffs := config.DiffFingerprints(taskCp.ConfigFingerprint, fingerprint)
+		if !rc.cfg.Checkpoint.AllowConfigChange {
+			return errors.Errorf("the configuration has been changed since the checkpoints were created by task %d:\n\t%s\n"+
+				"resuming with these changes may corrupt the imported data. Please revert the changes, "+
+				"or drop the imported tables and remove the checkpoints with `tidb-lightning-ctl --checkpoint-remove=all` to start over, "+
+				"or set `checkpoint.allow-config-change = true` to resume anyway",
+				taskCp.TaskID, strings.Join(diffs, "\n\t"))
+		}
+		log.L().Warn("resuming with changed configuration as `checkpoint.allow-config-change` is enabled",
+			zap.Int64("taskID", taskCp.TaskID), zap.Strings("changes", diffs))
+	}
+
+	taskCp.ConfigFingerprint = fingerprint
+	return errors.Trace(rc.checkpointsDB.UpdateTaskCheckpoint(ctx, taskCp))
+}
+
+// checkTablesEmpty ensures all target tables which have not been started yet
+// are empty. Importing into a non-empty table with the importer backend would
+// corrupt the indices and fail the checksum, unless the incremental mode is
+// explicitly enabled.
+func (rc *RestoreController) checkTablesEmpty(ctx context.Context) error {
+	if rc.cfg.TikvImporter.Backend != config.BackendImporter || rc.cfg.TikvImporter.Incremental {
+		return nil
+	}
+
+	task := log.L().Begin(zap.InfoLevel, "check target tables are empty")
+
+	type nonEmptyTable struct {
+		tableName string
+		rowCount  int64
+	}
+	var nonEmptyTables []nonEmptyTable
+
+	for _, dbMeta := range rc.dbMetas {
+		dbInfo := rc.dbInfos[dbMeta.Name]
+		for _, tableMeta := range dbMeta.Tables {
+			tableInfo := dbInfo.Tables[tableMeta.Name]
+			tableName := common.UniqueTable(dbInfo.Name, tableInfo.Name)
+
+			cp, err := rc.checkpointsDB.Get(ctx, tableName)
+			if err != nil {
+				task.End(zap.ErrorLevel, err)
+				return errors.Trace(err)
+			}
+			status := cp.Status
+			if status <= CheckpointStatusMaxInvalid {
+				status *= 10
+			}
+			if len(cp.Engines) > 0 || status > CheckpointStatusLoaded {
+				// the table has been started in a previous run, so it is
+				// natural that it already contains some data.
+				continue
+			}
+
+			empty, err := IsTableEmpty(ctx, rc.tidbMgr.db, tableName)
+			if err != nil {
+				task.End(zap.ErrorLevel, err)
+				return errors.Trace(err)
+			}
+			if !empty {
+				nonEmptyTables = append(nonEmptyTables, nonEmptyTable{
+					tableName: tableName,
+					rowCount:  ObtainApproxRowCount(ctx, rc.tidbMgr.db, dbInfo.Name, tableInfo.Name),
+				})
+			}
+		}
+	}
+
+	if len(nonEmptyTables) == 0 {
+		task.End(zap.ErrorLevel, nil)
+		return nil
+	}
+
+	err := errors.Errorf("%d target tables are not empty; please clean them up, or set `tikv-importer.incremental = true` to import into existing tables", len(nonEmptyTables))
+	task.End(zap.ErrorLevel, err)
+	for _, t := range nonEmptyTables {
+		task.Error("-", zap.String("table", t.tableName), zap.Int64("approxRowCount", t.rowCount))
+	}
+	return err
+}
+
 func extractTiDBVersion(version string) (*semver.Version, error) {
 	// version format: "5.7.10-TiDB-v2.1.0-rc.1-7-g38c939f"
 	//                               ^~~~~~~~~^ we only want this part
@@ -1215,9 +1656,12 @@ func checkVersion(component string, expected, actual semver.Version) error {
 }
 
 func (rc *RestoreController) cleanCheckpoints(ctx context.Context) error {
-	if !rc.cfg.Checkpoint.Enable {
+	if !rc.cfg.Checkpoint.Enable || !rc.leaser.canFinalize() {
 		return nil
 	}
+	if err := rc.leaser.waitForOthers(ctx); err != nil {
+		return errors.Trace(err)
+	}
 
 	logger := log.With(
 		zap.Bool("keepAfterSuccess", rc.cfg.Checkpoint.KeepAfterSuccess),
@@ -1285,6 +1729,27 @@ type TableRestore struct {
 	encTable  table.Table
 	alloc     autoid.Allocators
 	logger    log.Logger
+
+	// rule contains the column mapping settings of the table, and constants
+	// holds the values of its constant columns in the order of the table
+	// columns. The constants are prepended to every source row.
+	rule          *config.TableRule
+	constants     []types.Datum
+	constantIndex map[string]int
+	// maskedColumns are the columns whose source values are masked before
+	// encoding, according to the rule.
+	maskedColumns []maskedColumn
+
+	// timeZone is the time zone used to encode the table.
+	timeZone string
+	// onDuplicate is the action on duplicated rows of the TiDB backend.
+	onDuplicate string
+
+	dupDetector *duplicateDetector
+
+	// remoteChecksum is the checksum computed by TiDB in this run, nil if
+	// not computed.
+	remoteChecksum *RemoteChecksum
 }
 
 func NewTableRestore(
@@ -1311,6 +1776,76 @@ func NewTableRestore(
 	}, nil
 }
 
+// applyRule sets the column mapping rule of the table, after checking that all
+// target columns mentioned in the rule exist.
+func (tr *TableRestore) applyRule(rule *config.TableRule) error {
+	tr.rule = rule
+	tr.constants = nil
+	tr.constantIndex = nil
+	tr.maskedColumns = nil
+	if rule == nil {
+		return nil
+	}
+
+	targetColumns := make(map[string]struct{}, len(tr.tableInfo.Core.Columns))
+	for _, colInfo := range tr.tableInfo.Core.Columns {
+		targetColumns[colInfo.Name.L] = struct{}{}
+	}
+	checkTargetColumn := func(column string, kind string) error {
+		if _, ok := targetColumns[column]; !ok {
+			return errors.Errorf("%s column `%s` of table %s does not exist", kind, column, tr.tableName)
+		}
+		return nil
+	}
+	for _, column := range rule.ColumnMapping {
+		if err := checkTargetColumn(column, "mapped"); err != nil {
+			return err
+		}
+	}
+	for column := range rule.ConstantColumns {
+		if err := checkTargetColumn(column, "constant"); err != nil {
+			return err
+		}
+	}
+	for _, column := range rule.DefaultColumns {
+		if err := checkTargetColumn(column, "default"); err != nil {
+			return err
+		}
+	}
+	for column := range rule.Masks {
+		if err := checkTargetColumn(column, "masked"); err != nil {
+			return err
+		}
+	}
+
+	tr.constantIndex = make(map[string]int, len(rule.ConstantColumns))
+	for _, colInfo := range tr.tableInfo.Core.Columns {
+		if value, ok := rule.ConstantColumns[colInfo.Name.L]; ok {
+			tr.constantIndex[colInfo.Name.L] = len(tr.constants)
+			tr.constants = append(tr.constants, types.NewStringDatum(value))
+		}
+		if mask, ok := rule.Masks[colInfo.Name.L]; ok {
+			masker, err := newColumnMasker(mask)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			tr.maskedColumns = append(tr.maskedColumns, maskedColumn{offset: colInfo.Offset, mask: masker})
+		}
+	}
+	return nil
+}
+
+// withConstants prepends the constant column values to the source row, so
+// the row matches the column permutation computed by initializeColumns.
+func (tr *TableRestore) withConstants(row []types.Datum) []types.Datum {
+	if len(tr.constants) == 0 {
+		return row
+	}
+	res := make([]types.Datum, 0, len(tr.constants)+len(row))
+	res = append(res, tr.constants...)
+	return append(res, row...)
+}
+
 func (tr *TableRestore) Close() {
 	tr.encTable = nil
 	tr.logger.Info("restore done")
@@ -1324,7 +1859,17 @@ func (t *TableRestore) populateChunks(cfg *config.Config, cp *TableCheckpoint) e
 		failpoint.Inject("PopulateChunkTimestamp", func(v failpoint.Value) {
 			timestamp = int64(v.(int))
 		})
+		fingerprints := make(map[string]string)
 		for _, chunk := range chunks {
+			fingerprint, ok := fingerprints[chunk.File]
+			if !ok {
+				fingerprint, err = mydump.FileFingerprint(chunk.File)
+				if err != nil {
+					break
+				}
+				fingerprints[chunk.File] = fingerprint
+			}
+
 			engine, found := cp.Engines[chunk.EngineID]
 			if !found {
 				engine = &EngineCheckpoint{
@@ -1332,6 +1877,10 @@ func (t *TableRestore) populateChunks(cfg *config.Config, cp *TableCheckpoint) e
 				}
 				cp.Engines[chunk.EngineID] = engine
 			}
+			// the row IDs of an incremental import must not reuse the
+			// handles of the existing rows.
+			chunk.Chunk.PrevRowIDMax += cp.RowIDBase
+			chunk.Chunk.RowIDMax += cp.RowIDBase
 			engine.Chunks = append(engine.Chunks, &ChunkCheckpoint{
 				Key: ChunkCheckpointKey{
 					Path:   chunk.File,
@@ -1340,11 +1889,14 @@ func (t *TableRestore) populateChunks(cfg *config.Config, cp *TableCheckpoint) e
 				ColumnPermutation: nil,
 				Chunk:             chunk.Chunk,
 				Timestamp:         timestamp,
+				Fingerprint:       fingerprint,
 			})
 		}
 
 		// Add index engine checkpoint
-		cp.Engines[indexEngineID] = &EngineCheckpoint{Status: CheckpointStatusLoaded}
+		if err == nil {
+			cp.Engines[indexEngineID] = &EngineCheckpoint{Status: CheckpointStatusLoaded}
+		}
 	}
 	task.End(zap.ErrorLevel, err,
 		zap.Int("enginesCnt", len(cp.Engines)),
@@ -1365,26 +1917,77 @@ func (t *TableRestore) populateChunks(cfg *config.Config, cp *TableCheckpoint) e
 //
 // The column permutation of (d, b, a) is set to be [2, 1, -1, 0].
 //
+// The table rule is applied as well: source columns are renamed or ignored,
+// and the target columns are forced to use the default value (-1) or a
+// constant. The constants are prepended to the source row (see
+// `withConstants`), so the positions of the source columns are shifted by the
+// number of constants. Generated columns are never read from the source (-1)
+// since they are always evaluated by the encoder.
+//
 // The argument `columns` _must_ be in lower case.
 func (t *TableRestore) initializeColumns(columns []string, ccp *ChunkCheckpoint) {
 	colPerm := make([]int, 0, len(t.tableInfo.Core.Columns)+1)
 	shouldIncludeRowID := !t.tableInfo.Core.PKIsHandle
 
+	rule := t.rule
+	if rule == nil {
+		rule = &config.TableRule{}
+	}
+	defaultColumns := make(map[string]struct{}, len(rule.DefaultColumns))
+	for _, column := range rule.DefaultColumns {
+		defaultColumns[column] = struct{}{}
+	}
+	// overridePerm returns the permutation of a target column if it is
+	// forced to be a constant or the default value.
+	overridePerm := func(colInfo *model.ColumnInfo) (int, bool) {
+		if k, ok := t.constantIndex[colInfo.Name.L]; ok {
+			return k, true
+		}
+		if _, ok := defaultColumns[colInfo.Name.L]; ok {
+			return -1, true
+		}
+		return 0, false
+	}
+	shift := len(t.constants)
+
 	if len(columns) == 0 {
 		// no provided columns, so use identity permutation.
-		for i := range t.tableInfo.Core.Columns {
-			colPerm = append(colPerm, i)
+		for i, colInfo := range t.tableInfo.Core.Columns {
+			if perm, ok := overridePerm(colInfo); ok {
+				colPerm = append(colPerm, perm)
+			} else if colInfo.IsGenerated() {
+				colPerm = append(colPerm, -1)
+			} else {
+				colPerm = append(colPerm, i+shift)
+			}
 		}
 		if shouldIncludeRowID {
 			colPerm = append(colPerm, -1)
 		}
 	} else {
+		ignoredColumns := make(map[string]struct{}, len(rule.IgnoreColumns))
+		for _, column := range rule.IgnoreColumns {
+			ignoredColumns[column] = struct{}{}
+		}
+		// source columns which do not exist in the table are discarded as
+		// well, just like those in `ignore-columns`.
 		columnMap := make(map[string]int)
 		for i, column := range columns {
-			columnMap[column] = i
+			if _, ok := ignoredColumns[column]; ok {
+				continue
+			}
+			if target, ok := rule.ColumnMapping[column]; ok {
+				column = target
+			}
+			columnMap[column] = i + shift
 		}
 		for _, colInfo := range t.tableInfo.Core.Columns {
-			if i, ok := columnMap[colInfo.Name.L]; ok {
+			if perm, ok := overridePerm(colInfo); ok {
+				colPerm = append(colPerm, perm)
+			} else if colInfo.IsGenerated() {
+				// generated columns are always evaluated by the encoder.
+				colPerm = append(colPerm, -1)
+			} else if i, ok := columnMap[colInfo.Name.L]; ok {
 				colPerm = append(colPerm, i)
 			} else {
 				t.logger.Warn("column missing from data file, going to fill with default value",
@@ -1426,12 +2029,30 @@ func (tr *TableRestore) importKV(ctx context.Context, closedEngine *kv.ClosedEng
 	return nil
 }
 
+// checkDuplicates reports the rows having duplicated primary or unique keys.
+// Such rows will overwrite each other in the importer backend and will surely
+// cause checksum mismatch, so we fail early with a more useful message.
+func (tr *TableRestore) checkDuplicates() error {
+	if tr.dupDetector == nil {
+		return nil
+	}
+
+	task := tr.logger.Begin(zap.InfoLevel, "detect duplicated keys")
+	conflicts, conflictsPath, err := tr.dupDetector.finish()
+	if err == nil && conflicts > 0 {
+		err = errors.Errorf("found %d rows with duplicated primary or unique keys, see %s for details", conflicts, conflictsPath)
+	}
+	task.End(zap.ErrorLevel, err, zap.Int("conflicts", conflicts))
+	return err
+}
+
 // do checksum for each table.
 func (tr *TableRestore) compareChecksum(ctx context.Context, db *sql.DB, localChecksum verify.KVChecksum) error {
 	remoteChecksum, err := DoChecksum(ctx, db, tr.tableName)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	tr.remoteChecksum = remoteChecksum
 
 	if remoteChecksum.Checksum != localChecksum.Sum() ||
 		remoteChecksum.TotalKVs != localChecksum.SumKVS() ||
@@ -1538,17 +2159,35 @@ func increaseGCLifeTime(ctx context.Context, db *sql.DB) (err error) {
 ////////////////////////////////////////////////////////////////
 
 const (
-	maxKVQueueSize  = 128   // Cache at most this number of rows before blocking the encode loop
-	minDeliverBytes = 65536 // 64 KB. batch at least this amount of bytes to reduce number of messages
+	maxEncodeQueueSize = 64 // Cache at most this number of rows per encoder worker before blocking
 )
 
 type deliveredKVs struct {
 	kvs     kv.Row // if kvs is nil, this indicated we've got the last message.
+	size    uint64 // the byte size of kvs, accounted by the kvQueue.
 	columns []string
 	offset  int64
 	rowID   int64
 }
 
+// encodeTask is a row read from a chunk to be encoded.
+type encodeTask struct {
+	row     mydump.Row
+	columns []string
+	// offset and newOffset are the positions where the row starts and ends.
+	offset    int64
+	newOffset int64
+	rowID     int64
+}
+
+// encodeResult is an encodeTask after encoding.
+type encodeResult struct {
+	encodeTask
+	kvs       kv.Row
+	err       error
+	encodeDur time.Duration
+}
+
 type deliverResult struct {
 	totalDur time.Duration
 	err      error
@@ -1556,7 +2195,7 @@ type deliverResult struct {
 
 func (cr *chunkRestore) deliverLoop(
 	ctx context.Context,
-	kvsCh <-chan deliveredKVs,
+	kvsQueue *kvQueue,
 	t *TableRestore,
 	engineID int32,
 	dataEngine, indexEngine *kv.OpenedEngine,
@@ -1573,43 +2212,94 @@ func (cr *chunkRestore) deliverLoop(
 		zap.String("task", "deliver"),
 	)
 
+	minDeliverBytes := uint64(rc.cfg.App.MinDeliverBytes)
+	maxDeliverBytes := uint64(rc.cfg.App.MaxDeliverBytes)
+	writeFlushBytes := uint64(rc.cfg.TikvImporter.WriteFlushBytes)
+
+	// Keep a writer to each engine for the whole chunk, so the connection to
+	// the backend can be reused across batches.
+	dataWriter, err := dataEngine.OpenWriter(ctx)
+	if err != nil {
+		return
+	}
+	defer dataWriter.Close()
+	indexWriter, err := indexEngine.OpenWriter(ctx)
+	if err != nil {
+		return
+	}
+	defer indexWriter.Close()
+
+	// The rows are only guaranteed to be written after flushing the writers,
+	// so the checkpoint can only be saved after that.
+	var unflushedBytes uint64
+	hasUnflushed := false
+
 	for !channelClosed {
 		var dataChecksum, indexChecksum verify.KVChecksum
 		var offset, rowID int64
 		var columns []string
 
-		// Fetch enough KV pairs from the source.
-	populate:
-		for dataChecksum.SumSize()+indexChecksum.SumSize() < minDeliverBytes {
-			select {
-			case d := <-kvsCh:
-				if d.kvs == nil {
-					channelClosed = true
-					break populate
-				}
+		// Fetch enough KV pairs from the source. We wait until the batch
+		// reaches minDeliverBytes, and then keep taking the KV pairs which
+		// are already queued until maxDeliverBytes, so the batch grows when
+		// the encoders are faster than the backend.
+		for {
+			batchSize := dataChecksum.SumSize() + indexChecksum.SumSize()
+			if batchSize >= maxDeliverBytes {
+				break
+			}
 
-				d.kvs.ClassifyAndAppend(&dataKVs, &dataChecksum, &indexKVs, &indexChecksum)
-				columns = d.columns
-				offset = d.offset
-				rowID = d.rowID
-			case <-ctx.Done():
-				err = ctx.Err()
-				return
+			var d deliveredKVs
+			if batchSize < minDeliverBytes {
+				if d, err = kvsQueue.pop(ctx); err != nil {
+					return
+				}
+			} else {
+				var ok bool
+				if d, ok = kvsQueue.tryPop(); !ok {
+					break
+				}
+			}
+			if d.kvs == nil {
+				channelClosed = true
+				break
 			}
+
+			d.kvs.ClassifyAndAppend(&dataKVs, &dataChecksum, &indexKVs, &indexChecksum)
+			columns = d.columns
+			offset = d.offset
+			rowID = d.rowID
 		}
 
 		// Write KVs into the engine
 		start := time.Now()
 
-		if err = dataEngine.WriteRows(ctx, columns, dataKVs); err != nil {
+		if err = dataWriter.AppendRows(ctx, columns, dataKVs); err != nil {
 			deliverLogger.Error("write to data engine failed", log.ShortError(err))
 			return
 		}
-		if err = indexEngine.WriteRows(ctx, columns, indexKVs); err != nil {
+		if err = indexWriter.AppendRows(ctx, columns, indexKVs); err != nil {
 			deliverLogger.Error("write to index engine failed", log.ShortError(err))
 			return
 		}
 
+		if dataChecksum.SumKVS() != 0 || indexChecksum.SumKVS() != 0 {
+			unflushedBytes += dataChecksum.SumSize() + indexChecksum.SumSize()
+			hasUnflushed = true
+		}
+		// No need to flush and save checkpoint if nothing was delivered.
+		shouldFlush := hasUnflushed && (channelClosed || unflushedBytes >= writeFlushBytes)
+		if shouldFlush {
+			if err = dataWriter.Flush(ctx); err != nil {
+				deliverLogger.Error("flush data engine failed", log.ShortError(err))
+				return
+			}
+			if err = indexWriter.Flush(ctx); err != nil {
+				deliverLogger.Error("flush index engine failed", log.ShortError(err))
+				return
+			}
+		}
+
 		deliverDur := time.Since(start)
 		deliverTotalDur += deliverDur
 		metric.BlockDeliverSecondsHistogram.Observe(deliverDur.Seconds())
@@ -1626,11 +2316,14 @@ func (cr *chunkRestore) deliverLoop(
 		// No need to apply a lock since this is the only thread updating these variables.
 		cr.chunk.Checksum.Add(&dataChecksum)
 		cr.chunk.Checksum.Add(&indexChecksum)
-		cr.chunk.Chunk.Offset = offset
-		cr.chunk.Chunk.PrevRowIDMax = rowID
 		if dataChecksum.SumKVS() != 0 || indexChecksum.SumKVS() != 0 {
-			// No need to save checkpoint if nothing was delivered.
+			cr.chunk.Chunk.Offset = offset
+			cr.chunk.Chunk.PrevRowIDMax = rowID
+		}
+		if shouldFlush {
 			cr.saveCheckpoint(t, engineID, rc)
+			unflushedBytes = 0
+			hasUnflushed = false
 		}
 	}
 
@@ -1661,33 +2354,90 @@ func (cr *chunkRestore) saveCheckpoint(t *TableRestore, engineID int32, rc *Rest
 
 func (cr *chunkRestore) encodeLoop(
 	ctx context.Context,
-	kvsCh chan<- deliveredKVs,
+	kvsQueue *kvQueue,
 	t *TableRestore,
 	logger log.Logger,
-	kvEncoder kv.Encoder,
+	kvEncoders []kv.Encoder,
 	deliverCompleteCh <-chan deliverResult,
 	pauser *common.Pauser,
+	rejected *rejectedRows,
 ) (readTotalDur time.Duration, encodeTotalDur time.Duration, err error) {
 	send := func(kvs deliveredKVs) error {
-		select {
-		case kvsCh <- kvs:
-			return nil
-		case <-ctx.Done():
-			return ctx.Err()
-		case deliverResult, ok := <-deliverCompleteCh:
-			if deliverResult.err == nil && !ok {
-				deliverResult.err = ctx.Err()
+		for !kvsQueue.tryPush(kvs) {
+			select {
+			case <-kvsQueue.notFull:
+			case <-ctx.Done():
+				return ctx.Err()
+			case deliverResult, ok := <-deliverCompleteCh:
+				if deliverResult.err == nil && !ok {
+					deliverResult.err = ctx.Err()
+				}
+				if deliverResult.err == nil {
+					deliverResult.err = errors.New("unexpected premature fulfillment")
+					logger.DPanic("unexpected: deliverCompleteCh prematurely fulfilled with no error", zap.Bool("chIsOpen", ok))
+				}
+				return errors.Trace(deliverResult.err)
 			}
-			if deliverResult.err == nil {
-				deliverResult.err = errors.New("unexpected premature fulfillment")
-				logger.DPanic("unexpected: deliverCompleteCh prematurely fulfilled with no error", zap.Bool("chIsOpen", ok))
+		}
+		return nil
+	}
+
+	var targetColumnNames []string
+	deliver := func(res encodeResult) error {
+		encodeTotalDur += res.encodeDur
+
+		if res.err != nil {
+			// error is already logged inside kvEncoder.Encode(), just propagate up
+			// directly unless we could tolerate more rejected rows.
+			encodeErr := errors.Annotatef(res.err, "in file %s at offset %d", &cr.chunk.Key, res.newOffset)
+			if err := rejected.reject(t, &cr.chunk.Key, res.columns, res.row.Row, res.offset, encodeErr); err != nil {
+				return err
 			}
-			return errors.Trace(deliverResult.err)
+			logger.Warn("skipped row which failed to be encoded", zap.Int64("offset", res.offset), log.ShortError(encodeErr))
+			return nil
+		}
+		if err := t.dupDetector.record(&cr.chunk.Key, res.offset, res.kvs); err != nil {
+			return err
 		}
+
+		// the column permutation has been initialized by the reader when
+		// the first row arrives here.
+		if targetColumnNames == nil {
+			targetColumnNames = kv.TargetColumnNames(t.encTable, cr.chunk.ColumnPermutation)
+		}
+		deliverKvStart := time.Now()
+		if err := send(deliveredKVs{kvs: res.kvs, size: res.kvs.Size(), columns: targetColumnNames, offset: res.newOffset, rowID: res.rowID}); err != nil {
+			return err
+		}
+		metric.RowKVDeliverSecondsHistogram.Observe(time.Since(deliverKvStart).Seconds())
+		return nil
 	}
 
+	if len(kvEncoders) == 1 {
+		readTotalDur, err = cr.readLoop(ctx, t, pauser, func(task encodeTask) error {
+			return deliver(cr.encodeRow(logger, t, kvEncoders[0], task))
+		})
+	} else {
+		readTotalDur, err = cr.parallelEncodeLoop(ctx, t, logger, kvEncoders, pauser, deliver)
+	}
+	if err != nil {
+		return
+	}
+
+	err = send(deliveredKVs{kvs: nil})
+	return
+}
+
+// readLoop parses the rows of the chunk and passes them to `handle` in order,
+// until the end of the chunk is reached. The column permutation of the chunk
+// is initialized with the first row if needed.
+func (cr *chunkRestore) readLoop(
+	ctx context.Context,
+	t *TableRestore,
+	pauser *common.Pauser,
+	handle func(encodeTask) error,
+) (readTotalDur time.Duration, err error) {
 	initializedColumns := false
-outside:
 	for {
 		if err = pauser.Wait(ctx); err != nil {
 			return
@@ -1695,7 +2445,7 @@ outside:
 
 		offset, _ := cr.parser.Pos()
 		if offset >= cr.chunk.Chunk.EndOffset {
-			break
+			return
 		}
 
 		start := time.Now()
@@ -1711,7 +2461,8 @@ outside:
 				initializedColumns = true
 			}
 		case io.EOF:
-			break outside
+			err = nil
+			return
 		default:
 			err = errors.Annotatef(err, "in file %s at offset %d", &cr.chunk.Key, newOffset)
 			return
@@ -1722,27 +2473,114 @@ outside:
 		metric.RowReadSecondsHistogram.Observe(readDur.Seconds())
 		metric.RowReadBytesHistogram.Observe(float64(newOffset - offset))
 
-		// sql -> kv
-		lastRow := cr.parser.LastRow()
-		kvs, encodeErr := kvEncoder.Encode(logger, lastRow.Row, lastRow.RowID, cr.chunk.ColumnPermutation)
-		encodeDur := time.Since(start)
-		encodeTotalDur += encodeDur
-		metric.RowEncodeSecondsHistogram.Observe(encodeDur.Seconds())
-
-		if encodeErr != nil {
-			// error is already logged inside kvEncoder.Encode(), just propagate up directly.
-			err = errors.Annotatef(encodeErr, "in file %s at offset %d", &cr.chunk.Key, newOffset)
+		if err = handle(encodeTask{
+			row:       cr.parser.LastRow(),
+			columns:   columnNames,
+			offset:    offset,
+			newOffset: newOffset,
+			rowID:     rowID,
+		}); err != nil {
 			return
 		}
+	}
+}
 
-		deliverKvStart := time.Now()
-		if err = send(deliveredKVs{kvs: kvs, columns: columnNames, offset: newOffset, rowID: rowID}); err != nil {
-			return
+// encodeRow converts a row into KV pairs (sql -> kv).
+func (cr *chunkRestore) encodeRow(logger log.Logger, t *TableRestore, kvEncoder kv.Encoder, task encodeTask) encodeResult {
+	start := time.Now()
+	var kvs kv.Row
+	err := t.maskRow(task.row.Row, cr.chunk.ColumnPermutation)
+	if err == nil {
+		kvs, err = kvEncoder.Encode(logger, t.withConstants(task.row.Row), task.row.RowID, cr.chunk.ColumnPermutation)
+	}
+	encodeDur := time.Since(start)
+	metric.RowEncodeSecondsHistogram.Observe(encodeDur.Seconds())
+	return encodeResult{encodeTask: task, kvs: kvs, err: err, encodeDur: encodeDur}
+}
+
+// parallelEncodeLoop reads the rows in a separate goroutine and encodes them
+// with one worker per encoder. The rows are dispatched to the workers in a
+// round-robin fashion, and the results are collected in the same order, so
+// that `deliver` receives the rows in the order they are read and the offsets
+// and row IDs in the checkpoints stay monotonic.
+func (cr *chunkRestore) parallelEncodeLoop(
+	ctx context.Context,
+	t *TableRestore,
+	logger log.Logger,
+	kvEncoders []kv.Encoder,
+	pauser *common.Pauser,
+	deliver func(encodeResult) error,
+) (readTotalDur time.Duration, err error) {
+	encodeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := len(kvEncoders)
+	taskChs := make([]chan encodeTask, 0, concurrency)
+	resultChs := make([]chan encodeResult, 0, concurrency)
+	var wg sync.WaitGroup
+
+	for _, kvEncoder := range kvEncoders {
+		taskCh := make(chan encodeTask, maxEncodeQueueSize)
+		resultCh := make(chan encodeResult, maxEncodeQueueSize)
+		taskChs = append(taskChs, taskCh)
+		resultChs = append(resultChs, resultCh)
+
+		wg.Add(1)
+		go func(kvEncoder kv.Encoder) {
+			defer wg.Done()
+			defer close(resultCh)
+			for task := range taskCh {
+				select {
+				case resultCh <- cr.encodeRow(logger, t, kvEncoder, task):
+				case <-encodeCtx.Done():
+					return
+				}
+			}
+		}(kvEncoder)
+	}
+
+	var readErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			for _, taskCh := range taskChs {
+				close(taskCh)
+			}
+		}()
+		seq := 0
+		readTotalDur, readErr = cr.readLoop(encodeCtx, t, pauser, func(task encodeTask) error {
+			select {
+			case taskChs[seq%concurrency] <- task:
+				seq++
+				return nil
+			case <-encodeCtx.Done():
+				return encodeCtx.Err()
+			}
+		})
+	}()
+
+	// a closed result channel means the reader has stopped before producing
+	// the row with this sequence number, so there are no more rows.
+	for seq := 0; ; seq++ {
+		res, ok := <-resultChs[seq%concurrency]
+		if !ok {
+			break
 		}
-		metric.RowKVDeliverSecondsHistogram.Observe(time.Since(deliverKvStart).Seconds())
+		if err = deliver(res); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		// the workers may have quit early if the context is canceled.
+		err = ctx.Err()
 	}
 
-	err = send(deliveredKVs{kvs: nil})
+	cancel()
+	wg.Wait()
+	if err == nil {
+		err = readErr
+	}
 	return
 }
 
@@ -1753,24 +2591,34 @@ func (cr *chunkRestore) restore(
 	dataEngine, indexEngine *kv.OpenedEngine,
 	rc *RestoreController,
 ) error {
-	// Create the encoder.
-	kvEncoder := rc.backend.NewEncoder(t.encTable, &kv.SessionOptions{
-		SQLMode:          rc.cfg.TiDB.SQLMode,
-		Timestamp:        cr.chunk.Timestamp,
-		RowFormatVersion: rc.rowFormatVer,
-	})
-	kvsCh := make(chan deliveredKVs, maxKVQueueSize)
-	deliverCompleteCh := make(chan deliverResult)
-
+	// Create the encoders, one for each encoder worker.
+	kvEncoders := make([]kv.Encoder, 0, rc.cfg.App.EncodeConcurrency)
 	defer func() {
-		kvEncoder.Close()
-		kvEncoder = nil
-		close(kvsCh)
+		for _, kvEncoder := range kvEncoders {
+			kvEncoder.Close()
+		}
 	}()
+	for i := 0; i < rc.cfg.App.EncodeConcurrency; i++ {
+		kvEncoder, err := rc.backend.NewEncoder(t.encTable, &kv.SessionOptions{
+			SQLMode:          rc.cfg.TiDB.SQLMode,
+			Timestamp:        cr.chunk.Timestamp,
+			RowFormatVersion: rc.rowFormatVer,
+			TimeZone:         t.timeZone,
+			OnDuplicate:      t.onDuplicate,
+		})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		kvEncoders = append(kvEncoders, kvEncoder)
+	}
+	kvsQueue := newKVQueue(uint64(rc.cfg.App.KVQueueBytes))
+	deliverCompleteCh := make(chan deliverResult)
+
+	defer kvsQueue.close()
 
 	go func() {
 		defer close(deliverCompleteCh)
-		dur, err := cr.deliverLoop(ctx, kvsCh, t, engineID, dataEngine, indexEngine, rc)
+		dur, err := cr.deliverLoop(ctx, kvsQueue, t, engineID, dataEngine, indexEngine, rc)
 		select {
 		case <-ctx.Done():
 		case deliverCompleteCh <- deliverResult{dur, err}:
@@ -1783,7 +2631,7 @@ func (cr *chunkRestore) restore(
 		zap.Stringer("path", &cr.chunk.Key),
 	).Begin(zap.InfoLevel, "restore file")
 
-	readTotalDur, encodeTotalDur, err := cr.encodeLoop(ctx, kvsCh, t, logTask.Logger, kvEncoder, deliverCompleteCh, rc.pauser)
+	readTotalDur, encodeTotalDur, err := cr.encodeLoop(ctx, kvsQueue, t, logTask.Logger, kvEncoders, deliverCompleteCh, rc.pauser, rc.rejectedRows)
 	if err != nil {
 		return err
 	}
@@ -1796,6 +2644,7 @@ func (cr *chunkRestore) restore(
 			zap.Duration("deliverDur", deliverResult.totalDur),
 			zap.Object("checksum", &cr.chunk.Checksum),
 		)
+		rc.report.addChunkDurations(t.tableName, readTotalDur, encodeTotalDur, deliverResult.totalDur)
 		return errors.Trace(deliverResult.err)
 	case <-ctx.Done():
 		return ctx.Err()
@@ -21,8 +21,11 @@ import (
 	// "encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strings"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/golang/mock/gomock"
@@ -44,7 +47,10 @@ import (
 	"github.com/pingcap/tidb-lightning/lightning/worker"
 	"github.com/pingcap/tidb-lightning/mock"
 	"github.com/pingcap/tidb/ddl"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/types"
 	tmock "github.com/pingcap/tidb/util/mock"
+	"github.com/pingcap/tidb/util/timeutil"
 	uuid "github.com/satori/go.uuid"
 )
 
@@ -119,6 +125,41 @@ func (s *restoreSuite) TestErrorSummaries(c *C) {
 	})
 }
 
+func (s *restoreSuite) TestCheckTaskCheckpoint(c *C) {
+	ctx := context.Background()
+	cpdb, err := NewFileCheckpointsDB(filepath.Join(c.MkDir(), "cp.pb"), 1234)
+	c.Assert(err, IsNil)
+	defer cpdb.Close()
+
+	cfg := config.NewConfig()
+	cfg.TaskID = 1234
+	rc := &RestoreController{cfg: cfg, checkpointsDB: cpdb}
+
+	// the settings of a new task are recorded.
+	c.Assert(rc.checkTaskCheckpoint(ctx), IsNil)
+	taskCp, err := cpdb.TaskCheckpoint(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(taskCp, DeepEquals, &TaskCheckpoint{TaskID: 1234, ConfigFingerprint: cfg.Fingerprint()})
+
+	// resuming with the same settings is fine.
+	cfg.TaskID = 5678
+	cfg.App.RegionConcurrency++
+	c.Assert(rc.checkTaskCheckpoint(ctx), IsNil)
+
+	// resuming with changed data-affecting settings is rejected.
+	cfg.Mydumper.CSV.Separator = "|"
+	err = rc.checkTaskCheckpoint(ctx)
+	c.Assert(err, ErrorMatches, "(?s)the configuration has been changed since the checkpoints were created by task 1234:\n"+
+		"\tmydumper.csv.separator: \",\" -> \"\\|\"\n.*")
+
+	// ... unless explicitly allowed, and the new settings are recorded.
+	cfg.Checkpoint.AllowConfigChange = true
+	c.Assert(rc.checkTaskCheckpoint(ctx), IsNil)
+	taskCp, err = cpdb.TaskCheckpoint(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(taskCp, DeepEquals, &TaskCheckpoint{TaskID: 1234, ConfigFingerprint: cfg.Fingerprint()})
+}
+
 func MockDoChecksumCtx() context.Context {
 	ctx := context.Background()
 	manager := newGCLifeTimeManager()
@@ -334,6 +375,55 @@ func (s *tableRestoreSuite) SetUpTest(c *C) {
 	s.cfg.App.TableConcurrency = 2
 }
 
+func (s *tableRestoreSuite) TestPopulateChunksIncremental(c *C) {
+	ctx := context.Background()
+	cp := &TableCheckpoint{
+		Engines:   make(map[int32]*EngineCheckpoint),
+		RowIDBase: 1000,
+	}
+	c.Assert(s.tr.populateChunks(s.cfg, cp), IsNil)
+
+	// the row IDs of the chunks start after the existing handles.
+	var chunks []*ChunkCheckpoint
+	for _, engine := range cp.Engines {
+		chunks = append(chunks, engine.Chunks...)
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].Chunk.RowIDMax < chunks[j].Chunk.RowIDMax
+	})
+	c.Assert(chunks, HasLen, len(s.tr.tableMeta.DataFiles))
+	c.Assert(chunks[0].Chunk.PrevRowIDMax, Equals, int64(1000))
+	for i := 1; i < len(chunks); i++ {
+		c.Assert(chunks[i].Chunk.PrevRowIDMax, Equals, chunks[i-1].Chunk.RowIDMax)
+	}
+
+	// so the rows are encoded with new handles, instead of overwriting the
+	// existing rows.
+	cr, err := newChunkRestore(0, s.cfg, chunks[0], worker.NewPool(ctx, 1, "io"))
+	c.Assert(err, IsNil)
+	defer cr.close()
+	kvEncoder, err := kv.NewTableKVEncoder(s.tr.encTable, &kv.SessionOptions{
+		SQLMode:          s.cfg.TiDB.SQLMode,
+		Timestamp:        1234567895,
+		RowFormatVersion: "1",
+	})
+	c.Assert(err, IsNil)
+	kvsQueue := newKVQueue(1024)
+	_, _, err = cr.encodeLoop(ctx, kvsQueue, s.tr, s.tr.logger, []kv.Encoder{kvEncoder}, make(chan deliverResult), DeliverPauser, nil)
+	c.Assert(err, IsNil)
+
+	firstKVs, _ := kvsQueue.tryPop()
+	c.Assert(firstKVs.rowID, Equals, int64(1001))
+	var handles []int64
+	for _, pair := range kv.KvPairsFromRow(firstKVs.kvs) {
+		// skips the index keys.
+		if handle, err := tablecodec.DecodeRowKey(pair.Key); err == nil {
+			handles = append(handles, handle)
+		}
+	}
+	c.Assert(handles, DeepEquals, []int64{1001})
+}
+
 func (s *tableRestoreSuite) TestPopulateChunks(c *C) {
 	failpoint.Enable("github.com/pingcap/tidb-lightning/lightning/restore/PopulateChunkTimestamp", "return(1234567897)")
 	defer failpoint.Disable("github.com/pingcap/tidb-lightning/lightning/restore/PopulateChunkTimestamp")
@@ -344,6 +434,13 @@ func (s *tableRestoreSuite) TestPopulateChunks(c *C) {
 	err := s.tr.populateChunks(s.cfg, cp)
 	c.Assert(err, IsNil)
 
+	fingerprints := make([]string, 0, len(s.tr.tableMeta.DataFiles))
+	for _, path := range s.tr.tableMeta.DataFiles {
+		fingerprint, err := mydump.FileFingerprint(path)
+		c.Assert(err, IsNil)
+		fingerprints = append(fingerprints, fingerprint)
+	}
+
 	c.Assert(cp.Engines, DeepEquals, map[int32]*EngineCheckpoint{
 		-1: {
 			Status: CheckpointStatusLoaded,
@@ -359,7 +456,8 @@ func (s *tableRestoreSuite) TestPopulateChunks(c *C) {
 						PrevRowIDMax: 0,
 						RowIDMax:     18,
 					},
-					Timestamp: 1234567897,
+					Timestamp:   1234567897,
+					Fingerprint: fingerprints[0],
 				},
 				{
 					Key: ChunkCheckpointKey{Path: s.tr.tableMeta.DataFiles[1], Offset: 0},
@@ -369,7 +467,8 @@ func (s *tableRestoreSuite) TestPopulateChunks(c *C) {
 						PrevRowIDMax: 18,
 						RowIDMax:     36,
 					},
-					Timestamp: 1234567897,
+					Timestamp:   1234567897,
+					Fingerprint: fingerprints[1],
 				},
 				{
 					Key: ChunkCheckpointKey{Path: s.tr.tableMeta.DataFiles[2], Offset: 0},
@@ -379,7 +478,8 @@ func (s *tableRestoreSuite) TestPopulateChunks(c *C) {
 						PrevRowIDMax: 36,
 						RowIDMax:     54,
 					},
-					Timestamp: 1234567897,
+					Timestamp:   1234567897,
+					Fingerprint: fingerprints[2],
 				},
 			},
 		},
@@ -394,7 +494,8 @@ func (s *tableRestoreSuite) TestPopulateChunks(c *C) {
 						PrevRowIDMax: 54,
 						RowIDMax:     72,
 					},
-					Timestamp: 1234567897,
+					Timestamp:   1234567897,
+					Fingerprint: fingerprints[3],
 				},
 				{
 					Key: ChunkCheckpointKey{Path: s.tr.tableMeta.DataFiles[4], Offset: 0},
@@ -404,7 +505,8 @@ func (s *tableRestoreSuite) TestPopulateChunks(c *C) {
 						PrevRowIDMax: 72,
 						RowIDMax:     90,
 					},
-					Timestamp: 1234567897,
+					Timestamp:   1234567897,
+					Fingerprint: fingerprints[4],
 				},
 				{
 					Key: ChunkCheckpointKey{Path: s.tr.tableMeta.DataFiles[5], Offset: 0},
@@ -414,13 +516,156 @@ func (s *tableRestoreSuite) TestPopulateChunks(c *C) {
 						PrevRowIDMax: 90,
 						RowIDMax:     108,
 					},
-					Timestamp: 1234567897,
+					Timestamp:   1234567897,
+					Fingerprint: fingerprints[5],
 				},
 			},
 		},
 	})
 }
 
+func (s *tableRestoreSuite) TestVerifyFingerprints(c *C) {
+	dir := c.MkDir()
+	unchangedPath := filepath.Join(dir, "db.t.1.sql")
+	changedPath := filepath.Join(dir, "db.t.2.sql")
+	missingPath := filepath.Join(dir, "db.t.3.sql")
+	for _, path := range []string{unchangedPath, changedPath, missingPath} {
+		c.Assert(ioutil.WriteFile(path, []byte("INSERT INTO t VALUES (1);"), 0644), IsNil)
+	}
+	fingerprints := make(map[string]string)
+	for _, path := range []string{unchangedPath, changedPath, missingPath} {
+		fingerprint, err := mydump.FileFingerprint(path)
+		c.Assert(err, IsNil)
+		fingerprints[path] = fingerprint
+	}
+
+	newChunk := func(path string, fingerprint string) *ChunkCheckpoint {
+		return &ChunkCheckpoint{
+			Key:         ChunkCheckpointKey{Path: path},
+			Fingerprint: fingerprint,
+		}
+	}
+	cp := &TableCheckpoint{
+		Engines: map[int32]*EngineCheckpoint{
+			0: {Chunks: []*ChunkCheckpoint{
+				newChunk(unchangedPath, fingerprints[unchangedPath]),
+				newChunk(changedPath, fingerprints[changedPath]),
+			}},
+			1: {Chunks: []*ChunkCheckpoint{
+				newChunk(missingPath, fingerprints[missingPath]),
+			}},
+		},
+	}
+	tr := &TableRestore{tableName: "`db`.`t`", logger: log.L()}
+	c.Assert(tr.verifyFingerprints(cp), IsNil)
+
+	// chunks recorded without fingerprints are not verified.
+	cp.Engines[2] = &EngineCheckpoint{Chunks: []*ChunkCheckpoint{
+		newChunk(filepath.Join(dir, "db.t.4.sql"), ""),
+	}}
+	c.Assert(tr.verifyFingerprints(cp), IsNil)
+
+	c.Assert(ioutil.WriteFile(changedPath, []byte("INSERT INTO t VALUES (2);"), 0644), IsNil)
+	c.Assert(os.Remove(missingPath), IsNil)
+	err := tr.verifyFingerprints(cp)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Matches, "source files of table `db`.`t` have changed since the checkpoint was created: "+
+		regexp.QuoteMeta(changedPath+", "+missingPath)+"; .*")
+}
+
+func (s *tableRestoreSuite) TestResolveTimeZone(c *C) {
+	cfg := config.NewConfig()
+	cfg.TiDB.TimeZone = "+08:00"
+	cfg.TikvImporter.Backend = config.BackendImporter
+	rc := &RestoreController{cfg: cfg}
+	tr := &TableRestore{tableName: "`db`.`t`", logger: log.L()}
+
+	// a new table records the configured time zone.
+	cp := &TableCheckpoint{}
+	c.Assert(tr.resolveTimeZone(rc, cp), IsNil)
+	c.Assert(tr.timeZone, Equals, "+08:00")
+	c.Assert(cp.TimeZone, Equals, "+08:00")
+
+	// a resumed table keeps the time zone in the checkpoint.
+	cp = &TableCheckpoint{TimeZone: "Asia/Tokyo"}
+	c.Assert(tr.resolveTimeZone(rc, cp), IsNil)
+	c.Assert(tr.timeZone, Equals, "Asia/Tokyo")
+	c.Assert(cp.TimeZone, Equals, "Asia/Tokyo")
+
+	// ... unless the TiDB backend is used, which cannot switch the time zone.
+	cfg.TikvImporter.Backend = config.BackendTiDB
+	err := tr.resolveTimeZone(rc, cp)
+	c.Assert(err, ErrorMatches, "table `db`.`t` was partially imported with the time zone Asia/Tokyo, .*")
+
+	// without the time zone configured, the encoder uses the system one.
+	cfg.TiDB.TimeZone = ""
+	cp = &TableCheckpoint{}
+	c.Assert(tr.resolveTimeZone(rc, cp), IsNil)
+	c.Assert(tr.timeZone, Equals, timeutil.InferSystemTZ())
+	c.Assert(cp.TimeZone, Equals, timeutil.InferSystemTZ())
+}
+
+func (s *tableRestoreSuite) TestResolveSettings(c *C) {
+	cfg := config.NewConfig()
+	cfg.TiDB.TimeZone = "+08:00"
+	cfg.TikvImporter.Backend = config.BackendTiDB
+	cfg.TikvImporter.OnDuplicate = config.ReplaceOnDup
+	saveCpCh := make(chan saveCp, 2)
+	rc := &RestoreController{cfg: cfg, saveCpCh: saveCpCh}
+	tr := &TableRestore{tableName: "`db`.`t`", logger: log.L()}
+
+	// all settings of a new table are saved together.
+	cp := &TableCheckpoint{}
+	c.Assert(tr.resolveSettings(context.Background(), rc, cp), IsNil)
+	c.Assert(saveCpCh, HasLen, 1)
+	c.Assert((<-saveCpCh).merger, DeepEquals, &TableSettingsCheckpointMerger{
+		TimeZone:    "+08:00",
+		OnDuplicate: config.ReplaceOnDup,
+	})
+
+	// nothing is saved if unchanged.
+	c.Assert(tr.resolveSettings(context.Background(), rc, cp), IsNil)
+	c.Assert(saveCpCh, HasLen, 0)
+
+	// the other settings are saved as-is when any of them changes.
+	tr.rule = &config.TableRule{OnDuplicate: config.UpsertOnDup}
+	c.Assert(tr.resolveSettings(context.Background(), rc, cp), IsNil)
+	c.Assert(saveCpCh, HasLen, 1)
+	c.Assert((<-saveCpCh).merger, DeepEquals, &TableSettingsCheckpointMerger{
+		TimeZone:    "+08:00",
+		OnDuplicate: config.UpsertOnDup,
+	})
+}
+
+func (s *tableRestoreSuite) TestResolveOnDuplicate(c *C) {
+	cfg := config.NewConfig()
+	cfg.TikvImporter.Backend = config.BackendTiDB
+	cfg.TikvImporter.OnDuplicate = config.ReplaceOnDup
+	rc := &RestoreController{cfg: cfg}
+	tr := &TableRestore{tableName: "`db`.`t`", logger: log.L()}
+
+	// a new table records the global action.
+	cp := &TableCheckpoint{}
+	tr.resolveOnDuplicate(rc, cp)
+	c.Assert(tr.onDuplicate, Equals, config.ReplaceOnDup)
+	c.Assert(cp.OnDuplicate, Equals, config.ReplaceOnDup)
+
+	// the rule of the table overrides the global action, even if the table
+	// was partially written with another one.
+	tr.rule = &config.TableRule{OnDuplicate: config.UpsertOnDup}
+	tr.resolveOnDuplicate(rc, cp)
+	c.Assert(tr.onDuplicate, Equals, config.UpsertOnDup)
+	c.Assert(cp.OnDuplicate, Equals, config.UpsertOnDup)
+
+	// the action is irrelevant to the importer backend.
+	cfg.TikvImporter.Backend = config.BackendImporter
+	tr.onDuplicate = ""
+	cp = &TableCheckpoint{}
+	tr.resolveOnDuplicate(rc, cp)
+	c.Assert(tr.onDuplicate, Equals, "")
+	c.Assert(cp.OnDuplicate, Equals, "")
+}
+
 func (s *tableRestoreSuite) TestInitializeColumns(c *C) {
 	ccp := &ChunkCheckpoint{}
 	s.tr.initializeColumns(nil, ccp)
@@ -437,6 +682,41 @@ func (s *tableRestoreSuite) TestInitializeColumns(c *C) {
 	ccp.ColumnPermutation = nil
 	s.tr.initializeColumns([]string{"_tidb_rowid", "b", "a", "c"}, ccp)
 	c.Assert(ccp.ColumnPermutation, DeepEquals, []int{2, 1, 3, 0})
+
+	// unknown source columns are discarded.
+	ccp.ColumnPermutation = nil
+	s.tr.initializeColumns([]string{"a", "d", "b", "c"}, ccp)
+	c.Assert(ccp.ColumnPermutation, DeepEquals, []int{0, 2, 3, -1})
+}
+
+func (s *tableRestoreSuite) TestInitializeColumnsWithRule(c *C) {
+	err := s.tr.applyRule(&config.TableRule{
+		ColumnMapping:   map[string]string{"old_b": "b"},
+		IgnoreColumns:   []string{"x"},
+		ConstantColumns: map[string]string{"c": "99"},
+	})
+	c.Assert(err, IsNil)
+	defer s.tr.applyRule(nil)
+
+	// the constant of `c` is prepended to the row, so the source columns
+	// are shifted by 1.
+	ccp := &ChunkCheckpoint{}
+	s.tr.initializeColumns([]string{"x", "old_b", "c", "a"}, ccp)
+	c.Assert(ccp.ColumnPermutation, DeepEquals, []int{4, 2, 0, -1})
+	c.Assert(s.tr.withConstants(types.MakeDatums(1, 2, 3, 4)), DeepEquals, types.MakeDatums("99", 1, 2, 3, 4))
+
+	ccp.ColumnPermutation = nil
+	s.tr.initializeColumns(nil, ccp)
+	c.Assert(ccp.ColumnPermutation, DeepEquals, []int{1, 2, 0, -1})
+
+	err = s.tr.applyRule(&config.TableRule{DefaultColumns: []string{"a"}})
+	c.Assert(err, IsNil)
+	ccp.ColumnPermutation = nil
+	s.tr.initializeColumns([]string{"a", "b", "c"}, ccp)
+	c.Assert(ccp.ColumnPermutation, DeepEquals, []int{-1, 1, 2, -1})
+
+	err = s.tr.applyRule(&config.TableRule{ColumnMapping: map[string]string{"a": "z"}})
+	c.Assert(err, ErrorMatches, "mapped column `z` of table `db`.`table` does not exist")
 }
 
 func (s *tableRestoreSuite) TestCompareChecksumSuccess(c *C) {
@@ -590,12 +870,26 @@ func (s *chunkRestoreSuite) TearDownTest(c *C) {
 }
 
 func (s *chunkRestoreSuite) TestDeliverLoopCancel(c *C) {
-	rc := &RestoreController{backend: kv.NewMockImporter(nil, "")}
-
 	ctx, cancel := context.WithCancel(context.Background())
-	kvsCh := make(chan deliveredKVs)
+
+	controller := gomock.NewController(c)
+	defer controller.Finish()
+	mockBackend := mock.NewMockBackend(controller)
+	importer := kv.MakeBackend(mockBackend)
+
+	mockBackend.EXPECT().OpenEngine(ctx, gomock.Any()).Return(nil).Times(2)
+	mockBackend.EXPECT().MakeEmptyRows().Return(kv.MakeRowsFromKvPairs(nil)).AnyTimes()
+
+	dataEngine, err := importer.OpenEngine(ctx, s.tr.tableName, 0)
+	c.Assert(err, IsNil)
+	indexEngine, err := importer.OpenEngine(ctx, s.tr.tableName, -1)
+	c.Assert(err, IsNil)
+
+	rc := &RestoreController{cfg: s.cfg, backend: importer}
+
+	kvsQueue := newKVQueue(1024)
 	go cancel()
-	_, err := s.cr.deliverLoop(ctx, kvsCh, s.tr, 0, nil, nil, rc)
+	_, err = s.cr.deliverLoop(ctx, kvsQueue, s.tr, 0, dataEngine, indexEngine, rc)
 	c.Assert(errors.Cause(err), Equals, context.Canceled)
 }
 
@@ -620,17 +914,17 @@ func (s *chunkRestoreSuite) TestDeliverLoopEmptyData(c *C) {
 
 	// Deliver nothing.
 
-	rc := &RestoreController{backend: importer}
+	rc := &RestoreController{cfg: s.cfg, backend: importer}
 
-	kvsCh := make(chan deliveredKVs, 1)
-	kvsCh <- deliveredKVs{}
-	_, err = s.cr.deliverLoop(ctx, kvsCh, s.tr, 0, dataEngine, indexEngine, rc)
+	kvsQueue := newKVQueue(1024)
+	c.Assert(kvsQueue.tryPush(deliveredKVs{}), IsTrue)
+	_, err = s.cr.deliverLoop(ctx, kvsQueue, s.tr, 0, dataEngine, indexEngine, rc)
 	c.Assert(err, IsNil)
 }
 
 func (s *chunkRestoreSuite) TestDeliverLoop(c *C) {
 	ctx := context.Background()
-	kvsCh := make(chan deliveredKVs)
+	kvsQueue := newKVQueue(1024)
 	mockCols := []string{"c1", "c2"}
 
 	// Open two mock engines.
@@ -681,7 +975,7 @@ func (s *chunkRestoreSuite) TestDeliverLoop(c *C) {
 
 	saveCpCh := make(chan saveCp, 2)
 	go func() {
-		kvsCh <- deliveredKVs{
+		kvsQueue.tryPush(deliveredKVs{
 			kvs: kv.MakeRowFromKvPairs([]common.KvPair{
 				{
 					Key: []byte("txxxxxxxx_ryyyyyyyy"),
@@ -699,14 +993,13 @@ func (s *chunkRestoreSuite) TestDeliverLoop(c *C) {
 			columns: mockCols,
 			offset:  12,
 			rowID:   76,
-		}
-		kvsCh <- deliveredKVs{}
-		close(kvsCh)
+		})
+		kvsQueue.tryPush(deliveredKVs{})
 	}()
 
-	rc := &RestoreController{saveCpCh: saveCpCh, backend: importer}
+	rc := &RestoreController{cfg: s.cfg, saveCpCh: saveCpCh, backend: importer}
 
-	_, err = s.cr.deliverLoop(ctx, kvsCh, s.tr, 0, dataEngine, indexEngine, rc)
+	_, err = s.cr.deliverLoop(ctx, kvsQueue, s.tr, 0, dataEngine, indexEngine, rc)
 	c.Assert(err, IsNil)
 	c.Assert(saveCpCh, HasLen, 2)
 	c.Assert(s.cr.chunk.Chunk.Offset, Equals, int64(12))
@@ -714,81 +1007,364 @@ func (s *chunkRestoreSuite) TestDeliverLoop(c *C) {
 	c.Assert(s.cr.chunk.Checksum.SumKVS(), Equals, uint64(3))
 }
 
+func (s *chunkRestoreSuite) TestDeliverLoopAdaptiveBatch(c *C) {
+	ctx := context.Background()
+	mockCols := []string{"c1", "c2"}
+
+	controller := gomock.NewController(c)
+	defer controller.Finish()
+	mockBackend := mock.NewMockBackend(controller)
+	importer := kv.MakeBackend(mockBackend)
+
+	mockBackend.EXPECT().OpenEngine(ctx, gomock.Any()).Return(nil).Times(2)
+	mockBackend.EXPECT().MakeEmptyRows().Return(kv.MakeRowsFromKvPairs(nil)).AnyTimes()
+	mockBackend.EXPECT().MaxChunkSize().Return(10000).AnyTimes()
+
+	dataEngine, err := importer.OpenEngine(ctx, s.tr.tableName, 0)
+	c.Assert(err, IsNil)
+	indexEngine, err := importer.OpenEngine(ctx, s.tr.tableName, -1)
+	c.Assert(err, IsNil)
+
+	// Each row is 16 bytes. With min-deliver-bytes = 1, every row is enough
+	// for a batch, but the rows already queued are merged into the same batch
+	// until max-deliver-bytes = 32 is reached.
+	row := func(key string) kv.Row {
+		return kv.MakeRowFromKvPairs([]common.KvPair{{Key: []byte("txxxxxxxx_r" + key), Val: []byte("val")}})
+	}
+	kvsQueue := newKVQueue(1024)
+	for i, key := range []string{"aa", "bb", "cc"} {
+		r := row(key)
+		c.Assert(kvsQueue.tryPush(deliveredKVs{kvs: r, size: r.Size(), columns: mockCols, offset: int64(i + 1), rowID: int64(i + 1)}), IsTrue)
+	}
+	c.Assert(kvsQueue.tryPush(deliveredKVs{}), IsTrue)
+
+	gomock.InOrder(
+		mockBackend.EXPECT().
+			WriteRows(ctx, gomock.Any(), s.tr.tableName, mockCols, gomock.Any(), kv.MakeRowsFromKvPairs([]common.KvPair{
+				{Key: []byte("txxxxxxxx_raa"), Val: []byte("val")},
+				{Key: []byte("txxxxxxxx_rbb"), Val: []byte("val")},
+			})).
+			Return(nil),
+		mockBackend.EXPECT().
+			WriteRows(ctx, gomock.Any(), s.tr.tableName, mockCols, gomock.Any(), kv.MakeRowsFromKvPairs([]common.KvPair{
+				{Key: []byte("txxxxxxxx_rcc"), Val: []byte("val")},
+			})).
+			Return(nil),
+	)
+
+	cfg := config.NewConfig()
+	cfg.App.MinDeliverBytes = 1
+	cfg.App.MaxDeliverBytes = 32
+	cfg.TikvImporter.WriteFlushBytes = 1
+	saveCpCh := make(chan saveCp, 4)
+	rc := &RestoreController{cfg: cfg, saveCpCh: saveCpCh, backend: importer}
+
+	_, err = s.cr.deliverLoop(ctx, kvsQueue, s.tr, 0, dataEngine, indexEngine, rc)
+	c.Assert(err, IsNil)
+	c.Assert(saveCpCh, HasLen, 4)
+	c.Assert(s.cr.chunk.Chunk.Offset, Equals, int64(3))
+	c.Assert(s.cr.chunk.Checksum.SumKVS(), Equals, uint64(3))
+}
+
 func (s *chunkRestoreSuite) TestEncodeLoop(c *C) {
 	ctx := context.Background()
-	kvsCh := make(chan deliveredKVs, 2)
+	kvsQueue := newKVQueue(1024)
 	deliverCompleteCh := make(chan deliverResult)
-	kvEncoder := kv.NewTableKVEncoder(s.tr.encTable, &kv.SessionOptions{
+	kvEncoder, err := kv.NewTableKVEncoder(s.tr.encTable, &kv.SessionOptions{
 		SQLMode:          s.cfg.TiDB.SQLMode,
 		Timestamp:        1234567895,
 		RowFormatVersion: "1",
 	})
+	c.Assert(err, IsNil)
 
-	_, _, err := s.cr.encodeLoop(ctx, kvsCh, s.tr, s.tr.logger, kvEncoder, deliverCompleteCh, DeliverPauser)
+	_, _, err = s.cr.encodeLoop(ctx, kvsQueue, s.tr, s.tr.logger, []kv.Encoder{kvEncoder}, deliverCompleteCh, DeliverPauser, nil)
 	c.Assert(err, IsNil)
-	c.Assert(kvsCh, HasLen, 2)
+	queued := popAll(kvsQueue)
+	c.Assert(queued, HasLen, 2)
 
-	firstKVs := <-kvsCh
+	firstKVs := queued[0]
 	c.Assert(firstKVs.kvs, HasLen, 2)
+	c.Assert(firstKVs.size, Equals, firstKVs.kvs.Size())
 	c.Assert(firstKVs.rowID, Equals, int64(19))
 	c.Assert(firstKVs.offset, Equals, int64(36))
 
-	secondKVs := <-kvsCh
+	secondKVs := queued[1]
 	c.Assert(secondKVs.kvs, IsNil)
 }
 
 func (s *chunkRestoreSuite) TestEncodeLoopCanceled(c *C) {
 	ctx, cancel := context.WithCancel(context.Background())
-	kvsCh := make(chan deliveredKVs)
+	kvsQueue := newKVQueue(1)
+	c.Assert(kvsQueue.tryPush(deliveredKVs{size: 2}), IsTrue)
 	deliverCompleteCh := make(chan deliverResult)
-	kvEncoder := kv.NewTableKVEncoder(s.tr.encTable, &kv.SessionOptions{
+	kvEncoder, err := kv.NewTableKVEncoder(s.tr.encTable, &kv.SessionOptions{
 		SQLMode:          s.cfg.TiDB.SQLMode,
 		Timestamp:        1234567896,
 		RowFormatVersion: "1",
 	})
+	c.Assert(err, IsNil)
 
 	go cancel()
-	_, _, err := s.cr.encodeLoop(ctx, kvsCh, s.tr, s.tr.logger, kvEncoder, deliverCompleteCh, DeliverPauser)
+	_, _, err = s.cr.encodeLoop(ctx, kvsQueue, s.tr, s.tr.logger, []kv.Encoder{kvEncoder}, deliverCompleteCh, DeliverPauser, nil)
 	c.Assert(errors.Cause(err), Equals, context.Canceled)
-	c.Assert(kvsCh, HasLen, 0)
+	c.Assert(popAll(kvsQueue), HasLen, 1)
 }
 
 func (s *chunkRestoreSuite) TestEncodeLoopForcedError(c *C) {
 	ctx := context.Background()
-	kvsCh := make(chan deliveredKVs, 2)
+	kvsQueue := newKVQueue(1024)
 	deliverCompleteCh := make(chan deliverResult)
-	kvEncoder := kv.NewTableKVEncoder(s.tr.encTable, &kv.SessionOptions{
+	kvEncoder, err := kv.NewTableKVEncoder(s.tr.encTable, &kv.SessionOptions{
 		SQLMode:          s.cfg.TiDB.SQLMode,
 		Timestamp:        1234567897,
 		RowFormatVersion: "1",
 	})
+	c.Assert(err, IsNil)
 
 	// close the chunk so reading it will result in the "file already closed" error.
 	s.cr.parser.Close()
 
-	_, _, err := s.cr.encodeLoop(ctx, kvsCh, s.tr, s.tr.logger, kvEncoder, deliverCompleteCh, DeliverPauser)
+	_, _, err = s.cr.encodeLoop(ctx, kvsQueue, s.tr, s.tr.logger, []kv.Encoder{kvEncoder}, deliverCompleteCh, DeliverPauser, nil)
 	c.Assert(err, ErrorMatches, `in file .*[/\\]db\.table\.2\.sql:0 at offset 0:.*file already closed`)
-	c.Assert(kvsCh, HasLen, 0)
+	c.Assert(popAll(kvsQueue), HasLen, 0)
 }
 
 func (s *chunkRestoreSuite) TestEncodeLoopDeliverErrored(c *C) {
 	ctx := context.Background()
-	kvsCh := make(chan deliveredKVs)
+	kvsQueue := newKVQueue(1)
+	c.Assert(kvsQueue.tryPush(deliveredKVs{size: 2}), IsTrue)
 	deliverCompleteCh := make(chan deliverResult)
-	kvEncoder := kv.NewTableKVEncoder(s.tr.encTable, &kv.SessionOptions{
+	kvEncoder, err := kv.NewTableKVEncoder(s.tr.encTable, &kv.SessionOptions{
 		SQLMode:          s.cfg.TiDB.SQLMode,
 		Timestamp:        1234567898,
 		RowFormatVersion: "1",
 	})
+	c.Assert(err, IsNil)
 
 	go func() {
 		deliverCompleteCh <- deliverResult{
 			err: errors.New("fake deliver error"),
 		}
 	}()
-	_, _, err := s.cr.encodeLoop(ctx, kvsCh, s.tr, s.tr.logger, kvEncoder, deliverCompleteCh, DeliverPauser)
+	_, _, err = s.cr.encodeLoop(ctx, kvsQueue, s.tr, s.tr.logger, []kv.Encoder{kvEncoder}, deliverCompleteCh, DeliverPauser, nil)
 	c.Assert(err, ErrorMatches, "fake deliver error")
-	c.Assert(kvsCh, HasLen, 0)
+	c.Assert(popAll(kvsQueue), HasLen, 1)
+}
+
+func (s *chunkRestoreSuite) TestEncodeLoopRejectedRows(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+
+	dataPath := filepath.Join(dir, "db.table.3.sql")
+	err := ioutil.WriteFile(dataPath, []byte("INSERT INTO `table` VALUES ('x', 2, 3), (4, 5, 6), ('y', 8, 9);"), 0644)
+	c.Assert(err, IsNil)
+	chunk := ChunkCheckpoint{
+		Key:   ChunkCheckpointKey{Path: dataPath, Offset: 0},
+		Chunk: mydump.Chunk{Offset: 0, EndOffset: 63, PrevRowIDMax: 0, RowIDMax: 3},
+	}
+	cr, err := newChunkRestore(3, s.cfg, &chunk, worker.NewPool(ctx, 1, "io"))
+	c.Assert(err, IsNil)
+	defer cr.close()
+
+	s.cfg.Mydumper.MaxEncodeErrors = 2
+	s.cfg.Mydumper.RejectedRowsDir = filepath.Join(dir, "rejected")
+	rejected := newRejectedRows(s.cfg)
+	defer rejected.close()
+
+	kvsQueue := newKVQueue(1024)
+	deliverCompleteCh := make(chan deliverResult)
+	kvEncoder, err := kv.NewTableKVEncoder(s.tr.encTable, &kv.SessionOptions{
+		SQLMode:          mysql.ModeStrictAllTables,
+		Timestamp:        1234567899,
+		RowFormatVersion: "1",
+	})
+	c.Assert(err, IsNil)
+
+	_, _, err = cr.encodeLoop(ctx, kvsQueue, s.tr, s.tr.logger, []kv.Encoder{kvEncoder}, deliverCompleteCh, DeliverPauser, rejected)
+	c.Assert(err, IsNil)
+	queued := popAll(kvsQueue)
+	c.Assert(queued, HasLen, 2)
+	c.Assert(queued[0].rowID, Equals, int64(2))
+	c.Assert(queued[1].kvs, IsNil)
+	c.Assert(rejected.count(s.tr.tableName), Equals, int64(2))
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "rejected", "db.table.3.rejected.sql"))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "INSERT INTO `table` VALUES ('x',2,3);\nINSERT INTO `table` VALUES ('y',8,9);\n")
+	reasons, err := ioutil.ReadFile(filepath.Join(dir, "rejected", "db.table.3.rejected.log"))
+	c.Assert(err, IsNil)
+	c.Assert(string(reasons), Matches, "0\t.*\n49\t.*\n")
+
+	// exceeding the limit should fail the chunk.
+	chunk.Chunk.Offset = 0
+	cr2, err := newChunkRestore(3, s.cfg, &chunk, worker.NewPool(ctx, 1, "io"))
+	c.Assert(err, IsNil)
+	defer cr2.close()
+	_, _, err = cr2.encodeLoop(ctx, kvsQueue, s.tr, s.tr.logger, []kv.Encoder{kvEncoder}, deliverCompleteCh, DeliverPauser, rejected)
+	c.Assert(err, ErrorMatches, "too many rows failed to be encoded.*")
+}
+
+func (s *chunkRestoreSuite) TestResumeRejectedRows(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+
+	dataPath := filepath.Join(dir, "db.table.3.sql")
+	err := ioutil.WriteFile(dataPath, []byte("INSERT INTO `table` VALUES ('x', 2, 3), (4, 5, 6), ('y', 8, 9);"), 0644)
+	c.Assert(err, IsNil)
+	chunk := ChunkCheckpoint{
+		Key:   ChunkCheckpointKey{Path: dataPath, Offset: 0},
+		Chunk: mydump.Chunk{Offset: 0, EndOffset: 63, PrevRowIDMax: 0, RowIDMax: 3},
+	}
+	cp := &TableCheckpoint{Engines: map[int32]*EngineCheckpoint{0: {Chunks: []*ChunkCheckpoint{&chunk}}}}
+
+	s.cfg.Mydumper.MaxEncodeErrors = 2
+	s.cfg.Mydumper.RejectedRowsDir = filepath.Join(dir, "rejected")
+	kvEncoder, err := kv.NewTableKVEncoder(s.tr.encTable, &kv.SessionOptions{
+		SQLMode:          mysql.ModeStrictAllTables,
+		Timestamp:        1234567899,
+		RowFormatVersion: "1",
+	})
+	c.Assert(err, IsNil)
+
+	encodeChunk := func(rejected *rejectedRows) error {
+		cr, err := newChunkRestore(3, s.cfg, &chunk, worker.NewPool(ctx, 1, "io"))
+		c.Assert(err, IsNil)
+		defer cr.close()
+		_, _, err = cr.encodeLoop(ctx, newKVQueue(1024), s.tr, s.tr.logger, []kv.Encoder{kvEncoder}, make(chan deliverResult), DeliverPauser, rejected)
+		return err
+	}
+
+	rejected := newRejectedRows(s.cfg)
+	c.Assert(encodeChunk(rejected), IsNil)
+	c.Assert(rejected.count(s.tr.tableName), Equals, int64(2))
+	rejected.close()
+
+	// the previous run was checkpointed right after the second row, so only
+	// the third row is read again.
+	chunk.Chunk.Offset = 49
+	chunk.Chunk.PrevRowIDMax = 2
+	rejected = newRejectedRows(s.cfg)
+	defer rejected.close()
+	c.Assert(rejected.resume(s.tr, cp), IsNil)
+	c.Assert(rejected.count(s.tr.tableName), Equals, int64(1))
+	c.Assert(encodeChunk(rejected), IsNil)
+	c.Assert(rejected.count(s.tr.tableName), Equals, int64(2))
+
+	// the re-read row is not recorded twice.
+	content, err := ioutil.ReadFile(filepath.Join(dir, "rejected", "db.table.3.rejected.sql"))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "INSERT INTO `table` VALUES ('x',2,3);\nINSERT INTO `table` VALUES ('y',8,9);\n")
+	reasons, err := ioutil.ReadFile(filepath.Join(dir, "rejected", "db.table.3.rejected.log"))
+	c.Assert(err, IsNil)
+	c.Assert(string(reasons), Matches, "0\t[^\n]*\n49\t[^\n]*\n")
+
+	// the limit covers the rows rejected by the previous run.
+	chunk.Chunk.Offset = 0
+	chunk.Chunk.PrevRowIDMax = 0
+	c.Assert(encodeChunk(rejected), ErrorMatches, "too many rows failed to be encoded.*")
+
+	// a table restored from scratch removes the files of previous runs.
+	c.Assert(rejected.reset(s.tr, cp), IsNil)
+	_, err = os.Stat(filepath.Join(dir, "rejected", "db.table.3.rejected.sql"))
+	c.Assert(os.IsNotExist(err), IsTrue)
+	_, err = os.Stat(filepath.Join(dir, "rejected", "db.table.3.rejected.log"))
+	c.Assert(os.IsNotExist(err), IsTrue)
+}
+
+func (s *chunkRestoreSuite) TestRejectedRowsFilePrefix(c *C) {
+	cfg := config.NewConfig()
+	cfg.Mydumper.SourceDir = "/data/export"
+	cfg.Mydumper.RejectedRowsDir = "/data/rejected"
+	rejected := newRejectedRows(cfg)
+
+	// data files of the same name in different subdirectories are kept apart.
+	c.Assert(rejected.filePrefix("/data/export/db.t.1.sql"), Equals, "/data/rejected/db.t.1.rejected")
+	c.Assert(rejected.filePrefix("/data/export/a/db.t.1.sql"), Equals, "/data/rejected/a/db.t.1.rejected")
+	c.Assert(rejected.filePrefix("/data/export/b/db.t.1.sql"), Equals, "/data/rejected/b/db.t.1.rejected")
+	dataPath, isCSV := rejected.dataFilePath("/data/export/b/db.t.1.CSV")
+	c.Assert(dataPath, Equals, "/data/rejected/b/db.t.1.rejected.CSV")
+	c.Assert(isCSV, IsTrue)
+
+	// files outside of the source directory only keep their base names.
+	c.Assert(rejected.filePrefix("/tmp/db.t.1.sql"), Equals, "/data/rejected/db.t.1.rejected")
+}
+
+func (s *chunkRestoreSuite) TestParallelEncodeLoop(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+
+	var data strings.Builder
+	data.WriteString("INSERT INTO `table` VALUES ")
+	for i := 1; i <= 50; i++ {
+		if i > 1 {
+			data.WriteString(", ")
+		}
+		if i == 20 {
+			data.WriteString("('x', 0, 0)")
+		} else {
+			fmt.Fprintf(&data, "(%d, %d, %d)", i, i, i)
+		}
+	}
+	data.WriteString(";")
+	dataPath := filepath.Join(dir, "db.table.4.sql")
+	err := ioutil.WriteFile(dataPath, []byte(data.String()), 0644)
+	c.Assert(err, IsNil)
+	chunk := ChunkCheckpoint{
+		Key:   ChunkCheckpointKey{Path: dataPath, Offset: 0},
+		Chunk: mydump.Chunk{Offset: 0, EndOffset: int64(data.Len()), PrevRowIDMax: 0, RowIDMax: 50},
+	}
+	cr, err := newChunkRestore(4, s.cfg, &chunk, worker.NewPool(ctx, 1, "io"))
+	c.Assert(err, IsNil)
+	defer cr.close()
+
+	s.cfg.Mydumper.MaxEncodeErrors = 1
+	s.cfg.Mydumper.RejectedRowsDir = filepath.Join(dir, "rejected")
+	rejected := newRejectedRows(s.cfg)
+	defer rejected.close()
+
+	kvEncoders := make([]kv.Encoder, 0, 3)
+	for i := 0; i < 3; i++ {
+		kvEncoder, err := kv.NewTableKVEncoder(s.tr.encTable, &kv.SessionOptions{
+			SQLMode:          mysql.ModeStrictAllTables,
+			Timestamp:        1234567899,
+			RowFormatVersion: "1",
+		})
+		c.Assert(err, IsNil)
+		kvEncoders = append(kvEncoders, kvEncoder)
+	}
+
+	kvsQueue := newKVQueue(1 << 20)
+	deliverCompleteCh := make(chan deliverResult)
+	_, _, err = cr.encodeLoop(ctx, kvsQueue, s.tr, s.tr.logger, kvEncoders, deliverCompleteCh, DeliverPauser, rejected)
+	c.Assert(err, IsNil)
+	queued := popAll(kvsQueue)
+	c.Assert(queued, HasLen, 50)
+	c.Assert(rejected.count(s.tr.tableName), Equals, int64(1))
+
+	// the rows are delivered in order, except the rejected one.
+	lastOffset := int64(0)
+	for i := int64(1); i <= 50; i++ {
+		if i == 20 {
+			continue
+		}
+		kvs := queued[0]
+		queued = queued[1:]
+		c.Assert(kvs.rowID, Equals, i)
+		c.Assert(kvs.offset > lastOffset, IsTrue)
+		lastOffset = kvs.offset
+	}
+	c.Assert(lastOffset, Equals, int64(data.Len()-1))
+	c.Assert(queued[0].kvs, IsNil)
+
+	// exceeding the limit should fail the chunk and stop all workers.
+	chunk.Chunk.Offset = 0
+	chunk.Chunk.PrevRowIDMax = 0
+	cr2, err := newChunkRestore(4, s.cfg, &chunk, worker.NewPool(ctx, 1, "io"))
+	c.Assert(err, IsNil)
+	defer cr2.close()
+	_, _, err = cr2.encodeLoop(ctx, kvsQueue, s.tr, s.tr.logger, kvEncoders, deliverCompleteCh, DeliverPauser, rejected)
+	c.Assert(err, ErrorMatches, "too many rows failed to be encoded.*")
+	c.Assert(popAll(kvsQueue), HasLen, 19)
 }
 
 func (s *chunkRestoreSuite) TestRestore(c *C) {
@@ -813,8 +1389,9 @@ func (s *chunkRestoreSuite) TestRestore(c *C) {
 
 	// Expected API sequence
 	// (we don't care about the actual content, this would be checked in the integrated tests)
+	// (the write streams are opened with a context derived from ctx)
 
-	mockClient.EXPECT().WriteEngine(ctx).Return(mockDataWriter, nil)
+	mockClient.EXPECT().WriteEngine(gomock.Any()).Return(mockDataWriter, nil)
 	mockDataWriter.EXPECT().Send(gomock.Any()).Return(nil)
 	mockDataWriter.EXPECT().Send(gomock.Any()).DoAndReturn(func(req *import_kvpb.WriteEngineRequest) error {
 		c.Assert(req.GetBatch().GetMutations(), HasLen, 1)
@@ -822,7 +1399,7 @@ func (s *chunkRestoreSuite) TestRestore(c *C) {
 	})
 	mockDataWriter.EXPECT().CloseAndRecv().Return(nil, nil)
 
-	mockClient.EXPECT().WriteEngine(ctx).Return(mockIndexWriter, nil)
+	mockClient.EXPECT().WriteEngine(gomock.Any()).Return(mockIndexWriter, nil)
 	mockIndexWriter.EXPECT().Send(gomock.Any()).Return(nil)
 	mockIndexWriter.EXPECT().Send(gomock.Any()).DoAndReturn(func(req *import_kvpb.WriteEngineRequest) error {
 		c.Assert(req.GetBatch().GetMutations(), HasLen, 1)
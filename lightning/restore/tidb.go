@@ -17,6 +17,8 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"net"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -39,9 +41,23 @@ type TiDBManager struct {
 	db     *sql.DB
 	tls    *common.TLS
 	parser *parser.Parser
+
+	// endpointDBs are the connections to `tidb.endpoints` used by the TiDB
+	// backend, owned by the manager.
+	endpointDBs []*sql.DB
 }
 
 func NewTiDBManager(dsn config.DBStore, tls *common.TLS) (*TiDBManager, error) {
+	param := connectParam(dsn)
+	db, err := param.Connect()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return NewTiDBManagerWithDB(db, tls, dsn.SQLMode), nil
+}
+
+func connectParam(dsn config.DBStore) common.MySQLConnectParam {
 	param := common.MySQLConnectParam{
 		Host:             dsn.Host,
 		Port:             dsn.Port,
@@ -57,12 +73,39 @@ func NewTiDBManager(dsn config.DBStore, tls *common.TLS) (*TiDBManager, error) {
 			"tidb_checksum_table_concurrency":    strconv.Itoa(dsn.ChecksumTableConcurrency),
 		},
 	}
-	db, err := param.Connect()
-	if err != nil {
-		return nil, errors.Trace(err)
+	if len(dsn.TimeZone) > 0 {
+		// the TiDB backend relies on this to interpret TIMESTAMP values
+		// in the same time zone as the encoder.
+		param.Vars["time_zone"] = "'" + dsn.TimeZone + "'"
 	}
+	return param
+}
 
-	return NewTiDBManagerWithDB(db, tls, dsn.SQLMode), nil
+// ConnectEndpoints connects to every TiDB server in `tidb.endpoints` with the
+// same settings as the main connection, for writing rows via the TiDB backend.
+// If no endpoints are configured, only the main connection is returned.
+func (timgr *TiDBManager) ConnectEndpoints(dsn config.DBStore) ([]*sql.DB, error) {
+	if len(dsn.Endpoints) == 0 {
+		return []*sql.DB{timgr.db}, nil
+	}
+
+	for _, endpoint := range dsn.Endpoints {
+		host, port, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		param := connectParam(dsn)
+		param.Host = host
+		if param.Port, err = strconv.Atoi(port); err != nil {
+			return nil, errors.Trace(err)
+		}
+		db, err := param.Connect()
+		if err != nil {
+			return nil, errors.Annotatef(err, "cannot connect to TiDB server %s", endpoint)
+		}
+		timgr.endpointDBs = append(timgr.endpointDBs, db)
+	}
+	return timgr.endpointDBs, nil
 }
 
 // NewTiDBManagerWithDB creates a new TiDB manager with an existing database
@@ -79,6 +122,9 @@ func NewTiDBManagerWithDB(db *sql.DB, tls *common.TLS, sqlMode mysql.SQLMode) *T
 }
 
 func (timgr *TiDBManager) Close() {
+	for _, db := range timgr.endpointDBs {
+		db.Close()
+	}
 	timgr.db.Close()
 }
 
@@ -168,6 +214,88 @@ func (timgr *TiDBManager) DropTable(ctx context.Context, tableName string) error
 	return sql.Exec(ctx, "drop table", "DROP TABLE "+tableName)
 }
 
+// deleteRowsBatchSize is the number of rows deleted by each statement of
+// DeleteReimportedRows, to keep the transactions small.
+const deleteRowsBatchSize = 10000
+
+// DeleteReimportedRows deletes the rows previously imported from the chunks
+// to be re-imported, together with their index entries, so that only the rows
+// imported again remain afterwards. The rows are located by the row IDs
+// allocated to the chunks, which are their handles only if the table has no
+// integer primary key and the `_tidb_rowid` is not read from the source files.
+// Otherwise nothing is deleted and an error is returned. It returns the number
+// of deleted rows.
+func (timgr *TiDBManager) DeleteReimportedRows(ctx context.Context, tableName string, engines map[int32]*EngineCheckpoint) (int64, error) {
+	schema, table, err := common.ParseUniqueTable(tableName)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	tables, err := timgr.getTables(schema)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	var tableInfo *model.TableInfo
+	for _, tbl := range tables {
+		if tbl.Name.L == strings.ToLower(table) {
+			tableInfo = tbl
+			break
+		}
+	}
+	if tableInfo == nil {
+		return 0, errors.Errorf("table %s not found", tableName)
+	}
+	if tableInfo.PKIsHandle {
+		return 0, errors.Errorf("the rows of table %s are identified by the integer primary key, so the rows imported from the chunks cannot be deleted; please use `--checkpoint-error-destroy` instead", tableName)
+	}
+
+	type rowIDRange struct{ start, end int64 }
+	var ranges []rowIDRange
+	for _, engine := range engines {
+		for _, chunk := range engine.Chunks {
+			if perm := chunk.ColumnPermutation; len(perm) > 0 && perm[len(perm)-1] >= 0 {
+				return 0, errors.Errorf("the `_tidb_rowid` of table %s is read from %s, so the rows imported from the chunks cannot be deleted; please use `--checkpoint-error-destroy` instead", tableName, chunk.Key.Path)
+			}
+			ranges = append(ranges, rowIDRange{start: chunk.Chunk.PrevRowIDMax, end: chunk.Chunk.RowIDMax})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+
+	logger := log.With(zap.String("table", tableName))
+	query := fmt.Sprintf("DELETE FROM %s WHERE _tidb_rowid > ? AND _tidb_rowid <= ? LIMIT %d", tableName, deleteRowsBatchSize)
+	var deleted int64
+	for i := 0; i < len(ranges); i++ {
+		// the chunks usually take consecutive row IDs.
+		r := ranges[i]
+		for i+1 < len(ranges) && ranges[i+1].start <= r.end {
+			if ranges[i+1].end > r.end {
+				r.end = ranges[i+1].end
+			}
+			i++
+		}
+
+		task := logger.Begin(zap.InfoLevel, "delete reimported rows")
+		var rangeDeleted int64
+		for {
+			result, err := timgr.db.ExecContext(ctx, query, r.start, r.end)
+			var affected int64
+			if err == nil {
+				affected, err = result.RowsAffected()
+			}
+			if err != nil {
+				task.End(zap.ErrorLevel, err)
+				return deleted, errors.Annotatef(err, "cannot delete the rows of table %s with row IDs in (%d, %d]", tableName, r.start, r.end)
+			}
+			rangeDeleted += affected
+			if affected < deleteRowsBatchSize {
+				break
+			}
+		}
+		task.End(zap.ErrorLevel, nil, zap.Int64("start", r.start), zap.Int64("end", r.end), zap.Int64("deleted", rangeDeleted))
+		deleted += rangeDeleted
+	}
+	return deleted, nil
+}
+
 func (timgr *TiDBManager) LoadSchemaInfo(ctx context.Context, schemas []*mydump.MDDatabaseMeta) (map[string]*TidbDBInfo, error) {
 	result := make(map[string]*TidbDBInfo, len(schemas))
 	for _, schema := range schemas {
@@ -238,6 +366,67 @@ func ObtainRowFormatVersion(ctx context.Context, db *sql.DB) (rowFormatVersion s
 	return
 }
 
+// IsTableEmpty checks whether the table contains no rows at all.
+func IsTableEmpty(ctx context.Context, db *sql.DB, tableName string) (bool, error) {
+	var count int
+	err := common.SQLWithRetry{DB: db, Logger: log.With(zap.String("table", tableName))}.QueryRow(ctx, "check table empty",
+		fmt.Sprintf("SELECT COUNT(*) FROM (SELECT 1 FROM %s LIMIT 1) t", tableName),
+		&count,
+	)
+	return count == 0, err
+}
+
+// ObtainMaxHandle returns the largest row handle or auto-increment value
+// already used by the table, so that newly allocated IDs won't collide with
+// the existing rows. The shard bits of AUTO_RANDOM values are excluded.
+// Returns 0 if the table is empty.
+func ObtainMaxHandle(ctx context.Context, db *sql.DB, tableName string, tableInfo *model.TableInfo) (int64, error) {
+	var columns []string
+	if pkCol := tableInfo.GetPkColInfo(); tableInfo.PKIsHandle && pkCol != nil {
+		columns = append(columns, pkCol.Name.O)
+	} else {
+		columns = append(columns, model.ExtraHandleName.O)
+	}
+	if autoIncCol := tableInfo.GetAutoIncrementColInfo(); autoIncCol != nil && autoIncCol.Name.O != columns[0] {
+		columns = append(columns, autoIncCol.Name.O)
+	}
+
+	var query strings.Builder
+	query.WriteString("SELECT GREATEST(0")
+	for i, column := range columns {
+		query.WriteString(", COALESCE(MAX(")
+		common.WriteMySQLIdentifier(&query, column)
+		if i == 0 && tableInfo.PKIsHandle && tableInfo.ContainsAutoRandomBits() {
+			// only the auto-increasing part of AUTO_RANDOM values matters.
+			typeBits := uint64(mysql.DefaultLengthOfMysqlTypes[tableInfo.GetPkColInfo().Tp] * 8)
+			fmt.Fprintf(&query, " & %d", int64(1)<<(typeBits-tableInfo.AutoRandomBits-1)-1)
+		}
+		query.WriteString("), 0)")
+	}
+	fmt.Fprintf(&query, ") FROM %s", tableName)
+
+	var maxHandle int64
+	err := common.SQLWithRetry{DB: db, Logger: log.With(zap.String("table", tableName))}.QueryRow(ctx, "obtain max handle",
+		query.String(),
+		&maxHandle,
+	)
+	return maxHandle, errors.Trace(err)
+}
+
+// ObtainApproxRowCount returns the estimated number of rows of the table
+// according to the statistics. Returns -1 if the count is not available.
+func ObtainApproxRowCount(ctx context.Context, db *sql.DB, schema string, table string) int64 {
+	var rowCount sql.NullInt64
+	err := db.QueryRowContext(ctx,
+		"SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?",
+		schema, table,
+	).Scan(&rowCount)
+	if err != nil || !rowCount.Valid {
+		return -1
+	}
+	return rowCount.Int64
+}
+
 func AlterAutoIncrement(ctx context.Context, db *sql.DB, tableName string, incr int64) error {
 	sql := common.SQLWithRetry{
 		DB:     db,
@@ -255,3 +444,24 @@ func AlterAutoIncrement(ctx context.Context, db *sql.DB, tableName string, incr
 	}
 	return errors.Annotatef(err, "%s", query)
 }
+
+// AlterAutoRandom rebases the AUTO_RANDOM allocator of the table, so that the
+// auto-increasing part of the values generated by TiDB later starts from
+// randomBase.
+func AlterAutoRandom(ctx context.Context, db *sql.DB, tableName string, randomBase int64) error {
+	sql := common.SQLWithRetry{
+		DB:     db,
+		Logger: log.With(zap.String("table", tableName), zap.Int64("auto_random", randomBase)),
+	}
+	query := fmt.Sprintf("ALTER TABLE %s AUTO_RANDOM_BASE=%d", tableName, randomBase)
+	task := sql.Logger.Begin(zap.InfoLevel, "alter table auto_random")
+	err := sql.Exec(ctx, "alter table auto_random_base", query)
+	task.End(zap.ErrorLevel, err)
+	if err != nil {
+		task.Error(
+			"alter table auto_random_base failed, please perform the query manually",
+			zap.String("query", query),
+		)
+	}
+	return errors.Annotatef(err, "%s", query)
+}
@@ -27,11 +27,13 @@ import (
 	"github.com/pingcap/parser/ast"
 	"github.com/pingcap/parser/model"
 	tmysql "github.com/pingcap/parser/mysql"
+	"github.com/pingcap/parser/types"
 	"github.com/pingcap/tidb/ddl"
 	"github.com/pingcap/tidb/util/mock"
 
 	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
 	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
 	"github.com/pingcap/tidb-lightning/lightning/mydump"
 )
 
@@ -293,6 +295,70 @@ func (s *tidbSuite) TestLoadSchemaInfoMissing(c *C) {
 	c.Assert(err, ErrorMatches, ".*Unknown database.*")
 }
 
+func (s *tidbSuite) TestDeleteReimportedRows(c *C) {
+	ctx := context.Background()
+
+	nodes, _, err := s.timgr.parser.Parse(
+		"CREATE TABLE `t1` (`a` INT, `b` INT, KEY (`b`));"+
+			"CREATE TABLE `t2` (`a` INT PRIMARY KEY, `b` INT);",
+		"", "")
+	c.Assert(err, IsNil)
+	tableInfos := make([]*model.TableInfo, 0, len(nodes))
+	sctx := mock.NewContext()
+	for i, node := range nodes {
+		info, err := ddl.MockTableInfo(sctx, node.(*ast.CreateTableStmt), int64(i+100))
+		c.Assert(err, IsNil)
+		info.State = model.StatePublic
+		tableInfos = append(tableInfos, info)
+	}
+	s.handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Assert(req.URL.Path, Equals, "/schema/db")
+		c.Assert(json.NewEncoder(w).Encode(tableInfos), IsNil)
+	})
+
+	chunk := func(prevRowIDMax, rowIDMax int64, perm ...int) *checkpoints.ChunkCheckpoint {
+		return &checkpoints.ChunkCheckpoint{
+			Key:               checkpoints.ChunkCheckpointKey{Path: "/data/db.t1.sql", Offset: prevRowIDMax},
+			ColumnPermutation: perm,
+			Chunk:             mydump.Chunk{PrevRowIDMax: prevRowIDMax, RowIDMax: rowIDMax},
+		}
+	}
+	engines := map[int32]*checkpoints.EngineCheckpoint{
+		-1: {Status: checkpoints.CheckpointStatusLoaded},
+		0:  {Status: checkpoints.CheckpointStatusLoaded, Chunks: []*checkpoints.ChunkCheckpoint{chunk(20000, 30000), chunk(0, 10000)}},
+		2:  {Status: checkpoints.CheckpointStatusLoaded, Chunks: []*checkpoints.ChunkCheckpoint{chunk(10000, 20000, 0, 1, -1)}},
+		3:  {Status: checkpoints.CheckpointStatusLoaded, Chunks: []*checkpoints.ChunkCheckpoint{chunk(50000, 60000)}},
+	}
+
+	// the consecutive ranges are merged, and deleted in batches.
+	s.mockDB.
+		ExpectExec("\\QDELETE FROM `db`.`t1` WHERE _tidb_rowid > ? AND _tidb_rowid <= ? LIMIT 10000\\E").
+		WithArgs(0, 30000).
+		WillReturnResult(sqlmock.NewResult(0, 10000))
+	s.mockDB.
+		ExpectExec("\\QDELETE FROM `db`.`t1` WHERE _tidb_rowid > ? AND _tidb_rowid <= ? LIMIT 10000\\E").
+		WithArgs(0, 30000).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	s.mockDB.
+		ExpectExec("\\QDELETE FROM `db`.`t1` WHERE _tidb_rowid > ? AND _tidb_rowid <= ? LIMIT 10000\\E").
+		WithArgs(50000, 60000).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	deleted, err := s.timgr.DeleteReimportedRows(ctx, "`db`.`t1`", engines)
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, int64(10005))
+
+	// the rows cannot be located if the handles are not the row IDs.
+	_, err = s.timgr.DeleteReimportedRows(ctx, "`db`.`t2`", engines)
+	c.Assert(err, ErrorMatches, "the rows of table `db`.`t2` are identified by the integer primary key, .*")
+	engines[3].Chunks[0].ColumnPermutation = []int{0, 1, 2}
+	_, err = s.timgr.DeleteReimportedRows(ctx, "`db`.`t1`", engines)
+	c.Assert(err, ErrorMatches, "the `_tidb_rowid` of table `db`.`t1` is read from /data/db.t1.sql, .*")
+	_, err = s.timgr.DeleteReimportedRows(ctx, "`db`.`t3`", engines)
+	c.Assert(err, ErrorMatches, "table `db`.`t3` not found")
+
+	s.mockDB.ExpectClose()
+}
+
 func (s *tidbSuite) TestGetGCLifetime(c *C) {
 	ctx := context.Background()
 
@@ -334,6 +400,19 @@ func (s *tidbSuite) TestAlterAutoInc(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *tidbSuite) TestAlterAutoRandom(c *C) {
+	ctx := context.Background()
+
+	s.mockDB.
+		ExpectExec("\\QALTER TABLE `db`.`table` AUTO_RANDOM_BASE=12345\\E").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mockDB.
+		ExpectClose()
+
+	err := AlterAutoRandom(ctx, s.timgr.db, "`db`.`table`", 12345)
+	c.Assert(err, IsNil)
+}
+
 func (s *tidbSuite) TestObtainRowFormatVersionSucceed(c *C) {
 	ctx := context.Background()
 
@@ -359,3 +438,146 @@ func (s *tidbSuite) TestObtainRowFormatVersionFailure(c *C) {
 	version := ObtainRowFormatVersion(ctx, s.timgr.db)
 	c.Assert(version, Equals, "1")
 }
+
+func (s *tidbSuite) TestIsTableEmpty(c *C) {
+	ctx := context.Background()
+
+	s.mockDB.
+		ExpectQuery("\\QSELECT COUNT(*) FROM (SELECT 1 FROM `db`.`empty` LIMIT 1) t\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(0))
+	s.mockDB.
+		ExpectQuery("\\QSELECT COUNT(*) FROM (SELECT 1 FROM `db`.`full` LIMIT 1) t\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+	s.mockDB.
+		ExpectClose()
+
+	empty, err := IsTableEmpty(ctx, s.timgr.db, "`db`.`empty`")
+	c.Assert(err, IsNil)
+	c.Assert(empty, IsTrue)
+
+	empty, err = IsTableEmpty(ctx, s.timgr.db, "`db`.`full`")
+	c.Assert(err, IsNil)
+	c.Assert(empty, IsFalse)
+}
+
+func (s *tidbSuite) TestObtainMaxHandle(c *C) {
+	ctx := context.Background()
+
+	s.mockDB.
+		ExpectQuery("\\QSELECT GREATEST(0, COALESCE(MAX(`id`), 0)) FROM `db`.`pk`\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"GREATEST"}).AddRow(100))
+	s.mockDB.
+		ExpectQuery("\\QSELECT GREATEST(0, COALESCE(MAX(`_tidb_rowid`), 0), COALESCE(MAX(`a`), 0)) FROM `db`.`rowid`\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"GREATEST"}).AddRow(0))
+	s.mockDB.
+		ExpectClose()
+
+	pkTableInfo := &model.TableInfo{
+		PKIsHandle: true,
+		Columns: []*model.ColumnInfo{
+			{Name: model.NewCIStr("id"), FieldType: types.FieldType{Flag: tmysql.PriKeyFlag | tmysql.AutoIncrementFlag}},
+			{Name: model.NewCIStr("b")},
+		},
+	}
+	maxHandle, err := ObtainMaxHandle(ctx, s.timgr.db, "`db`.`pk`", pkTableInfo)
+	c.Assert(err, IsNil)
+	c.Assert(maxHandle, Equals, int64(100))
+
+	rowIDTableInfo := &model.TableInfo{
+		Columns: []*model.ColumnInfo{
+			{Name: model.NewCIStr("a"), FieldType: types.FieldType{Flag: tmysql.UniqueKeyFlag | tmysql.AutoIncrementFlag}},
+		},
+	}
+	maxHandle, err = ObtainMaxHandle(ctx, s.timgr.db, "`db`.`rowid`", rowIDTableInfo)
+	c.Assert(err, IsNil)
+	c.Assert(maxHandle, Equals, int64(0))
+}
+
+func (s *tidbSuite) TestObtainMaxHandleAutoRandom(c *C) {
+	ctx := context.Background()
+
+	s.mockDB.
+		ExpectQuery("\\QSELECT GREATEST(0, COALESCE(MAX(`id` & 576460752303423487), 0)) FROM `db`.`rand`\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"GREATEST"}).AddRow(30))
+	s.mockDB.
+		ExpectClose()
+
+	tableInfo := &model.TableInfo{
+		PKIsHandle:     true,
+		AutoRandomBits: 4,
+		Columns: []*model.ColumnInfo{
+			{Name: model.NewCIStr("id"), FieldType: types.FieldType{Tp: tmysql.TypeLonglong, Flag: tmysql.PriKeyFlag}},
+		},
+	}
+	maxHandle, err := ObtainMaxHandle(ctx, s.timgr.db, "`db`.`rand`", tableInfo)
+	c.Assert(err, IsNil)
+	c.Assert(maxHandle, Equals, int64(30))
+}
+
+func (s *tidbSuite) TestObtainApproxRowCount(c *C) {
+	ctx := context.Background()
+
+	s.mockDB.
+		ExpectQuery("\\QSELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?\\E").
+		WithArgs("db", "t1").
+		WillReturnRows(sqlmock.NewRows([]string{"TABLE_ROWS"}).AddRow(1234))
+	s.mockDB.
+		ExpectQuery("\\QSELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?\\E").
+		WithArgs("db", "t2").
+		WillReturnError(errors.New("ERROR 1146 (42S02): Table 'information_schema.TABLES' doesn't exist"))
+	s.mockDB.
+		ExpectClose()
+
+	c.Assert(ObtainApproxRowCount(ctx, s.timgr.db, "db", "t1"), Equals, int64(1234))
+	c.Assert(ObtainApproxRowCount(ctx, s.timgr.db, "db", "t2"), Equals, int64(-1))
+}
+
+func (s *tidbSuite) TestCheckTablesEmpty(c *C) {
+	ctx := context.Background()
+
+	cfg := config.NewConfig()
+	cfg.TikvImporter.Backend = config.BackendImporter
+	rc := &RestoreController{
+		cfg: cfg,
+		dbMetas: []*mydump.MDDatabaseMeta{
+			{
+				Name: "db",
+				Tables: []*mydump.MDTableMeta{
+					{DB: "db", Name: "t1"},
+					{DB: "db", Name: "t2"},
+				},
+			},
+		},
+		dbInfos: map[string]*checkpoints.TidbDBInfo{
+			"db": {
+				Name: "db",
+				Tables: map[string]*checkpoints.TidbTableInfo{
+					"t1": {Name: "t1"},
+					"t2": {Name: "t2"},
+				},
+			},
+		},
+		checkpointsDB: checkpoints.NewNullCheckpointsDB(),
+		tidbMgr:       s.timgr,
+	}
+
+	s.mockDB.
+		ExpectQuery("\\QSELECT COUNT(*) FROM (SELECT 1 FROM `db`.`t1` LIMIT 1) t\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(0))
+	s.mockDB.
+		ExpectQuery("\\QSELECT COUNT(*) FROM (SELECT 1 FROM `db`.`t2` LIMIT 1) t\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+	s.mockDB.
+		ExpectQuery("\\QSELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?\\E").
+		WithArgs("db", "t2").
+		WillReturnRows(sqlmock.NewRows([]string{"TABLE_ROWS"}).AddRow(100))
+	s.mockDB.
+		ExpectClose()
+
+	err := rc.checkTablesEmpty(ctx)
+	c.Assert(err, ErrorMatches, "1 target tables are not empty.*")
+
+	// no checks at all in the incremental mode.
+	cfg.TikvImporter.Incremental = true
+	c.Assert(rc.checkTablesEmpty(ctx), IsNil)
+}
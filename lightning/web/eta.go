@@ -0,0 +1,265 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/metric"
+)
+
+const (
+	// throughputWindow is the period over which the recent throughput is
+	// computed.
+	throughputWindow = time.Minute
+	// sampleInterval is the minimum distance between two samples kept in a
+	// sliding window, which bounds the number of samples.
+	sampleInterval = time.Second
+)
+
+// progressPhase is the step a table is going through, as far as the
+// estimation is concerned.
+type progressPhase int
+
+const (
+	phaseWrite progressPhase = iota
+	phaseImport
+	phaseChecksum
+	phaseAnalyze
+	phaseDone
+)
+
+func phaseOf(status checkpoints.CheckpointStatus) progressPhase {
+	switch {
+	case status <= checkpoints.CheckpointStatusMaxInvalid, status >= checkpoints.CheckpointStatusAnalyzeSkipped:
+		return phaseDone
+	case status >= checkpoints.CheckpointStatusChecksumSkipped:
+		return phaseAnalyze
+	case status >= checkpoints.CheckpointStatusAlteredAutoInc:
+		return phaseChecksum
+	case status >= checkpoints.CheckpointStatusAllWritten:
+		return phaseImport
+	default:
+		return phaseWrite
+	}
+}
+
+type rateSample struct {
+	at    time.Time
+	bytes float64
+}
+
+// slidingRate computes the rate of a growing number of bytes over the
+// throughput window.
+type slidingRate struct {
+	samples []rateSample
+}
+
+func (r *slidingRate) add(at time.Time, bytes float64) {
+	n := len(r.samples)
+	if n >= 2 && at.Sub(r.samples[n-2].at) < sampleInterval {
+		r.samples[n-1] = rateSample{at: at, bytes: bytes}
+	} else {
+		r.samples = append(r.samples, rateSample{at: at, bytes: bytes})
+	}
+
+	// keep the latest sample older than the window as the baseline.
+	cut := 0
+	for cut+1 < len(r.samples) && at.Sub(r.samples[cut+1].at) >= throughputWindow {
+		cut++
+	}
+	r.samples = r.samples[cut:]
+}
+
+// rate returns the number of bytes per second, or 0 if unknown.
+func (r *slidingRate) rate() float64 {
+	if len(r.samples) < 2 {
+		return 0
+	}
+	first, last := r.samples[0], r.samples[len(r.samples)-1]
+	seconds := last.at.Sub(first.at).Seconds()
+	if seconds <= 0 || last.bytes <= first.bytes {
+		return 0
+	}
+	return (last.bytes - first.bytes) / seconds
+}
+
+// phaseCost accumulates the time spent by the tables in a post-processing
+// phase, which is assumed to be proportional to the table size.
+type phaseCost struct {
+	seconds float64
+	bytes   int64
+}
+
+// estimate returns the expected seconds spent by a table of the given size in
+// the phase. A phase not yet completed by any table is assumed instant.
+func (c *phaseCost) estimate(size int64) float64 {
+	if c.bytes <= 0 {
+		return 0
+	}
+	return c.seconds * float64(size) / float64(c.bytes)
+}
+
+// estimation is the state kept for estimating the completion time of a
+// table.
+type estimation struct {
+	phase      progressPhase
+	phaseStart time.Time
+	written    slidingRate
+}
+
+// writtenBytes returns the number of bytes of the source files already
+// written into the engines.
+func writtenBytes(cp *checkpoints.TableCheckpoint) int64 {
+	tw := int64(0)
+	for _, engine := range cp.Engines {
+		for _, chunk := range engine.Chunks {
+			if engine.Status >= checkpoints.CheckpointStatusAllWritten {
+				tw += chunk.Chunk.EndOffset - chunk.Key.Offset
+			} else {
+				tw += chunk.Chunk.Offset - chunk.Key.Offset
+			}
+		}
+	}
+	return tw
+}
+
+// deliveredKVBytes returns the number of bytes of KV pairs delivered so far.
+func deliveredKVBytes() float64 {
+	var total float64
+	for _, kind := range []string{metric.BlockDeliverKindData, metric.BlockDeliverKindIndex} {
+		observer := metric.BlockDeliverBytesHistogram.WithLabelValues(kind)
+		if histogram, ok := observer.(prometheus.Histogram); ok {
+			total += metric.ReadHistogramSum(histogram)
+		}
+	}
+	return total
+}
+
+// setPhase moves the table into the phase of the given status, and learns
+// the cost of the phase it leaves. It must be called with the lock held.
+func (p *taskProgress) setPhase(tbl *tableInfo, status checkpoints.CheckpointStatus) {
+	phase := phaseOf(status)
+	if phase == tbl.estimation.phase && !tbl.estimation.phaseStart.IsZero() {
+		return
+	}
+	now := p.now()
+	old := tbl.estimation.phase
+	if old > phaseWrite && old < phaseDone && !tbl.estimation.phaseStart.IsZero() {
+		cost := &p.costs[old]
+		cost.seconds += now.Sub(tbl.estimation.phaseStart).Seconds()
+		cost.bytes += tbl.TotalSize
+	}
+	tbl.estimation.phase = phase
+	tbl.estimation.phaseStart = now
+}
+
+// postProcessETA estimates the seconds needed by the table after all its data
+// are written.
+func (p *taskProgress) postProcessETA(tbl *tableInfo, now time.Time) float64 {
+	var eta float64
+	for phase := phaseImport; phase < phaseDone; phase++ {
+		switch {
+		case phase < tbl.estimation.phase:
+		case phase == tbl.estimation.phase:
+			elapsed := now.Sub(tbl.estimation.phaseStart).Seconds()
+			eta += math.Max(p.costs[phase].estimate(tbl.TotalSize)-elapsed, 0)
+		default:
+			eta += p.costs[phase].estimate(tbl.TotalSize)
+		}
+	}
+	return eta
+}
+
+// refreshEstimates samples the throughput, and recomputes the ETAs of the
+// unfinished tables and of the whole task. It must be called with the lock
+// held.
+//
+// A running table is expected to write its remaining data at its own recent
+// rate, while a pending table is expected to be written once the data of all
+// tables are written at the recent rate of the task. The post-processing of a
+// table is expected to take as long per byte as the tables which went
+// through it before in this task.
+func (p *taskProgress) refreshEstimates() {
+	now := p.now()
+
+	var written, remaining int64
+	for _, tbl := range p.Tables {
+		written += tbl.TotalWritten
+		if tbl.Status != taskStatusCompleted && tbl.estimation.phase == phaseWrite && tbl.TotalSize > tbl.TotalWritten {
+			remaining += tbl.TotalSize - tbl.TotalWritten
+		}
+	}
+	p.written.add(now, float64(written))
+	p.delivered.add(now, deliveredKVBytes())
+	p.Speed = p.written.rate()
+	p.KVSpeed = p.delivered.rate()
+	metric.ThroughputGauge.WithLabelValues(metric.ThroughputKindSource).Set(p.Speed)
+	metric.ThroughputGauge.WithLabelValues(metric.ThroughputKindKV).Set(p.KVSpeed)
+
+	taskWriteETA, taskWriteKnown := 0.0, true
+	if remaining > 0 {
+		taskWriteETA, taskWriteKnown = float64(remaining)/p.Speed, p.Speed > 0
+	}
+
+	taskETA, taskKnown := taskWriteETA, taskWriteKnown
+	for name, tbl := range p.Tables {
+		if tbl.Status == taskStatusCompleted || tbl.estimation.phase == phaseDone {
+			tbl.ETA = nil
+			tbl.Speed = 0
+			metric.TableETASecondsGauge.DeleteLabelValues(name)
+			continue
+		}
+
+		eta, known := 0.0, true
+		if tbl.estimation.phase == phaseWrite {
+			if tbl.Status == taskStatusRunning {
+				tbl.estimation.written.add(now, float64(tbl.TotalWritten))
+				tbl.Speed = tbl.estimation.written.rate()
+				if tbl.TotalSize > tbl.TotalWritten {
+					eta, known = float64(tbl.TotalSize-tbl.TotalWritten)/tbl.Speed, tbl.Speed > 0
+				}
+			} else {
+				eta, known = taskWriteETA, taskWriteKnown
+			}
+		} else {
+			tbl.Speed = 0
+		}
+
+		if !known {
+			tbl.ETA = nil
+			taskKnown = false
+			metric.TableETASecondsGauge.WithLabelValues(name).Set(math.NaN())
+			continue
+		}
+		eta += p.postProcessETA(tbl, now)
+		seconds := int64(math.Ceil(eta))
+		tbl.ETA = &seconds
+		metric.TableETASecondsGauge.WithLabelValues(name).Set(eta)
+		taskETA = math.Max(taskETA, eta)
+	}
+
+	if taskKnown {
+		seconds := int64(math.Ceil(taskETA))
+		p.ETA = &seconds
+		metric.TaskETASecondsGauge.Set(taskETA)
+	} else {
+		p.ETA = nil
+		metric.TaskETASecondsGauge.Set(math.NaN())
+	}
+}
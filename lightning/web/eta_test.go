@@ -0,0 +1,121 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
+)
+
+func TestWeb(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&etaSuite{})
+
+type etaSuite struct{}
+
+func (s *etaSuite) TestPhaseOf(c *C) {
+	c.Assert(phaseOf(checkpoints.CheckpointStatusLoaded), Equals, phaseWrite)
+	c.Assert(phaseOf(checkpoints.CheckpointStatusAllWritten), Equals, phaseImport)
+	c.Assert(phaseOf(checkpoints.CheckpointStatusIndexImported), Equals, phaseImport)
+	c.Assert(phaseOf(checkpoints.CheckpointStatusAlteredAutoInc), Equals, phaseChecksum)
+	c.Assert(phaseOf(checkpoints.CheckpointStatusChecksummed), Equals, phaseAnalyze)
+	c.Assert(phaseOf(checkpoints.CheckpointStatusAnalyzeSkipped), Equals, phaseDone)
+	c.Assert(phaseOf(checkpoints.CheckpointStatusChecksummed/10), Equals, phaseDone)
+}
+
+func (s *etaSuite) TestSlidingRate(c *C) {
+	var r slidingRate
+	start := time.Unix(1565238374, 0)
+	c.Assert(r.rate(), Equals, 0.0)
+
+	r.add(start, 0)
+	c.Assert(r.rate(), Equals, 0.0)
+	r.add(start.Add(10*time.Second), 1000)
+	c.Assert(r.rate(), Equals, 100.0)
+
+	// samples closer than a second are merged.
+	r.add(start.Add(10500*time.Millisecond), 1100)
+	c.Assert(r.samples, HasLen, 3)
+	r.add(start.Add(10800*time.Millisecond), 1200)
+	c.Assert(r.samples, HasLen, 3)
+
+	// the samples out of the window are dropped, except the baseline.
+	r.add(start.Add(80*time.Second), 1200)
+	c.Assert(r.samples[0].at, Equals, start.Add(10800*time.Millisecond))
+	c.Assert(r.rate(), Equals, 0.0)
+}
+
+func (s *etaSuite) TestRefreshEstimates(c *C) {
+	now := time.Unix(1565238374, 0)
+	p := &taskProgress{
+		Status: taskStatusRunning,
+		Tables: map[string]*tableInfo{
+			"`db`.`t1`": {TotalSize: 1000},
+			"`db`.`t2`": {TotalSize: 2000},
+		},
+		now: func() time.Time { return now },
+	}
+	t1, t2 := p.Tables["`db`.`t1`"], p.Tables["`db`.`t2`"]
+
+	t1.Status = taskStatusRunning
+	p.setPhase(t1, checkpoints.CheckpointStatusLoaded)
+	p.refreshEstimates()
+	c.Assert(p.ETA, IsNil)
+	c.Assert(t1.ETA, IsNil)
+
+	// t1 writes 100 bytes per second.
+	now = now.Add(5 * time.Second)
+	t1.TotalWritten = 500
+	p.refreshEstimates()
+	c.Assert(t1.Speed, Equals, 100.0)
+	c.Assert(*t1.ETA, Equals, int64(5))
+	// t2 is written after the remaining 2500 bytes of both tables.
+	c.Assert(*t2.ETA, Equals, int64(25))
+	c.Assert(*p.ETA, Equals, int64(25))
+
+	// t1 spends 2 seconds in importing, which is assumed to take 4 seconds
+	// for t2.
+	now = now.Add(5 * time.Second)
+	t1.TotalWritten = 1000
+	p.setPhase(t1, checkpoints.CheckpointStatusAllWritten)
+	now = now.Add(2 * time.Second)
+	p.setPhase(t1, checkpoints.CheckpointStatusAlteredAutoInc)
+	c.Assert(p.costs[phaseImport], Equals, phaseCost{seconds: 2, bytes: 1000})
+	now = now.Add(1 * time.Second)
+	p.setPhase(t1, checkpoints.CheckpointStatusChecksummed)
+	p.refreshEstimates()
+	c.Assert(*t1.ETA, Equals, int64(0))
+	// 2000 bytes at 1000/13 bytes per second, then 4 seconds of importing
+	// and 2 seconds of checksum.
+	c.Assert(*t2.ETA, Equals, int64(32))
+
+	p.setPhase(t1, checkpoints.CheckpointStatusAnalyzed)
+	t1.Status = taskStatusCompleted
+	p.refreshEstimates()
+	c.Assert(t1.ETA, IsNil)
+
+	data, err := json.Marshal(p)
+	c.Assert(err, IsNil)
+	var decoded map[string]interface{}
+	c.Assert(json.Unmarshal(data, &decoded), IsNil)
+	c.Assert(decoded["e"], Equals, 32.0)
+	c.Assert(decoded["t"].(map[string]interface{})["`db`.`t1`"], Not(HasKey), "e")
+}
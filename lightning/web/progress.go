@@ -3,10 +3,12 @@ package web
 import (
 	"encoding/json"
 	"sync"
+	"time"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
 	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/metric"
 	"github.com/pingcap/tidb-lightning/lightning/mydump"
 )
 
@@ -47,6 +49,7 @@ func (cpm *checkpointsMap) insert(key string, cp *checkpoints.TableCheckpoint) {
 type totalWritten struct {
 	key          string
 	totalWritten int64
+	status       checkpoints.CheckpointStatus
 }
 
 func (cpm *checkpointsMap) update(diffs map[string]*checkpoints.TableCheckpointDiff) []totalWritten {
@@ -58,18 +61,7 @@ func (cpm *checkpointsMap) update(diffs map[string]*checkpoints.TableCheckpointD
 	for key, diff := range diffs {
 		cp := cpm.checkpoints[key]
 		cp.Apply(diff)
-
-		tw := int64(0)
-		for _, engine := range cp.Engines {
-			for _, chunk := range engine.Chunks {
-				if engine.Status >= checkpoints.CheckpointStatusAllWritten {
-					tw += chunk.Chunk.EndOffset - chunk.Key.Offset
-				} else {
-					tw += chunk.Chunk.Offset - chunk.Key.Offset
-				}
-			}
-		}
-		totalWrittens = append(totalWrittens, totalWritten{key: key, totalWritten: tw})
+		totalWrittens = append(totalWrittens, totalWritten{key: key, totalWritten: writtenBytes(cp), status: cp.Status})
 	}
 	return totalWrittens
 }
@@ -97,6 +89,12 @@ type tableInfo struct {
 	TotalSize    int64      `json:"z"`
 	Status       taskStatus `json:"s"`
 	Message      string     `json:"m,omitempty"`
+	// ETA is the estimated seconds to complete the table, absent if unknown.
+	ETA *int64 `json:"e,omitempty"`
+	// Speed is the number of source bytes written per second recently.
+	Speed float64 `json:"v,omitempty"`
+
+	estimation estimation
 }
 
 type taskProgress struct {
@@ -104,20 +102,40 @@ type taskProgress struct {
 	Tables  map[string]*tableInfo `json:"t"`
 	Status  taskStatus            `json:"s"`
 	Message string                `json:"m,omitempty"`
+	// ETA is the estimated seconds to complete the task, absent if unknown.
+	ETA *int64 `json:"e,omitempty"`
+	// Speed and KVSpeed are the number of source bytes written, and the
+	// number of bytes of KV pairs delivered, per second recently.
+	Speed   float64 `json:"v,omitempty"`
+	KVSpeed float64 `json:"kv,omitempty"`
+
+	now       func() time.Time
+	written   slidingRate
+	delivered slidingRate
+	costs     [phaseDone]phaseCost
 
 	// The contents have their own mutex for protection
 	checkpoints checkpointsMap
 }
 
 var currentProgress = taskProgress{
+	now:         time.Now,
 	checkpoints: makeCheckpointsMap(),
 }
 
 func BroadcastStartTask() {
 	currentProgress.mu.Lock()
 	currentProgress.Status = taskStatusRunning
+	currentProgress.ETA = nil
+	currentProgress.Speed = 0
+	currentProgress.KVSpeed = 0
+	currentProgress.written = slidingRate{}
+	currentProgress.delivered = slidingRate{}
+	currentProgress.costs = [phaseDone]phaseCost{}
 	currentProgress.mu.Unlock()
 
+	metric.TableETASecondsGauge.Reset()
+
 	currentProgress.checkpoints.clear()
 }
 
@@ -127,7 +145,14 @@ func BroadcastEndTask(err error) {
 	currentProgress.mu.Lock()
 	currentProgress.Status = taskStatusCompleted
 	currentProgress.Message = errString
+	currentProgress.ETA = nil
+	currentProgress.Speed = 0
+	currentProgress.KVSpeed = 0
 	currentProgress.mu.Unlock()
+
+	metric.TaskETASecondsGauge.Set(0)
+	metric.TableETASecondsGauge.Reset()
+	metric.ThroughputGauge.Reset()
 }
 
 func BroadcastInitProgress(databases []*mydump.MDDatabaseMeta) {
@@ -147,7 +172,12 @@ func BroadcastInitProgress(databases []*mydump.MDDatabaseMeta) {
 
 func BroadcastTableCheckpoint(tableName string, cp *checkpoints.TableCheckpoint) {
 	currentProgress.mu.Lock()
-	currentProgress.Tables[tableName].Status = taskStatusRunning
+	tbl := currentProgress.Tables[tableName]
+	tbl.Status = taskStatusRunning
+	// a resumed table has already written some data.
+	tbl.TotalWritten = writtenBytes(cp)
+	currentProgress.setPhase(tbl, cp.Status)
+	currentProgress.refreshEstimates()
 	currentProgress.mu.Unlock()
 
 	// create a deep copy to avoid false sharing
@@ -159,8 +189,11 @@ func BroadcastCheckpointDiff(diffs map[string]*checkpoints.TableCheckpointDiff)
 
 	currentProgress.mu.Lock()
 	for _, tw := range totalWrittens {
-		currentProgress.Tables[tw.key].TotalWritten = tw.totalWritten
+		tbl := currentProgress.Tables[tw.key]
+		tbl.TotalWritten = tw.totalWritten
+		currentProgress.setPhase(tbl, tw.status)
 	}
+	currentProgress.refreshEstimates()
 	currentProgress.mu.Unlock()
 }
 
@@ -171,13 +204,17 @@ func BroadcastError(tableName string, err error) {
 	if tbl := currentProgress.Tables[tableName]; tbl != nil {
 		tbl.Status = taskStatusCompleted
 		tbl.Message = errString
+		currentProgress.refreshEstimates()
 	}
 	currentProgress.mu.Unlock()
 }
 
 func MarshalTaskProgress() ([]byte, error) {
-	currentProgress.mu.RLock()
-	defer currentProgress.mu.RUnlock()
+	currentProgress.mu.Lock()
+	defer currentProgress.mu.Unlock()
+	if currentProgress.Status == taskStatusRunning && currentProgress.Tables != nil {
+		currentProgress.refreshEstimates()
+	}
 	return json.Marshal(&currentProgress)
 }
 
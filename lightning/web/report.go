@@ -0,0 +1,51 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"sync"
+
+	"github.com/pingcap/errors"
+)
+
+// lastReport is the import report of the last finished task, kept until the
+// next task finishes.
+var lastReport struct {
+	mu       sync.RWMutex
+	json     []byte
+	markdown []byte
+}
+
+// BroadcastReport publishes the import report of the finished task, in both
+// JSON and Markdown formats.
+func BroadcastReport(json []byte, markdown []byte) {
+	lastReport.mu.Lock()
+	lastReport.json = json
+	lastReport.markdown = markdown
+	lastReport.mu.Unlock()
+}
+
+// MarshalReport returns the import report of the last finished task, either
+// as JSON or as Markdown.
+func MarshalReport(markdown bool) ([]byte, error) {
+	lastReport.mu.RLock()
+	defer lastReport.mu.RUnlock()
+	if lastReport.json == nil {
+		return nil, errors.NotFoundf("import report")
+	}
+	if markdown {
+		return lastReport.markdown, nil
+	}
+	return lastReport.json, nil
+}
@@ -0,0 +1,4 @@
+CREATE TABLE t(
+    id BIGINT PRIMARY KEY AUTO_RANDOM(5),
+    v INT NOT NULL
+);
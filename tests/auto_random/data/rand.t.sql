@@ -0,0 +1 @@
+INSERT INTO t (v) VALUES (1), (2), (3), (4), (5), (6), (7), (8);
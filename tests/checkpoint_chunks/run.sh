@@ -32,7 +32,7 @@ verify_checkpoint_noop() {
     run_sql 'SELECT count(i), sum(i) FROM cpch_tsr.tbl;'
     check_contains "count(i): $(($ROW_COUNT*$CHUNK_COUNT))"
     check_contains "sum(i): $(( $ROW_COUNT*$CHUNK_COUNT*(($CHUNK_COUNT+2)*$ROW_COUNT + 1)/2 ))"
-    run_sql 'SELECT count(*) FROM `tidb_lightning_checkpoint_test_cpch.1234567890.bak`.table_v5 WHERE status >= 200'
+    run_sql 'SELECT count(*) FROM `tidb_lightning_checkpoint_test_cpch.1234567890.bak`.table_v9 WHERE status >= 200'
     check_contains "count(*): 1"
 }
 
@@ -68,6 +68,18 @@ set -e
 
 verify_checkpoint_noop
 
+# The task history should record the result of the last run.
+echo "******** Verify task history ********"
+run_lightning_ctl --enable-checkpoint=1 --config "tests/$TEST_NAME/config.toml" -d "$DBPATH" \
+    -task-history-list > "$TEST_DIR/sql_res.$TEST_NAME.txt"
+check_contains '1234567890'
+check_contains 'succeeded'
+run_lightning_ctl --enable-checkpoint=1 --config "tests/$TEST_NAME/config.toml" -d "$DBPATH" \
+    -task-history-show=1234567890 > "$TEST_DIR/sql_res.$TEST_NAME.txt"
+check_contains 'status:      succeeded'
+check_contains '`cpch_tsr`.`tbl`'
+check_contains "$(($ROW_COUNT*$CHUNK_COUNT))"
+
 # Next, test kill lightning via signal mechanism
 run_sql 'DROP DATABASE IF EXISTS cpch_tsr'
 run_sql 'DROP DATABASE IF EXISTS tidb_lightning_checkpoint_test_cpch'
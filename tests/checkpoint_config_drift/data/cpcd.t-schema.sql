@@ -0,0 +1 @@
+CREATE TABLE t(a INT PRIMARY KEY, b VARCHAR(16));
@@ -0,0 +1,41 @@
+#!/bin/sh
+#
+# Copyright 2019 PingCAP, Inc.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -eu
+
+run_sql 'DROP DATABASE IF EXISTS cpcd'
+rm -f "$TEST_DIR"/cpcd.pb*
+
+# Interrupt the import after the first chunk is written.
+export GO_FAILPOINTS='github.com/pingcap/tidb-lightning/lightning/restore/FailIfImportedChunk=return(3)'
+set +e
+run_lightning --enable-checkpoint=1 2> /dev/null
+[ $? -ne 0 ] || exit 1
+set -e
+export GO_FAILPOINTS=''
+
+# Resuming with a changed batch size is rejected, showing the changed setting.
+set +e
+run_lightning --enable-checkpoint=1 --config "tests/$TEST_NAME/changed.toml" 2> "$TEST_DIR/sql_res.$TEST_NAME.txt"
+[ $? -ne 0 ] || exit 1
+set -e
+check_contains 'the configuration has been changed since the checkpoints were created'
+check_contains 'mydumper.batch-size: 107374182400 -> 1000000'
+
+# ... unless explicitly allowed.
+run_lightning --enable-checkpoint=1 --config "tests/$TEST_NAME/changed.toml" --allow-config-change
+run_sql 'SELECT count(*), sum(a) FROM cpcd.t'
+check_contains 'count(*): 6'
+check_contains 'sum(a): 21'
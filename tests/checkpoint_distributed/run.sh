@@ -0,0 +1,68 @@
+#!/bin/sh
+#
+# Copyright 2019 PingCAP, Inc.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -eu
+
+DBPATH="$TEST_DIR/cpdist.mydump"
+TABLE_COUNT=6
+
+rm -rf "$DBPATH"
+mkdir -p "$DBPATH"
+echo 'CREATE DATABASE cpdist;' > "$DBPATH/cpdist-schema-create.sql"
+for i in $(seq "$TABLE_COUNT"); do
+    echo "CREATE TABLE t$i(i INT PRIMARY KEY);" > "$DBPATH/cpdist.t$i-schema.sql"
+    echo "INSERT INTO t$i VALUES (1), (2), (3);" > "$DBPATH/cpdist.t$i.1.sql"
+    echo "INSERT INTO t$i VALUES (4), (5), (6);" > "$DBPATH/cpdist.t$i.2.sql"
+done
+
+verify_tables() {
+    for i in $(seq "$TABLE_COUNT"); do
+        run_sql "SELECT count(*), sum(i) FROM cpdist.t$i"
+        check_contains 'count(*): 6'
+        check_contains 'sum(i): 21'
+    done
+    # the checkpoints, including the leases, are cleaned up by the last instance.
+    run_sql 'SHOW DATABASES LIKE "tidb_lightning_checkpoint_test_cpdist"'
+    check_not_contains 'tidb_lightning_checkpoint_test_cpdist'
+}
+
+# 1. An instance dies after importing a chunk, and the table is taken over by
+#    another instance once the lease expires.
+run_sql 'DROP DATABASE IF EXISTS cpdist'
+run_sql 'DROP DATABASE IF EXISTS tidb_lightning_checkpoint_test_cpdist'
+
+export GO_FAILPOINTS='github.com/pingcap/tidb-lightning/lightning/restore/KillIfImportedChunk=return(1)'
+set +e
+run_lightning -d "$DBPATH" --enable-checkpoint=1 2> /dev/null
+[ $? -ne 0 ] || exit 1
+set -e
+export GO_FAILPOINTS=''
+
+run_sql 'SELECT count(*) > 0 AS leased FROM tidb_lightning_checkpoint_test_cpdist.lease_v1'
+check_contains 'leased: 1'
+
+run_lightning -d "$DBPATH" --enable-checkpoint=1
+verify_tables
+
+# 2. Two instances import the tables together.
+run_sql 'DROP DATABASE IF EXISTS cpdist'
+
+run_lightning -d "$DBPATH" --enable-checkpoint=1 &
+FIRST_PID=$!
+run_lightning -d "$DBPATH" --enable-checkpoint=1 &
+SECOND_PID=$!
+wait "$FIRST_PID"
+wait "$SECOND_PID"
+verify_tables
@@ -39,6 +39,13 @@ for i in $(seq 8); do
     run_lightning $ARGS
     set -e
     ls -la /tmp/lightning_test_result/importer/.temp/
+    run_lightning_ctl $ARGS -checkpoint-list > /tmp/lightning_test_result/sql_res.$TEST_NAME.txt
+    check_contains '`cped`.`t`: failed'
+    rm -rf /tmp/lightning_test_result/cp_dump
+    run_lightning_ctl $ARGS -checkpoint-dump=/tmp/lightning_test_result/cp_dump
+    cat /tmp/lightning_test_result/cp_dump/*.csv > /tmp/lightning_test_result/sql_res.$TEST_NAME.txt
+    check_contains 'table_name,engine_id,status,create_time,update_time'
+    check_contains '`cped`.`t`,-1,'
     run_lightning_ctl $ARGS -checkpoint-error-destroy=all
     ls -la /tmp/lightning_test_result/importer/.temp/
 done
@@ -46,3 +53,16 @@ done
 run_lightning --enable-checkpoint=1 --config "tests/$TEST_NAME/file.toml" -d "tests/$TEST_NAME/good-data"
 run_sql 'SELECT * FROM cped.t'
 check_contains 'x: 1999-09-09 09:09:09'
+
+# Migrate the file checkpoints of a failed import into MySQL
+
+run_sql 'DROP DATABASE cped'
+run_sql 'DROP DATABASE IF EXISTS tidb_lightning_checkpoint'
+set +e
+run_lightning --enable-checkpoint=1 --config "tests/$TEST_NAME/file.toml" -d "tests/$TEST_NAME/bad-data"
+set -e
+run_lightning_ctl --enable-checkpoint=1 --config "tests/$TEST_NAME/file.toml" -checkpoint-migrate='mysql:root@tcp(127.0.0.1:4000)/'
+run_lightning_ctl --enable-checkpoint=1 --config "tests/$TEST_NAME/mysql.toml" -checkpoint-list > /tmp/lightning_test_result/sql_res.$TEST_NAME.txt
+check_contains '`cped`.`t`: failed'
+run_lightning_ctl --enable-checkpoint=1 --config "tests/$TEST_NAME/file.toml" -checkpoint-remove=all
+run_lightning_ctl --enable-checkpoint=1 --config "tests/$TEST_NAME/mysql.toml" -checkpoint-error-destroy=all
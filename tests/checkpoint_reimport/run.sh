@@ -0,0 +1,55 @@
+#!/bin/sh
+#
+# Copyright 2019 PingCAP, Inc.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -eu
+
+DBPATH="$TEST_DIR/cpri.mydump"
+ARGS="--enable-checkpoint=1 --config tests/$TEST_NAME/config.toml -d $DBPATH"
+
+run_sql 'DROP DATABASE IF EXISTS cpri'
+rm -rf "$DBPATH" "$TEST_DIR"/cpri.pb*
+mkdir -p "$DBPATH"
+echo 'CREATE DATABASE cpri;' > "$DBPATH/cpri-schema-create.sql"
+echo 'CREATE TABLE t (a int, b int, KEY (b));' > "$DBPATH/cpri.t-schema.sql"
+printf 'INSERT INTO t VALUES (1, 1), (2, 2), (3, 3);\n' > "$DBPATH/cpri.t.1.sql"
+# the second file contains a bad value.
+printf 'INSERT INTO t VALUES (4, 4), (5, 50), (6, 6);\n' > "$DBPATH/cpri.t.2.sql"
+
+# Stop right after the whole table is imported, before the post-processing.
+export GO_FAILPOINTS='github.com/pingcap/tidb-lightning/lightning/restore/FailIfIndexEngineImported=return(1)'
+set +e
+run_lightning $ARGS 2> /dev/null
+[ $? -ne 0 ] || exit 1
+set -e
+export GO_FAILPOINTS=''
+run_sql 'SELECT count(*), sum(b) FROM cpri.t'
+check_contains 'count(*): 6'
+check_contains 'sum(b): 66'
+
+# Replace the bad file, and re-import only that file. The rows imported from
+# the old file, including the one missing from the new file, are deleted.
+printf 'INSERT INTO t VALUES (4, 4), (5, 5);\n' > "$DBPATH/cpri.t.2.sql"
+run_lightning_ctl $ARGS -checkpoint-reimport="\`cpri\`.\`t\`:$DBPATH/cpri.t.2.sql"
+run_lightning_ctl $ARGS -checkpoint-list > "$TEST_DIR/sql_res.$TEST_NAME.txt"
+check_contains '`cpri`.`t`: pending'
+run_sql 'SELECT count(*), sum(b) FROM cpri.t'
+check_contains 'count(*): 3'
+check_contains 'sum(b): 6'
+
+run_lightning $ARGS
+run_sql 'SELECT count(*), sum(b) FROM cpri.t'
+check_contains 'count(*): 5'
+check_contains 'sum(b): 15'
+run_sql 'ADMIN CHECK TABLE cpri.t'
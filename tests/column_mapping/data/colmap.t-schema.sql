@@ -0,0 +1,6 @@
+CREATE TABLE t(
+    id INT NOT NULL PRIMARY KEY,
+    new_name VARCHAR(20),
+    src INT,
+    tag VARCHAR(10) DEFAULT 'def'
+);
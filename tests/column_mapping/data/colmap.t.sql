@@ -0,0 +1 @@
+INSERT INTO t (id, old_name, obsolete, tag) VALUES (1, 'a', 'zzz', 't1'), (2, 'b', 'yyy', 't2');
@@ -0,0 +1,28 @@
+#!/bin/sh
+#
+# Copyright 2019 PingCAP, Inc.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -eux
+# Check that source columns can be renamed, ignored, or overridden by constants
+# and default values according to [[tables]].
+run_sql 'DROP DATABASE IF EXISTS colmap;'
+
+run_lightning
+
+run_sql 'SELECT count(*), sum(id), group_concat(new_name ORDER BY id) AS names, sum(src), group_concat(DISTINCT tag) AS tags FROM colmap.t'
+check_contains 'count(*): 2'
+check_contains 'sum(id): 3'
+check_contains 'names: a,b'
+check_contains 'sum(src): 14'
+check_contains 'tags: def'
@@ -0,0 +1,5 @@
+CREATE TABLE orders(
+    id INT NOT NULL PRIMARY KEY,
+    user_email VARCHAR(64),
+    amount INT
+);
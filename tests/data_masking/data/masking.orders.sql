@@ -0,0 +1 @@
+INSERT INTO orders VALUES (10, 'alice@example.com', 5), (11, 'alice@example.com', 7), (12, 'bob@example.org', 9);
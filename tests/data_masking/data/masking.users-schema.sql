@@ -0,0 +1,7 @@
+CREATE TABLE users(
+    id INT NOT NULL PRIMARY KEY,
+    name VARCHAR(20),
+    email VARCHAR(64),
+    phone VARCHAR(32),
+    note TEXT
+);
@@ -0,0 +1,3 @@
+INSERT INTO users VALUES
+(1, 'Alice', 'alice@example.com', '+1 555-0100', 'vip'),
+(2, 'Bob', 'bob@example.org', '+1 555-0199', NULL);
@@ -0,0 +1,37 @@
+#!/bin/sh
+#
+# Copyright 2019 PingCAP, Inc.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -eux
+# Check that the columns are masked according to [[tables]], and the masked
+# values can still be joined across tables.
+for BACKEND in importer tidb; do
+    run_sql 'DROP DATABASE IF EXISTS masking;'
+
+    run_lightning --backend $BACKEND
+
+    run_sql "SELECT count(*) FROM masking.users WHERE email LIKE '%@example.com' OR email LIKE '%@example.org'"
+    check_contains 'count(*): 2'
+    run_sql "SELECT count(*) FROM masking.users WHERE email IN ('alice@example.com', 'bob@example.org') OR phone IN ('+1 555-0100', '+1 555-0199')"
+    check_contains 'count(*): 0'
+    run_sql "SELECT count(*) FROM masking.users WHERE phone LIKE '+_ ___-____'"
+    check_contains 'count(*): 2'
+    run_sql 'SELECT group_concat(name ORDER BY id) AS names, count(note) FROM masking.users'
+    check_contains 'names: A,B'
+    check_contains 'count(note): 0'
+
+    run_sql 'SELECT u.id, sum(o.amount) AS total FROM masking.users u JOIN masking.orders o ON u.email = o.user_email GROUP BY u.id ORDER BY u.id'
+    check_contains 'total: 12'
+    check_contains 'total: 9'
+done
@@ -18,14 +18,16 @@ set -eux
 # Check that error summary are written at the bottom of import.
 run_sql 'DROP DATABASE IF EXISTS tidb_lightning_checkpoint_error_summary;'
 
-# The easiest way to induce error is to prepopulate the target table with conflicting content.
+# The easiest way to induce error is to prepopulate the target table with content conflicting on the primary key.
 run_sql 'CREATE DATABASE IF NOT EXISTS error_summary;'
 run_sql 'DROP TABLE IF EXISTS error_summary.a;'
 run_sql 'DROP TABLE IF EXISTS error_summary.c;'
 run_sql 'CREATE TABLE error_summary.a (id INT NOT NULL PRIMARY KEY, k INT NOT NULL);'
 run_sql 'CREATE TABLE error_summary.c (id INT NOT NULL PRIMARY KEY, k INT NOT NULL);'
 run_sql 'INSERT INTO error_summary.a VALUES (2, 4), (6, 8);'
-run_sql 'INSERT INTO error_summary.c VALUES (3, 9), (27, 81);'
+run_sql 'INSERT INTO error_summary.c VALUES (10, 9), (27, 81);'
+
+rm -f "$TEST_DIR/error_summary.report.json" "$TEST_DIR/error_summary.report.md"
 
 set +e
 run_lightning --enable-checkpoint=1 --log-file "$TEST_DIR/lightning-error-summary.log"
@@ -46,6 +48,14 @@ grep -Fq '[-] [table=`error_summary`.`a`] [status=checksum] [error="checksum mis
 grep -Fq '[-] [table=`error_summary`.`c`] [status=checksum] [error="checksum mismatched' "$TEST_DIR/lightning-error-summary.tail"
 ! grep -Fq '[-] [table=`error_summary`.`b`] [status=checksum] [error="checksum mismatched' "$TEST_DIR/lightning-error-summary.tail"
 
+# Verify the import report is written with the failed tables
+grep -Fq '"status": "failed"' "$TEST_DIR/error_summary.report.json"
+grep -Fq '"failed_step": "checksum"' "$TEST_DIR/error_summary.report.json"
+grep -Fq '"remote_checksum": {' "$TEST_DIR/error_summary.report.json"
+grep -Fq '| `error_summary`.`a` | invalid (checksum) |' "$TEST_DIR/error_summary.report.md"
+grep -Fq '| `error_summary`.`b` | analyzed |' "$TEST_DIR/error_summary.report.md"
+grep -Fq '| `error_summary`.`c` | invalid (checksum) |' "$TEST_DIR/error_summary.report.md"
+
 # Now check the error log when the checkpoint is not cleaned.
 
 set +e
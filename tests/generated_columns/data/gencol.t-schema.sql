@@ -0,0 +1,6 @@
+CREATE TABLE t(
+    a INT NOT NULL PRIMARY KEY,
+    b INT AS (a * 2) STORED,
+    c INT AS (b + 1) VIRTUAL,
+    KEY idx_c (c)
+);
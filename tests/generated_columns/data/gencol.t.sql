@@ -0,0 +1 @@
+INSERT INTO t VALUES (1, 0, 0), (2, 0, 0), (3, 999, 999);
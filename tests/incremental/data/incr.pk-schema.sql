@@ -0,0 +1,4 @@
+CREATE TABLE pk(
+    id INT NOT NULL PRIMARY KEY,
+    k INT NOT NULL
+);
@@ -0,0 +1 @@
+INSERT INTO pk VALUES (3, 30), (4, 40);
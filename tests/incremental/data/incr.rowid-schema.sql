@@ -0,0 +1,3 @@
+CREATE TABLE rowid(
+    k INT NOT NULL
+);
@@ -0,0 +1 @@
+INSERT INTO rowid VALUES (300), (400);
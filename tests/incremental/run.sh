@@ -0,0 +1,39 @@
+#!/bin/sh
+#
+# Copyright 2019 PingCAP, Inc.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -eux
+# Check that incremental import appends to existing tables and passes checksum.
+run_sql 'DROP DATABASE IF EXISTS incr;'
+run_sql 'CREATE DATABASE incr;'
+run_sql 'CREATE TABLE incr.pk (id INT NOT NULL PRIMARY KEY, k INT NOT NULL);'
+run_sql 'CREATE TABLE incr.rowid (k INT NOT NULL);'
+run_sql 'INSERT INTO incr.pk VALUES (1, 10), (2, 20);'
+run_sql 'INSERT INTO incr.rowid VALUES (100), (200);'
+
+run_lightning --log-file "$TEST_DIR/lightning-incremental.log"
+
+run_sql 'SELECT count(*), sum(id), sum(k) FROM incr.pk'
+check_contains 'count(*): 4'
+check_contains 'sum(id): 10'
+check_contains 'sum(k): 100'
+
+# The imported rows must not overwrite the existing rows sharing the same _tidb_rowid.
+run_sql 'SELECT count(*), sum(k) FROM incr.rowid'
+check_contains 'count(*): 4'
+check_contains 'sum(k): 1000'
+run_sql 'SELECT count(distinct _tidb_rowid) AS c FROM incr.rowid'
+check_contains 'c: 4'
+
+grep -Fq 'prepare incremental import' "$TEST_DIR/lightning-incremental.log"
@@ -0,0 +1,4 @@
+CREATE TABLE empty(
+    id INT NOT NULL PRIMARY KEY,
+    k INT NOT NULL
+);
@@ -0,0 +1 @@
+INSERT INTO empty VALUES (3, 30), (4, 40);
@@ -0,0 +1 @@
+INSERT INTO filled VALUES (1, 10), (2, 20);
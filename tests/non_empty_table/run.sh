@@ -0,0 +1,39 @@
+#!/bin/sh
+#
+# Copyright 2019 PingCAP, Inc.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -eux
+
+# Check that Lightning refuses to import into non-empty tables.
+run_sql 'DROP DATABASE IF EXISTS ne;'
+run_sql 'CREATE DATABASE ne;'
+run_sql 'CREATE TABLE ne.filled (id INT NOT NULL PRIMARY KEY, k INT NOT NULL);'
+run_sql 'INSERT INTO ne.filled VALUES (5, 50);'
+
+set +e
+run_lightning --log-file "$TEST_DIR/lightning-non-empty-table.log"
+ERRORCODE=$?
+set -e
+
+[ "$ERRORCODE" -ne 0 ]
+
+grep -Fq '1 target tables are not empty' "$TEST_DIR/lightning-non-empty-table.log"
+grep -Fq '[-] [table=`ne`.`filled`]' "$TEST_DIR/lightning-non-empty-table.log"
+! grep -Fq '[-] [table=`ne`.`empty`]' "$TEST_DIR/lightning-non-empty-table.log"
+
+# Nothing should be imported at all.
+run_sql 'SELECT count(*) FROM ne.filled'
+check_contains 'count(*): 1'
+run_sql 'SELECT count(*) FROM ne.empty'
+check_contains 'count(*): 0'
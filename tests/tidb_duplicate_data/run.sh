@@ -19,7 +19,7 @@ set -eu
 # on BSD/macOS sed -i must have a following string as backup filename extension
 sed -i.bak 's/new/old/g' "tests/tidb_duplicate_data/data/dup.dup.sql" && rm tests/tidb_duplicate_data/data/dup.dup.sql.bak
 
-for type in replace ignore error; do
+for type in replace ignore error upsert; do
     run_sql 'DROP DATABASE IF EXISTS dup;'
 
     export GO_FAILPOINTS="github.com/pingcap/tidb-lightning/lightning/backend/FailIfImportedSomeRows=return"
@@ -42,7 +42,7 @@ for type in replace ignore error; do
         [ "$ERRORCODE" -ne 0 ]
         tail -20 "$TEST_DIR/lightning-error-on-dup.log" > "$TEST_DIR/lightning-error-on-dup.tail"
         grep -Fq 'Duplicate entry' "$TEST_DIR/lightning-error-on-dup.tail"
-    elif [ $type = 'replace' ]; then
+    elif [ $type = 'replace' ] || [ $type = 'upsert' ]; then
         run_lightning --config "tests/$TEST_NAME/$type.toml"
         run_sql 'SELECT count(*) FROM dup.dup'
         check_contains 'count(*): 2'
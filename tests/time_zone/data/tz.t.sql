@@ -0,0 +1 @@
+INSERT INTO t VALUES (1, '2000-01-01 08:00:00'), (2, '2019-06-30 20:00:00');
@@ -0,0 +1,28 @@
+#!/bin/sh
+#
+# Copyright 2019 PingCAP, Inc.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -eux
+# Check that TIMESTAMP values are interpreted in the configured time zone
+# regardless of the time zone of the host running Lightning.
+for BACKEND in importer tidb; do
+    run_sql 'DROP DATABASE IF EXISTS tz;'
+
+    TZ=America/Los_Angeles run_lightning --backend $BACKEND
+
+    run_sql "SET time_zone = '+00:00'; SELECT ts FROM tz.t WHERE id = 1;"
+    check_contains 'ts: 2000-01-01 00:00:00'
+    run_sql "SET time_zone = '+00:00'; SELECT ts FROM tz.t WHERE id = 2;"
+    check_contains 'ts: 2019-06-30 12:00:00'
+done
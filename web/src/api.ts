@@ -56,12 +56,17 @@ export interface TableInfo {
     z: number
     s: TaskStatus
     m?: string
+    e?: number // estimated seconds to complete
+    v?: number // source bytes written per second
 }
 
 export interface TaskProgress {
     s: TaskStatus
     t: { [tableName: string]: TableInfo }
     m?: string
+    e?: number // estimated seconds to complete
+    v?: number // source bytes written per second
+    kv?: number // KV bytes delivered per second
 }
 
 export interface TaskQueue {